@@ -0,0 +1,628 @@
+// Package authcmd automates `claude` -> /mcp -> Figma -> Authenticate and
+// prints the auth URL. With -servers it does the same for every server that
+// needs it. With -script the steps come from a YAML scenario instead, with
+// several -script they run as concurrent sessions, and -sessions runs flows
+// concurrently in directories of their own. Replay plays back what
+// -record recorded, or runs a -script against it.
+package authcmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+
+	"ved/test/config"
+	"ved/test/logging"
+	"ved/test/ptyauto"
+	"ved/test/scenarios"
+	"ved/test/summary"
+)
+
+// Main runs the tool with args, name is the command it was started as. It
+// returns the exit code after writing the -summary-file.
+func Main(name string, args []string) int {
+	return finish(func() int { return run(name, args) })
+}
+
+// Replay is the replay subcommand, see runReplay.
+func Replay(name string, args []string) int {
+	return finish(func() int { return runReplay(name, args) })
+}
+
+// Authenticate runs the built-in Figma flow, or the scenario at script,
+// headless and returns the auth URL it printed, "" when the server was
+// authenticated already. Nothing is echoed. It is what devops serve runs,
+// one at a time: the flow drives a single claude configuration.
+func Authenticate(ctx context.Context, script string) (string, error) {
+	var f flow
+	var err error
+	if script == "" {
+		f = flow{name: "figma", host: "figma"}
+		f.sc, err = figmaScenario()
+	} else {
+		f = flow{script: script}
+		f.name, _ = sessionName(script)
+		f.sc, err = ptyauto.LoadScenario(script)
+	}
+	if err != nil {
+		return "", fmt.Errorf("loading scenario: %w", err)
+	}
+	redactor, err := ptyauto.NewRedactor(f.sc.Redact)
+	if err != nil {
+		return "", err
+	}
+	return runOnce(ctx, f, io.Discard, true, redactor, transcripts{}, urlActions{})
+}
+
+// finish records f's exit code in the -summary-file, a panic still leaves
+// one.
+func finish(f func() int) int {
+	defer func() {
+		if r := recover(); r != nil {
+			runSummary.Crash(r)
+			panic(r)
+		}
+	}()
+
+	code := f()
+	runSummary.Finish(code)
+	logs.Close(code)
+	return code
+}
+
+// run returns the exit code, so the deferred cleanup (closing the pty,
+// restoring our terminal) happens before the process exits. -max-runtime
+// applies to the whole run, retries included.
+func run(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var scripts stringList
+	fs.Var(&scripts, "script", "YAML or JSON scenario to run instead of the built-in Figma flow, repeat it (optionally as name=path) to run several sessions at once")
+	sessionsFile := fs.String("sessions", "", "YAML file of sessions to run at once, each a flow (-script or the built-in one) in its own directory, see loadSessions")
+	maxParallel := fs.Int("max-parallel", 4, "with several -script or -sessions, how many sessions run at the same time")
+	servers := fs.String("servers", "", "authenticate every MCP server /mcp lists as needing it, one after another, and print a table: all, or a comma separated list like figma,linear")
+	status := fs.Bool("status", false, "only report whether the Figma MCP server is authenticated, changes nothing: exit 0 if it is, 3 if it needs authentication")
+	retries := fs.Int("session-retries", 0, "when the flow fails, start over with a fresh child up to this many times")
+	retryBackoff := fs.Duration("retry-backoff", 5*time.Second, "wait before the first retry, each further retry waits that much longer")
+	maxRuntime := fs.Duration("max-runtime", 2*time.Minute, "kill the child and fail if the whole run takes longer, 0 disables")
+	var ts transcripts
+	fs.StringVar(&ts.dir, "transcript-dir", "", "save a timestamped transcript of every run here, with step results and captured values")
+	fs.BoolVar(&ts.raw, "transcript-raw", false, "keep ANSI escapes in -transcript-dir transcripts")
+	stepTimeout := fs.Duration("timeout", 0, "how long a step waits for its text unless it sets its own timeout, overrides the scenario's (30s by default); -max-runtime caps the whole run")
+	matchTimeoutAction := fs.String("match-timeout-action", "", "what an optional step does when its waitFor times out: skip or fail, overrides the scenario")
+	fs.BoolVar(&stripControl, "strip-control-chars", true, "match and extract URLs from the output without escape sequences and control characters (\\r, backspace, bell), false matches the raw bytes")
+	verbose := fs.Bool("v", false, "also log every expect, send and wait with how long it took")
+	var redact stringList
+	fs.Var(&redact, "redact", "extra regexp whose matches are masked in output and logs, repeatable")
+	summaryFile := fs.String("summary-file", "", "write a JSON summary of the run (outcome, duration, captured URLs, exit code) here when it ends, even when it fails")
+	fs.StringVar(&recordPath, "record", "", "record the session's output to this asciicast v2 file, for asciinema play or the replay subcommand; only sendSecret values are redacted")
+	var urls urlActions
+	fs.BoolVar(&urls.open, "open", false, "open the captured auth URL in the default browser")
+	fs.BoolVar(&urls.copy, "copy", false, "copy the captured auth URL to the clipboard")
+	fs.StringVar(&urls.out, "url-out", "", "write the captured auth URL to this file, one URL per line with several -script")
+	logs.Flags(fs)
+	fs.Parse(args)
+	configErr := config.Apply(fs, "auto mcp")
+
+	runSummary = summary.New("pty-auth", *summaryFile)
+
+	if err := logs.Open(name); err != nil {
+		slog.Error("bad logging flags", "err", err)
+		return 2
+	}
+	slog.SetDefault(slog.New(logHandler()))
+	if configErr != nil {
+		slog.Error("bad configuration", "err", configErr)
+		return 2
+	}
+	if *verbose {
+		logs.Level.Set(slog.LevelDebug)
+	}
+	switch *matchTimeoutAction {
+	case "", ptyauto.MatchTimeoutSkip, ptyauto.MatchTimeoutFail:
+	default:
+		slog.Error("-match-timeout-action must be skip or fail")
+		return 2
+	}
+	if *stepTimeout < 0 {
+		slog.Error("-timeout must not be negative")
+		return 2
+	}
+
+	// an interrupt stops the retries, the child gets it from forwardSignals
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if *maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *maxRuntime)
+		defer cancel()
+	}
+
+	var serverFilter []string
+	if *servers != "" {
+		for _, name := range strings.Split(*servers, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				serverFilter = append(serverFilter, name)
+			}
+		}
+		switch {
+		case len(scripts) > 0 || *sessionsFile != "" || *status:
+			slog.Error("-servers runs the built-in flow for each server, it can't be used with -script, -sessions or -status")
+			return 2
+		case urls.copy:
+			slog.Error("-copy puts one URL on the clipboard, it can't be used with -servers")
+			return 2
+		case recordPath != "":
+			slog.Error("-record records one session, it can't be used with -servers")
+			return 2
+		}
+	}
+
+	if len(scripts) > 1 || *sessionsFile != "" {
+		if *status {
+			slog.Error("-status checks a single session, it can't be used with several -script or -sessions")
+			return 2
+		}
+		if urls.copy || recordPath != "" {
+			slog.Error("-copy and -record are for a single session, they can't be used with several -script or -sessions")
+			return 2
+		}
+		var sessions []batchSession
+		if *sessionsFile != "" {
+			if len(scripts) > 0 {
+				slog.Error("-sessions names the scripts of its sessions, it can't be used with -script")
+				return 2
+			}
+			var err error
+			if sessions, err = loadSessions(*sessionsFile); err != nil {
+				slog.Error("loading -sessions failed", "err", err)
+				return 1
+			}
+		}
+		for _, arg := range scripts {
+			name, path := sessionName(arg)
+			sessions = append(sessions, batchSession{Name: name, Script: path})
+		}
+		redactor, err := ptyauto.NewRedactor(redact)
+		if err != nil {
+			slog.Error("bad -redact pattern", "err", err)
+			return 1
+		}
+		slog.SetDefault(slog.New(redactor.Handler(logHandler())))
+		return runBatch(ctx, sessions, *maxParallel, *retries, *retryBackoff, redact, *matchTimeoutAction, *stepTimeout, ts, urls)
+	}
+
+	var script string
+	if len(scripts) == 1 {
+		_, script = sessionName(scripts[0])
+	}
+
+	load := figmaScenario
+	if script != "" {
+		load = func() (*ptyauto.Scenario, error) { return ptyauto.LoadScenario(script) }
+	}
+	sc, err := load()
+	if err != nil {
+		slog.Error("loading scenario failed", "err", err)
+		return 1
+	}
+
+	if *matchTimeoutAction != "" {
+		sc.MatchTimeoutAction = *matchTimeoutAction
+	}
+	if *stepTimeout > 0 {
+		sc.Timeout = *stepTimeout
+	}
+
+	redactor, err := ptyauto.NewRedactor(append(redact, sc.Redact...))
+	if err != nil {
+		slog.Error("bad -redact pattern", "err", err)
+		return 1
+	}
+	slog.SetDefault(slog.New(redactor.Handler(logHandler())))
+
+	// Under CI there is no terminal: the child still gets a pty, but there
+	// is nothing to forward keys from or to put in raw mode.
+	headless := !term.IsTerminal(int(os.Stdin.Fd()))
+	if headless {
+		slog.Info("stdin is not a terminal, running headless")
+	}
+	echo := redactor.Writer(os.Stdout)
+
+	if serverFilter != nil {
+		return runServers(ctx, sc, serverFilter, echo, headless, redactor, ts, urls, *retries, *retryBackoff)
+	}
+
+	if *status {
+		s, _, cleanup, err := launch(ctx, sc, echo, redactor, slog.Default())
+		if err != nil {
+			slog.Error("starting command failed", "cmd", sc.Cmd, "err", err)
+			return statusError
+		}
+		defer cleanup()
+		return checkStatus(s)
+	}
+
+	f := flow{name: "figma", sc: sc, host: "figma"}
+	if script != "" {
+		f = flow{sc: sc, script: script}
+		f.name, _ = sessionName(script)
+	}
+	err = retry(ctx, slog.Default().With("cmd", sc.Cmd), *retries, *retryBackoff, func() error {
+		_, err := runOnce(ctx, f, echo, headless, redactor, ts, urls)
+		return err
+	})
+	code := exitCode(err)
+	if err != nil {
+		slog.Error("automation failed", "exit_code", code, "err", firstLine(err.Error()))
+	}
+	return code
+}
+
+// Exit codes of a run besides 0, 1 for any other failure, 2 for bad flags
+// and the -status ones.
+const (
+	exitTimeout = 4 // a step or -max-runtime timed out
+	exitExited  = 5 // the program quit before the flow was done
+)
+
+// exitCode is the exit code for the error a run ended with, so CI can tell
+// a hung TUI from a program that crashed.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ptyauto.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.Is(err, ptyauto.ErrExited), errors.Is(err, ptyauto.ErrClosed):
+		return exitExited
+	}
+	return 1
+}
+
+// retry calls attempt until it succeeds, at most retries more times after
+// the first, waiting backoff times the attempt number in between. It gives
+// up when ctx ends: on an interrupt or when -max-runtime is over.
+func retry(ctx context.Context, log *slog.Logger, retries int, backoff time.Duration, attempt func() error) error {
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil {
+			return nil
+		}
+		log.Error("attempt failed", "attempt", n, "err", err)
+		if n > retries {
+			return err
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("not retrying: %w", context.Cause(ctx))
+		}
+
+		wait := time.Duration(n) * backoff
+		log.Info("retrying with a fresh session", "attempt", n+1, "in", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("not retrying: %w", context.Cause(ctx))
+		}
+	}
+}
+
+// flow is what runOnce drives: a -script, or one of the built-in flows
+// that log into an MCP server and must end with an auth URL on host.
+type flow struct {
+	name   string // of the server or the session
+	sc     *ptyauto.Scenario
+	script string // the -script path, empty for a built-in flow
+	host   string // the auth URL's host contains it
+}
+
+// runOnce starts a fresh child in a new pty, drives it through f and
+// returns the auth URL it printed. For a built-in flow a run without one
+// failed too, unless the scenario finished early because the server was
+// authenticated already. The URL found goes through urls.
+func runOnce(ctx context.Context, f flow, echo io.Writer, headless bool, redactor *ptyauto.Redactor, ts transcripts, urls urlActions) (url string, err error) {
+	sc, script := f.sc, f.script
+	t := transcript{name: f.name, start: time.Now(), sc: sc, redactor: redactor, captures: map[string]string{}}
+
+	s, ctx, cleanup, err := launch(ctx, sc, echo, redactor, slog.Default())
+	if err != nil {
+		return "", fmt.Errorf("starting command: %w", err)
+	}
+	defer func() {
+		cleanup()
+		t.err, t.output = err, s.Output()
+		ts.keep(t)
+		runSummary.URL(t.captures["url"])
+	}()
+
+	restore := func() {}
+	if !headless {
+		if restore, err = rawTerminal(s); err != nil {
+			slog.Warn("could not put terminal in raw mode", "err", err)
+		}
+	}
+	defer restore()
+
+	t.steps, err = sc.RunSteps(ctx, s)
+	restore()
+	for k, v := range ptyauto.Captures(t.steps) {
+		t.captures[k] = v
+		if k != "url" {
+			slog.Info("captured", "name", k, "value", v)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if n := len(t.steps); n > 0 && t.steps[n-1].Finished {
+		if script == "" {
+			slog.Info(f.name + " mcp is already authenticated, nothing to do")
+		} else {
+			slog.Info("scenario finished early, nothing to do", "script", script)
+		}
+		return "", nil
+	}
+
+	if script != "" {
+		slog.Info("scenario finished", "script", script)
+		if _, ok := t.captures["url"]; !ok {
+			if url, _ := ptyauto.ExtractURL(s.Text(), ""); url != "" {
+				t.captures["url"] = url
+			}
+		}
+		if url = t.captures["url"]; url != "" {
+			return url, urls.handle(url)
+		}
+		return "", nil
+	}
+
+	url, err = ptyauto.ExtractURL(s.Text(), f.host)
+	if err != nil {
+		return "", fmt.Errorf("no %s auth url in output: %w", f.name, err)
+	}
+	t.captures["url"] = url
+	// the URL is what the user needs, don't mask it
+	slog.Info(f.name+" auth url", ptyauto.Unredacted("url", url))
+	return url, urls.handle(url)
+}
+
+// launch starts sc's command in a pty with signals forwarded to it, the
+// session logs its timings to log and its secrets go to redactor. When the
+// deadline of parent (-max-runtime) passes the child is killed. cleanup
+// kills and reaps the child, so nothing is left over for a retry.
+func launch(parent context.Context, sc *ptyauto.Scenario, echo io.Writer, redactor *ptyauto.Redactor, log *slog.Logger) (s *ptyauto.Session, ctx context.Context, cleanup func(), err error) {
+	s, cmd, stopRecording, err := startSession(sc, echo, redactor)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	s.Log = log
+	stop := forwardSignals(cmd, 3*time.Second)
+
+	ctx, cancel := context.WithCancel(parent)
+	// closing the pty makes whatever the scenario waits on return
+	go func() {
+		<-ctx.Done()
+		if errors.Is(parent.Err(), context.DeadlineExceeded) {
+			killGroup(cmd)
+			s.Close()
+		}
+	}()
+
+	return s, ctx, func() {
+		cancel()
+		stop()
+		s.Close()
+		killGroup(cmd)
+		// the session reaps the child
+		s.WaitExit(5 * time.Second)
+		stopRecording()
+	}, nil
+}
+
+// Exit codes of -status.
+const (
+	statusAuthenticated = 0
+	statusError         = 1
+	statusNeedsAuth     = 3
+)
+
+// checkStatus opens the /mcp list and reads the server states off it without
+// selecting anything, so it is safe to run as a preflight check.
+func checkStatus(s *ptyauto.Session) int {
+	if err := s.WaitStable(2*time.Second, 30*time.Second); err != nil {
+		slog.Error("claude did not start", "err", err)
+		return statusError
+	}
+	if err := s.Send("/mcp\r"); err != nil {
+		slog.Error("sending /mcp failed", "err", err)
+		return statusError
+	}
+
+	// the figma row shows the list is drawn, then let it finish so the row
+	// has its state
+	if err := s.ExpectWith("figma", ptyauto.MatchOptions{IgnoreCase: true}, 30*time.Second); err != nil {
+		slog.Error("mcp server list did not show up", "err", err)
+		return statusError
+	}
+	s.WaitStable(time.Second, 10*time.Second)
+
+	status, state := figmaStatus(s.Screen())
+	switch status {
+	case statusNeedsAuth:
+		slog.Info("figma mcp needs authentication")
+	case statusAuthenticated:
+		slog.Info("figma mcp is authenticated")
+	default:
+		slog.Error("figma mcp is not usable", "state", state)
+	}
+	return status
+}
+
+// connected matches the "connected" state but not "disconnected".
+var connected = regexp.MustCompile(`(^|[^a-z])connected`)
+
+// figmaStatus finds the figma row in the /mcp server list and returns the
+// status for its state, along with the row. The list is redrawn as servers
+// connect, text is the screen as drawn now and its last figma row the
+// current one. A row like "figma · ✔ connected" is authenticated,
+// "figma · △ needs authentication" is not, anything else (failed,
+// disconnected) is an error.
+func figmaStatus(text string) (int, string) {
+	row := ""
+	for _, line := range strings.Split(text, "\n") {
+		if strings.Contains(strings.ToLower(line), "figma") {
+			row = line
+		}
+	}
+	if row == "" {
+		return statusError, "figma is not in the mcp server list"
+	}
+
+	state := strings.ToLower(row)
+	switch {
+	case strings.Contains(state, "needs authentication"):
+		return statusNeedsAuth, strings.TrimSpace(row)
+	case connected.MatchString(state):
+		return statusAuthenticated, strings.TrimSpace(row)
+	}
+	return statusError, strings.TrimSpace(row)
+}
+
+// startSession starts sc's command in a pty sized like our terminal, or as
+// the configuration's pty without one, and echoes its output to echo. With
+// -record it is recorded too, until done is called, with the secrets typed
+// by sendSecret steps masked.
+func startSession(sc *ptyauto.Scenario, echo io.Writer, redactor *ptyauto.Redactor) (s *ptyauto.Session, cmd *exec.Cmd, done func(), err error) {
+	rows, cols := uint16(ptyauto.DefaultRows), uint16(ptyauto.DefaultCols)
+	if c, err := config.Load(); err == nil && c.PTY.Rows > 0 && c.PTY.Cols > 0 {
+		rows, cols = uint16(c.PTY.Rows), uint16(c.PTY.Cols)
+	}
+	if ws, err := pty.GetsizeFull(os.Stdin); err == nil && ws.Rows > 0 && ws.Cols > 0 {
+		rows, cols = ws.Rows, ws.Cols
+	}
+
+	done = func() {}
+	if recordPath != "" {
+		var rec *ptyauto.Recorder
+		if rec, done, err = record(recordPath, int(rows), int(cols)); err != nil {
+			return nil, nil, nil, fmt.Errorf("-record: %w", err)
+		}
+		echo = io.MultiWriter(echo, redactor.SecretWriter(rec))
+	}
+
+	s, cmd, err = sc.Start(rows, cols, echo)
+	if err != nil {
+		done()
+		return nil, nil, nil, err
+	}
+	s.MatchRaw = !stripControl
+	s.Redactor = redactor
+	return s, cmd, done, nil
+}
+
+// rawTerminal puts our terminal in raw mode and forwards keystrokes to the
+// child, so a human can step in (Ctrl-C goes to the child as a key). The
+// returned func restores the terminal and is safe to call more than once.
+func rawTerminal(s *ptyauto.Session) (restore func(), err error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return func() {}, err
+	}
+
+	stdin.forward(s.PTY())
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stdin.forward(nil)
+			term.Restore(fd, state)
+		})
+	}, nil
+}
+
+// stdin copies our stdin to the pty of the current attempt. There is only
+// one copy, started by the first rawTerminal: a copy per attempt would stay
+// blocked reading stdin after its attempt ended and swallow keystrokes meant
+// for the next one. Keys typed between attempts are dropped.
+var stdin stdinForwarder
+
+type stdinForwarder struct {
+	start sync.Once
+	mu    sync.Mutex
+	to    io.Writer
+}
+
+func (f *stdinForwarder) forward(to io.Writer) {
+	f.mu.Lock()
+	f.to = to
+	f.mu.Unlock()
+	f.start.Do(func() { go io.Copy(f, os.Stdin) })
+}
+
+// Write never fails, that would end the copy.
+func (f *stdinForwarder) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.to != nil {
+		f.to.Write(p)
+	}
+	return len(p), nil
+}
+
+// logs is -log-level, -log-format and -log-output, -v lowers the level to
+// debug.
+var logs logging.Config
+
+// stripControl is -strip-control-chars, it applies to every session.
+var stripControl = true
+
+// logHandler is the handler every logger writes through, before redaction.
+// Errors logged through it end up in the -summary-file.
+func logHandler() slog.Handler {
+	return runSummary.Handler(logs.Handler())
+}
+
+// runSummary is the record -summary-file gets, nil without it.
+var runSummary *summary.Summary
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// figmaScenario is the built-in flow, claude -> /mcp -> Figma ->
+// Authenticate. It is the same file -script scenarios/figma.yaml runs, with
+// claude's path, directory and TERM from the configuration.
+func figmaScenario() (*ptyauto.Scenario, error) {
+	sc, err := ptyauto.ParseScenario(scenarios.Figma)
+	if err != nil {
+		return nil, err
+	}
+	c, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	sc.Cmd = c.Binaries.Claude
+	sc.Dir = c.Claude.Dir
+	sc.Env = append(sc.Env, "TERM="+c.Claude.Term)
+	return sc, nil
+}
@@ -0,0 +1,135 @@
+package authcmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"ved/test/ptyauto"
+)
+
+func TestParseMCPList(t *testing.T) {
+	screen := `╭──────────────────────────────────────────────╮
+│ Manage MCP servers                           │
+│                                              │
+│ ❯ 1. figma · △ needs authentication · Enter  │
+│   2. github  · ✔ connected                   │
+│   3. sentry · ✘ failed                       │
+│                                              │
+│ 12 tools available                           │
+╰──────────────────────────────────────────────╯`
+	want := []mcpServer{
+		{Index: 1, Name: "figma", State: "needs authentication"},
+		{Index: 2, Name: "github", State: "connected"},
+		{Index: 3, Name: "sentry", State: "failed"},
+	}
+	got := parseMCPList(screen)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if !got[0].needsAuth() || got[1].needsAuth() {
+		t.Errorf("needsAuth: %v %v", got[0].needsAuth(), got[1].needsAuth())
+	}
+	if got := parseMCPList("No MCP servers configured"); got != nil {
+		t.Errorf("no servers: %+v", got)
+	}
+}
+
+func TestFigmaStatus(t *testing.T) {
+	tests := []struct {
+		name, text string
+		want       int
+	}{
+		{"connected", "1. figma · ✔ connected", statusAuthenticated},
+		{"needs auth", "❯ 1. figma · △ needs authentication", statusNeedsAuth},
+		// disconnected contains connected
+		{"disconnected", "1. figma · ✘ disconnected", statusError},
+		{"failed", "1. Figma · ✘ failed", statusError},
+		{"missing", "1. github · ✔ connected", statusError},
+		{"last row wins", "figma (loading)\n1. figma · ✔ connected", statusAuthenticated},
+	}
+	for _, tt := range tests {
+		if got, row := figmaStatus(tt.text); got != tt.want {
+			t.Errorf("%s: got %d (%q), want %d", tt.name, got, row, tt.want)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for err, want := range map[error]int{
+		nil:                      0,
+		errors.New("no url"):     1,
+		ptyauto.ErrTimeout:       exitTimeout,
+		context.DeadlineExceeded: exitTimeout,
+		fmt.Errorf("step 3: %w", ptyauto.ErrExited): exitExited,
+		ptyauto.ErrClosed: exitExited,
+	} {
+		if got := exitCode(err); got != want {
+			t.Errorf("%v: got %d, want %d", err, got, want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	failing := errors.New("no url")
+
+	n := 0
+	err := retry(context.Background(), log, 2, time.Millisecond, func() error {
+		if n++; n < 3 {
+			return failing
+		}
+		return nil
+	})
+	if err != nil || n != 3 {
+		t.Errorf("succeeding third time: %v after %d attempts", err, n)
+	}
+
+	n = 0
+	err = retry(context.Background(), log, 1, time.Millisecond, func() error { n++; return failing })
+	if !errors.Is(err, failing) || n != 2 {
+		t.Errorf("always failing: %v after %d attempts, want 2", err, n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n = 0
+	err = retry(ctx, log, 5, time.Hour, func() error { n++; cancel(); return failing })
+	if !errors.Is(err, context.Canceled) || n != 1 {
+		t.Errorf("interrupted: %v after %d attempts, want 1", err, n)
+	}
+}
+
+func TestSessionName(t *testing.T) {
+	for arg, want := range map[string][2]string{
+		"figma=scenarios/figma.yaml": {"figma", "scenarios/figma.yaml"},
+		"scenarios/figma.yaml":       {"figma", "scenarios/figma.yaml"},
+		"login":                      {"login", "login"},
+		"a=b=c.yaml":                 {"a", "b=c.yaml"},
+	} {
+		if name, path := sessionName(arg); name != want[0] || path != want[1] {
+			t.Errorf("%s: got %q %q, want %q %q", arg, name, path, want[0], want[1])
+		}
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	a := &prefixWriter{w: &out, mu: &mu, prefix: "[a] ", bol: true}
+	b := &prefixWriter{w: &out, mu: &mu, prefix: "[b] ", bol: true}
+	// a line written in pieces is prefixed once
+	io.WriteString(a, "one\ntw")
+	io.WriteString(a, "o\n")
+	io.WriteString(b, "three\n\nfour")
+	want := "[a] one\n[a] two\n[b] three\n[b] \n[b] four"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
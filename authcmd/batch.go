@@ -0,0 +1,328 @@
+package authcmd
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"ved/test/ptyauto"
+)
+
+// batchSession is one session of a batch: a flow, the scenario at Script
+// or the built-in Figma flow without one, run in Dir instead of the
+// scenario's directory when set.
+type batchSession struct {
+	Name   string `yaml:"name"`
+	Dir    string `yaml:"dir"`
+	Script string `yaml:"script"`
+}
+
+// loadSessions reads a -sessions file. Relative dirs and scripts are taken
+// from the file's directory. A session without a name is named after its
+// script, or its dir for the built-in flow.
+//
+//	sessions:
+//	  - name: web
+//	    dir: /src/web
+//	  - name: api-vault
+//	    dir: /src/api
+//	    script: scenarios/vault-login.yaml
+func loadSessions(path string) ([]batchSession, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Sessions []batchSession `yaml:"sessions"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Sessions) == 0 {
+		return nil, fmt.Errorf("%s: no sessions", path)
+	}
+
+	base, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for i := range file.Sessions {
+		bs := &file.Sessions[i]
+		if bs.Dir != "" && !filepath.IsAbs(bs.Dir) {
+			bs.Dir = filepath.Join(base, bs.Dir)
+		}
+		if bs.Script != "" && !filepath.IsAbs(bs.Script) {
+			bs.Script = filepath.Join(base, bs.Script)
+		}
+		if bs.Name == "" {
+			switch {
+			case bs.Script != "":
+				bs.Name, _ = sessionName(bs.Script)
+			case bs.Dir != "":
+				bs.Name = filepath.Base(bs.Dir)
+			default:
+				return nil, fmt.Errorf("%s: session %d needs a name, a dir or a script", path, i+1)
+			}
+		}
+		// the name tells the output, logs and transcripts apart
+		if seen[bs.Name] {
+			return nil, fmt.Errorf("%s: session %s is there twice, give them names", path, bs.Name)
+		}
+		seen[bs.Name] = true
+	}
+	return file.Sessions, nil
+}
+
+// batchResult is how a session of a batch went, all its attempts.
+type batchResult struct {
+	ptyauto.Result
+	took       time.Duration
+	transcript string // the last attempt's, with -transcript-dir
+}
+
+// runBatch runs every session in its own pty, at most maxParallel at a
+// time. Child output and logs are prefixed with the session name. A script
+// that doesn't load fails only its own session. A failed session is
+// retried on its own, up to retries times. It prints a summary of every
+// session with what it captured and returns 0 only if every session
+// succeeded. ctx ends the whole batch (-max-runtime). The URLs captured go
+// through urls together once all sessions are done.
+func runBatch(ctx context.Context, sessions []batchSession, maxParallel, retries int, retryBackoff time.Duration, redact []string, matchTimeoutAction string, stepTimeout time.Duration, ts transcripts, urls urlActions) int {
+	results := make([]batchResult, len(sessions))
+
+	// RunAllFunc gets the flows that loaded, index maps them back
+	var flows []flow
+	var loaded []*ptyauto.Scenario
+	var index []int
+	for i, bs := range sessions {
+		f := flow{name: bs.Name, script: bs.Script}
+		var err error
+		if bs.Script == "" {
+			f.host = "figma"
+			f.sc, err = figmaScenario()
+		} else {
+			f.sc, err = ptyauto.LoadScenario(bs.Script)
+		}
+		if err != nil {
+			slog.Error("loading scenario failed", "session", bs.Name, "err", err)
+			results[i].Err = err
+			continue
+		}
+		if bs.Dir != "" {
+			// starting the program in a missing dir says the program is missing
+			if info, err := os.Stat(bs.Dir); err != nil || !info.IsDir() {
+				err = cmp.Or(err, fmt.Errorf("%s is not a directory", bs.Dir))
+				slog.Error("bad session dir", "session", bs.Name, "err", err)
+				results[i].Err = err
+				continue
+			}
+			f.sc.Dir = bs.Dir
+		}
+		if matchTimeoutAction != "" {
+			f.sc.MatchTimeoutAction = matchTimeoutAction
+		}
+		if stepTimeout > 0 {
+			f.sc.Timeout = stepTimeout
+		}
+		flows = append(flows, f)
+		loaded = append(loaded, f.sc)
+		index = append(index, i)
+	}
+
+	var outMu sync.Mutex
+	ptyauto.RunAllFunc(loaded, maxParallel, func(j int, sc *ptyauto.Scenario) ptyauto.Result {
+		f := flows[j]
+		br := &results[index[j]]
+		start := time.Now()
+		retry(ctx, slog.Default().With("session", f.name), retries, retryBackoff, func() error {
+			br.Result, br.transcript = runBatchSession(ctx, f, redact, ts, &outMu)
+			return br.Err
+		})
+		br.took = time.Since(start)
+		return br.Result
+	})
+
+	code := printBatchSummary(os.Stdout, sessions, results, ts.dir != "")
+
+	var captured []string
+	for _, r := range results {
+		if url := r.Captures["url"]; url != "" {
+			captured = append(captured, url)
+			if urls.open {
+				urlActions{open: true}.handle(url)
+			}
+		}
+	}
+	if urls.out != "" {
+		if err := writeURLs(urls.out, captured); err != nil {
+			slog.Error("saving the urls failed", "err", err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// printBatchSummary prints a row per session with what it captured, then
+// why the failed ones failed, and returns 1 if any failed.
+func printBatchSummary(w io.Writer, sessions []batchSession, results []batchResult, withTranscripts bool) int {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := "Session\tDir\tResult\tTook\tCaptured"
+	if withTranscripts {
+		header += "\tTranscript"
+	}
+	fmt.Fprintln(tw, header)
+	code := 0
+	var failed []string
+	for i, r := range results {
+		status := "ok"
+		if r.Err != nil {
+			status = "failed"
+			code = 1
+			failed = append(failed, fmt.Sprintf("%s: %s", sessions[i].Name, firstLine(r.Err.Error())))
+		} else if n := len(r.Steps); n > 0 && r.Steps[n-1].Finished {
+			status = "nothing to do"
+		}
+		dir := sessions[i].Dir
+		if dir == "" {
+			dir = "-"
+		}
+		captured := make([]string, 0, len(r.Captures))
+		for k, v := range r.Captures {
+			captured = append(captured, k+"="+v)
+		}
+		sort.Strings(captured)
+		if len(captured) == 0 {
+			captured = append(captured, "-")
+		}
+		row := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", sessions[i].Name, dir, status, r.took.Round(100*time.Millisecond), strings.Join(captured, " "))
+		if withTranscripts {
+			row += "\t" + cmp.Or(r.transcript, "-")
+		}
+		fmt.Fprintln(tw, row)
+	}
+	tw.Flush()
+
+	if len(failed) > 0 {
+		fmt.Fprintf(w, "\n%d of %d sessions failed:\n", len(failed), len(results))
+		for _, f := range failed {
+			fmt.Fprintln(w, "  "+f)
+		}
+	}
+	return code
+}
+
+// runBatchSession runs one flow headless with its own logger, echo prefix
+// and transcript, and returns the transcript's path. Unless a step captured
+// "url", the first https URL it printed, if any, is; the built-in flow
+// fails without one on its host, unless it finished early.
+func runBatchSession(ctx context.Context, f flow, redact []string, ts transcripts, outMu *sync.Mutex) (r ptyauto.Result, transcriptPath string) {
+	name, sc := f.name, f.sc
+	r.Captures = map[string]string{}
+	log := slog.Default().With("session", name)
+
+	redactor, err := ptyauto.NewRedactor(append(redact, sc.Redact...))
+	if err != nil {
+		log.Error("bad redact pattern", "err", err)
+		r.Err = err
+		return r, ""
+	}
+	log = slog.New(redactor.Handler(logHandler())).With("session", name)
+
+	echo := &prefixWriter{w: os.Stdout, mu: outMu, prefix: "[" + name + "] ", bol: true}
+	s, ctx, cleanup, err := launch(ctx, sc, redactor.Writer(echo), redactor, log)
+	if err != nil {
+		log.Error("starting command failed", "cmd", sc.Cmd, "dir", sc.Dir, "err", err)
+		r.Err = err
+		return r, ""
+	}
+	start := time.Now()
+	defer func() {
+		cleanup()
+		r.Output = s.Output()
+		transcriptPath = ts.keep(transcript{name: name, start: start, sc: sc, redactor: redactor,
+			steps: r.Steps, captures: r.Captures, err: r.Err, output: r.Output})
+		runSummary.URL(r.Captures["url"])
+	}()
+
+	r.Steps, r.Err = sc.RunSteps(ctx, s)
+	for k, v := range ptyauto.Captures(r.Steps) {
+		r.Captures[k] = v
+	}
+	if r.Err != nil {
+		log.Error("scenario failed", "cmd", sc.Cmd, "err", r.Err)
+		return r, ""
+	}
+	if n := len(r.Steps); n > 0 && r.Steps[n-1].Finished {
+		log.Info("scenario finished early, nothing to do")
+		return r, ""
+	}
+	log.Info("scenario finished")
+
+	if _, ok := r.Captures["url"]; !ok {
+		url, err := ptyauto.ExtractURL(s.Text(), f.host)
+		switch {
+		case url != "":
+			r.Captures["url"] = url
+		// not every script ends on a URL, that's not a failure
+		case f.script == "":
+			r.Err = fmt.Errorf("no %s auth url in output: %w", f.host, err)
+			log.Error("scenario failed", "cmd", sc.Cmd, "err", r.Err)
+		}
+	}
+	return r, ""
+}
+
+// sessionName splits a name=path argument, without a name the file name
+// minus its extension is used.
+func sessionName(arg string) (name, path string) {
+	if name, path, ok := strings.Cut(arg, "="); ok {
+		return name, path
+	}
+	base := filepath.Base(arg)
+	return strings.TrimSuffix(base, filepath.Ext(base)), arg
+}
+
+// prefixWriter starts every line with prefix. The mutex is shared between
+// sessions so their lines don't get mixed up.
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	bol    bool // at the beginning of a line
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if pw.bol {
+			buf.WriteString(pw.prefix)
+		}
+		buf.Write(line)
+		pw.bol = line[len(line)-1] == '\n'
+	}
+	if _, err := pw.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
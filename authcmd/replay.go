@@ -0,0 +1,129 @@
+package authcmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ved/test/config"
+	"ved/test/ptyauto"
+)
+
+// recordPath is -record: every session started is recorded there as an
+// asciicast, the last one wins.
+var recordPath string
+
+// record creates path for an asciicast of a rows x cols session. The file
+// has the output as printed, secrets the redaction masks in logs included
+// (but for sendSecret values), so only we can read it.
+func record(path string, rows, cols int) (*ptyauto.Recorder, func(), error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	rec, err := ptyauto.NewRecorder(f, rows, cols)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return rec, func() {
+		if err := rec.Err(); err != nil {
+			slog.Warn("recording is incomplete", "path", path, "err", err)
+		}
+		f.Close()
+	}, nil
+}
+
+// runReplay is the replay subcommand: it plays a recording back to our
+// terminal, or with -script runs a scenario against it to test the steps
+// without the real program.
+func runReplay(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] session.cast\n\n", name)
+		fs.PrintDefaults()
+	}
+	speed := fs.Float64("speed", 1, "play this many times faster than recorded, 0 is instant (onScreen steps then only see the last screen)")
+	script := fs.String("script", "", "run this scenario against the recording instead of printing it, the end of the recording is the program exiting with code 0")
+	screen := fs.Bool("screen", false, "print the screen as it is rendered at the end")
+	timeout := fs.Duration("timeout", 0, "how long a step waits for its text unless it sets its own timeout")
+	logs.Flags(fs)
+	fs.Parse(args)
+	configErr := config.Apply(fs, "auto replay")
+	if fs.NArg() != 1 || *speed < 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := logs.Open(name); err != nil {
+		slog.Error("bad logging flags", "err", err)
+		return 2
+	}
+	slog.SetDefault(slog.New(logHandler()))
+	if configErr != nil {
+		slog.Error("bad configuration", "err", configErr)
+		return 2
+	}
+
+	c, err := ptyauto.LoadCast(fs.Arg(0))
+	if err != nil {
+		slog.Error("loading recording failed", "err", err)
+		return 1
+	}
+	if *script == "" {
+		rendered := ptyauto.NewScreen(c.Size())
+		if _, err := io.Copy(io.MultiWriter(os.Stdout, rendered), ptyauto.NewReplayPTY(c, *speed)); err != nil {
+			slog.Error("replay failed", "err", err)
+			return 1
+		}
+		if *screen {
+			fmt.Printf("\n--- screen ---\n%s\n", rendered)
+		}
+		return 0
+	}
+
+	sc, err := ptyauto.LoadScenario(*script)
+	if err != nil {
+		slog.Error("loading scenario failed", "err", err)
+		return 1
+	}
+	if *timeout > 0 {
+		sc.Timeout = *timeout
+	}
+
+	s := ptyauto.Replay(c, *speed)
+	defer s.Close()
+	s.MatchRaw = !stripControl
+	results, err := sc.RunSteps(context.Background(), s)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Step\tResult\tDuration")
+	for _, r := range results {
+		result := "ok"
+		switch {
+		case r.Err != nil:
+			result = "failed"
+		case r.Skipped:
+			result = "skipped"
+		case r.Finished:
+			result = "finished early"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", r.Step, result, r.Duration.Round(time.Millisecond))
+	}
+	tw.Flush()
+	for k, v := range ptyauto.Captures(results) {
+		fmt.Printf("captured %s: %s\n", k, v)
+	}
+	if *screen {
+		fmt.Printf("--- screen ---\n%s\n", s.Screen())
+	}
+	if err != nil {
+		slog.Error("scenario failed against the recording", "err", firstLine(err.Error()))
+		return exitCode(err)
+	}
+	return 0
+}
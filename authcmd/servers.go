@@ -0,0 +1,163 @@
+package authcmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	"ved/test/ptyauto"
+)
+
+// mcpServer is one row of claude's /mcp list, like
+// "❯ 1. figma · △ needs authentication · Enter to login".
+type mcpServer struct {
+	Index int // the number that selects it
+	Name  string
+	State string // "needs authentication", "connected", "failed", ...
+}
+
+func (m mcpServer) needsAuth() bool {
+	return strings.Contains(m.State, "needs authentication")
+}
+
+// mcpRow is a numbered row of the list inside the TUI's border.
+var mcpRow = regexp.MustCompile(`^[\s│❯›>]*(\d+)\.\s+(\S+)\s+(.*)$`)
+
+// parseMCPList reads the servers off the /mcp screen. The state is what
+// follows the name up to the next " · ", without the status glyph.
+func parseMCPList(screen string) []mcpServer {
+	var servers []mcpServer
+	for _, line := range strings.Split(screen, "\n") {
+		m := mcpRow.FindStringSubmatch(strings.TrimRight(line, " │"))
+		if m == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(m[1])
+		state := strings.TrimLeft(m[3], " ·")
+		state, _, _ = strings.Cut(state, " · ")
+		state = strings.TrimLeftFunc(state, func(r rune) bool { return !unicode.IsLetter(r) })
+		servers = append(servers, mcpServer{Index: index, Name: m[2], State: strings.ToLower(strings.TrimSpace(state))})
+	}
+	return servers
+}
+
+// listServers starts base's program, opens /mcp and reads the list.
+func listServers(ctx context.Context, base *ptyauto.Scenario, echo io.Writer, redactor *ptyauto.Redactor) ([]mcpServer, error) {
+	s, _, cleanup, err := launch(ctx, base, echo, redactor, slog.Default())
+	if err != nil {
+		return nil, fmt.Errorf("starting command: %w", err)
+	}
+	defer cleanup()
+
+	if err := s.WaitStable(2*time.Second, 30*time.Second); err != nil {
+		return nil, fmt.Errorf("claude did not start: %w", err)
+	}
+	if err := s.Send("/mcp\r"); err != nil {
+		return nil, err
+	}
+	// a numbered row shows the list is drawn, then let it finish so the
+	// rows have their state
+	if _, err := s.ExpectScreenRegexp(`\d+\.\s+\S+`, 30*time.Second); err != nil {
+		return nil, fmt.Errorf("mcp server list did not show up: %w", err)
+	}
+	s.WaitStable(time.Second, 10*time.Second)
+
+	servers := parseMCPList(s.Screen())
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers in the mcp list:\n%s", s.Screen())
+	}
+	return servers, nil
+}
+
+// serverScenario is the figma flow for srv: base's program, srv picked by
+// its number in the list.
+func serverScenario(base *ptyauto.Scenario, srv mcpServer) *ptyauto.Scenario {
+	// see scenarios/figma.yaml for why the MCP menu needs fixed(5s)
+	fixed := &ptyauto.Settle{Kind: ptyauto.SettleFixed, Duration: 5 * time.Second}
+	sc := *base
+	sc.Steps = []ptyauto.Step{
+		{Send: "/mcp", Settle: &ptyauto.Settle{Kind: ptyauto.SettleStable, Duration: 2 * time.Second}, ConfirmEcho: true},
+		{Send: "\r"},
+		{WaitFor: srv.Name, Settle: fixed, Send: strconv.Itoa(srv.Index), RetryIfEchoed: 2},
+		{WaitFor: "Authenticate", Settle: fixed, Send: "1", RetryIfEchoed: 2},
+		{WaitFor: "https://", WaitStable: time.Second},
+	}
+	return &sc
+}
+
+// runServers is -servers: it reads the /mcp list, then authenticates every
+// server that needs it, of those named in filter unless that is "all",
+// each with a fresh claude and retried on its own. It prints a table of
+// every server with its state and URL and returns 0 only if each one it
+// tried gave a URL.
+func runServers(ctx context.Context, base *ptyauto.Scenario, filter []string, echo io.Writer, headless bool, redactor *ptyauto.Redactor, ts transcripts, urls urlActions, retries int, retryBackoff time.Duration) int {
+	servers, err := listServers(ctx, base, echo, redactor)
+	if err != nil {
+		slog.Error("reading the mcp server list failed", "err", err)
+		return exitCode(err)
+	}
+
+	all := slices.Contains(filter, "all")
+	for _, name := range filter {
+		if name != "all" && !slices.ContainsFunc(servers, func(m mcpServer) bool { return m.Name == name }) {
+			slog.Warn("server is not in the mcp list", "server", name)
+		}
+	}
+
+	type row struct {
+		srv    mcpServer
+		status string
+		url    string
+	}
+	var rows []row
+	var captured []string
+	code := 0
+	for _, srv := range servers {
+		if !all && !slices.Contains(filter, srv.Name) {
+			continue
+		}
+		if !srv.needsAuth() {
+			rows = append(rows, row{srv, "skipped", ""})
+			continue
+		}
+
+		f := flow{name: srv.Name, sc: serverScenario(base, srv)}
+		var url string
+		err := retry(ctx, slog.Default().With("server", srv.Name), retries, retryBackoff, func() error {
+			var err error
+			url, err = runOnce(ctx, f, echo, headless, redactor, ts, urlActions{open: urls.open})
+			return err
+		})
+		if err != nil {
+			rows = append(rows, row{srv, "failed", ""})
+			code = max(code, exitCode(err))
+			continue
+		}
+		rows = append(rows, row{srv, "ok", url})
+		captured = append(captured, url)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Server\tState\tResult\tURL")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.srv.Name, r.srv.State, r.status, r.url)
+	}
+	tw.Flush()
+
+	if urls.out != "" {
+		if err := writeURLs(urls.out, captured); err != nil {
+			slog.Error("saving the urls failed", "err", err)
+			code = max(code, 1)
+		}
+	}
+	return code
+}
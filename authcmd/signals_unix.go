@@ -0,0 +1,58 @@
+//go:build unix
+
+package authcmd
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// forwardSignals relays SIGINT and SIGTERM to the child's process group (see
+// killGroup) so the TUI can clean up its terminal state and exit on its own.
+// A child still running grace after the signal is killed. The returned func
+// stops it.
+func forwardSignals(cmd *exec.Cmd, grace time.Duration) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	var kill *time.Timer
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				pgid := cmd.Process.Pid
+				slog.Info("forwarding signal to child", "signal", sig, "pgid", pgid)
+				syscall.Kill(-pgid, sig.(syscall.Signal))
+
+				if kill == nil {
+					kill = time.AfterFunc(grace, func() {
+						slog.Warn("child ignored signal, killing it", "pgid", pgid)
+						syscall.Kill(-pgid, syscall.SIGKILL)
+					})
+				}
+			case <-done:
+				if kill != nil {
+					kill.Stop()
+				}
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// killGroup kills the child and everything it started. pty.Start puts the
+// child in its own session, so its pid is also its process group id.
+func killGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,39 @@
+//go:build windows
+
+package authcmd
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"time"
+)
+
+// forwardSignals kills the child on an interrupt. Windows can't send a
+// console program a signal of its own, so there is nothing to relay and
+// nothing to wait grace for. The returned func stops it.
+func forwardSignals(cmd *exec.Cmd, grace time.Duration) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			slog.Info("killing child", "signal", sig, "pid", cmd.Process.Pid)
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// killGroup kills the child. Its own children aren't tracked on Windows.
+func killGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
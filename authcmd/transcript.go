@@ -0,0 +1,108 @@
+package authcmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ved/test/ptyauto"
+)
+
+// transcripts keeps a transcript of every run in dir (-transcript-dir),
+// successful or not. An empty dir keeps nothing.
+type transcripts struct {
+	dir string
+	raw bool
+}
+
+// keep saves t and returns its path, "" when nothing was saved.
+func (ts transcripts) keep(t transcript) string {
+	if ts.dir == "" {
+		return ""
+	}
+	path, err := t.write(ts.dir, ts.raw)
+	if err != nil {
+		slog.Warn("saving transcript failed", "err", err)
+		return ""
+	}
+	slog.Info("transcript saved", "path", path)
+	return path
+}
+
+// transcript is the record of one run.
+type transcript struct {
+	name     string
+	start    time.Time
+	sc       *ptyauto.Scenario
+	redactor *ptyauto.Redactor
+	steps    []ptyauto.StepResult
+	captures map[string]string
+	err      error
+	output   string
+}
+
+// write saves t as <dir>/<name>-<start>.txt and returns the path. The
+// output is redacted like the echo, and ANSI-stripped unless raw is set.
+// Captured values are written as they are, they are what the run was for.
+func (t transcript) write(dir string, raw bool) (string, error) {
+	redactor := t.redactor
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "started: %s\n", t.start.Format(time.RFC3339))
+	fmt.Fprintf(&b, "duration: %s\n", time.Since(t.start).Round(time.Millisecond))
+	fmt.Fprintf(&b, "cmd: %s\n", redactor.Redact(strings.Join(append([]string{t.sc.Cmd}, t.sc.Args...), " ")))
+	for _, r := range t.steps {
+		status := "ok"
+		if r.Skipped {
+			status = "skipped, optional and not matched"
+		}
+		if r.Finished {
+			status = "ok, finished early"
+		}
+		if r.Err != nil {
+			status = "failed: " + firstLine(redactor.Redact(r.Err.Error()))
+		}
+		if r.Retries > 0 {
+			status += fmt.Sprintf(", %d retries", r.Retries)
+		}
+		fmt.Fprintf(&b, "step %d: %s (%s)\n", r.Step, status, r.Duration.Round(time.Millisecond))
+	}
+
+	keys := make([]string, 0, len(t.captures))
+	for k := range t.captures {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "captured %s: %s\n", k, t.captures[k])
+	}
+
+	if t.err != nil {
+		fmt.Fprintf(&b, "result: failed: %s\n", firstLine(redactor.Redact(t.err.Error())))
+	} else {
+		fmt.Fprintln(&b, "result: ok")
+	}
+
+	output := redactor.Redact(t.output)
+	if !raw {
+		output = ptyauto.StripANSI(output)
+	}
+	fmt.Fprintf(&b, "\n--- output ---\n%s\n", output)
+
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.txt", t.name, t.start.UTC().Format("20060102T150405.000Z")))
+	return path, os.WriteFile(path, []byte(b.String()), 0o600)
+}
+
+// firstLine cuts the output tail scenario errors carry, the transcript has
+// the whole output anyway.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
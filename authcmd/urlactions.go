@@ -0,0 +1,131 @@
+package authcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"ved/test/ptyauto"
+)
+
+// urlActions is what happens to a captured auth URL besides logging it:
+// -open starts the browser on it, -copy puts it on the clipboard and
+// -url-out writes it to a file for a CI pipeline to pick up. Only https
+// URLs that pass ptyauto.CleanURL are opened or copied.
+type urlActions struct {
+	open bool
+	copy bool
+	out  string
+}
+
+// handle does the actions for url. Opening and copying are conveniences
+// and only warn when they fail, a -url-out that can't be written fails the
+// run: the pipeline waiting for it would not get it.
+func (a urlActions) handle(url string) error {
+	if a.open || a.copy {
+		clean, err := ptyauto.CleanURL(url)
+		if err != nil {
+			slog.Warn("not opening or copying the url", "err", err)
+		} else {
+			if a.open {
+				if err := openBrowser(clean); err != nil {
+					slog.Warn("opening the browser failed, open the url yourself", "err", err)
+				}
+			}
+			if a.copy {
+				if err := copyToClipboard(clean); err != nil {
+					slog.Warn("copying the url failed", "err", err)
+				} else {
+					slog.Info("url copied to the clipboard")
+				}
+			}
+		}
+	}
+	if a.out != "" {
+		return writeURLs(a.out, []string{url})
+	}
+	return nil
+}
+
+// writeURLs writes urls to path one per line, readable only by us: an
+// auth URL lets whoever opens it first sign in.
+func writeURLs(path string, urls []string) error {
+	var b strings.Builder
+	for _, u := range urls {
+		b.WriteString(u + "\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("writing -url-out: %w", err)
+	}
+	return nil
+}
+
+// openBrowser opens url in the default browser without waiting for it.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		// start would need cmd.exe and its quoting, & is common in URLs
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// clipboardTimeout bounds a clipboard command, xclip without a display can
+// hang.
+const clipboardTimeout = 5 * time.Second
+
+// copyToClipboard puts text on the clipboard with the platform's tool:
+// pbcopy, clip, or on Linux and the BSDs wl-copy under Wayland, else xclip
+// or xsel.
+func copyToClipboard(text string) error {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip"}}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append(candidates, []string{"wl-copy"})
+		}
+		candidates = append(candidates, []string{"xclip", "-selection", "clipboard"}, []string{"xsel", "--clipboard", "--input"})
+	}
+
+	for _, c := range candidates {
+		path, err := exec.LookPath(c[0])
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), clipboardTimeout)
+		cmd := exec.CommandContext(ctx, path, c[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		// no output pipes: xclip forks to keep serving the selection and
+		// would hold them open
+		err = cmd.Run()
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%s: %w", c[0], err)
+		}
+		return nil
+	}
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c[0]
+	}
+	return errors.New("no clipboard tool found, tried " + strings.Join(names, ", "))
+}
@@ -0,0 +1,138 @@
+// Command devops is both tools in one binary:
+//
+//	devops disk report [flags]        disk usage report, what day1 runs
+//	devops disk watch [flags]         a report every -watch interval (1m)
+//	devops disk top                   live usage of every mount, like htop
+//	devops disk check -fail-over 90% -baseline baseline.json
+//	devops disk io -interval 10s      processes reading and writing the most
+//	devops disk diff old.json new.json
+//	devops disk history -mount / -since 7d
+//	devops disk k8s [flags]           nodes and volume claims of a cluster
+//	devops disk largest -top 50 -older-than 30d /var
+//	devops disk clean -dry-run
+//	devops disk docker
+//	devops auto mcp [flags]           claude /mcp authentication, what test.go runs
+//	devops auto mcp -sessions sessions.yaml -max-parallel 4
+//	devops auto replay session.cast   play back an -record recording
+//	devops serve [flags]              HTTP API for disk reports and MCP authentication
+//
+// Every command takes -h for its flags. devops --config file.yaml <command>
+// reads the configuration from file.yaml, see package config.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"ved/test/authcmd"
+	"ved/test/config"
+	"ved/test/diskcmd"
+	"ved/test/servecmd"
+)
+
+// command is one word of a command line: it runs, or dispatches the next
+// word to its subcommands.
+type command struct {
+	name    string
+	summary string
+	run     func(name string, args []string) int
+	sub     []*command
+}
+
+var root = &command{name: "devops", sub: []*command{
+	{name: "disk", summary: "report disk usage", sub: []*command{
+		{name: "report", summary: "collect one report and print it", run: disk()},
+		{name: "watch", summary: "collect a report every -watch interval, 1m by default", run: disk("-watch=1m")},
+		{name: "top", summary: "live usage bars of every mount, open one to see its largest directories", run: diskcmd.Top},
+		{name: "check", summary: "exit 1 when a mount is too full or grew too much since a baseline, for CI", run: diskcmd.Check},
+		{name: "io", summary: "the processes reading and writing the disks the most, from /proc/<pid>/io (Linux)", run: diskcmd.IO},
+		{name: "diff", summary: "compare two -format json reports", run: disk("-diff")},
+		{name: "history", summary: "usage recorded with -history, growth per day and when mounts run full", run: diskcmd.History},
+		{name: "k8s", summary: "node filesystems and persistent volume claims of a Kubernetes cluster, flags as for report", run: disk("-kube")},
+		{name: "largest", summary: "the largest files under a directory, with age and owner", run: diskcmd.Largest},
+		{name: "clean", summary: "remove old temp files, rotated logs and other stale artifacts, -dry-run lists them", run: diskcmd.Clean},
+		{name: "docker", summary: "space used by docker images, containers, volumes and build cache", run: diskcmd.Docker},
+	}},
+	{name: "auto", summary: "automate interactive programs", sub: []*command{
+		{name: "mcp", summary: "authenticate claude's MCP servers and print the auth URLs", run: authcmd.Main},
+		{name: "replay", summary: "play back a -record recording or run a -script against it", run: authcmd.Replay},
+	}},
+	{name: "serve", summary: "serve disk reports and MCP authentication over an HTTP API", run: servecmd.Main},
+}}
+
+// disk runs diskcmd with flags in front of the arguments, those given on
+// the command line still win.
+func disk(flags ...string) func(string, []string) int {
+	return func(name string, args []string) int {
+		diskcmd.Main(name, append(flags, args...))
+		return 0
+	}
+}
+
+func main() {
+	os.Exit(root.dispatch(root.name, configFlag(os.Args[1:])))
+}
+
+// configFlag takes a leading --config file or --config=file (one dash
+// works too) off args and makes every command read that file.
+func configFlag(args []string) []string {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "-") {
+		return args
+	}
+	name, path, hasPath := strings.Cut(strings.TrimLeft(args[0], "-"), "=")
+	if name != "config" {
+		return args
+	}
+	if !hasPath {
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "devops: --config needs a file")
+			os.Exit(2)
+		}
+		path, args = args[1], args[1:]
+	}
+	config.Path = path
+	return args[1:]
+}
+
+// dispatch runs the subcommand args starts with, name is the command line
+// so far.
+func (c *command) dispatch(name string, args []string) int {
+	if c.run != nil {
+		return c.run(name, args)
+	}
+	if len(args) == 0 {
+		c.usage(os.Stderr, name)
+		return 2
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		c.usage(os.Stdout, name)
+		return 0
+	}
+	for _, sub := range c.sub {
+		if sub.name == args[0] {
+			return sub.dispatch(name+" "+sub.name, args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", name, args[0])
+	c.usage(os.Stderr, name)
+	return 2
+}
+
+func (c *command) usage(w io.Writer, name string) {
+	usage := name
+	if c == root {
+		usage += " [--config file]"
+	}
+	fmt.Fprintf(w, "usage: %s <command> [flags]\n\ncommands:\n", usage)
+	width := 0
+	for _, sub := range c.sub {
+		width = max(width, len(sub.name))
+	}
+	for _, sub := range c.sub {
+		fmt.Fprintf(w, "  %s%s  %s\n", sub.name, strings.Repeat(" ", width-len(sub.name)), sub.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for a command's flags.\n", name)
+}
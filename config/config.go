@@ -0,0 +1,375 @@
+// Package config reads config.yaml, the settings of every devops command
+// that differ between machines: where claude, kubectl and ssh are, which
+// directory claude starts in, the pty size, and defaults for any command's
+// flags, like thresholds, the alert rules file or the API's address.
+//
+//	binaries:
+//	  claude: /opt/homebrew/bin/claude
+//	claude:
+//	  dir: ~/src/app
+//	pty:
+//	  rows: 50
+//	  cols: 200
+//	flags:
+//	  disk:
+//	    threshold: 85
+//	    rules: /etc/devops/rules.yaml
+//	  disk history:
+//	    since: 30d
+//	  auto mcp:
+//	    max-parallel: 8
+//
+// The file is the one given with devops --config or $DEVOPS_CONFIG, else
+// config.yaml in the current directory, else ~/.config/devops/config.yaml.
+// None is fine, the defaults apply. Every setting can be overridden with a
+// DEVOPS_ environment variable named after its key: DEVOPS_PTY_ROWS,
+// DEVOPS_BINARIES_CLAUDE, DEVOPS_DISK_THRESHOLD, DEVOPS_AUTO_MCP_MAX_PARALLEL.
+// A flag given on the command line wins over both.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Path is the file devops --config names, $DEVOPS_CONFIG when empty.
+var Path string
+
+// pathEnv names the file without --config.
+const pathEnv = "DEVOPS_CONFIG"
+
+// Commands are the keys of the flags section, one per flag set.
+var Commands = []string{
+	"disk", "disk report", "disk watch", "disk diff", "disk k8s",
+	"disk top", "disk check", "disk io", "disk history", "disk largest", "disk clean", "disk docker",
+	"auto mcp", "auto replay", "serve",
+}
+
+// Config is config.yaml with the environment overrides applied.
+type Config struct {
+	Binaries struct {
+		Claude  string `yaml:"claude"`
+		Kubectl string `yaml:"kubectl"`
+		SSH     string `yaml:"ssh"`
+	} `yaml:"binaries"`
+	// Claude is how the built-in MCP flow starts claude. Its MCP servers
+	// are configured per directory, Dir is the one to authenticate.
+	Claude struct {
+		Dir  string `yaml:"dir"`
+		Term string `yaml:"term"`
+	} `yaml:"claude"`
+	// PTY is the size of the pty automated programs get when there is no
+	// terminal to take it from, under CI or devops serve.
+	PTY struct {
+		Rows int `yaml:"rows"`
+		Cols int `yaml:"cols"`
+	} `yaml:"pty"`
+	// Flags are flag defaults by command, as given on the command line.
+	// A list sets a repeatable flag several times.
+	Flags map[string]map[string]yaml.Node `yaml:"flags"`
+
+	// File is the file read, "" when there was none.
+	File string `yaml:"-"`
+}
+
+// Load returns the configuration, read once per process and again by
+// Reload.
+func Load() (*Config, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if current != nil {
+		return current, nil
+	}
+	return reload()
+}
+
+// Reload reads the configuration again, for a daemon told to on SIGHUP,
+// and Load returns it from then on. When it fails Load keeps returning
+// the one before.
+func Reload() (*Config, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return reload()
+}
+
+var (
+	mu      sync.Mutex
+	current *Config
+)
+
+func reload() (*Config, error) {
+	path, explicit := Path, true
+	if path == "" {
+		path = os.Getenv(pathEnv)
+	}
+	if path == "" {
+		explicit = false
+		path = find()
+	}
+	c, err := read(path, explicit)
+	if err != nil {
+		return nil, err
+	}
+	if c.File != "" {
+		slog.Debug("configuration read", "path", c.File)
+	}
+	current = c
+	return c, nil
+}
+
+// find returns the first config.yaml of the search path, "" if none is
+// there.
+func find() string {
+	candidates := []string{"config.yaml"}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, "devops", "config.yaml"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".config", "devops", "config.yaml"))
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// read parses path, none when empty, applies the defaults and the
+// environment and validates the result. A missing file is only an error
+// when it was asked for.
+func read(path string, explicit bool) (*Config, error) {
+	c := &Config{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			dec := yaml.NewDecoder(bytes.NewReader(data))
+			dec.KnownFields(true)
+			if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			c.File = path
+		case explicit || !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+	}
+	if err := c.setDefaults(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// setting is one key of the sections other than flags.
+type setting struct {
+	key string
+	str *string
+	num *int
+	def string
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "binaries.claude", str: &c.Binaries.Claude, def: "claude"},
+		{key: "binaries.kubectl", str: &c.Binaries.Kubectl, def: "kubectl"},
+		{key: "binaries.ssh", str: &c.Binaries.SSH, def: "ssh"},
+		// the home directory is where claude keeps the user's MCP servers
+		{key: "claude.dir", str: &c.Claude.Dir, def: "~"},
+		{key: "claude.term", str: &c.Claude.Term, def: "xterm-256color"},
+		// 0 is ptyauto.DefaultRows and DefaultCols
+		{key: "pty.rows", num: &c.PTY.Rows},
+		{key: "pty.cols", num: &c.PTY.Cols},
+	}
+}
+
+// setDefaults overrides the settings with the environment, fills in the
+// defaults and checks them. Errors name the key and where its value came
+// from.
+func (c *Config) setDefaults() error {
+	for _, st := range c.settings() {
+		source := c.File
+		if v, ok := os.LookupEnv(envName(st.key)); ok {
+			source = "$" + envName(st.key)
+			if st.num != nil {
+				n, err := strconv.Atoi(strings.TrimSpace(v))
+				if err != nil {
+					return fmt.Errorf("%s: %s: %q is not a number", source, st.key, v)
+				}
+				*st.num = n
+			} else {
+				*st.str = v
+			}
+		}
+
+		if st.num != nil {
+			if *st.num < 0 || *st.num > 1000 {
+				return fmt.Errorf("%s: %s: %d, want 1 to 1000, or 0 for the default", source, st.key, *st.num)
+			}
+			continue
+		}
+		if *st.str == "" {
+			*st.str = st.def
+		}
+		if *st.str == "~" || strings.HasPrefix(*st.str, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return fmt.Errorf("%s: %s: %w", source, st.key, err)
+			}
+			*st.str = filepath.Join(home, strings.TrimPrefix(*st.str, "~"))
+		}
+		switch {
+		case st.key == "claude.dir" && !filepath.IsAbs(*st.str):
+			return fmt.Errorf("%s: %s: %q, want an absolute path or one starting with ~/", source, st.key, *st.str)
+		case st.key == "claude.term" && strings.ContainsAny(*st.str, " \t\n="):
+			return fmt.Errorf("%s: %s: %q is not a terminal type", source, st.key, *st.str)
+		}
+	}
+
+	for command := range c.Flags {
+		if !slices.Contains(Commands, command) {
+			return fmt.Errorf("%s: flags.%s: no such command, want one of %s", c.File, command, strings.Join(Commands, ", "))
+		}
+	}
+	return nil
+}
+
+// envName is the variable overriding key: DEVOPS_ and the key in upper
+// case with _ between the words.
+func envName(key ...string) string {
+	name := strings.ToUpper(strings.Join(key, "_"))
+	return "DEVOPS_" + strings.NewReplacer(".", "_", "-", "_", " ", "_").Replace(name)
+}
+
+// Command is the flags section of the devops command line name, e.g.
+// "disk watch" for "devops disk watch", "" for any other name.
+func Command(name string) string {
+	if command, ok := strings.CutPrefix(name, "devops "); ok && slices.Contains(Commands, command) {
+		return command
+	}
+	return ""
+}
+
+// Apply sets the flags of fs not given on the command line from the flags
+// sections of the configuration, or the environment, which wins.
+// A flag in several sections takes the value of the last one, empty
+// sections are skipped. Call it right after fs.Parse. A flag the command
+// doesn't have or a value it doesn't take is an error naming the key.
+func Apply(fs *flag.FlagSet, sections ...string) error {
+	c, err := Load()
+	if err != nil {
+		return err
+	}
+	return c.apply(fs, sections)
+}
+
+func (c *Config) apply(fs *flag.FlagSet, sections []string) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	type value struct {
+		source string
+		values []string
+	}
+	values := map[string]value{}
+	for _, section := range sections {
+		if section == "" {
+			continue
+		}
+		names := make([]string, 0, len(c.Flags[section]))
+		for name := range c.Flags[section] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			node := c.Flags[section][name]
+			source := fmt.Sprintf("%s:%d: flags.%s.%s", c.File, node.Line, section, name)
+			if fs.Lookup(name) == nil {
+				return fmt.Errorf("%s: %s has no flag -%s", source, section, name)
+			}
+			vs, ok := nodeValues(node)
+			if !ok {
+				return fmt.Errorf("%s: want a value or a list of values", source)
+			}
+			values[name] = value{source, vs}
+		}
+	}
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		for _, section := range sections {
+			if section == "" {
+				continue
+			}
+			if v, ok := os.LookupEnv(envName(section, f.Name)); ok {
+				values[f.Name] = value{"$" + envName(section, f.Name), []string{v}}
+			}
+		}
+		v, ok := values[f.Name]
+		if !ok || given[f.Name] {
+			return
+		}
+		for _, s := range v.values {
+			if err := fs.Set(f.Name, s); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", v.source, err))
+				return
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// nodeValues are the values of a flag in the flags sections, false when it
+// is neither a value nor a list of them.
+func nodeValues(node yaml.Node) ([]string, bool) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []string{node.Value}, true
+	case yaml.SequenceNode:
+		var vs []string
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, false
+			}
+			vs = append(vs, item.Value)
+		}
+		return vs, true
+	}
+	return nil, false
+}
+
+// Flag is what the flags sections, or the environment, set the flag name
+// to, as Apply would: the values of the last of sections that has it.
+// ok is false when none does, the flag then has its default.
+func (c *Config) Flag(name string, sections ...string) (values []string, ok bool) {
+	for _, section := range sections {
+		if section == "" {
+			continue
+		}
+		if node, found := c.Flags[section][name]; found {
+			if vs, valid := nodeValues(node); valid {
+				values, ok = vs, true
+			}
+		}
+	}
+	for _, section := range sections {
+		if section == "" {
+			continue
+		}
+		if v, found := os.LookupEnv(envName(section, name)); found {
+			values, ok = []string{v}, true
+		}
+	}
+	return values, ok
+}
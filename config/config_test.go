@@ -0,0 +1,161 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `binaries:
+  claude: /opt/claude/bin/claude
+claude:
+  dir: ~/src/app
+pty:
+  rows: 50
+flags:
+  disk:
+    threshold: 85
+    exclude: ["*.iso", "cache"]
+  disk watch:
+    watch: 5m
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", "/home/me")
+	t.Setenv("DEVOPS_PTY_COLS", "200")
+
+	c, err := read(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Binaries.Claude != "/opt/claude/bin/claude" || c.Binaries.SSH != "ssh" {
+		t.Errorf("binaries %+v", c.Binaries)
+	}
+	if c.Claude.Dir != "/home/me/src/app" || c.Claude.Term != "xterm-256color" {
+		t.Errorf("claude %+v", c.Claude)
+	}
+	if c.PTY.Rows != 50 || c.PTY.Cols != 200 {
+		t.Errorf("pty %+v, want 50x200", c.PTY)
+	}
+
+	fs := flag.NewFlagSet("devops disk watch", flag.ContinueOnError)
+	threshold := fs.Int("threshold", 90, "")
+	watch := fs.Duration("watch", 0, "")
+	format := fs.String("format", "table", "")
+	var exclude []string
+	fs.Func("exclude", "", func(v string) error {
+		exclude = append(exclude, v)
+		return nil
+	})
+	fs.Parse([]string{"-threshold", "70"})
+	t.Setenv("DEVOPS_DISK_WATCH_FORMAT", "json")
+	if err := c.apply(fs, []string{"disk", "disk watch"}); err != nil {
+		t.Fatal(err)
+	}
+	// the command line wins over the file, the environment over the file
+	if *threshold != 70 || *watch != 5*time.Minute || *format != "json" || strings.Join(exclude, " ") != "*.iso cache" {
+		t.Errorf("threshold %d, watch %s, format %s, exclude %v", *threshold, *watch, *format, exclude)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, tt := range map[string]struct {
+		data, env, want string
+	}{
+		"unknown key":     {data: "pty:\n  row: 50\n", want: "field row not found"},
+		"unknown command": {data: "flags:\n  disk reprot:\n    threshold: 85\n", want: "flags.disk reprot: no such command"},
+		"relative dir":    {data: "claude:\n  dir: src\n", want: "claude.dir"},
+		"env size":        {env: "DEVOPS_PTY_ROWS=big", want: "$DEVOPS_PTY_ROWS: pty.rows"},
+		"size":            {data: "pty:\n  cols: -1\n", want: "pty.cols"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".yaml")
+			os.WriteFile(path, []byte(tt.data), 0o600)
+			if k, v, ok := strings.Cut(tt.env, "="); ok {
+				t.Setenv(k, v)
+			}
+			_, err := read(path, true)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := read(filepath.Join(dir, "missing.yaml"), true); err == nil {
+		t.Error("a missing --config file is no error")
+	}
+	if _, err := read(filepath.Join(dir, "missing.yaml"), false); err != nil {
+		t.Errorf("no config.yaml found: %v", err)
+	}
+
+	c := &Config{File: "config.yaml"}
+	if err := yamlFlags(c, "disk:\n  treshold: 85\n"); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("disk", flag.ContinueOnError)
+	fs.Int("threshold", 90, "")
+	if err := c.apply(fs, []string{"disk"}); err == nil || !strings.Contains(err.Error(), "config.yaml:2: flags.disk.treshold") {
+		t.Errorf("got %v, want an error naming flags.disk.treshold and its line", err)
+	}
+	if err := yamlFlags(c, "disk:\n  threshold: lots\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.apply(fs, []string{"disk"}); err == nil || !strings.Contains(err.Error(), "flags.disk.threshold") {
+		t.Errorf("got %v, want an error naming flags.disk.threshold", err)
+	}
+}
+
+// yamlFlags sets c's flags sections from data.
+func yamlFlags(c *Config, data string) error {
+	return yaml.Unmarshal([]byte(data), &c.Flags)
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("flags:\n  disk:\n    threshold: 85\n    rules: /etc/rules.yaml\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(p string) { Path, current = p, nil }(Path)
+	Path, current = path, nil
+
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := c.Flag("threshold", "disk", "disk watch"); !ok || v[0] != "85" {
+		t.Errorf("threshold %v %v, want 85", v, ok)
+	}
+
+	os.WriteFile(path, []byte("flags:\n  disk watch:\n    threshold: 75\n"), 0o600)
+	t.Setenv("DEVOPS_DISK_WATCH_SUSTAINED", "5m")
+	if c, err = Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := c.Flag("threshold", "disk", "disk watch"); !ok || v[0] != "75" {
+		t.Errorf("threshold %v %v after reload, want 75", v, ok)
+	}
+	if v, ok := c.Flag("rules", "disk", "disk watch"); ok {
+		t.Errorf("rules %v after reload, want none", v)
+	}
+	if v, ok := c.Flag("sustained", "disk", "disk watch"); !ok || v[0] != "5m" {
+		t.Errorf("sustained %v %v, want 5m from the environment", v, ok)
+	}
+
+	// a bad file leaves the configuration as it was
+	os.WriteFile(path, []byte("flags: [\n"), 0o600)
+	if _, err := Reload(); err == nil {
+		t.Error("reloading a bad file succeeded")
+	}
+	if l, _ := Load(); l != c {
+		t.Error("a failed reload replaced the configuration")
+	}
+}
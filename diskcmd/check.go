@@ -0,0 +1,294 @@
+package diskcmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ved/test/config"
+	"ved/test/diskusage"
+)
+
+// Check is `devops disk check`: it collects usage, or reads a report, and
+// fails when a mount is fuller than -fail-over or grew more than
+// -max-growth since the -baseline report, so a CI pipeline can gate on disk
+// headroom by exit code. It returns 0 when every mount passed, 1 when one
+// failed and 2 when it couldn't check.
+func Check(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] [report.json]\n\nChecks the usage of every mount, or of the mounts in a -format json report.\n\n", name)
+		fs.PrintDefaults()
+	}
+	failOver := fs.String("fail-over", "90%", "fail when a mount is used more than this percent, 0 to not check")
+	baseline := fs.String("baseline", "", "a -format json report to compare with, from disk report or -update-baseline")
+	maxGrowth := fs.String("max-growth", "5%", "with -baseline, fail when a mount's use grew more than this many percent points (5%) or bytes (10G), or either (5%,10G)")
+	update := fs.Bool("update-baseline", false, "save the usage as the new -baseline when the check passes, or when there is none yet")
+	var mounts []string
+	fs.Func("mount", "only check this mount point, repeatable", func(v string) error {
+		mounts = append(mounts, v)
+		return nil
+	})
+	collectorKind := fs.String("collector", "auto", "how filesystem usage is collected: statfs, df, or auto for statfs where available")
+	mountsFile := fs.String("mounts", "/proc/mounts", "mount table used for filesystem types")
+	mountTimeout := fs.Duration("mount-timeout", 5*time.Second, "timeout for each mount")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout for collecting usage")
+	format := fs.String("format", "text", "output format: text or json")
+	logs.Flags(fs)
+	fs.Parse(args)
+	configErr := config.Apply(fs, "disk check")
+
+	if err := logs.Open(name); err != nil {
+		slog.Error("bad logging flags", "err", err)
+		return 2
+	}
+	defer logs.Close(0)
+	slog.SetDefault(slog.New(logs.Handler()))
+	if configErr != nil {
+		slog.Error("bad configuration", "err", configErr)
+		return 2
+	}
+
+	var limits diskusage.CheckLimits
+	p, err := parsePercent(*failOver)
+	if err != nil {
+		slog.Error("bad -fail-over", "err", err)
+		return 2
+	}
+	limits.MaxPercent = p
+	for _, v := range strings.Split(*maxGrowth, ",") {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+		case strings.HasSuffix(v, "%"):
+			limits.MaxGrowthPoints, err = parsePercent(v)
+		default:
+			limits.MaxGrowthBytes, err = diskusage.ParseSize(v)
+		}
+		if err != nil {
+			slog.Error("bad -max-growth", "err", err)
+			return 2
+		}
+	}
+	if *format != "text" && *format != "json" {
+		slog.Error("-format must be text or json")
+		return 2
+	}
+	if *update && *baseline == "" {
+		slog.Error("-update-baseline needs -baseline")
+		return 2
+	}
+	if fs.NArg() > 1 {
+		slog.Error("check takes at most one report")
+		return 2
+	}
+
+	var before *diskusage.Report
+	if *baseline != "" {
+		r, err := diskusage.LoadReport(*baseline)
+		switch {
+		case err == nil:
+			before = &r
+		case *update && errors.Is(err, os.ErrNotExist):
+			slog.Info("no baseline yet, growth isn't checked", "baseline", *baseline)
+		default:
+			slog.Error("loading the baseline failed", "err", err)
+			return 2
+		}
+	}
+
+	var report diskusage.Report
+	if fs.NArg() == 1 {
+		report, err = diskusage.LoadReport(fs.Arg(0))
+		if err != nil {
+			slog.Error("loading the report failed", "err", err)
+			return 2
+		}
+	} else {
+		report, err = collectCheck(*collectorKind, *mountsFile, *mountTimeout, *timeout)
+		if err != nil {
+			slog.Error("collecting disk usage failed", "err", err)
+			return 2
+		}
+	}
+	if len(mounts) > 0 {
+		only := func(filesystems []diskusage.Filesystem) []diskusage.Filesystem {
+			return slices.DeleteFunc(filesystems, func(f diskusage.Filesystem) bool { return !slices.Contains(mounts, f.MountPoint) })
+		}
+		report.Filesystems = only(report.Filesystems)
+		if before != nil {
+			before.Filesystems = only(before.Filesystems)
+		}
+		for _, m := range mounts {
+			if !slices.ContainsFunc(report.Filesystems, func(f diskusage.Filesystem) bool { return f.MountPoint == m }) {
+				slog.Error("mount not found", "mount", m)
+				return 2
+			}
+		}
+	}
+
+	checks := diskusage.Check(report.Filesystems, before, limits)
+	failed := diskusage.CheckFailed(checks)
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(struct {
+			OK       bool                   `json:"ok"`
+			Limits   diskusage.CheckLimits  `json:"limits"`
+			Baseline string                 `json:"baseline,omitempty"`
+			Mounts   []diskusage.MountCheck `json:"mounts"`
+		}{!failed, limits, *baseline, checks})
+	} else {
+		err = printChecks(os.Stdout, checks, before != nil)
+	}
+	if err != nil {
+		slog.Error("writing the check failed", "err", err)
+		return 2
+	}
+
+	if *update && !failed {
+		if err := saveBaseline(*baseline, report); err != nil {
+			slog.Error("saving the baseline failed", "err", err)
+			return 2
+		}
+		slog.Info("baseline saved", "baseline", *baseline)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// collectCheck collects the usage of the real and network filesystems.
+func collectCheck(kind, mountsFile string, mountTimeout, timeout time.Duration) (diskusage.Report, error) {
+	include := map[string]bool{diskusage.ClassReal: true, diskusage.ClassNetwork: true}
+	collector, err := diskusage.NewCollector(kind, diskusage.DfCollector{
+		MountsFile: mountsFile,
+		Exact:      true,
+		PerMount:   true,
+		Timeout:    mountTimeout,
+		Include:    include,
+	})
+	if err != nil {
+		return diskusage.Report{}, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	report := diskusage.Report{SchemaVersion: diskusage.SchemaVersion, Time: time.Now()}
+	filesystems, err := collector.Collect(ctx)
+	if err != nil && len(filesystems) == 0 {
+		return report, err
+	}
+	if err != nil {
+		slog.Warn("some mounts weren't collected", "err", err)
+	}
+	report.Filesystems = diskusage.FilterClasses(filesystems, include)
+	report.Sort()
+	return report, nil
+}
+
+// printChecks writes a line per mount with its use, its growth when there
+// is a baseline, and why it failed, then the verdict.
+func printChecks(w io.Writer, checks []diskusage.MountCheck, withBaseline bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if withBaseline {
+		fmt.Fprintln(tw, "Mount\tUse\tBaseline\tGrowth\tResult")
+	} else {
+		fmt.Fprintln(tw, "Mount\tUse\tResult")
+	}
+	failed := 0
+	for _, m := range checks {
+		result := "ok"
+		switch {
+		case m.Missing:
+			result = "not mounted"
+		case len(m.Failures) > 0:
+			result = "FAIL " + strings.Join(m.Failures, "; ")
+			failed++
+		}
+		use := "-"
+		if !m.Missing && m.Status == "" {
+			use = formatPercent(m.UsePercent)
+		}
+		if !withBaseline {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", m.MountPoint, use, result)
+			continue
+		}
+		base, growth := "-", "-"
+		if m.Baseline != nil {
+			base = formatPercent(m.Baseline.Percent())
+		}
+		if m.Baseline != nil && !m.Missing && m.Status == "" {
+			growth = fmt.Sprintf("%+.1f (%s)", m.GrowthPoints(), signedBytes(m.GrowthBytes()))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", m.MountPoint, use, base, growth, result)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	var err error
+	if failed > 0 {
+		_, err = fmt.Fprintf(w, "\nFAIL: %d of %d mounts over their limits\n", failed, len(checks))
+	} else {
+		_, err = fmt.Fprintf(w, "\nOK: %d mounts within their limits\n", len(checks))
+	}
+	return err
+}
+
+// saveBaseline writes report to path as -format json does, through a
+// temporary file so a baseline is never half written.
+func saveBaseline(path string, report diskusage.Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// parsePercent reads a percentage like 90 or 90%.
+func parsePercent(s string) (float64, error) {
+	p, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || p < 0 || p > 100 {
+		return 0, fmt.Errorf("%q: want a percentage from 0 to 100", s)
+	}
+	return p, nil
+}
+
+// formatPercent is p with at most one decimal.
+func formatPercent(p float64) string {
+	return strconv.FormatFloat(math.Round(p*10)/10, 'f', -1, 64) + "%"
+}
+
+// signedBytes is n like humanBytes, with a sign.
+func signedBytes(n int64) string {
+	if n < 0 {
+		return "-" + humanBytes(-n)
+	}
+	return "+" + humanBytes(n)
+}
@@ -0,0 +1,56 @@
+package diskcmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"ved/test/diskusage"
+)
+
+func TestParsePercent(t *testing.T) {
+	for in, want := range map[string]float64{"90": 90, "90%": 90, " 12.5% ": 12.5, "0": 0, "100%": 100} {
+		if got, err := parsePercent(in); err != nil || got != want {
+			t.Errorf("%q: got %v, %v, want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "%", "ninety", "-1", "101%", "90%%"} {
+		if _, err := parsePercent(in); err == nil {
+			t.Errorf("%q: got no error", in)
+		}
+	}
+}
+
+func TestPrintChecks(t *testing.T) {
+	base := &diskusage.Filesystem{MountPoint: "/", Size: 1000, Used: 500, Avail: 500, UsePercent: 50, Status: diskusage.StatusOK}
+	checks := []diskusage.MountCheck{
+		{MountPoint: "/", UsePercent: 92, Used: 920, Baseline: base, Failures: []string{"92% used, over 90%"}},
+		{MountPoint: "/data", UsePercent: 10.04, Used: 100},
+		{MountPoint: "/mnt/nfs", Status: diskusage.StatusStale, Failures: []string{"no stats: stale"}},
+		{MountPoint: "/old", Baseline: base, Missing: true},
+	}
+
+	var out bytes.Buffer
+	if err := printChecks(&out, checks, true); err != nil {
+		t.Fatal(err)
+	}
+	want := `Mount     Use  Baseline  Growth        Result
+/         92%  50%       +42.0 (+420)  FAIL 92% used, over 90%
+/data     10%  -         -             ok
+/mnt/nfs  -    -         -             FAIL no stats: stale
+/old      -    50%       -             not mounted
+
+FAIL: 2 of 4 mounts over their limits
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := printChecks(&out, checks[1:2], false); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); !strings.HasPrefix(got, "Mount  Use  Result\n/data  10%  ok\n") || !strings.HasSuffix(got, "OK: 1 mounts within their limits\n") {
+		t.Errorf("without baseline:\n%s", got)
+	}
+}
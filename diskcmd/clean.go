@@ -0,0 +1,201 @@
+package diskcmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+
+	"ved/test/config"
+	"ved/test/diskusage"
+)
+
+// Clean is `devops disk clean`: it removes what the clean rules match,
+// after listing it and asking. With -dry-run it only lists. Every removal
+// is appended to the audit log. It returns the exit code.
+func Clean(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	rulesFile := fs.String("config", "", "YAML file of clean rules, replaces the defaults (temp files and rotated logs untouched for 7 days)")
+	var only []string
+	fs.Func("rule", "only run this rule, repeatable", func(v string) error {
+		only = append(only, v)
+		return nil
+	})
+	dryRun := fs.Bool("dry-run", false, "list what would be removed and how much it would free, remove nothing")
+	yes := fs.Bool("yes", false, "remove without asking, for cron and scripts")
+	auditLog := fs.String("audit-log", filepath.Join(defaultStateDir(), "clean-audit.jsonl"), "JSON lines file every removal is appended to")
+	dockerHost := fs.String("docker-host", "", "Docker engine of the docker rules, unix:///path/docker.sock or tcp://host:port (default $DOCKER_HOST or "+diskusage.DefaultDockerHost+")")
+	format := fs.String("format", "table", "output format: table or json")
+	timeout := fs.Duration("timeout", 30*time.Minute, "give up after this long")
+	logs.Flags(fs)
+	fs.Parse(args)
+	configErr := config.Apply(fs, "disk clean")
+
+	if err := logs.Open(name); err != nil {
+		slog.Error("bad logging flags", "err", err)
+		return 2
+	}
+	defer logs.Close(0)
+	slog.SetDefault(slog.New(logs.Handler()))
+	if configErr != nil {
+		slog.Error("bad configuration", "err", configErr)
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		slog.Error("-format must be table or json")
+		return 2
+	}
+
+	rules := diskusage.DefaultCleanRules()
+	if *rulesFile != "" {
+		var err error
+		if rules, err = diskusage.LoadCleanRules(*rulesFile); err != nil {
+			slog.Error("loading clean rules failed", "err", err)
+			return 1
+		}
+	}
+	if len(only) > 0 {
+		if err := rules.Only(only); err != nil {
+			slog.Error("bad -rule", "err", err)
+			return 2
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	plan, err := diskusage.PlanClean(ctx, rules, *dockerHost, time.Now())
+	if err != nil {
+		slog.Error("looking for what to clean failed", "err", err)
+		return 1
+	}
+	for rule, msg := range plan.Errors {
+		slog.Warn("clean rule failed", "rule", rule, "err", msg)
+	}
+
+	if *format == "table" {
+		printCleanPlan(os.Stdout, plan, time.Now())
+	}
+	if *dryRun || len(plan.Targets) == 0 {
+		if *format == "table" {
+			if *dryRun && len(plan.Targets) > 0 {
+				fmt.Printf("Would free %s (dry run, nothing was removed)\n", humanBytes(plan.TotalBytes))
+			}
+			return 0
+		}
+		return writeCleanPlan(plan)
+	}
+
+	if !*yes {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			slog.Error("not removing anything without a terminal to ask on, use -yes or -dry-run")
+			return 2
+		}
+		if !confirm(os.Stdin, os.Stderr, fmt.Sprintf("Remove %d entries, freeing %s? [y/N] ", len(plan.Targets), humanBytes(plan.TotalBytes))) {
+			fmt.Fprintln(os.Stderr, "Nothing was removed.")
+			return 0
+		}
+	}
+
+	who := ""
+	if u, err := user.Current(); err == nil {
+		who = u.Username
+	}
+	code := 0
+	var freed int64
+	removed := 0
+	for i := range plan.Targets {
+		t := &plan.Targets[i]
+		if err := diskusage.RemoveTarget(ctx, *t, *dockerHost); err != nil {
+			t.Error = err.Error()
+			slog.Warn("not removed", "rule", t.Rule, "path", t.Path, "err", err)
+			code = 1
+		} else {
+			t.Removed = true
+			freed += t.Size
+			removed++
+			slog.Info("removed", "rule", t.Rule, "path", t.Path, "size_bytes", t.Size)
+		}
+		// right away, a removal must be in the log even when the run is
+		// killed halfway; without a log nothing more is removed
+		if err := diskusage.AppendCleanAudit(*auditLog, []diskusage.CleanAudit{{Time: time.Now(), User: who, CleanTarget: *t}}); err != nil {
+			slog.Error("writing the audit log failed, not removing the rest", "path", *auditLog, "err", err)
+			code = 1
+			break
+		}
+	}
+
+	if *format == "json" {
+		if c := writeCleanPlan(plan); c != 0 {
+			return c
+		}
+		return code
+	}
+	fmt.Printf("Removed %d of %d entries, freed %s\n", removed, len(plan.Targets), humanBytes(freed))
+	return code
+}
+
+func writeCleanPlan(plan *diskusage.CleanPlan) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(plan); err != nil {
+		slog.Error("writing the clean plan failed", "err", err)
+		return 1
+	}
+	return 0
+}
+
+// printCleanPlan lists the targets rule by rule with what each rule frees.
+func printCleanPlan(w io.Writer, plan *diskusage.CleanPlan, now time.Time) {
+	if len(plan.Targets) == 0 {
+		fmt.Fprintln(w, "Nothing to clean.")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Rule\tSize\tUnused for\tKind\tPath")
+	byRule := map[string]int64{}
+	var order []string
+	for _, t := range plan.Targets {
+		if _, ok := byRule[t.Rule]; !ok {
+			order = append(order, t.Rule)
+		}
+		byRule[t.Rule] += t.Size
+		// an image's time is when it was built, not last used
+		unused := "-"
+		if t.Kind != diskusage.CleanDockerImage && !t.ModTime.IsZero() {
+			unused = age(now.Sub(t.ModTime))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.Rule, humanBytes(t.Size), unused, t.Kind, t.Path)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, rule := range order {
+		fmt.Fprintf(tw, "%s\t%s\n", rule, humanBytes(byRule[rule]))
+	}
+	fmt.Fprintf(tw, "total\t%s\n", humanBytes(plan.TotalBytes))
+	tw.Flush()
+}
+
+// confirm asks question on w and tells whether the answer read from r
+// was yes.
+func confirm(r io.Reader, w io.Writer, question string) bool {
+	fmt.Fprint(w, question)
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package diskcmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+
+	"ved/test/diskusage"
+)
+
+// controlRequest is one line on the -control channel, e.g.
+//
+//	{"id": 1, "cmd": "set-threshold", "value": 80}
+//
+// The id is optional and copied to the response.
+type controlRequest struct {
+	ID    json.RawMessage `json:"id,omitempty"`
+	Cmd   string          `json:"cmd"`
+	Value int             `json:"value,omitempty"`
+}
+
+type controlResponse struct {
+	ID        json.RawMessage   `json:"id,omitempty"`
+	OK        bool              `json:"ok"`
+	Error     string            `json:"error,omitempty"`
+	Report    *diskusage.Report `json:"report,omitempty"`
+	Threshold int               `json:"threshold,omitempty"`
+}
+
+// controlCommand hands a request to the watch loop, which owns the settings
+// and answers on reply.
+type controlCommand struct {
+	req   controlRequest
+	reply chan controlResponse
+}
+
+// serveControl reads one JSON command per line from r and writes one JSON
+// response per line to w, until r ends or ctx is done.
+func serveControl(ctx context.Context, r io.Reader, w io.Writer, cmds chan<- controlCommand) {
+	enc := json.NewEncoder(w)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var req controlRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			enc.Encode(controlResponse{Error: "bad request: " + err.Error()})
+			continue
+		}
+
+		c := controlCommand{req: req, reply: make(chan controlResponse, 1)}
+		select {
+		case cmds <- c:
+		case <-ctx.Done():
+			return
+		}
+		resp := <-c.reply
+		resp.ID = req.ID
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// listenControl serves the control protocol on a unix socket, one
+// connection at a time is enough for a controller.
+func listenControl(ctx context.Context, path string, cmds chan<- controlCommand) error {
+	// a socket left over from a previous run would make Listen fail,
+	// anything else at path is not ours to delete
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	go func() {
+		defer os.Remove(path)
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					slog.Error("control socket failed", "err", err)
+				}
+				return
+			}
+			serveControl(ctx, conn, conn, cmds)
+			conn.Close()
+		}
+	}()
+
+	slog.Info("control socket listening", "path", path)
+	return nil
+}
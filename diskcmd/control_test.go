@@ -0,0 +1,69 @@
+package diskcmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"ved/test/diskusage"
+)
+
+func TestControl(t *testing.T) {
+	o := &options{threshold: 90}
+	cache := &lastGood{}
+	polls := 0
+	poll := func() (diskusage.Report, error) {
+		polls++
+		if polls > 1 {
+			return diskusage.Report{}, errors.New("df: timed out")
+		}
+		return diskusage.Report{DuSkipped: 3}, nil
+	}
+
+	cmds := make(chan controlCommand)
+	go func() {
+		for c := range cmds {
+			c.reply <- handleControl(c.req, o, poll, cache)
+		}
+	}()
+	defer close(cmds)
+
+	in := strings.Join([]string{
+		`{"id": 1, "cmd": "get"}`,
+		`{"id": "a", "cmd": "scan"}`,
+		`{"cmd": "scan"}`,
+		`{"id": 2, "cmd": "set-threshold", "value": 80}`,
+		`{"id": 3, "cmd": "set-threshold", "value": 101}`,
+		`{"cmd": "reboot"}`,
+		`not json`,
+	}, "\n")
+	var out bytes.Buffer
+	serveControl(context.Background(), strings.NewReader(in), &out, cmds)
+
+	want := []string{
+		`{"id":1,"ok":false,"error":"no successful collection yet"}`,
+		`{"id":"a","ok":true,"report":`,
+		`{"ok":false,"error":"df: timed out"}`,
+		`{"id":2,"ok":true,"threshold":80}`,
+		`{"id":3,"ok":false,"error":"value must be a percent between 1 and 100"}`,
+		`{"ok":false,"error":"unknown cmd reboot"}`,
+		`{"ok":false,"error":"bad request: `,
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d responses, want %d:\n%s", len(lines), len(want), out.String())
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(lines[i], w) {
+			t.Errorf("response %d: got %s, want %s", i+1, lines[i], w)
+		}
+	}
+	if !strings.Contains(lines[1], `"du_skipped":3`) || !strings.Contains(lines[1], `"schema_version"`) {
+		t.Errorf("scan report: %s", lines[1])
+	}
+	if o.threshold != 80 {
+		t.Errorf("threshold %d, want 80", o.threshold)
+	}
+}
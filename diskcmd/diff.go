@@ -0,0 +1,55 @@
+package diskcmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"ved/test/diskusage"
+)
+
+// runDiff compares two saved reports and returns the exit code: 0 when
+// they match within tolerance, 1 when they differ, 2 when one can't be read.
+func runDiff(pathA, pathB string, tolerance float64) int {
+	a, err := diskusage.LoadReport(pathA)
+	if err != nil {
+		slog.Error("loading report failed", "err", err)
+		return 2
+	}
+	b, err := diskusage.LoadReport(pathB)
+	if err != nil {
+		slog.Error("loading report failed", "err", err)
+		return 2
+	}
+
+	diffs := diskusage.Diff(a, b, tolerance)
+	printDiff(os.Stdout, diffs, pathA, pathB)
+	if len(diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// printDiff writes the mounts that differ between report a and b.
+func printDiff(w io.Writer, diffs []diskusage.MountDiff, nameA, nameB string) {
+	if len(diffs) == 0 {
+		fmt.Fprintln(w, "No differences.")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Mounted on\tDifference")
+	for _, d := range diffs {
+		switch d.Kind {
+		case diskusage.DiffOnlyA:
+			fmt.Fprintf(tw, "%s\tonly in %s\n", d.Name(), nameA)
+		case diskusage.DiffOnlyB:
+			fmt.Fprintf(tw, "%s\tonly in %s\n", d.Name(), nameB)
+		default:
+			fmt.Fprintf(tw, "%s\t%s\n", d.Name(), d.Reason)
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,837 @@
+// Package diskcmd is the disk usage tool: df and du collected into a report,
+// printed once, every -watch interval or served over HTTP.
+package diskcmd
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"ved/test/config"
+	"ved/test/diskusage"
+	"ved/test/duscan"
+	"ved/test/storage"
+	"ved/test/summary"
+)
+
+// options are the collection settings shared by one-shot and -watch runs.
+type options struct {
+	timeout      time.Duration
+	perMount     bool
+	inodes       bool
+	mountTimeout time.Duration
+	mountsFile   string
+	exact        bool
+	duPath       string
+	duTimeout    time.Duration
+	// duScanner is "native" for the duscan walker, "du" to run du
+	duScanner string
+	scan      duscan.Options
+	top       int
+	threshold int
+	// thresholdControlled is set once -control's set-threshold changed
+	// threshold, a reload keeps it like one given on the command line
+	thresholdControlled bool
+	dedupDevices        bool
+	duPriority          diskusage.Priority
+	countFiles          bool
+	lsblk               bool
+	byExtension         bool
+	sinceBoot           bool
+	stateDir            string
+	countTimeout        time.Duration
+	include             map[string]bool
+	collector           diskusage.Collector
+	budget              *diskusage.BudgetPlan
+	reclaim             *diskusage.ReclaimRules
+
+	includeStderr bool
+	stderrCap     int
+
+	// env is set for df and du on top of the C locale, -env
+	env []string
+
+	// debugDump prints what the collectors returned before anything is
+	// filtered or formatted
+	debugDump bool
+
+	// remote is set with -hosts and -kube, filesystems come from other
+	// machines and du doesn't run
+	remote bool
+
+	// paths is set with -paths-stdin, only those paths are reported
+	paths       []string
+	skipMissing bool
+}
+
+// Main runs the disk usage tool, name is the command it was started as.
+// It returns when the run succeeded, failures exit the process with their
+// code after writing the -summary-file.
+func Main(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	var o options
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "timeout for collecting one report")
+	collectorKind := fs.String("collector", "auto", "how filesystem usage is collected: statfs (GetDiskFreeSpaceEx on Windows, nothing is run), df, or auto for statfs where available")
+	fs.BoolVar(&o.inodes, "inodes", false, "with the df collector, also run df -i for inode usage (statfs always has it)")
+	fs.BoolVar(&o.perMount, "per-mount", false, "with the df collector, run df separately for every mount in -mounts so one stale mount can't block the report")
+	fs.DurationVar(&o.mountTimeout, "mount-timeout", 5*time.Second, "timeout for each mount with the statfs collector or -per-mount")
+	fs.StringVar(&o.mountsFile, "mounts", "/proc/mounts", "mount table used for filesystem types and -per-mount")
+	fs.BoolVar(&o.exact, "bytes", false, "collect exact byte counts instead of df -h and du -h sizes")
+	fs.StringVar(&o.duPath, "du-path", ".", "directory scanned with du")
+	fs.DurationVar(&o.duTimeout, "du-timeout", 0, "timeout for du alone, 0 uses -timeout")
+	fs.IntVar(&o.top, "top", 10, "number of largest directories to report")
+	fs.StringVar(&o.duScanner, "du-scanner", "native", "how directory sizes are totalled: native (parallel walk, no du needed) or du")
+	fs.IntVar(&o.scan.MaxDepth, "max-depth", 0, "with -du-scanner native, only report directories this deep below -du-path, 0 for all")
+	fs.Func("exclude", "with -du-scanner native, skip files and directories matching this glob (name or path relative to -du-path), repeatable", func(v string) error {
+		if _, err := filepath.Match(v, ""); err != nil {
+			return err
+		}
+		o.scan.Exclude = append(o.scan.Exclude, v)
+		return nil
+	})
+	fs.BoolVar(&o.scan.FollowSymlinks, "follow-symlinks", false, "with -du-scanner native, count what symlinks point to (du -L)")
+	fs.IntVar(&o.scan.Workers, "du-workers", duscan.DefaultWorkers, "with -du-scanner native, directories read at once")
+	fs.BoolVar(&o.duPriority.Nice, "nice", false, "run du with nice -n 19 (picks -du-scanner du)")
+	fs.BoolVar(&o.duPriority.IONice, "ionice", false, "run du in the idle IO class with ionice -c3 (Linux, picks -du-scanner du)")
+	lowPriority := fs.Bool("low-priority", false, "same as -nice -ionice, only the du subprocess is affected, not this program (picks -du-scanner du)")
+	fs.BoolVar(&o.countFiles, "count-files", false, "also count the files in each reported directory, to spot inode hogs")
+	fs.BoolVar(&o.sinceBoot, "since-boot", false, "report the directories that grew most since boot, the first run after a boot saves the baseline")
+	fs.StringVar(&o.stateDir, "state-dir", defaultStateDir(), "where -since-boot keeps its baseline and -history its samples")
+	history := fs.Bool("history", false, "append every report's usage per mount to history.jsonl in -state-dir, for `devops disk history`")
+	fs.BoolVar(&o.byExtension, "by-extension", false, "also total the files under -du-path by extension (du -a)")
+	fs.DurationVar(&o.countTimeout, "count-timeout", 10*time.Second, "time limit for counting files in one directory")
+	fs.IntVar(&o.threshold, "threshold", 90, "warn about mounts at or above this use percent")
+	fs.BoolVar(&o.lsblk, "lsblk", false, "show the block device behind each filesystem (disk, partition, LVM volume and its volume group's free space) from lsblk, Linux only")
+	fs.BoolVar(&o.dedupDevices, "dedup-devices", false, "warn once per device when it is mounted in several places (bind mounts), the report still lists every mount")
+	fs.BoolVar(&o.includeStderr, "include-stderr", false, "keep what df and du print on stderr in each collector's entry of the report")
+	fs.IntVar(&o.stderrCap, "stderr-cap", 4096, "with -include-stderr, keep at most this many bytes per collector")
+	fs.Func("env", "set KEY=VALUE for df and du, repeatable; they run with LC_ALL=C and LANG=C unless overridden here", func(v string) error {
+		if !strings.Contains(v, "=") {
+			return errors.New("want KEY=VALUE")
+		}
+		o.env = append(o.env, v)
+		return nil
+	})
+	verbose := fs.Bool("v", false, "log every df and du command as it was run, with its duration and exit code, and each collector's duration (-du-scanner native runs no du)")
+	fs.BoolVar(&o.debugDump, "debug-dump", false, "debugging: print the raw collected structs to stderr before filtering and formatting")
+	sustained := fs.Duration("sustained", 0, "with -watch, only warn about a mount once it stayed over -threshold this long")
+	diff := fs.Bool("diff", false, "compare the filesystems of two -format json reports given as arguments, exit 1 if they differ")
+	tolerance := fs.Float64("compare-threshold-tolerance", 2, "with -diff, ignore use percent differences up to this many points and size or used bytes differences up to this percent")
+	format := fs.String("format", "table", "output format: table (text), json, csv (one row per filesystem and directory) or log (one slog record per mount and directory)")
+	sortOutput := fs.Bool("sort-output", true, "sort json and csv output by mount point and path so reports diff cleanly")
+	output := fs.String("output", "-", "file the report is written to, - for stdout, syslog for the local syslog daemon")
+	watch := fs.Duration("watch", 0, "collect a report every interval until interrupted, 0 runs once")
+	listen := fs.String("listen", "", "with -watch, serve /healthz, /readyz and Prometheus /metrics on this address, e.g. :9100")
+	control := fs.String("control", "", "with -watch, take JSON commands (scan, get, set-threshold) one per line from stdin or a unix socket path")
+	staleAfter := fs.Duration("stale-after", 0, "/healthz fails when the last good collection is older than this (default 3x -watch)")
+	includePseudo := fs.Bool("include-pseudo", false, "include pseudo filesystems (proc, sysfs, cgroup...)")
+	includeVirtual := fs.Bool("include-virtual", false, "include virtual filesystems (tmpfs, devtmpfs, overlay...)")
+	includeLoop := fs.Bool("include-loop", false, "include loop and squashfs mounts")
+	includeContainers := fs.Bool("include-containers", false, "include the mounts of containers (overlay roots, shm, pod volumes under /var/lib/docker, /var/lib/kubelet...), whatever their type")
+	oneline := fs.Bool("oneline", false, "print a single mount:percent line for a shell prompt or status bar (df only)")
+	onelineFormat := fs.String("oneline-format", defaultOnelineFormat, "text/template for each mount in -oneline, fields as in the JSON report")
+	color := fs.Bool("color", false, "colorize -oneline by -threshold")
+	budgetFile := fs.String("budget", "", "YAML capacity plan to compare usage against")
+	rulesFile := fs.String("rules", "", "YAML file of alert rules per mount glob, e.g. use_percent > 85 || avail_bytes < 20G, checked like -threshold, and the notifiers (webhook, slack, smtp) alerts are sent to")
+	alertTopWriters := fs.Int("alert-top-writers", 0, "with -rules, name this many of the processes writing the most in the alerts sent, sampled from /proc/<pid>/io when one fires (Linux)")
+	alertIOInterval := fs.Duration("alert-io-interval", time.Second, "with -alert-top-writers, how long the writes are sampled")
+	reclaim := fs.Bool("reclaim", false, "suggest cleanup candidates under -du-path (old logs, caches, temp files, core dumps), never deletes anything")
+	reclaimConfig := fs.String("reclaim-config", "", "YAML file tuning the -reclaim rules")
+	hosts := fs.String("hosts", "", "collect filesystem usage from these machines over ssh instead of this one, a comma separated list of [user@]host")
+	hostsFile := fs.String("hosts-file", "", "like -hosts, one host per line, # starts a comment")
+	hostTimeout := fs.Duration("host-timeout", 20*time.Second, "with -hosts or -kube, give up on a host or node that didn't answer in this long")
+	maxHosts := fs.Int("max-hosts", 8, "with -hosts or -kube, how many hosts or nodes are asked at the same time")
+	kube := fs.Bool("kube", false, "collect the node filesystems and persistent volume claims of a Kubernetes cluster from the kubelets, with kubectl, instead of this machine")
+	kubeconfig := fs.String("kubeconfig", "", "with -kube, the kubeconfig file, kubectl's default when empty")
+	kubeContext := fs.String("kube-context", "", "with -kube, the kubeconfig context, the current one when empty")
+	kubeNodes := fs.String("kube-nodes", "", "with -kube, only these nodes, a comma separated list")
+	pathsStdin := fs.Bool("paths-stdin", false, "report only the filesystems of the paths read from stdin, one per line")
+	fs.BoolVar(&o.skipMissing, "skip-missing", false, "with -paths-stdin, skip paths that don't exist instead of failing")
+	ship := fs.String("ship", "", "upload every report to this bucket, s3://bucket/prefix (?region=, ?endpoint= for S3-compatible stores), gs://bucket/prefix or file:///dir, under <host>/<date>/")
+	shipFormat := fs.String("ship-format", "json", "with -ship, the format of the uploaded reports: json or csv")
+	shipRetention := fs.String("ship-retention", "", "with -ship, delete this host's uploaded reports older than this, a duration like 36h, 30d or 2w")
+	shipKeep := fs.Int("ship-keep", 0, "with -ship, keep only this many of this host's newest uploaded reports, 0 for all")
+	shipTimeout := fs.Duration("ship-timeout", time.Minute, "with -ship, timeout for uploading one report")
+	summaryFile := fs.String("summary-file", "", "write a JSON summary of the run (outcome, duration, alerts fired, exit code) here when it ends, even when it fails")
+	logs.Flags(fs)
+	fs.Parse(args)
+	// day1 has no section of its own, it is disk report under its old name
+	sections := []string{"disk", cmp.Or(config.Command(name), "disk report")}
+	reload := newReloader(fs, sections...)
+	configErr := config.Apply(fs, sections...)
+
+	runSummary = summary.New("day1", *summaryFile)
+	// the returns below are successful runs, failures go through fatal
+	defer func() {
+		if r := recover(); r != nil {
+			runSummary.Crash(r)
+			panic(r)
+		}
+		runSummary.Finish(0)
+		logs.Close(0)
+	}()
+
+	if err := logs.Open(name); err != nil {
+		fatal(2, "bad logging flags", "err", err)
+	}
+	slog.SetDefault(slog.New(logs.Handler()))
+	if configErr != nil {
+		fatal(2, "bad configuration", "err", configErr)
+	}
+	// loaded by Apply, an error would have been configErr
+	cfg, _ := config.Load()
+	if *verbose {
+		logs.Level.Set(slog.LevelDebug)
+	}
+
+	if *diff {
+		if fs.NArg() != 2 {
+			fatal(2, "-diff needs two report files")
+		}
+		exit(runDiff(fs.Arg(0), fs.Arg(1), *tolerance))
+	}
+
+	if *lowPriority {
+		o.duPriority = diskusage.Priority{Nice: true, IONice: true}
+	}
+
+	o.include = map[string]bool{
+		diskusage.ClassReal:      true,
+		diskusage.ClassNetwork:   true,
+		diskusage.ClassPseudo:    *includePseudo,
+		diskusage.ClassVirtual:   *includeVirtual,
+		diskusage.ClassLoop:      *includeLoop,
+		diskusage.ClassContainer: *includeContainers,
+	}
+
+	if o.duScanner != "native" && o.duScanner != "du" {
+		fatal(2, "unknown -du-scanner, want native or du", "du_scanner", o.duScanner)
+	}
+	// the priority flags are about the du subprocess, the native scanner
+	// has none: they pick du, unless native was asked for by name, where
+	// only -by-extension still runs du
+	if o.duPriority != (diskusage.Priority{}) && o.duScanner == "native" {
+		chosen := false
+		fs.Visit(func(f *flag.Flag) { chosen = chosen || f.Name == "du-scanner" })
+		switch {
+		case !chosen:
+			slog.Debug("-nice, -ionice and -low-priority run du, using -du-scanner du")
+			o.duScanner = "du"
+		case !o.byExtension:
+			fatal(2, "-nice, -ionice and -low-priority need -du-scanner du, the native scanner runs no subprocess")
+		}
+	}
+	if o.duScanner == "du" && (o.scan.MaxDepth != 0 || len(o.scan.Exclude) > 0 || o.scan.FollowSymlinks) {
+		fatal(2, "-max-depth, -exclude and -follow-symlinks need -du-scanner native")
+	}
+
+	var err error
+	o.collector, err = diskusage.NewCollector(*collectorKind, diskusage.DfCollector{
+		MountsFile: o.mountsFile,
+		Exact:      o.exact,
+		PerMount:   o.perMount,
+		Timeout:    o.mountTimeout,
+		Include:    o.include,
+		Inodes:     o.inodes,
+	})
+	if err != nil {
+		fatal(2, "bad -collector", "err", err)
+	}
+
+	if *hosts != "" || *hostsFile != "" {
+		list, err := readHosts(*hosts, *hostsFile)
+		if err != nil {
+			fatal(1, "reading -hosts-file failed", "err", err)
+		}
+		if len(list) == 0 {
+			fatal(2, "-hosts and -hosts-file name no hosts")
+		}
+		// du, lsblk and the rest look at this machine
+		if o.sinceBoot || *reclaim || o.byExtension || o.countFiles || o.lsblk || *pathsStdin {
+			fatal(2, "-hosts only collects filesystem usage, it can't be used with -since-boot, -reclaim, -by-extension, -count-files, -lsblk or -paths-stdin")
+		}
+		o.remote = true
+		o.collector = diskusage.SSHCollector{Hosts: list, Timeout: *hostTimeout, Parallel: *maxHosts, SSH: cfg.Binaries.SSH}
+	}
+
+	if *kube {
+		if o.remote {
+			fatal(2, "-kube and -hosts can't be used together")
+		}
+		if o.sinceBoot || *reclaim || o.byExtension || o.countFiles || o.lsblk || *pathsStdin {
+			fatal(2, "-kube only collects filesystem usage, it can't be used with -since-boot, -reclaim, -by-extension, -count-files, -lsblk or -paths-stdin")
+		}
+		nodes, _ := readHosts(*kubeNodes, "")
+		o.remote = true
+		o.collector = diskusage.KubeletCollector{
+			Nodes:      nodes,
+			Kubeconfig: *kubeconfig,
+			Context:    *kubeContext,
+			Timeout:    *hostTimeout,
+			Parallel:   *maxHosts,
+			Kubectl:    cfg.Binaries.Kubectl,
+		}
+	}
+
+	if *pathsStdin {
+		if *control == "stdin" {
+			fatal(2, "-control stdin and -paths-stdin can't both read stdin")
+		}
+		paths, err := diskusage.ReadPaths(os.Stdin)
+		if err != nil {
+			fatal(1, "reading paths failed", "err", err)
+		}
+		o.paths = paths
+	}
+
+	if *oneline {
+		tmpl, err := template.New("oneline").Parse(*onelineFormat)
+		if err != nil {
+			fatal(2, "bad -oneline-format", "err", err)
+		}
+
+		ctx, cancel := context.WithTimeout(diskusage.WithEnv(context.Background(), o.env), o.timeout)
+		defer cancel()
+		filesystems, err := o.collector.Collect(ctx)
+		if err != nil {
+			fatal(1, "collecting disk usage failed", "err", err)
+		}
+		filesystems = diskusage.FilterClasses(filesystems, o.include)
+		if err := writeOneline(os.Stdout, filesystems, tmpl, *color, o.threshold); err != nil {
+			fatal(1, "writing oneline failed", "err", err)
+		}
+		return
+	}
+
+	if *budgetFile != "" {
+		plan, err := diskusage.LoadBudget(*budgetFile)
+		if err != nil {
+			fatal(1, "loading budget failed", "err", err)
+		}
+		o.budget = plan
+	}
+
+	if *reclaim {
+		o.reclaim = diskusage.DefaultReclaimRules()
+		if *reclaimConfig != "" {
+			rules, err := diskusage.LoadReclaimRules(*reclaimConfig)
+			if err != nil {
+				fatal(1, "loading reclaim rules failed", "err", err)
+			}
+			o.reclaim = rules
+		}
+	}
+
+	if !slices.Contains(formats, *format) {
+		fatal(2, "unknown -format, want table, json, csv or log", "format", *format)
+	}
+
+	out, err := openOutput(*output)
+	if err != nil {
+		fatal(1, "opening output failed", "err", err)
+	}
+	defer out.Close()
+
+	// -paths-stdin output keeps the order the paths were given in
+	w := &reportWriter{w: out, format: *format, stream: *watch > 0, sort: *sortOutput && !*pathsStdin}
+
+	if *listen != "" && *watch == 0 {
+		fatal(2, "-listen needs -watch")
+	}
+	if *sustained > 0 && *watch == 0 {
+		fatal(2, "-sustained needs -watch")
+	}
+	alerts := &alerter{sustained: *sustained, status: &notifierStatus{}}
+	if *rulesFile != "" {
+		rules, err := diskusage.LoadAlertRules(*rulesFile)
+		if err != nil {
+			fatal(1, "loading rules failed", "err", err)
+		}
+		alerts.setRules(rules)
+	}
+	if *alertTopWriters < 0 || *alertIOInterval <= 0 {
+		fatal(2, "-alert-top-writers can't be negative and -alert-io-interval must be positive")
+	}
+	if *alertTopWriters > 0 && *rulesFile == "" {
+		fatal(2, "-alert-top-writers needs -rules")
+	}
+	alerts.topWriters, alerts.ioInterval, alerts.procDir = *alertTopWriters, *alertIOInterval, "/proc"
+	var shipReports *shipper
+	if *ship != "" {
+		store, err := storage.Open(*ship)
+		if err != nil {
+			fatal(2, "bad -ship", "err", err)
+		}
+		if *shipFormat != "json" && *shipFormat != "csv" {
+			fatal(2, "-ship-format must be json or csv")
+		}
+		if *shipKeep < 0 {
+			fatal(2, "-ship-keep can't be negative")
+		}
+		shipReports = &shipper{store: store, format: *shipFormat, timeout: *shipTimeout, retention: storage.Retention{Keep: *shipKeep}}
+		if *shipRetention != "" {
+			if shipReports.retention.MaxAge, err = parseAge(*shipRetention); err != nil {
+				fatal(2, "bad -ship-retention", "err", err)
+			}
+		}
+		if shipReports.host, err = os.Hostname(); err != nil {
+			fatal(1, "-ship needs the host name", "err", err)
+		}
+	}
+	if *control != "" && *watch == 0 {
+		fatal(2, "-control needs -watch")
+	}
+	// responses to stdin commands go to stdout, reports can't go there too
+	if *control == "stdin" && *output == "-" {
+		fatal(2, "-control stdin needs -output")
+	}
+
+	if *watch == 0 {
+		// an interrupted run still ends with its -summary-file
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		report, err := collect(ctx, o)
+		if ctx.Err() != nil {
+			fatal(130, "interrupted before the report was written")
+		}
+		if err != nil {
+			fatal(1, "collecting disk usage failed", "err", err)
+		}
+		if err := w.Write(report); err != nil {
+			fatal(1, "writing report failed", "err", err)
+		}
+		if *history {
+			if err := diskusage.AppendHistory(historyFile(o.stateDir), report); err != nil {
+				fatal(1, "appending to the history failed", "err", err)
+			}
+		}
+		if shipReports != nil {
+			if err := shipReports.ship(ctx, report); err != nil {
+				fatal(1, "shipping the report failed", "err", err)
+			}
+		}
+		alerts.check(ctx, report.Filesystems, o.threshold, o.dedupDevices, report.Time)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cache := &lastGood{started: time.Now()}
+	if *listen != "" {
+		if *staleAfter == 0 {
+			*staleAfter = 3 * *watch
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", healthHandler(cache, alerts.status, *staleAfter))
+		mux.Handle("/readyz", readyHandler(cache, alerts.status))
+		mux.Handle("/metrics", metricsHandler(cache))
+		go serve(ctx, *listen, mux)
+	}
+
+	cmds := make(chan controlCommand)
+	switch *control {
+	case "":
+	case "stdin":
+		go func() {
+			serveControl(ctx, os.Stdin, os.Stdout, cmds)
+			// the controlling process went away, so do we
+			slog.Info("control input closed")
+			stop()
+		}()
+	default:
+		if err := listenControl(ctx, *control, cmds); err != nil {
+			fatal(1, "opening control socket failed", "err", err)
+		}
+	}
+
+	// SIGHUP reopens the output file so logrotate can move it away and
+	// reloads the configuration and rules, SIGUSR1 collects right away
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	force := make(chan os.Signal, 1)
+	if len(collectNow) > 0 {
+		signal.Notify(force, collectNow...)
+	}
+
+	ticker := time.NewTicker(*watch)
+	defer ticker.Stop()
+
+	poll := func() (diskusage.Report, error) {
+		report, err := collect(ctx, o)
+		cache.update(report, err)
+		if err != nil {
+			slog.Error("collecting disk usage failed", "err", err)
+			return report, err
+		}
+		if err := w.Write(report); err != nil {
+			slog.Error("writing report failed", "err", err)
+		}
+		if *history {
+			if err := diskusage.AppendHistory(historyFile(o.stateDir), report); err != nil {
+				slog.Error("appending to the history failed", "err", err)
+			}
+		}
+		if shipReports != nil {
+			if err := shipReports.ship(ctx, report); err != nil {
+				slog.Error("shipping the report failed", "err", err)
+			}
+		}
+		alerts.check(ctx, report.Filesystems, o.threshold, o.dedupDevices, report.Time)
+		return report, nil
+	}
+
+	poll()
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("stopping")
+			return
+		case <-hup:
+			if err := out.Reopen(); err != nil {
+				slog.Error("reopening output failed", "err", err)
+			}
+			w.reset()
+			if err := reload.reload(&o, alerts); err != nil {
+				slog.Error("reloading the configuration failed, keeping the old one", "err", err)
+			} else {
+				slog.Info("configuration reloaded", "threshold", o.threshold, "rules", alerts.rules != nil)
+			}
+		case <-force:
+			slog.Info("collecting now")
+			poll()
+			ticker.Reset(*watch)
+		case <-ticker.C:
+			poll()
+		case c := <-cmds:
+			c.reply <- handleControl(c.req, &o, poll, cache)
+		}
+	}
+}
+
+// handleControl runs one -control command in the watch loop.
+func handleControl(req controlRequest, o *options, poll func() (diskusage.Report, error), cache *lastGood) controlResponse {
+	switch req.Cmd {
+	case "scan":
+		report, err := poll()
+		if err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+		report.SchemaVersion = diskusage.SchemaVersion
+		return controlResponse{OK: true, Report: &report}
+	case "get":
+		report, lastSuccess, _ := cache.get()
+		if lastSuccess.IsZero() {
+			return controlResponse{Error: "no successful collection yet"}
+		}
+		report.SchemaVersion = diskusage.SchemaVersion
+		return controlResponse{OK: true, Report: &report}
+	case "set-threshold":
+		if req.Value < 1 || req.Value > 100 {
+			return controlResponse{Error: "value must be a percent between 1 and 100"}
+		}
+		slog.Info("threshold changed", "from", o.threshold, "to", req.Value)
+		o.threshold = req.Value
+		o.thresholdControlled = true
+		return controlResponse{OK: true, Threshold: o.threshold}
+	}
+	return controlResponse{Error: "unknown cmd " + req.Cmd}
+}
+
+// collect gathers one report. df and du run side by side, each collector's
+// outcome is recorded in the report. Only a df failure fails the report.
+func collect(ctx context.Context, o options) (diskusage.Report, error) {
+	ctx, cancel := context.WithTimeout(diskusage.WithEnv(ctx, o.env), o.timeout)
+	defer cancel()
+
+	report := diskusage.Report{Time: time.Now()}
+
+	if o.paths != nil {
+		var filesystems []diskusage.Filesystem
+		status, err := o.runCollector(ctx, "df", 0, func(ctx context.Context) (err error) {
+			filesystems, err = diskusage.DfPaths(ctx, o.paths, o.mountsFile, o.exact, o.skipMissing)
+			return err
+		})
+		report.Filesystems = filesystems
+		report.Collectors = []diskusage.CollectorStatus{status}
+		if o.debugDump {
+			debugDump("df", filesystems)
+		}
+		return report, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		du       diskusage.DuResult
+		duStatus diskusage.CollectorStatus
+	)
+	// du looks at this machine, -hosts reports others
+	if !o.remote {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			duStatus, err = o.runCollector(ctx, "du", o.duTimeout, func(ctx context.Context) (err error) {
+				n, exact := o.top, o.exact
+				if o.sinceBoot {
+					// the baseline is compared run after run, rounded sizes
+					// would show growth that is only du's rounding
+					n, exact = max(n, diskusage.BaselineDirs), true
+				}
+				if o.duScanner == "native" {
+					// always exact, to the block
+					scan := o.scan
+					scan.Top = n
+					du, err = duscan.Scan(ctx, o.duPath, scan)
+					return err
+				}
+				du, err = diskusage.Du(ctx, o.duPath, n, exact, o.duPriority)
+				return err
+			})
+			if err != nil {
+				slog.Error("du failed", "err", err)
+			}
+		}()
+	}
+
+	var filesystems []diskusage.Filesystem
+	dfStatus, err := o.runCollector(ctx, o.collector.Name(), 0, func(ctx context.Context) (err error) {
+		filesystems, err = o.collector.Collect(ctx)
+		return err
+	})
+	wg.Wait()
+	report.Collectors = []diskusage.CollectorStatus{dfStatus}
+	if !o.remote {
+		report.Collectors = append(report.Collectors, duStatus)
+	}
+	if o.debugDump {
+		debugDump(o.collector.Name(), filesystems)
+		debugDump("du", du.Dirs)
+	}
+	if err != nil {
+		return report, err
+	}
+	report.Filesystems = diskusage.FilterClasses(filesystems, o.include)
+	diskusage.AnnotateErrors(report.Filesystems)
+
+	if o.lsblk {
+		// without lsblk the report is still complete, just not annotated
+		status, _ := o.runCollector(ctx, "lsblk", 0, func(ctx context.Context) error {
+			devices, err := diskusage.Lsblk(ctx)
+			if err != nil {
+				return err
+			}
+			diskusage.AnnotateBlockDevices(report.Filesystems, devices)
+			return nil
+		})
+		report.Collectors = append(report.Collectors, status)
+	}
+
+	if o.budget != nil {
+		report.Budget = o.budget.Check(report.Filesystems, report.Time)
+		for _, b := range report.Budget {
+			if b.Over {
+				runSummary.Alert(b.MountPoint, "budget")
+				slog.Warn("mount over budget",
+					"mount", b.MountPoint,
+					"use_percent", fmt.Sprintf("%.1f", b.UsePercent),
+					"planned_percent", fmt.Sprintf("%.1f", b.PlannedPercent),
+					"over_by", fmt.Sprintf("%.1f", b.OverBy))
+			}
+		}
+	}
+
+	if o.sinceBoot {
+		status, err := o.runCollector(ctx, "since-boot", 0, func(ctx context.Context) error {
+			// a failed or cut off scan would be saved as the baseline of the
+			// whole boot, or compared as growth that isn't there
+			if !duStatus.OK {
+				return errors.New("du didn't finish, the baseline is left alone")
+			}
+			boot, err := diskusage.BootTime(ctx)
+			if err != nil {
+				return err
+			}
+			base, created, err := diskusage.LoadOrCreateBaseline(o.stateDir, o.duPath, boot, du.Dirs)
+			if err != nil {
+				return err
+			}
+			if created {
+				slog.Info("saved since-boot baseline, growth is reported from the next run on", "boot_time", boot)
+				return nil
+			}
+			report.SinceBoot = base.Growth(du.Dirs, o.top)
+			return nil
+		})
+		if err != nil {
+			slog.Warn("skipping since-boot growth", "err", err)
+		}
+		report.Collectors = append(report.Collectors, status)
+		if len(du.Dirs) > o.top {
+			du.Dirs = du.Dirs[:o.top]
+		}
+	}
+
+	if o.countFiles {
+		status, _ := o.runCollector(ctx, "count-files", 0, func(ctx context.Context) error {
+			diskusage.CountFiles(ctx, du.Dirs, o.countTimeout)
+			for _, d := range du.Dirs {
+				if d.FilesPartial {
+					return fmt.Errorf("some counts hit -count-timeout %s", o.countTimeout)
+				}
+			}
+			return nil
+		})
+		report.Collectors = append(report.Collectors, status)
+	}
+	report.Dirs = du.Dirs
+	report.DuSkippedPaths = du.SkippedPaths
+	report.DuSkipped = len(du.SkippedPaths)
+
+	if o.byExtension {
+		status, err := o.runCollector(ctx, "by-extension", o.duTimeout, func(ctx context.Context) (err error) {
+			report.Extensions, err = diskusage.DuByExtension(ctx, o.duPath, o.duPriority)
+			return err
+		})
+		if err != nil {
+			slog.Error("du by extension failed", "err", err)
+		}
+		report.Collectors = append(report.Collectors, status)
+	}
+
+	if o.reclaim != nil {
+		status, err := o.runCollector(ctx, "reclaim", 0, func(ctx context.Context) (err error) {
+			report.Reclaim, err = diskusage.FindReclaim(ctx, o.duPath, o.reclaim, report.Time)
+			return err
+		})
+		if err != nil {
+			slog.Error("looking for reclaim candidates failed", "err", err)
+		}
+		report.Collectors = append(report.Collectors, status)
+	}
+
+	return report, nil
+}
+
+// defaultStateDir is the user's cache dir, falling back to /var/tmp.
+func defaultStateDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "diskusage")
+	}
+	return "/var/tmp/diskusage"
+}
+
+// debugDump prints items one per line with field names, exactly as
+// collected, including placeholder values of mounts without stats.
+func debugDump[T any](name string, items []T) {
+	fmt.Fprintf(os.Stderr, "== %s: %d ==\n", name, len(items))
+	for _, it := range items {
+		fmt.Fprintln(os.Stderr, debugFormat(it))
+	}
+}
+
+// debugFormat is %+v with pointer fields dereferenced, %+v alone prints
+// the address of e.g. UsedPercent.
+func debugFormat(v any) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Struct {
+		return fmt.Sprintf("%+v", v)
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := range rv.NumField() {
+		field := rv.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if b.Len() > 1 {
+			b.WriteByte(' ')
+		}
+		f := rv.Field(i)
+		if f.Kind() == reflect.Pointer {
+			if f.IsNil() {
+				fmt.Fprintf(&b, "%s:<nil>", field.Name)
+				continue
+			}
+			f = f.Elem()
+		}
+		fmt.Fprintf(&b, "%s:%+v", field.Name, f.Interface())
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// runCollector runs fn, with its own timeout if one is given, and records
+// how it went. With -include-stderr the stderr of its commands is kept too.
+func (o options) runCollector(ctx context.Context, name string, timeout time.Duration, fn func(context.Context) error) (diskusage.CollectorStatus, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var stderr *cappedBuffer
+	if o.includeStderr {
+		stderr = &cappedBuffer{max: o.stderrCap}
+		ctx = diskusage.WithStderr(ctx, stderr)
+	}
+
+	start := time.Now()
+	err := fn(ctx)
+	status := diskusage.CollectorStatus{Name: name, OK: err == nil, Duration: time.Since(start)}
+	if err != nil {
+		// the command's own error is just "signal: killed"
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s: %w", status.Duration.Round(time.Millisecond), err)
+		}
+		status.Err = err.Error()
+	}
+	if stderr != nil {
+		status.Stderr = stderr.String()
+	}
+	slog.Debug("collector finished", "collector", name, "took", status.Duration.Round(time.Millisecond), "ok", status.OK)
+	return status, err
+}
+
+// cappedBuffer keeps the first max bytes written to it and counts the rest.
+// Per-mount df calls write to it concurrently.
+type cappedBuffer struct {
+	mu      sync.Mutex
+	max     int
+	buf     []byte
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := min(len(p), max(b.max-len(b.buf), 0))
+	b.buf = append(b.buf, p[:n]...)
+	b.dropped += len(p) - n
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.dropped > 0 {
+		return fmt.Sprintf("%s... (%d more bytes)", b.buf, b.dropped)
+	}
+	return string(b.buf)
+}
@@ -0,0 +1,102 @@
+package diskcmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ved/test/config"
+	"ved/test/diskusage"
+)
+
+// Docker is `devops disk docker`: the space the Docker engine uses by
+// images, containers, volumes and build cache, like docker system df -v.
+// It returns the exit code.
+func Docker(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	host := fs.String("host", "", "Docker engine to ask, unix:///path/docker.sock or tcp://host:port (default $DOCKER_HOST or "+diskusage.DefaultDockerHost+")")
+	top := fs.Int("top", 5, "list this many of the largest items of each kind, 0 only prints the totals")
+	format := fs.String("format", "table", "output format: table or json")
+	timeout := fs.Duration("timeout", 30*time.Second, "how long the engine may take, it adds up every layer and volume")
+	logs.Flags(fs)
+	fs.Parse(args)
+	configErr := config.Apply(fs, "disk docker")
+
+	if err := logs.Open(name); err != nil {
+		slog.Error("bad logging flags", "err", err)
+		return 2
+	}
+	defer logs.Close(0)
+	slog.SetDefault(slog.New(logs.Handler()))
+	if configErr != nil {
+		slog.Error("bad configuration", "err", configErr)
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		slog.Error("-format must be table or json")
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	usage, err := diskusage.DockerDf(ctx, *host, *top)
+	if err != nil {
+		slog.Error("asking docker failed", "err", err)
+		return 1
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(usage); err != nil {
+			slog.Error("writing docker usage failed", "err", err)
+			return 1
+		}
+		return 0
+	}
+	printDocker(os.Stdout, usage)
+	return 0
+}
+
+func printDocker(w io.Writer, u *diskusage.DockerUsage) {
+	categories := []struct {
+		name string
+		c    diskusage.DockerCategory
+	}{
+		{"Images", u.Images},
+		{"Containers", u.Containers},
+		{"Local Volumes", u.Volumes},
+		{"Build Cache", u.BuildCache},
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Type\tTotal\tActive\tSize\tReclaimable")
+	for _, cat := range categories {
+		pct := 0.0
+		if cat.c.Size > 0 {
+			pct = float64(cat.c.Reclaimable) / float64(cat.c.Size) * 100
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s (%.0f%%)\n", cat.name, cat.c.Count, cat.c.Active,
+			humanBytes(cat.c.Size), humanBytes(cat.c.Reclaimable), pct)
+	}
+	tw.Flush()
+
+	for _, cat := range categories {
+		if len(cat.c.Items) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\nLargest %s:\n", cat.name)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tName\tSize\tActive")
+		for _, it := range cat.c.Items {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n", it.ID, it.Name, humanBytes(it.Size), it.Active)
+		}
+		tw.Flush()
+	}
+}
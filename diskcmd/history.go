@@ -0,0 +1,158 @@
+package diskcmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ved/test/config"
+	"ved/test/diskusage"
+)
+
+// historyFile is where -history appends its samples.
+func historyFile(stateDir string) string {
+	return filepath.Join(stateDir, "history.jsonl")
+}
+
+// History is `devops disk history`: the usage -history recorded, with the
+// growth rate of every mount and when it runs full at that rate. It
+// returns the exit code.
+func History(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	stateDir := fs.String("state-dir", defaultStateDir(), "where -history keeps its samples")
+	mount := fs.String("mount", "", "only this mount point, and list its samples")
+	since := fs.String("since", "7d", "only samples this recent, a duration like 36h, 7d or 2w")
+	format := fs.String("format", "table", "output format: table or json")
+	logs.Flags(fs)
+	fs.Parse(args)
+	configErr := config.Apply(fs, "disk history")
+
+	if err := logs.Open(name); err != nil {
+		slog.Error("bad logging flags", "err", err)
+		return 2
+	}
+	defer logs.Close(0)
+	slog.SetDefault(slog.New(logs.Handler()))
+	if configErr != nil {
+		slog.Error("bad configuration", "err", configErr)
+		return 2
+	}
+
+	d, err := parseAge(*since)
+	if err != nil {
+		slog.Error("bad -since", "err", err)
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		slog.Error("-format must be table or json")
+		return 2
+	}
+
+	samples, err := diskusage.ReadHistory(historyFile(*stateDir), *mount, time.Now().Add(-d))
+	if err != nil {
+		slog.Error("reading history failed", "err", err)
+		return 1
+	}
+	trends := diskusage.Trends(samples)
+
+	if *format == "json" {
+		out := struct {
+			Trends  []diskusage.Trend         `json:"trends"`
+			Samples []diskusage.HistorySample `json:"samples,omitempty"`
+		}{Trends: trends}
+		if *mount != "" {
+			out.Samples = samples
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			slog.Error("writing history failed", "err", err)
+			return 1
+		}
+		return 0
+	}
+
+	if len(trends) == 0 {
+		fmt.Printf("No samples since %s.\n", *since)
+		return 0
+	}
+	if *mount != "" {
+		printSamples(os.Stdout, samples)
+		fmt.Println()
+	}
+	printTrends(os.Stdout, trends, time.Now())
+	return 0
+}
+
+// parseAge is time.ParseDuration that also takes days (7d) and weeks (2w).
+func parseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("invalid duration %q", s)
+	}
+	return d, err
+}
+
+func printSamples(w io.Writer, samples []diskusage.HistorySample) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Time\tSize\tUsed\tAvail\tUse%\t")
+	for _, s := range samples {
+		pct := 0.0
+		if s.Size > 0 {
+			pct = float64(s.Used) / float64(s.Size) * 100
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.0f%%\t\n", s.Time.Local().Format("2006-01-02 15:04"),
+			humanBytes(s.Size), humanBytes(s.Used), humanBytes(s.Avail), pct)
+	}
+	tw.Flush()
+}
+
+func printTrends(w io.Writer, trends []diskusage.Trend, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Mounted on\tSamples\tUsed\tAvail\tGrowth/day\tFull in")
+	for _, t := range trends {
+		growth := "-"
+		switch {
+		case t.To.Sub(t.From) < diskusage.MinTrendSpan:
+		case t.GrowthPerDay < 0:
+			growth = "-" + humanBytes(int64(-t.GrowthPerDay))
+		default:
+			growth = "+" + humanBytes(int64(t.GrowthPerDay))
+		}
+		full := "never"
+		if t.FullAt != nil {
+			full = fullIn(t.FullAt.Sub(now))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", t.Mount, t.Samples,
+			humanBytes(t.Last.Used), humanBytes(t.Last.Avail), growth, full)
+	}
+	tw.Flush()
+}
+
+// fullIn rounds d to days, or hours under two days.
+func fullIn(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "full"
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%.0fh", d.Hours())
+	}
+	return fmt.Sprintf("%.0fd", d.Hours()/24)
+}
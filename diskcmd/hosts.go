@@ -0,0 +1,41 @@
+package diskcmd
+
+import (
+	"os"
+	"slices"
+	"strings"
+
+	"ved/test/diskusage"
+)
+
+// readHosts is -hosts and the hosts in -hosts-file, in that order, each
+// once.
+func readHosts(list, file string) ([]string, error) {
+	var hosts []string
+	for _, h := range strings.Split(list, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		// an inventory has the same format as -paths-stdin
+		more, err := diskusage.ReadPaths(f)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, more...)
+	}
+
+	var unique []string
+	for _, h := range hosts {
+		if !slices.Contains(unique, h) {
+			unique = append(unique, h)
+		}
+	}
+	return unique, nil
+}
@@ -0,0 +1,207 @@
+package diskcmd
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"ved/test/config"
+	"ved/test/diskusage"
+)
+
+// IO is `devops disk io`: which processes read and write the disks, from
+// the counters of /proc/<pid>/io sampled -interval apart, so a disk filling
+// up or a saturated device can be pinned on a process. It is Linux only
+// and sees other users' processes only as root. It returns the exit code.
+func IO(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags]\n\nLists the processes that did the most disk I/O over an interval.\n\n", name)
+		fs.PrintDefaults()
+	}
+	interval := fs.Duration("interval", 5*time.Second, "how long each sample is")
+	count := fs.Int("count", 1, "how many samples to take one after another, 0 until interrupted")
+	top := fs.Int("top", 20, "list this many processes per sample, 0 for all that did I/O")
+	sortBy := fs.String("sort", "write", "order processes by write, read or total bytes")
+	format := fs.String("format", "table", "output format: table or json, one object per line when -count isn't 1")
+	procDir := fs.String("proc", "/proc", "where the proc filesystem is mounted")
+	logs.Flags(fs)
+	fs.Parse(args)
+	configErr := config.Apply(fs, "disk io")
+
+	if err := logs.Open(name); err != nil {
+		slog.Error("bad logging flags", "err", err)
+		return 2
+	}
+	defer logs.Close(0)
+	slog.SetDefault(slog.New(logs.Handler()))
+	if configErr != nil {
+		slog.Error("bad configuration", "err", configErr)
+		return 2
+	}
+
+	if *interval <= 0 {
+		slog.Error("-interval must be positive")
+		return 2
+	}
+	if *count < 0 || *top < 0 {
+		slog.Error("-count and -top can't be negative")
+		return 2
+	}
+	if *sortBy != "write" && *sortBy != "read" && *sortBy != "total" {
+		slog.Error("-sort must be write, read or total")
+		return 2
+	}
+	if *format != "table" && *format != "json" {
+		slog.Error("-format must be table or json")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	before, _, err := diskusage.ReadProcIO(*procDir)
+	if err != nil {
+		slog.Error("reading process I/O failed", "err", err)
+		return 1
+	}
+	users := map[string]string{}
+	start := time.Now()
+	for n := 0; *count == 0 || n < *count; n++ {
+		select {
+		case <-time.After(*interval):
+		case <-ctx.Done():
+			return 0
+		}
+		after, denied, err := diskusage.ReadProcIO(*procDir)
+		if err != nil {
+			slog.Error("reading process I/O failed", "err", err)
+			return 1
+		}
+		now := time.Now()
+		rates := diskusage.IORates(before, after, now.Sub(start))
+		before, start = after, now
+		if denied > 0 && n == 0 {
+			slog.Warn(fmt.Sprintf("%d processes of other users can't be read, run as root to see them", denied))
+		}
+
+		sortRates(rates, *sortBy)
+		if *top > 0 {
+			rates = rates[:min(len(rates), *top)]
+		}
+		nameUsers(rates, users)
+		if *format == "json" {
+			if rates == nil {
+				rates = []diskusage.IORate{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			if *count == 1 {
+				enc.SetIndent("", "  ")
+			}
+			err = enc.Encode(struct {
+				Time      time.Time          `json:"time"`
+				Interval  float64            `json:"interval_seconds"`
+				Denied    int                `json:"denied_processes"`
+				Processes []diskusage.IORate `json:"processes"`
+			}{now, interval.Seconds(), denied, rates})
+		} else {
+			if n > 0 {
+				fmt.Println()
+			}
+			err = printIO(os.Stdout, rates, now)
+		}
+		if err != nil {
+			slog.Error("writing the sample failed", "err", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// sortRates orders rates by written, read or total bytes, most first.
+// IORates already sorts by written.
+func sortRates(rates []diskusage.IORate, by string) {
+	key := func(r diskusage.IORate) int64 {
+		switch by {
+		case "read":
+			return r.Read
+		case "total":
+			return r.Read + r.Written
+		}
+		return r.Written
+	}
+	sort.SliceStable(rates, func(i, j int) bool { return key(rates[i]) > key(rates[j]) })
+}
+
+// nameUsers sets the user name of every rate, looked up once per UID.
+func nameUsers(rates []diskusage.IORate, names map[string]string) {
+	for i := range rates {
+		uid := rates[i].UID
+		name, ok := names[uid]
+		if !ok {
+			name = uid
+			if u, err := user.LookupId(uid); err == nil {
+				name = u.Username
+			}
+			names[uid] = name
+		}
+		rates[i].User = name
+	}
+}
+
+func printIO(w io.Writer, rates []diskusage.IORate, now time.Time) error {
+	if len(rates) == 0 {
+		_, err := fmt.Fprintf(w, "%s  no disk I/O\n", now.Format("15:04:05"))
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%s\tPID\tUser\tRead/s\tWrite/s\tRead\tWritten\t  Command\n", now.Format("15:04:05"))
+	for _, r := range rates {
+		fmt.Fprintf(tw, "\t%d\t%s\t%s\t%s\t%s\t%s\t  %s\n", r.PID, cmp.Or(r.User, "-"),
+			humanBytes(int64(r.ReadPerSec)), humanBytes(int64(r.WrittenPerSec)),
+			humanBytes(r.Read), humanBytes(r.Written), command(r.ProcIO, 60))
+	}
+	return tw.Flush()
+}
+
+// command is p's command line, its name when it has none (kernel
+// threads), cut to width.
+func command(p diskusage.ProcIO, width int) string {
+	c := p.Cmdline
+	if c == "" {
+		c = "[" + p.Comm + "]"
+	}
+	if r := []rune(c); len(r) > width {
+		c = string(r[:width-3]) + "..."
+	}
+	return strings.ReplaceAll(c, "\t", " ")
+}
+
+// topWriters is the n processes that wrote the most over interval, as
+// "comm[pid] 12M/s", for an alert.
+func topWriters(ctx context.Context, procDir string, interval time.Duration, n int) ([]string, error) {
+	rates, _, err := diskusage.SampleIO(ctx, procDir, interval)
+	if err != nil {
+		return nil, err
+	}
+	var writers []string
+	for _, r := range rates {
+		if len(writers) == n || r.Written == 0 {
+			break
+		}
+		writers = append(writers, r.Comm+"["+strconv.Itoa(r.PID)+"] "+humanBytes(int64(r.WrittenPerSec))+"/s")
+	}
+	return writers, nil
+}
@@ -0,0 +1,150 @@
+package diskcmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"ved/test/config"
+	"ved/test/diskusage"
+	"ved/test/duscan"
+)
+
+// Largest is `devops disk largest`: the biggest files under the given
+// directories, with their size, modification time and owner, the first
+// thing to look at when a disk fills up. It returns the exit code.
+func Largest(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [flags] [dir...]\n\nLists the largest files under each dir, . when none is given.\n\n", name)
+		fs.PrintDefaults()
+	}
+	var opts duscan.Options
+	fs.IntVar(&opts.Top, "top", 20, "list this many files")
+	minSize := fs.String("min-size", "", "only files at least this big, like 100M or 2G")
+	olderThan := fs.String("older-than", "", "only files not modified for this long, a duration like 36h, 30d or 2w")
+	owner := fs.String("owner", "", "only files of this user, a name or a numeric ID")
+	fs.Func("exclude", "skip files and directories matching this glob (name or path relative to dir), repeatable", func(v string) error {
+		opts.Exclude = append(opts.Exclude, v)
+		return nil
+	})
+	fs.BoolVar(&opts.OneFilesystem, "one-file-system", true, "don't descend into other filesystems mounted below dir, like du -x")
+	fs.BoolVar(&opts.Apparent, "apparent-size", false, "compare file sizes instead of the disk space files take, sparse files then look as big as they claim")
+	fs.IntVar(&opts.Workers, "workers", duscan.DefaultWorkers, "how many directories are read at once")
+	timeout := fs.Duration("timeout", 10*time.Minute, "stop and list what was found so far after this long")
+	format := fs.String("format", "table", "output format: table or json")
+	logs.Flags(fs)
+	fs.Parse(args)
+	configErr := config.Apply(fs, "disk largest")
+
+	if err := logs.Open(name); err != nil {
+		slog.Error("bad logging flags", "err", err)
+		return 2
+	}
+	defer logs.Close(0)
+	slog.SetDefault(slog.New(logs.Handler()))
+	if configErr != nil {
+		slog.Error("bad configuration", "err", configErr)
+		return 2
+	}
+
+	var filter duscan.FileFilter
+	filter.Owner = *owner
+	if *minSize != "" {
+		size, err := diskusage.ParseSize(*minSize)
+		if err != nil {
+			slog.Error("bad -min-size", "err", err)
+			return 2
+		}
+		filter.MinSize = size
+	}
+	if *olderThan != "" {
+		d, err := parseAge(*olderThan)
+		if err != nil {
+			slog.Error("bad -older-than", "err", err)
+			return 2
+		}
+		filter.ModifiedBefore = time.Now().Add(-d)
+	}
+	if *format != "table" && *format != "json" {
+		slog.Error("-format must be table or json")
+		return 2
+	}
+	if opts.Top <= 0 {
+		slog.Error("-top must be at least 1")
+		return 2
+	}
+	if err := opts.Validate(); err != nil {
+		slog.Error("bad -exclude", "err", err)
+		return 2
+	}
+	dirs := fs.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	var res duscan.LargestResult
+	code := 0
+	for _, dir := range dirs {
+		r, err := duscan.Largest(ctx, dir, opts, filter)
+		if err != nil {
+			slog.Error("scanning failed", "dir", dir, "err", err)
+			code = 1
+		}
+		res.Files = append(res.Files, r.Files...)
+		res.SkippedPaths = append(res.SkippedPaths, r.SkippedPaths...)
+	}
+	sort.SliceStable(res.Files, func(i, j int) bool { return res.Files[i].Size > res.Files[j].Size })
+	res.Files = res.Files[:min(len(res.Files), opts.Top)]
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			slog.Error("writing files failed", "err", err)
+			return 1
+		}
+		return code
+	}
+	printFiles(os.Stdout, res.Files, time.Now())
+	return code
+}
+
+func printFiles(w io.Writer, files []duscan.File, now time.Time) {
+	if len(files) == 0 {
+		fmt.Fprintln(w, "No files found.")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Size\tModified\tAge\tOwner\tPath")
+	for _, f := range files {
+		owner := f.Owner
+		if owner == "" {
+			owner = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", humanBytes(f.Size), f.ModTime.Format("2006-01-02 15:04"), age(now.Sub(f.ModTime)), owner, f.Path)
+	}
+	tw.Flush()
+}
+
+// age is d in the largest unit that fits, like 3d or 5h.
+func age(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+	return "now"
+}
@@ -0,0 +1,48 @@
+package diskcmd
+
+import (
+	"io"
+	"strings"
+	"text/template"
+
+	"ved/test/diskusage"
+)
+
+const defaultOnelineFormat = "{{.MountPoint}}:{{.UsePercent}}%"
+
+const (
+	ansiRed    = "\033[31m"
+	ansiYellow = "\033[33m"
+	ansiReset  = "\033[0m"
+)
+
+// writeOneline prints every filesystem with stats through tmpl, space
+// separated on one line, e.g. "/:62% /data:88%" for a shell prompt. With
+// color, mounts at the threshold are red and within 10 points of it yellow.
+func writeOneline(w io.Writer, filesystems []diskusage.Filesystem, tmpl *template.Template, color bool, threshold int) error {
+	var parts []string
+	for _, fs := range filesystems {
+		if !fs.HasStats() {
+			continue
+		}
+
+		var b strings.Builder
+		if err := tmpl.Execute(&b, fs); err != nil {
+			return err
+		}
+
+		part := b.String()
+		if color {
+			switch {
+			case fs.Percent() >= float64(threshold):
+				part = ansiRed + part + ansiReset
+			case fs.Percent() >= float64(threshold-10):
+				part = ansiYellow + part + ansiReset
+			}
+		}
+		parts = append(parts, part)
+	}
+
+	_, err := io.WriteString(w, strings.Join(parts, " ")+"\n")
+	return err
+}
@@ -0,0 +1,90 @@
+package diskcmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// output is where reports go: stdout, syslog or a file that can be reopened
+// after logrotate moved it.
+type output struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+	// syslog is set for -output syslog
+	syslog io.WriteCloser
+}
+
+// openOutput opens path for appending, "-" means stdout and "syslog" the
+// local syslog daemon.
+func openOutput(path string) (*output, error) {
+	o := &output{path: path}
+	switch path {
+	case "-":
+		o.f = os.Stdout
+		return o, nil
+	case "syslog":
+		w, err := openSyslog()
+		if err != nil {
+			return nil, err
+		}
+		o.syslog = w
+		return o, nil
+	}
+	return o, o.Reopen()
+}
+
+// Write writes p to the file. To syslog every line is its own message, a
+// text report is several lines and a json one is one.
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.syslog == nil {
+		return o.f.Write(p)
+	}
+
+	for _, line := range bytes.Split(p, []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if _, err := o.syslog.Write(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Reopen closes and reopens the file, creating it if it was rotated away.
+func (o *output) Reopen() error {
+	if o.path == "-" || o.syslog != nil {
+		return nil
+	}
+
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.f != nil {
+		o.f.Close()
+	}
+	o.f = f
+	return nil
+}
+
+func (o *output) Close() error {
+	if o.path == "-" {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.syslog != nil {
+		return o.syslog.Close()
+	}
+	return o.f.Close()
+}
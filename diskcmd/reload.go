@@ -0,0 +1,86 @@
+package diskcmd
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"ved/test/config"
+	"ved/test/diskusage"
+)
+
+// reloader rereads what -watch can change without a restart when it gets
+// SIGHUP: the configuration's -threshold, -sustained and -rules, and the
+// rules file itself. Flags given on the command line keep their value, as
+// they win over the configuration at start, but the rules file they name is
+// still read again. So does a -threshold set through -control.
+type reloader struct {
+	fs       *flag.FlagSet
+	sections []string
+	cmdline  map[string]bool
+}
+
+// newReloader remembers the flags of fs given on the command line, call it
+// right after fs.Parse, before config.Apply sets the others.
+func newReloader(fs *flag.FlagSet, sections ...string) *reloader {
+	r := &reloader{fs: fs, sections: sections, cmdline: map[string]bool{}}
+	fs.Visit(func(f *flag.Flag) { r.cmdline[f.Name] = true })
+	return r
+}
+
+// reload reads the configuration and the rules file again and applies them
+// to o and alerts. Nothing changes when any of it is bad.
+func (r *reloader) reload(o *options, alerts *alerter) error {
+	c, err := config.Reload()
+	if err != nil {
+		return err
+	}
+
+	threshold := o.threshold
+	if !r.cmdline["threshold"] {
+		if threshold, err = strconv.Atoi(r.value(c, "threshold")); err != nil {
+			return fmt.Errorf("-threshold: %w", err)
+		}
+		if o.thresholdControlled {
+			if threshold != o.threshold {
+				slog.Info("keeping the threshold set through -control", "threshold", o.threshold, "configured", threshold)
+			}
+			threshold = o.threshold
+		}
+	}
+	sustained := alerts.sustained
+	if !r.cmdline["sustained"] {
+		if sustained, err = time.ParseDuration(r.value(c, "sustained")); err != nil {
+			return fmt.Errorf("-sustained: %w", err)
+		}
+	}
+	var rules *diskusage.AlertRules
+	if path := r.value(c, "rules"); path != "" {
+		if rules, err = diskusage.LoadAlertRules(path); err != nil {
+			return err
+		}
+	}
+
+	if threshold != o.threshold {
+		slog.Info("threshold changed", "from", o.threshold, "to", threshold)
+		o.threshold = threshold
+	}
+	alerts.sustained = sustained
+	alerts.setRules(rules)
+	return nil
+}
+
+// value is the flag's value on the command line, else the configuration's,
+// else its default.
+func (r *reloader) value(c *config.Config, name string) string {
+	f := r.fs.Lookup(name)
+	if r.cmdline[name] {
+		return f.Value.String()
+	}
+	if vs, ok := c.Flag(name, r.sections...); ok && len(vs) > 0 {
+		return vs[len(vs)-1]
+	}
+	return f.DefValue
+}
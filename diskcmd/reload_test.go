@@ -0,0 +1,69 @@
+package diskcmd
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("DEVOPS_CONFIG", path)
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newFlags := func(args ...string) *reloader {
+		fs := flag.NewFlagSet("disk", flag.ContinueOnError)
+		fs.Int("threshold", 90, "")
+		fs.Duration("sustained", 0, "")
+		fs.String("rules", "", "")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return newReloader(fs, "disk", "disk watch")
+	}
+
+	write("flags:\n  disk:\n    threshold: 80\n    sustained: 5m\n")
+	o := &options{threshold: 90}
+	alerts := &alerter{status: &notifierStatus{}}
+	if err := newFlags().reload(o, alerts); err != nil {
+		t.Fatal(err)
+	}
+	if o.threshold != 80 || alerts.sustained != 5*time.Minute {
+		t.Errorf("threshold %d, sustained %v, want the configuration's 80 and 5m", o.threshold, alerts.sustained)
+	}
+
+	// the command line wins
+	o.threshold = 95
+	if err := newFlags("-threshold", "95").reload(o, alerts); err != nil {
+		t.Fatal(err)
+	}
+	if o.threshold != 95 {
+		t.Errorf("threshold %d, want 95 from the command line", o.threshold)
+	}
+
+	// so does -control, until the process restarts
+	r := newFlags()
+	o.threshold, o.thresholdControlled = 70, true
+	write("flags:\n  disk:\n    threshold: 85\n    sustained: 1m\n")
+	if err := r.reload(o, alerts); err != nil {
+		t.Fatal(err)
+	}
+	if o.threshold != 70 || alerts.sustained != time.Minute {
+		t.Errorf("threshold %d, sustained %v, want 70 from -control and 1m", o.threshold, alerts.sustained)
+	}
+
+	// a bad value changes nothing
+	o.thresholdControlled = false
+	write("flags:\n  disk:\n    threshold: high\n    sustained: 2m\n")
+	if err := r.reload(o, alerts); err == nil {
+		t.Error("a bad threshold reloaded")
+	}
+	if o.threshold != 70 || alerts.sustained != time.Minute {
+		t.Errorf("threshold %d, sustained %v after a failed reload", o.threshold, alerts.sustained)
+	}
+}
@@ -0,0 +1,411 @@
+package diskcmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ved/test/diskusage"
+)
+
+// formats are the -format values, text is the old name of table.
+var formats = []string{"table", "text", "json", "csv", "log"}
+
+// reportWriter renders reports in one format. With stream set (-watch) JSON
+// is written one report per line and the CSV header only once. The log
+// format is one slog record per filesystem and directory. With sort
+// set, JSON and CSV reports are sorted by stable keys first, tables keep
+// the largest directories first.
+type reportWriter struct {
+	w      io.Writer
+	format string
+	stream bool
+	sort   bool
+
+	wroteHeader bool
+}
+
+func (rw *reportWriter) Write(report diskusage.Report) error {
+	report.SchemaVersion = diskusage.SchemaVersion
+	table := rw.format == "table" || rw.format == "text"
+	if rw.sort && !table {
+		report.Sort()
+	}
+
+	switch {
+	case table:
+		if rw.stream {
+			fmt.Fprintf(rw.w, "== %s ==\n", report.Time.Format(time.RFC3339))
+		}
+		printFilesystems(rw.w, report.Filesystems)
+		if len(report.Dirs) > 0 {
+			fmt.Fprintln(rw.w)
+			printDirs(rw.w, report.Dirs)
+		}
+		if report.DuSkipped > 0 {
+			fmt.Fprintf(rw.w, "du could not read %d directories, sizes are too low\n", report.DuSkipped)
+		}
+		if len(report.Budget) > 0 {
+			fmt.Fprintln(rw.w)
+			printBudget(rw.w, report.Budget)
+		}
+		if report.SinceBoot != nil {
+			fmt.Fprintln(rw.w)
+			printGrowth(rw.w, report.SinceBoot)
+		}
+		if len(report.Extensions) > 0 {
+			fmt.Fprintln(rw.w)
+			printExtensions(rw.w, report.Extensions)
+		}
+		if report.Reclaim != nil {
+			fmt.Fprintln(rw.w)
+			printReclaim(rw.w, report.Reclaim, report.Time)
+		}
+		printFailedCollectors(rw.w, report.Collectors)
+		if rw.stream {
+			fmt.Fprintln(rw.w)
+		}
+		return nil
+	case rw.format == "json":
+		enc := json.NewEncoder(rw.w)
+		if !rw.stream {
+			enc.SetIndent("", "  ")
+		}
+		return enc.Encode(report)
+	case rw.format == "csv":
+		err := writeCSV(rw.w, report, !rw.wroteHeader)
+		rw.wroteHeader = true
+		return err
+	case rw.format == "log":
+		writeLog(slog.New(slog.NewTextHandler(rw.w, nil)), report)
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", rw.format)
+}
+
+// reset makes the next CSV report start with a header again, used after the
+// output file was reopened.
+func (rw *reportWriter) reset() {
+	rw.wroteHeader = false
+}
+
+// writeLog logs the report as records a log shipper can parse, for running
+// under systemd or as a container sidecar. Mounts without stats and failed
+// collectors are warnings.
+func writeLog(log *slog.Logger, report diskusage.Report) {
+	for _, fs := range report.Filesystems {
+		level := slog.LevelInfo
+		if !fs.HasStats() {
+			level = slog.LevelWarn
+		}
+		args := []any{"mount", fs.MountPoint, "source", fs.Source, "fs_type", fs.FSType,
+			"size_bytes", fs.Size, "used_bytes", fs.Used, "avail_bytes", fs.Avail,
+			"use_percent", fmt.Sprintf("%.1f", fs.Percent()), "status", fs.Status}
+		if fs.Host != "" {
+			args = append(args, "host", fs.Host)
+		}
+		if fs.Inodes != nil {
+			args = append(args, "inode_percent", fmt.Sprintf("%.1f", fs.Inodes.UsePercent))
+		}
+		if fs.ReadOnly {
+			args = append(args, "read_only", true)
+		}
+		if fs.Errors != nil {
+			args = append(args, "fs_errors", *fs.Errors)
+		}
+		log.Log(context.Background(), level, "filesystem", args...)
+	}
+	for _, d := range report.Dirs {
+		log.Info("directory", "path", d.Path, "size_bytes", d.Size)
+	}
+	for _, c := range report.Collectors {
+		if !c.OK {
+			log.Warn("collector failed", "collector", c.Name, "err", c.Err)
+		}
+	}
+}
+
+var csvHeader = []string{
+	"schema_version", "time", "kind", "source", "fs_type", "class", "size_bytes", "used_bytes",
+	"avail_bytes", "use_percent", "mount_point", "status", "path", "files", "ext", "host",
+	"inodes", "inodes_used", "inodes_free", "read_only", "fs_errors",
+}
+
+// writeCSV writes one row per filesystem and per directory, the kind column
+// tells them apart. Collector statuses are only in the JSON and text output.
+func writeCSV(w io.Writer, report diskusage.Report, header bool) error {
+	cw := csv.NewWriter(w)
+	if header {
+		cw.Write(csvHeader)
+	}
+
+	version := strconv.Itoa(report.SchemaVersion)
+	ts := report.Time.Format(time.RFC3339)
+	for _, fs := range report.Filesystems {
+		cw.Write(append([]string{
+			version, ts, "filesystem", fs.Source, fs.FSType, fs.Class,
+			strconv.FormatInt(fs.Size, 10), strconv.FormatInt(fs.Used, 10),
+			strconv.FormatInt(fs.Avail, 10), strconv.Itoa(fs.UsePercent),
+			fs.MountPoint, fs.Status, fs.Path, "", "", fs.Host,
+		}, healthColumns(fs)...))
+	}
+	for _, d := range report.Dirs {
+		cw.Write([]string{
+			version, ts, "dir", "", "", "", strconv.FormatInt(d.Size, 10), "", "", "", "", "", d.Path,
+			strconv.FormatInt(d.Files, 10), "", "", "", "", "", "", "",
+		})
+	}
+	if report.Reclaim != nil {
+		for _, c := range report.Reclaim.Candidates {
+			cw.Write([]string{
+				version, ts, "reclaim", "", "", "", strconv.FormatInt(c.Size, 10), "", "", "", "", "", c.Path, "", "", "", "", "", "", "", "",
+			})
+		}
+	}
+	for _, e := range report.Extensions {
+		cw.Write([]string{
+			version, ts, "extension", "", "", "", strconv.FormatInt(e.Size, 10), "", "", "", "", "", "",
+			strconv.FormatInt(e.Files, 10), e.Ext, "", "", "", "", "", "",
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// healthColumns are the inode, read-only and error columns of a filesystem
+// row, empty where they weren't collected.
+func healthColumns(fs diskusage.Filesystem) []string {
+	cols := []string{"", "", "", strconv.FormatBool(fs.ReadOnly), ""}
+	if fs.Inodes != nil {
+		cols[0] = strconv.FormatInt(fs.Inodes.Total, 10)
+		cols[1] = strconv.FormatInt(fs.Inodes.Used, 10)
+		cols[2] = strconv.FormatInt(fs.Inodes.Free, 10)
+	}
+	if fs.Errors != nil {
+		cols[4] = strconv.FormatInt(*fs.Errors, 10)
+	}
+	return cols
+}
+
+// printFilesystems writes a df style table. A Path column is added when
+// usage was asked for paths, an IUse% column when inodes were collected and
+// a Device column with -lsblk.
+func printFilesystems(w io.Writer, filesystems []diskusage.Filesystem) {
+	withPath, withInodes, withDevice := false, false, false
+	for _, fs := range filesystems {
+		if fs.Path != "" {
+			withPath = true
+		}
+		if fs.Inodes != nil {
+			withInodes = true
+		}
+		if fs.BlockDevice != nil {
+			withDevice = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if withPath {
+		fmt.Fprint(tw, "Path\t")
+	}
+	fmt.Fprint(tw, "Filesystem\tType\tSize\tUsed\tAvail\tUse%\t")
+	if withInodes {
+		fmt.Fprint(tw, "IUse%\t")
+	}
+	fmt.Fprint(tw, "Mounted on\tStatus")
+	if withDevice {
+		fmt.Fprint(tw, "\tDevice")
+	}
+	fmt.Fprintln(tw)
+
+	for _, fs := range filesystems {
+		if withPath {
+			fmt.Fprintf(tw, "%s\t", fs.Path)
+		}
+		if !fs.HasStats() {
+			fmt.Fprintf(tw, "%s\t%s\t-\t-\t-\t-\t", fs.Source, fs.FSType)
+		} else {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d%%\t",
+				fs.Source, fs.FSType, humanBytes(fs.Size), humanBytes(fs.Used), humanBytes(fs.Avail), fs.UsePercent)
+		}
+		if withInodes {
+			if fs.Inodes != nil {
+				fmt.Fprintf(tw, "%.0f%%\t", fs.Inodes.UsePercent)
+			} else {
+				fmt.Fprint(tw, "-\t")
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s", fs.Name(), statusText(fs))
+		if withDevice {
+			fmt.Fprintf(tw, "\t%s", describeDevice(fs.BlockDevice))
+		}
+		fmt.Fprintln(tw)
+	}
+
+	tw.Flush()
+}
+
+// describeDevice sums up a BlockDevice in a few words, e.g. "lvm vg0/root
+// (vg0 12.0G free) on /dev/sda".
+func describeDevice(d *diskusage.BlockDevice) string {
+	if d == nil {
+		return "-"
+	}
+	desc := d.Type
+	if d.VolumeGroup != "" {
+		desc += " " + d.VolumeGroup + "/" + d.LogicalVolume
+		if d.VGFree != nil {
+			desc += fmt.Sprintf(" (%s %s free)", d.VolumeGroup, humanBytes(*d.VGFree))
+		}
+	}
+	if len(d.Disks) > 0 && (len(d.Disks) > 1 || d.Disks[0] != d.Path) {
+		desc += " on " + strings.Join(d.Disks, ",")
+	}
+	return desc
+}
+
+// printDirs writes the largest directories as a du style table.
+func printDirs(w io.Writer, dirs []diskusage.Dir) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	counted := false
+	for _, d := range dirs {
+		if d.Files > 0 {
+			counted = true
+		}
+	}
+
+	if counted {
+		fmt.Fprintln(tw, "Size\tFiles\tPath")
+	} else {
+		fmt.Fprintln(tw, "Size\tPath")
+	}
+
+	for _, d := range dirs {
+		if !counted {
+			fmt.Fprintf(tw, "%s\t%s\n", humanBytes(d.Size), d.Path)
+			continue
+		}
+		files := strconv.FormatInt(d.Files, 10)
+		if d.FilesPartial {
+			files = ">" + files
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", humanBytes(d.Size), files, d.Path)
+	}
+
+	tw.Flush()
+}
+
+// printBudget writes the budget comparison.
+func printBudget(w io.Writer, results []diskusage.BudgetResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Mounted on\tUse%\tPlanned%\tStatus")
+
+	for _, b := range results {
+		status := "ok"
+		if b.Over {
+			status = fmt.Sprintf("over budget by %.1f%%", b.OverBy)
+		}
+		fmt.Fprintf(tw, "%s\t%.1f%%\t%.1f%%\t%s\n", b.MountPoint, b.UsePercent, b.PlannedPercent, status)
+	}
+
+	tw.Flush()
+}
+
+// printGrowth writes the directories that grew since boot.
+func printGrowth(w io.Writer, g *diskusage.SinceBoot) {
+	fmt.Fprintf(w, "Grown since boot (%s, baseline %s):\n",
+		g.BootTime.Format(time.RFC3339), g.BaselineTime.Format(time.RFC3339))
+	if len(g.Dirs) == 0 {
+		fmt.Fprintln(w, "nothing grew")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Grown\tSize\tPath")
+	for _, d := range g.Dirs {
+		path := d.Path
+		if d.New {
+			path += " (new)"
+		}
+		fmt.Fprintf(tw, "+%s\t%s\t%s\n", humanBytes(d.Grown), humanBytes(d.Size), path)
+	}
+	tw.Flush()
+}
+
+// printExtensions writes the space per file extension.
+func printExtensions(w io.Writer, usage []diskusage.ExtensionUsage) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Size\tFiles\tExtension")
+	for _, e := range usage {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", humanBytes(e.Size), e.Files, e.Ext)
+	}
+	tw.Flush()
+}
+
+// printReclaim writes the cleanup candidates and what they add up to.
+func printReclaim(w io.Writer, r *diskusage.Reclaim, now time.Time) {
+	if len(r.Candidates) == 0 {
+		fmt.Fprintln(w, "No reclaim candidates.")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Size\tUnused for\tRule\tReclaim candidate")
+	for _, c := range r.Candidates {
+		days := int(now.Sub(c.ModTime).Hours() / 24)
+		fmt.Fprintf(tw, "%s\t%dd\t%s\t%s\n", humanBytes(c.Size), days, c.Pattern, c.Path)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Reclaimable: %s (suggestions only, nothing was deleted)\n", humanBytes(r.TotalBytes))
+}
+
+// printFailedCollectors notes the collectors that failed, so a missing
+// section doesn't look like there was nothing to report.
+func printFailedCollectors(w io.Writer, collectors []diskusage.CollectorStatus) {
+	for _, c := range collectors {
+		if !c.OK {
+			fmt.Fprintf(w, "\n%s failed: %s\n", c.Name, c.Err)
+		}
+	}
+}
+
+// statusText is the Status column: the status, and whether the filesystem
+// is read-only or counted errors.
+func statusText(fs diskusage.Filesystem) string {
+	status := fs.Status
+	if fs.ReadOnly {
+		status += ", read-only"
+	}
+	if fs.Errors != nil && *fs.Errors > 0 {
+		status += fmt.Sprintf(", %d errors", *fs.Errors)
+	}
+	return status
+}
+
+// humanBytes formats n like df -h does.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	v := float64(n) / float64(div)
+	if v < 10 {
+		return fmt.Sprintf("%.1f%c", v, "KMGTPE"[exp])
+	}
+	return fmt.Sprintf("%.0f%c", v, "KMGTPE"[exp])
+}
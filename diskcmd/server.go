@@ -0,0 +1,233 @@
+package diskcmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"ved/test/diskusage"
+	"ved/test/metrics"
+)
+
+// lastGood remembers the last successful report and how the latest
+// collection went, for the HTTP endpoints.
+type lastGood struct {
+	// started is when -watch started, the first collection has until
+	// -stale-after from then
+	started     time.Time
+	mu          sync.Mutex
+	report      diskusage.Report
+	lastSuccess time.Time
+	lastErr     error
+}
+
+func (c *lastGood) update(report diskusage.Report, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastErr = err
+	if err == nil {
+		c.report = report
+		c.lastSuccess = time.Now()
+	}
+}
+
+func (c *lastGood) get() (diskusage.Report, time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.report, c.lastSuccess, c.lastErr
+}
+
+// notifierStatus is how many notifiers the alert rules have and how
+// sending to them last went.
+type notifierStatus struct {
+	mu        sync.Mutex
+	notifiers int
+	lastSent  time.Time
+	lastErr   error
+}
+
+func (s *notifierStatus) configure(notifiers int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notifiers = notifiers
+}
+
+func (s *notifierStatus) sent(at time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSent, s.lastErr = at, err
+}
+
+func (s *notifierStatus) get() notifierHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := notifierHealth{Count: s.notifiers, LastSent: s.lastSent}
+	if s.lastErr != nil {
+		h.LastError = s.lastErr.Error()
+	}
+	return h
+}
+
+type notifierHealth struct {
+	Count     int       `json:"count"`
+	LastSent  time.Time `json:"last_sent,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type health struct {
+	Status      string         `json:"status"`
+	LastSuccess time.Time      `json:"last_success,omitzero"`
+	AgeSeconds  float64        `json:"age_seconds,omitempty"`
+	LastError   string         `json:"last_error,omitempty"`
+	Notifiers   notifierHealth `json:"notifiers"`
+}
+
+// newHealth is the state of the last collection and of the notifiers.
+func newHealth(c *lastGood, n *notifierStatus) health {
+	_, lastSuccess, lastErr := c.get()
+	h := health{Status: "ok", LastSuccess: lastSuccess, Notifiers: n.get()}
+	if lastErr != nil {
+		h.LastError = lastErr.Error()
+	}
+	if !lastSuccess.IsZero() {
+		h.AgeSeconds = time.Since(lastSuccess).Seconds()
+	}
+	return h
+}
+
+// healthHandler is the liveness probe: it answers 200 while collections
+// keep succeeding and 503 once the last success, or the start when there
+// was none, is older than staleAfter, a hung or failing collector that a
+// restart may fix. It is independent of the data endpoints.
+func healthHandler(c *lastGood, n *notifierStatus, staleAfter time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHealth(c, n)
+		code := http.StatusOK
+		switch {
+		case h.LastSuccess.IsZero() && time.Since(c.started) <= staleAfter:
+			h.Status = "starting"
+		case h.LastSuccess.IsZero():
+			h.Status = "no successful collection yet"
+			code = http.StatusServiceUnavailable
+		case time.Since(h.LastSuccess) > staleAfter:
+			h.Status = "stale"
+			code = http.StatusServiceUnavailable
+		}
+		writeHealth(w, code, h)
+	}
+}
+
+// readyHandler is the readiness probe: it answers 503 until the first
+// collection succeeded, then 200, so /metrics isn't scraped empty. A
+// notifier that failed is reported but doesn't make it unready, only the
+// next alert would tell it recovered.
+func readyHandler(c *lastGood, n *notifierStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := newHealth(c, n)
+		code := http.StatusOK
+		if h.LastSuccess.IsZero() {
+			h.Status = "no successful collection yet"
+			code = http.StatusServiceUnavailable
+		}
+		writeHealth(w, code, h)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, code int, h health) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(h)
+}
+
+// metricsHandler serves the last successful report in the Prometheus text
+// format. Mounts without stats only get disk_stats_available 0.
+func metricsHandler(c *lastGood) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, lastSuccess, _ := c.get()
+
+		reg := metrics.NewRegistry()
+		fsLabels := []string{"mount", "source", "fs_type", "class", "host"}
+		available := reg.Gauge("disk_stats_available", "1 if df reported usage for the filesystem, 0 if it is stale, unreachable or has no stats.", fsLabels...)
+		size := reg.Gauge("disk_size_bytes", "Size of the filesystem in bytes.", fsLabels...)
+		used := reg.Gauge("disk_used_bytes", "Used bytes of the filesystem.", fsLabels...)
+		avail := reg.Gauge("disk_avail_bytes", "Bytes available to unprivileged users.", fsLabels...)
+		percent := reg.Gauge("disk_use_percent", "Used percent of the filesystem, exact with -bytes.", fsLabels...)
+		readOnly := reg.Gauge("disk_read_only", "1 if the filesystem is mounted read-only.", fsLabels...)
+		inodes := reg.Gauge("disk_inodes_total", "Inodes of the filesystem, only for filesystems with a fixed number of them.", fsLabels...)
+		inodesUsed := reg.Gauge("disk_inodes_used", "Used inodes of the filesystem.", fsLabels...)
+		inodesFree := reg.Gauge("disk_inodes_free", "Free inodes of the filesystem.", fsLabels...)
+		inodePercent := reg.Gauge("disk_inode_use_percent", "Used percent of the inodes.", fsLabels...)
+		fsErrors := reg.Gauge("disk_fs_errors", "Errors the kernel counted for the filesystem since it was created, ext2/3/4 only.", fsLabels...)
+		for _, fs := range report.Filesystems {
+			labels := []string{fs.MountPoint, fs.Source, fs.FSType, fs.Class, fs.Host}
+			ro := 0.0
+			if fs.ReadOnly {
+				ro = 1
+			}
+			readOnly.Set(ro, labels...)
+			if fs.Errors != nil {
+				fsErrors.Set(float64(*fs.Errors), labels...)
+			}
+			if !fs.HasStats() {
+				available.Set(0, labels...)
+				continue
+			}
+			available.Set(1, labels...)
+			size.Set(float64(fs.Size), labels...)
+			used.Set(float64(fs.Used), labels...)
+			avail.Set(float64(fs.Avail), labels...)
+			percent.Set(fs.Percent(), labels...)
+			if fs.Inodes != nil {
+				inodes.Set(float64(fs.Inodes.Total), labels...)
+				inodesUsed.Set(float64(fs.Inodes.Used), labels...)
+				inodesFree.Set(float64(fs.Inodes.Free), labels...)
+				inodePercent.Set(fs.Inodes.UsePercent, labels...)
+			}
+		}
+
+		dirs := reg.Gauge("dir_size_bytes", "Size of one of the largest directories under -du-path.", "path")
+		for _, d := range report.Dirs {
+			dirs.Set(float64(d.Size), d.Path)
+		}
+
+		success := reg.Gauge("disk_collector_success", "1 if the collector succeeded in the last successful report.", "collector")
+		took := reg.Gauge("disk_collector_duration_seconds", "How long the collector took.", "collector")
+		for _, col := range report.Collectors {
+			ok := 0.0
+			if col.OK {
+				ok = 1
+			}
+			success.Set(ok, col.Name)
+			took.Set(col.Duration.Seconds(), col.Name)
+		}
+		if !lastSuccess.IsZero() {
+			reg.Gauge("disk_last_success_timestamp_seconds", "When the last successful collection finished.").
+				Set(float64(lastSuccess.UnixNano()) / 1e9)
+		}
+
+		w.Header().Set("Content-Type", metrics.ContentType)
+		reg.WriteTo(w)
+	}
+}
+
+// serve runs the HTTP server until ctx is done.
+func serve(ctx context.Context, addr string, mux *http.ServeMux) {
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("listening", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("http server failed", "err", err)
+	}
+}
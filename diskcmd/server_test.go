@@ -0,0 +1,58 @@
+package diskcmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ved/test/diskusage"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	get := func(h http.HandlerFunc) (int, health) {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest("GET", "/healthz", nil))
+		var body health
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, body
+	}
+
+	c := &lastGood{started: time.Now()}
+	n := &notifierStatus{}
+	n.configure(2)
+	if code, h := get(healthHandler(c, n, time.Minute)); code != http.StatusOK || h.Status != "starting" || h.Notifiers.Count != 2 {
+		t.Errorf("starting: %d %+v", code, h)
+	}
+	if code, h := get(readyHandler(c, n)); code != http.StatusServiceUnavailable || h.Status != "no successful collection yet" {
+		t.Errorf("ready before the first collection: %d %+v", code, h)
+	}
+	c.started = time.Now().Add(-time.Hour)
+	if code, h := get(healthHandler(c, n, time.Minute)); code != http.StatusServiceUnavailable || h.Status != "no successful collection yet" {
+		t.Errorf("never collected: %d %+v", code, h)
+	}
+
+	c.update(diskusage.Report{}, nil)
+	n.sent(time.Now(), errors.New("webhook: 500"))
+	if code, h := get(healthHandler(c, n, time.Minute)); code != http.StatusOK || h.Status != "ok" || h.Notifiers.LastError != "webhook: 500" {
+		t.Errorf("collected: %d %+v", code, h)
+	}
+	// a failed notifier doesn't make it unready
+	if code, h := get(readyHandler(c, n)); code != http.StatusOK || h.Status != "ok" {
+		t.Errorf("ready: %d %+v", code, h)
+	}
+
+	// a failed collection keeps the last success
+	c.update(diskusage.Report{}, errors.New("df: timed out"))
+	c.lastSuccess = time.Now().Add(-2 * time.Minute)
+	if code, h := get(healthHandler(c, n, time.Minute)); code != http.StatusServiceUnavailable || h.Status != "stale" || h.LastError != "df: timed out" || h.AgeSeconds < 120 {
+		t.Errorf("stale: %d %+v", code, h)
+	}
+	if code, _ := get(readyHandler(c, n)); code != http.StatusOK {
+		t.Errorf("stale but ready once collected: %d", code)
+	}
+}
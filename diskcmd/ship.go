@@ -0,0 +1,73 @@
+package diskcmd
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path"
+	"time"
+
+	"ved/test/diskusage"
+	"ved/test/storage"
+)
+
+// shipPruneEvery is how often -ship looks for reports past their
+// retention, listing a bucket every -watch tick would cost more than it
+// saves.
+const shipPruneEvery = time.Hour
+
+// shipper uploads every report to -ship, under
+// <host>/<yyyy>/<mm>/<dd>/<time>.<format> so a fleet of hosts can share a
+// bucket, and deletes this host's reports past the retention.
+type shipper struct {
+	store     storage.Storage
+	format    string
+	host      string
+	retention storage.Retention
+	timeout   time.Duration
+
+	lastPrune time.Time
+}
+
+// key is where the report taken at t goes. It has milliseconds, a report
+// collected right away with SIGUSR1 or -control must not overwrite the one
+// of the same second.
+func (s *shipper) key(t time.Time) string {
+	t = t.UTC()
+	return path.Join(s.host, t.Format("2006/01/02"), t.Format("20060102T150405.000Z")+"."+s.format)
+}
+
+// ship uploads report, then prunes when it is time to.
+func (s *shipper) ship(ctx context.Context, report diskusage.Report) error {
+	var buf bytes.Buffer
+	rw := &reportWriter{w: &buf, format: s.format, sort: true}
+	if err := rw.Write(report); err != nil {
+		return err
+	}
+	contentType := "application/json"
+	if s.format == "csv" {
+		contentType = "text/csv"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	key := s.key(report.Time)
+	if err := s.store.Put(ctx, key, buf.Bytes(), contentType); err != nil {
+		return err
+	}
+	slog.Debug("report shipped", "key", key, "bytes", buf.Len())
+
+	if time.Since(s.lastPrune) < shipPruneEvery {
+		return nil
+	}
+	s.lastPrune = time.Now()
+	deleted, err := storage.Prune(ctx, s.store, s.host+"/", s.retention, time.Now())
+	if len(deleted) > 0 {
+		slog.Info("old shipped reports deleted", "count", len(deleted))
+	}
+	if err != nil {
+		// the report is up, the next prune tries again
+		slog.Warn("deleting old shipped reports failed", "err", err)
+	}
+	return nil
+}
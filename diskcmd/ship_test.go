@@ -0,0 +1,37 @@
+package diskcmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ved/test/diskusage"
+	"ved/test/storage"
+)
+
+func TestShipKey(t *testing.T) {
+	store, err := storage.Open("file://" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &shipper{store: store, format: "json", host: "web1", timeout: 10 * time.Second}
+
+	at := time.Date(2026, 10, 15, 9, 30, 12, 345678901, time.FixedZone("CEST", 2*3600))
+	if got, want := s.key(at), "web1/2026/10/15/20261015T073012.345Z.json"; got != want {
+		t.Errorf("key %s, want %s", got, want)
+	}
+
+	// a collection forced right after the scheduled one keeps both
+	for _, at := range []time.Time{at, at.Add(200 * time.Millisecond)} {
+		if err := s.ship(context.Background(), diskusage.Report{Time: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	objects, err := store.List(context.Background(), "web1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Errorf("%d reports shipped, want 2: %+v", len(objects), objects)
+	}
+}
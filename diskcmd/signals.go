@@ -0,0 +1,12 @@
+//go:build !windows && !plan9
+
+package diskcmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// collectNow are the signals that make -watch collect right away, kill
+// -USR1 <pid>.
+var collectNow = []os.Signal{syscall.SIGUSR1}
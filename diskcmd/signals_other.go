@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package diskcmd
+
+import "os"
+
+// collectNow is empty, there is no SIGUSR1 here. -control's scan
+// command does the same.
+var collectNow []os.Signal
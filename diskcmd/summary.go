@@ -0,0 +1,30 @@
+package diskcmd
+
+import (
+	"log/slog"
+	"os"
+
+	"ved/test/logging"
+	"ved/test/summary"
+)
+
+// runSummary is the record -summary-file gets, nil without it.
+var runSummary *summary.Summary
+
+// logs is -log-level, -log-format and -log-output, -v lowers the level to
+// debug.
+var logs logging.Config
+
+// exit is os.Exit that writes the -summary-file and closes the log first.
+func exit(code int) {
+	runSummary.Finish(code)
+	logs.Close(code)
+	os.Exit(code)
+}
+
+// fatal logs an error, keeps it for the -summary-file and exits with code.
+func fatal(code int, msg string, args ...any) {
+	slog.Error(msg, args...)
+	runSummary.Fail(msg, args...)
+	exit(code)
+}
@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package diskcmd
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// openSyslog connects to the local syslog daemon, reports are logged as
+// daemon.info tagged with the program name.
+func openSyslog() (io.WriteCloser, error) {
+	return syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "")
+}
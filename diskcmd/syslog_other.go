@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package diskcmd
+
+import (
+	"errors"
+	"io"
+)
+
+func openSyslog() (io.WriteCloser, error) {
+	return nil, errors.New("-output syslog is not supported on this system")
+}
@@ -0,0 +1,299 @@
+package diskcmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"ved/test/diskusage"
+	"ved/test/notify"
+)
+
+// notifyTimeout bounds sending one alert to all notifiers, a hanging
+// webhook must not hold up the next poll.
+const notifyTimeout = 10 * time.Second
+
+// alerter warns about mounts at or above the threshold and about the -rules
+// that fire. With sustained set (-watch only) a mount has to stay over the
+// threshold, or a rule keep firing, that long across collections before it
+// is reported, so short bursts of temp files don't alert.
+//
+// The notifiers of the rules file hear about an alert once when it is
+// first reported, again every repeat while it lasts and once more when it
+// resolves; the log warns on every check.
+type alerter struct {
+	sustained time.Duration
+	rules     *diskusage.AlertRules
+	notifiers []notify.Notifier
+	repeat    time.Duration
+	host      string
+	// since is when each mount was first seen over the threshold, or
+	// mount+rule first fired, seen is what was over in this check
+	since map[string]time.Time
+	seen  map[string]bool
+	// notified is when the notifiers were last told about a key, last is
+	// each mount's previous usage for growth_per_hour
+	notified map[string]time.Time
+	last     map[string]usage
+	// writable are the mounts last seen mounted read-write
+	writable map[string]bool
+
+	// topWriters is how many of the processes writing the most a firing
+	// alert names, sampled over ioInterval from procDir once per check
+	topWriters int
+	ioInterval time.Duration
+	procDir    string
+	writers    []string
+	sampled    bool
+
+	// status is how sending went, for /readyz
+	status *notifierStatus
+}
+
+type usage struct {
+	used int64
+	at   time.Time
+}
+
+// check logs a warning for every mount at or above threshold percent, by
+// the exact percent when bytes were collected, and for every rule that fires.
+// Mounts df could not report on are logged at debug level only, an unknown
+// value is not a breach and doesn't reset -sustained either. With dedup a
+// device mounted in several places warns once, for its primary mount.
+func (a *alerter) check(ctx context.Context, filesystems []diskusage.Filesystem, threshold int, dedup bool, now time.Time) {
+	if a.since == nil {
+		a.since = map[string]time.Time{}
+		a.notified = map[string]time.Time{}
+		a.last = map[string]usage{}
+		a.writable = map[string]bool{}
+	}
+	a.seen = map[string]bool{}
+	a.writers, a.sampled = nil, false
+
+	if !dedup {
+		for _, fs := range filesystems {
+			a.warn(ctx, fs, threshold, now)
+			a.fire(ctx, fs, now)
+		}
+	} else {
+		for _, d := range diskusage.GroupByDevice(filesystems) {
+			a.warn(ctx, d.Filesystem, threshold, now, "also_mounted_on", d.MountPoints[1:])
+			a.fire(ctx, d.Filesystem, now, "also_mounted_on", d.MountPoints[1:])
+		}
+	}
+
+	for _, fs := range filesystems {
+		a.remount(ctx, fs, now)
+	}
+
+	// a mount that went away or recovered can't still be over, unknown
+	// mounts are marked seen so they keep their time
+	for key, first := range a.since {
+		if !a.seen[key] {
+			delete(a.since, key)
+			a.resolve(ctx, key, first, now)
+		}
+	}
+
+	for _, fs := range filesystems {
+		if fs.HasStats() {
+			a.last[fs.Name()] = usage{used: fs.Used, at: now}
+		}
+	}
+}
+
+// setRules replaces the alert rules and their notifiers, nil for none.
+func (a *alerter) setRules(rules *diskusage.AlertRules) {
+	a.rules, a.notifiers, a.repeat = nil, nil, 0
+	if rules != nil {
+		a.rules = rules
+		a.notifiers = rules.Targets()
+		a.repeat = rules.RepeatInterval
+		a.host, _ = os.Hostname()
+	}
+	a.status.configure(len(a.notifiers))
+}
+
+// growth is how many bytes per hour fs's used bytes grew since the previous
+// check, nil on the first.
+func (a *alerter) growth(fs diskusage.Filesystem, now time.Time) *float64 {
+	prev, ok := a.last[fs.Name()]
+	if !ok || !now.After(prev.at) {
+		return nil
+	}
+	perHour := float64(fs.Used-prev.used) / now.Sub(prev.at).Hours()
+	return &perHour
+}
+
+// notify tells the notifiers that key is firing, the first time it is
+// reported and then every repeat.
+func (a *alerter) notify(ctx context.Context, key, mount, rule, msg string, first, now time.Time) {
+	if len(a.notifiers) == 0 {
+		return
+	}
+	if last, ok := a.notified[key]; ok && (a.repeat == 0 || now.Sub(last) < a.repeat) {
+		return
+	}
+	a.notified[key] = now
+	a.send(ctx, notify.Alert{State: notify.StateFiring, Mount: mount, Rule: rule, Message: msg, Since: first, Time: now, TopWriters: a.sampleWriters(ctx)})
+}
+
+// sampleWriters is the top writers for this check's alerts, sampled by
+// the first that asks, so several alerts at once wait for one sample. The
+// sample ends with ctx, an interrupt doesn't wait for -alert-io-interval.
+func (a *alerter) sampleWriters(ctx context.Context) []string {
+	if a.topWriters == 0 || a.sampled {
+		return a.writers
+	}
+	a.sampled = true
+	ctx, cancel := context.WithTimeout(ctx, a.ioInterval+notifyTimeout)
+	defer cancel()
+	writers, err := topWriters(ctx, a.procDir, a.ioInterval, a.topWriters)
+	if err != nil {
+		slog.Warn("sampling the top writers failed", "err", err)
+	}
+	a.writers = writers
+	return writers
+}
+
+// resolve tells the notifiers that key is no longer firing, if they were
+// told it was.
+func (a *alerter) resolve(ctx context.Context, key string, first, now time.Time) {
+	if _, ok := a.notified[key]; !ok {
+		return
+	}
+	delete(a.notified, key)
+	mount, rule, ok := strings.Cut(key, "\x00")
+	if !ok {
+		rule = "threshold"
+	}
+	a.send(ctx, notify.Alert{State: notify.StateResolved, Mount: mount, Rule: rule, Message: "no longer firing", Since: first, Time: now})
+}
+
+func (a *alerter) send(ctx context.Context, alert notify.Alert) {
+	alert.Host = a.host
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	err := notify.SendAll(ctx, a.notifiers, alert)
+	a.status.sent(alert.Time, err)
+	if err != nil {
+		slog.Error("sending alert failed", "mount", alert.Mount, "rule", alert.Rule, "state", alert.State, "err", err)
+	}
+}
+
+// over records that key is over now and tells whether to report it, with
+// -sustained only once it has been over that long. first is when it went
+// over.
+func (a *alerter) over(key string, now time.Time) (first time.Time, report bool) {
+	a.seen[key] = true
+	first, ok := a.since[key]
+	if !ok {
+		first = now
+		a.since[key] = now
+	}
+	return first, now.Sub(first) >= a.sustained
+}
+
+// keep marks everything of mount as seen, a mount without stats neither
+// breaches nor recovers.
+func (a *alerter) keep(mount string) {
+	for key := range a.since {
+		if key == mount || strings.HasPrefix(key, mount+"\x00") {
+			a.seen[key] = true
+		}
+	}
+}
+
+// fire logs a warning for every rule that fires for fs.
+func (a *alerter) fire(ctx context.Context, fs diskusage.Filesystem, now time.Time, args ...any) {
+	if a.rules == nil {
+		return
+	}
+	perHour := a.growth(fs, now)
+	for _, r := range a.rules.Fired(fs, perHour) {
+		key := fs.Name() + "\x00" + r.Name
+		first, report := a.over(key, now)
+		if !report {
+			slog.Debug("rule fired, not for -sustained yet", "mount", fs.Name(), "rule", r.Name, "since", first)
+			continue
+		}
+		args := args
+		if a.sustained > 0 {
+			args = append(args, "over_since", first.Format(time.RFC3339))
+		}
+		msg := r.When
+		if r.GrowthPerHour != "" {
+			// a growth rule only fires with a rate
+			grew := humanBytes(int64(*perHour)) + "/h"
+			args = append(args, "growth_per_hour", grew)
+			if msg != "" {
+				msg += ", "
+			}
+			msg += fmt.Sprintf("grew %s, limit %s/h", grew, r.GrowthPerHour)
+		}
+		runSummary.Alert(fs.Name(), r.Name)
+		slog.Warn("alert rule fired", append([]any{
+			"mount", fs.Name(),
+			"rule", r.Name,
+			"when", r.When,
+			"use_percent", fs.UsePercent,
+			"avail_bytes", fs.Avail,
+		}, args...)...)
+		a.notify(ctx, key, fs.Name(), r.Name, fmt.Sprintf("%s (use %d%%, %s available)", msg, fs.UsePercent, humanBytes(fs.Avail)), first, now)
+	}
+}
+
+// remount warns while fs is read-only after it was seen read-write, the
+// way ext4 with errors=remount-ro reacts to IO errors. A mount that was
+// read-only from the start is not reported.
+func (a *alerter) remount(ctx context.Context, fs diskusage.Filesystem, now time.Time) {
+	name := fs.Name()
+	if !fs.ReadOnly {
+		a.writable[name] = true
+		return
+	}
+	key := name + "\x00read_only"
+	if _, firing := a.since[key]; !firing && !a.writable[name] {
+		return
+	}
+	delete(a.writable, name)
+	// it is reported right away, -sustained is for usage that comes and goes
+	first, _ := a.over(key, now)
+	runSummary.Alert(name, "read_only")
+	slog.Warn("filesystem remounted read-only", "mount", name, "source", fs.Source, "since", first.Format(time.RFC3339))
+	a.notify(ctx, key, name, "read_only", "remounted read-only", first, now)
+}
+
+func (a *alerter) warn(ctx context.Context, fs diskusage.Filesystem, threshold int, now time.Time, args ...any) {
+	if !fs.HasStats() {
+		slog.Debug("skipping threshold check, no stats", "mount", fs.Name(), "status", fs.Status)
+		a.keep(fs.Name())
+		return
+	}
+	if fs.Percent() < float64(threshold) {
+		return
+	}
+
+	first, report := a.over(fs.Name(), now)
+	if !report {
+		slog.Debug("over threshold, not for -sustained yet", "mount", fs.Name(), "since", first)
+		return
+	}
+	if a.sustained > 0 {
+		args = append(args, "over_since", first.Format(time.RFC3339))
+	}
+
+	if fs.UsedPercent != nil {
+		args = append(args, "used_percent", fmt.Sprintf("%.2f", *fs.UsedPercent))
+	}
+	runSummary.Alert(fs.Name(), "threshold")
+	slog.Warn("disk usage over threshold", append([]any{
+		"mount", fs.Name(),
+		"use_percent", fs.UsePercent,
+		"threshold", threshold,
+	}, args...)...)
+	a.notify(ctx, fs.Name(), fs.Name(), "threshold", fmt.Sprintf("use %.1f%%, threshold %d%%", fs.Percent(), threshold), first, now)
+}
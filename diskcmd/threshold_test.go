@@ -0,0 +1,39 @@
+package diskcmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSampleWritersInterrupted(t *testing.T) {
+	proc := filepath.Join(t.TempDir(), "1")
+	if err := os.MkdirAll(proc, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"io":   "read_bytes: 0\nwrite_bytes: 4096\ncancelled_write_bytes: 0\n",
+		"stat": "1 (init) S 0 1 1 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 10 0 0\n",
+	} {
+		if err := os.WriteFile(filepath.Join(proc, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &alerter{topWriters: 3, ioInterval: time.Hour, procDir: filepath.Dir(proc)}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if writers := a.sampleWriters(ctx); writers != nil {
+		t.Errorf("writers %q from an interrupted sample", writers)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the interrupted sample took %v", d)
+	}
+	// the next alert of the check doesn't sample again
+	if !a.sampled {
+		t.Error("not marked sampled")
+	}
+}
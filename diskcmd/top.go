@@ -0,0 +1,408 @@
+package diskcmd
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+
+	"ved/test/config"
+	"ved/test/diskusage"
+	"ved/test/duscan"
+	"ved/test/logging"
+)
+
+// Top is `devops disk top`: usage bars of every mount, refreshed every
+// -interval, like htop for disk space. A mount can be opened to see its
+// largest directories, and those in turn. It needs a terminal and returns
+// the exit code.
+func Top(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	interval := fs.Duration("interval", 2*time.Second, "how often usage is collected")
+	threshold := fs.Int("threshold", 90, "show mounts at or above this use percent in red, within 10 points of it in yellow")
+	collectorKind := fs.String("collector", "auto", "how filesystem usage is collected: statfs, df, or auto for statfs where available")
+	mountsFile := fs.String("mounts", "/proc/mounts", "mount table used for filesystem types")
+	mountTimeout := fs.Duration("mount-timeout", 5*time.Second, "timeout for each mount")
+	all := fs.Bool("all", false, "also show pseudo, virtual, loop and container filesystems, the a key toggles it")
+	var scan duscan.Options
+	fs.IntVar(&scan.Top, "top", 100, "list this many of the largest subdirectories of an opened directory")
+	fs.Func("exclude", "skip files and directories matching this glob when scanning a directory, repeatable", func(v string) error {
+		scan.Exclude = append(scan.Exclude, v)
+		return nil
+	})
+	fs.BoolVar(&scan.Apparent, "apparent-size", false, "total file sizes instead of the disk space files take")
+	fs.IntVar(&scan.Workers, "workers", duscan.DefaultWorkers, "how many directories are read at once when scanning")
+	scanTimeout := fs.Duration("scan-timeout", 10*time.Minute, "stop scanning a directory after this long and list what was found so far")
+	logs.Flags(fs)
+	fs.Parse(args)
+	configErr := config.Apply(fs, "disk top")
+
+	if err := logs.Open(name); err != nil {
+		slog.Error("bad logging flags", "err", err)
+		return 2
+	}
+	defer logs.Close(0)
+	slog.SetDefault(slog.New(logs.Handler()))
+	if configErr != nil {
+		slog.Error("bad configuration", "err", configErr)
+		return 2
+	}
+
+	if *interval < 100*time.Millisecond {
+		slog.Error("-interval must be at least 100ms")
+		return 2
+	}
+	if scan.Top <= 0 {
+		slog.Error("-top must be at least 1")
+		return 2
+	}
+	if err := scan.Validate(); err != nil {
+		slog.Error("bad -exclude", "err", err)
+		return 2
+	}
+	// the list holds a directory's children, the root is the total
+	scan.MaxDepth = 1
+	scan.Top++
+	scan.OneFilesystem = true
+
+	// every class is collected so a toggles them without waiting
+	include := map[string]bool{}
+	for _, class := range []string{diskusage.ClassReal, diskusage.ClassNetwork, diskusage.ClassPseudo, diskusage.ClassVirtual, diskusage.ClassLoop, diskusage.ClassContainer} {
+		include[class] = true
+	}
+	collector, err := diskusage.NewCollector(*collectorKind, diskusage.DfCollector{
+		MountsFile: *mountsFile,
+		Exact:      true,
+		PerMount:   true,
+		Timeout:    *mountTimeout,
+		Include:    include,
+	})
+	if err != nil {
+		slog.Error("bad -collector", "err", err)
+		return 2
+	}
+
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		slog.Error("disk top needs a terminal, disk watch prints reports without one")
+		return 2
+	}
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		slog.Error("setting up the terminal failed", "err", err)
+		return 1
+	}
+	// the alternate screen, without a cursor, is given back on the way out
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+
+	// records would be drawn over by the next frame, they are kept and
+	// printed once the screen is restored
+	var held lockedBuffer
+	if logs.Output == "" || logs.Output == logging.OutputStderr {
+		slog.SetDefault(slog.New(slog.NewTextHandler(&held, &slog.HandlerOptions{Level: &logs.Level})))
+	}
+
+	v := &topView{threshold: *threshold, interval: *interval, all: *all}
+	runTop(v, collector, scan, *scanTimeout, os.Stdin, os.Stdout, func() (int, int, error) { return term.GetSize(out) })
+
+	fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+	term.Restore(in, state)
+	os.Stderr.Write(held.Bytes())
+	return 0
+}
+
+// topCollection is the result of one collection.
+type topCollection struct {
+	filesystems []diskusage.Filesystem
+	err         error
+	at          time.Time
+}
+
+// topScan is a scanned directory, for l.
+type topScan struct {
+	l   *dirListing
+	res diskusage.DuResult
+	err error
+}
+
+// runTop draws v on w and changes it as keys come from r, until q. Usage is
+// collected every v.interval, one collection at a time; directories are
+// scanned in the background, leaving one cancels its scan.
+func runTop(v *topView, collector diskusage.Collector, scan duscan.Options, scanTimeout time.Duration, r io.Reader, w io.Writer, size func() (int, int, error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer func() {
+		for _, l := range v.dirs {
+			l.cancel()
+		}
+	}()
+
+	collected := make(chan topCollection, 1)
+	collecting := false
+	collect := func() {
+		if collecting {
+			return
+		}
+		collecting = true
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, max(v.interval, 30*time.Second))
+			defer cancel()
+			filesystems, err := collector.Collect(ctx)
+			collected <- topCollection{filesystems, err, time.Now()}
+		}()
+	}
+
+	scanned := make(chan topScan)
+	open := func(path string) {
+		scanCtx, cancel := context.WithTimeout(ctx, scanTimeout)
+		l := &dirListing{path: path, scanning: true, started: time.Now(), cancel: cancel}
+		v.dirs = append(v.dirs, l)
+		go func() {
+			res, err := duscan.Scan(scanCtx, path, scan)
+			// a listing left before its scan ended still gets the result,
+			// it is just no longer shown
+			select {
+			case scanned <- topScan{l, res, err}:
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	keys := make(chan string)
+	go readKeys(r, keys)
+
+	collect()
+	tick := time.NewTicker(v.interval)
+	defer tick.Stop()
+	// the size is checked often, a resized terminal is redrawn right away
+	redraw := time.NewTicker(250 * time.Millisecond)
+	defer redraw.Stop()
+
+	draw := func() {
+		width, height, err := size()
+		if err != nil {
+			width, height = 80, 24
+		}
+		v.width, v.height = width, height
+		io.WriteString(w, v.render(time.Now()))
+	}
+	draw()
+	for {
+		select {
+		case c := <-collected:
+			collecting = false
+			v.collectErr = c.err
+			if c.err == nil || len(c.filesystems) > 0 {
+				v.filesystems, v.collected = c.filesystems, c.at
+			}
+		case s := <-scanned:
+			s.l.scanning = false
+			s.l.took = time.Since(s.l.started)
+			s.l.err = s.err
+			s.l.skipped = len(s.res.SkippedPaths)
+			for _, d := range s.res.Dirs {
+				if d.Path == s.l.path {
+					s.l.total = d.Size
+				} else {
+					s.l.dirs = append(s.l.dirs, d)
+				}
+			}
+		case <-tick.C:
+			collect()
+		case <-redraw.C:
+			width, height, err := size()
+			resized := err == nil && (width != v.width || height != v.height)
+			// while a scan runs its time goes on
+			if l := v.current(); !resized && (l == nil || !l.scanning) {
+				continue
+			}
+		case k, ok := <-keys:
+			if !ok || !v.key(k, collect, open) {
+				return
+			}
+		}
+		draw()
+	}
+}
+
+// key handles one key, it returns false to quit.
+func (v *topView) key(k string, refresh func(), open func(path string)) bool {
+	l := v.current()
+	filter := &v.filter
+	if l != nil {
+		filter = &l.filter
+	}
+	if v.editing {
+		switch k {
+		case "enter", "esc":
+			v.editing = false
+		case "backspace":
+			if r := []rune(*filter); len(r) > 0 {
+				*filter = string(r[:len(r)-1])
+			}
+		case "ctrl-c":
+			return false
+		default:
+			if len([]rune(k)) == 1 {
+				*filter += k
+			}
+		}
+		v.move(0)
+		return true
+	}
+
+	switch k {
+	case "q", "ctrl-c":
+		return false
+	case "up", "k":
+		v.move(-1)
+	case "down", "j":
+		v.move(1)
+	case "pgup":
+		v.move(-v.listHeight())
+	case "pgdown":
+		v.move(v.listHeight())
+	case "home", "g":
+		v.move(-1 << 30)
+	case "end", "G":
+		v.move(1 << 30)
+	case "enter", "right", "l":
+		if l == nil {
+			rows := v.mountRows()
+			if v.selected < len(rows) && rows[v.selected].HasStats() {
+				open(rows[v.selected].MountPoint)
+			}
+			break
+		}
+		if rows := l.rows(v.reverse); l.selected < len(rows) {
+			open(rows[l.selected].Path)
+		}
+	case "left", "backspace", "esc", "h":
+		if l != nil {
+			l.cancel()
+			v.dirs = v.dirs[:len(v.dirs)-1]
+		}
+	case "s":
+		if l != nil {
+			l.sort = (l.sort + 1) % len(dirSorts)
+		} else {
+			v.sort = (v.sort + 1) % len(mountSorts)
+		}
+	case "S":
+		v.reverse = !v.reverse
+	case "/":
+		v.editing = true
+	case "o":
+		v.overOnly = !v.overOnly
+	case "a":
+		v.all = !v.all
+	case "r":
+		if l == nil {
+			refresh()
+			break
+		}
+		// scanned again in place of the one shown
+		l.cancel()
+		v.dirs = v.dirs[:len(v.dirs)-1]
+		open(l.path)
+		v.dirs[len(v.dirs)-1].sort = l.sort
+	}
+	return true
+}
+
+// keyNames are the escape sequences of the keys Top knows, as terminals in
+// normal and application cursor mode send them.
+var keyNames = map[string]string{
+	"\x1b[A": "up", "\x1bOA": "up",
+	"\x1b[B": "down", "\x1bOB": "down",
+	"\x1b[C": "right", "\x1bOC": "right",
+	"\x1b[D": "left", "\x1bOD": "left",
+	"\x1b[H": "home", "\x1bOH": "home", "\x1b[1~": "home",
+	"\x1b[F": "end", "\x1bOF": "end", "\x1b[4~": "end",
+	"\x1b[5~": "pgup", "\x1b[6~": "pgdown",
+}
+
+// readKeys sends the keys read from r to keys by name: up, down, enter,
+// esc..., or the character typed. keys is closed when r ends.
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		for _, k := range parseKeys(buf[:n]) {
+			keys <- k
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseKeys splits what one read returned into keys. An escape sequence
+// arrives in one read, a lone ESC is the esc key; unknown sequences are
+// dropped.
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch c := b[0]; {
+		case c == 0x1b:
+			n := 1
+			if len(b) > 1 && (b[1] == '[' || b[1] == 'O') {
+				n = 2
+				for n < len(b) && (b[n] < 0x40 || b[n] > 0x7e) {
+					n++
+				}
+				n = min(n+1, len(b))
+			}
+			if n == 1 {
+				keys = append(keys, "esc")
+			} else if name, ok := keyNames[string(b[:n])]; ok {
+				keys = append(keys, name)
+			}
+			b = b[n:]
+			continue
+		case c == '\r' || c == '\n':
+			keys = append(keys, "enter")
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, "backspace")
+		case c == 0x03:
+			keys = append(keys, "ctrl-c")
+		case c < 0x20:
+			// other control keys do nothing
+		default:
+			r, n := utf8.DecodeRune(b)
+			keys = append(keys, string(r))
+			b = b[n:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// lockedBuffer holds the log records written while the screen is up, the
+// scans log from their own goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
@@ -0,0 +1,390 @@
+package diskcmd
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"ved/test/diskusage"
+)
+
+// ANSI sequences the view is drawn with, besides the colors of -oneline.
+const (
+	ansiReverse = "\033[7m"
+	ansiBold    = "\033[1m"
+	ansiDim     = "\033[2m"
+)
+
+// mountSorts are the orders of the mount list, s cycles through them.
+var mountSorts = []string{"use%", "used", "avail", "size", "mount"}
+
+// dirSorts are the orders of a directory listing.
+var dirSorts = []string{"size", "name"}
+
+// topView is the state of devops disk top: the last collection, what is
+// shown of it and the directories drilled into. It is only touched by the
+// loop in Top, which draws it after every change.
+type topView struct {
+	threshold int
+	interval  time.Duration
+
+	filesystems []diskusage.Filesystem
+	collected   time.Time
+	collectErr  error
+
+	// all shows every class of filesystem, not only real and network ones
+	all bool
+	// overOnly shows only the mounts at or over the threshold
+	overOnly bool
+	sort     int
+	reverse  bool
+	filter   string
+	editing  bool
+	selected int
+	// scroll is the first row shown
+	scroll int
+
+	// dirs are the directories drilled into, the mount's first
+	dirs []*dirListing
+
+	width, height int
+}
+
+// dirListing is a directory and its subdirectories, largest first, as the
+// scanner found them.
+type dirListing struct {
+	path  string
+	total int64
+	dirs  []diskusage.Dir
+	// skipped counts the directories the scanner couldn't read
+	skipped  int
+	err      error
+	scanning bool
+	started  time.Time
+	took     time.Duration
+	cancel   func()
+
+	sort     int
+	selected int
+	scroll   int
+	filter   string
+}
+
+// mountRows are the filesystems shown: filtered, sorted, and without
+// pseudo and virtual filesystems unless all is set.
+func (v *topView) mountRows() []diskusage.Filesystem {
+	var rows []diskusage.Filesystem
+	for _, f := range v.filesystems {
+		if !v.all && f.Class != diskusage.ClassReal && f.Class != diskusage.ClassNetwork {
+			continue
+		}
+		if v.overOnly && (!f.HasStats() || f.Percent() < float64(v.threshold)) {
+			continue
+		}
+		if v.filter != "" && !strings.Contains(f.MountPoint, v.filter) && !strings.Contains(f.Source, v.filter) {
+			continue
+		}
+		rows = append(rows, f)
+	}
+
+	key := mountSorts[v.sort]
+	slices.SortStableFunc(rows, func(a, b diskusage.Filesystem) int {
+		var c int
+		switch key {
+		case "use%":
+			c = cmp.Compare(b.UsePercent, a.UsePercent)
+		case "used":
+			c = cmp.Compare(b.Used, a.Used)
+		case "avail":
+			c = cmp.Compare(a.Avail, b.Avail)
+		case "size":
+			c = cmp.Compare(b.Size, a.Size)
+		}
+		if c == 0 {
+			c = strings.Compare(a.MountPoint, b.MountPoint)
+		}
+		if v.reverse {
+			return -c
+		}
+		return c
+	})
+	return rows
+}
+
+// rows are the subdirectories of l shown, filtered and sorted.
+func (l *dirListing) rows(reverse bool) []diskusage.Dir {
+	var rows []diskusage.Dir
+	for _, d := range l.dirs {
+		if l.filter == "" || strings.Contains(filepath.Base(d.Path), l.filter) {
+			rows = append(rows, d)
+		}
+	}
+	byName := dirSorts[l.sort] == "name"
+	slices.SortStableFunc(rows, func(a, b diskusage.Dir) int {
+		c := cmp.Compare(b.Size, a.Size)
+		if byName || c == 0 {
+			c = strings.Compare(a.Path, b.Path)
+		}
+		if reverse {
+			return -c
+		}
+		return c
+	})
+	return rows
+}
+
+// current is the directory shown, nil for the mount list.
+func (v *topView) current() *dirListing {
+	if len(v.dirs) == 0 {
+		return nil
+	}
+	return v.dirs[len(v.dirs)-1]
+}
+
+// listHeight is how many rows fit between the header and the footer.
+func (v *topView) listHeight() int {
+	return max(v.height-4, 1)
+}
+
+// move moves the selection by n rows, keeping it on the screen.
+func (v *topView) move(n int) {
+	count := len(v.mountRows())
+	selected, scroll := &v.selected, &v.scroll
+	if l := v.current(); l != nil {
+		count = len(l.rows(v.reverse))
+		selected, scroll = &l.selected, &l.scroll
+	}
+	*selected = max(min(*selected+n, count-1), 0)
+	if *selected < *scroll {
+		*scroll = *selected
+	}
+	if *selected >= *scroll+v.listHeight() {
+		*scroll = *selected - v.listHeight() + 1
+	}
+}
+
+// render draws the whole screen, one line per row, each cut to the width
+// and cleared to its end.
+func (v *topView) render(now time.Time) string {
+	var lines []string
+	if l := v.current(); l != nil {
+		lines = v.renderDir(l, now)
+	} else {
+		lines = v.renderMounts()
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i == v.height {
+			break
+		}
+		b.WriteString(cut(line, v.width))
+		b.WriteString(ansiReset + "\x1b[K")
+		if i < len(lines)-1 && i < v.height-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\x1b[J")
+	return b.String()
+}
+
+func (v *topView) renderMounts() []string {
+	rows := v.mountRows()
+	v.move(0)
+
+	status := fmt.Sprintf("every %s, collected %s", v.interval, v.collected.Format("15:04:05"))
+	if v.collected.IsZero() {
+		status = "collecting..."
+	}
+	if v.collectErr != nil {
+		status = ansiRed + "collecting failed: " + v.collectErr.Error() + ansiReset
+	}
+	lines := []string{
+		fmt.Sprintf("%sdevops disk top%s  %d filesystems  threshold %d%%  %s", ansiBold, ansiReset, len(rows), v.threshold, status),
+		v.settings(mountSorts[v.sort]),
+	}
+
+	mountWidth, sourceWidth := len("Mount"), len("Source")
+	for _, f := range rows {
+		mountWidth = max(mountWidth, len(f.MountPoint))
+		sourceWidth = max(sourceWidth, len(f.Source))
+	}
+	mountWidth, sourceWidth = min(mountWidth, 32), min(sourceWidth, 24)
+	columns := fmt.Sprintf("%-*s %-*s %-8s %7s %7s %7s %5s ", mountWidth, "Mount", sourceWidth, "Source", "Type", "Size", "Used", "Avail", "Use%")
+	barWidth := min(v.width-len(columns)-2, 50)
+	lines = append(lines, ansiBold+columns+ansiReset)
+
+	for i := v.scroll; i < len(rows) && i < v.scroll+v.listHeight(); i++ {
+		f := rows[i]
+		line := fmt.Sprintf("%-*s %-*s %-8s %7s %7s %7s %5s ",
+			mountWidth, cut(f.MountPoint, mountWidth), sourceWidth, cut(f.Source, sourceWidth), cut(f.FSType, 8),
+			sizeOrDash(f.Size), sizeOrDash(f.Used), sizeOrDash(f.Avail), percentOrDash(f.UsePercent))
+		color := ""
+		if f.HasStats() {
+			line += bar(f.UsePercent, barWidth)
+			// like -oneline: red at the threshold, yellow within 10 points
+			switch {
+			case f.Percent() >= float64(v.threshold):
+				color = ansiRed
+			case f.Percent() >= float64(v.threshold-10):
+				color = ansiYellow
+			}
+		}
+		lines = append(lines, v.row(line, i == v.selected, color))
+	}
+	if len(rows) == 0 && !v.collected.IsZero() {
+		lines = append(lines, "No filesystems to show.")
+	}
+	return v.footer(lines, "↑↓ select  enter open  s sort  S reverse  / filter  o over threshold  a all  r refresh  q quit")
+}
+
+func (v *topView) renderDir(l *dirListing, now time.Time) []string {
+	rows := l.rows(v.reverse)
+	v.move(0)
+
+	var status string
+	switch {
+	case l.scanning:
+		status = fmt.Sprintf("scanning for %s...", now.Sub(l.started).Round(time.Second))
+	case l.err != nil:
+		status = ansiRed + l.err.Error() + ansiReset
+	default:
+		status = fmt.Sprintf("%s in %d directories, scanned in %s", humanBytes(l.total), len(l.dirs), l.took.Round(time.Millisecond))
+	}
+	if l.skipped > 0 {
+		status += fmt.Sprintf(", %d unreadable", l.skipped)
+	}
+	lines := []string{
+		fmt.Sprintf("%sdevops disk top%s  %s  %s", ansiBold, ansiReset, l.path, status),
+		v.settings(dirSorts[l.sort]),
+	}
+	columns := fmt.Sprintf("%7s %6s ", "Size", "Share")
+	barWidth := min(v.width/3, 30)
+	lines = append(lines, ansiBold+columns+strings.Repeat(" ", barWidth+3)+"Directory"+ansiReset)
+
+	for i := l.scroll; i < len(rows) && i < l.scroll+v.listHeight(); i++ {
+		d := rows[i]
+		share := 0
+		if l.total > 0 {
+			share = int(d.Size * 100 / l.total)
+		}
+		line := fmt.Sprintf("%7s %5d%% %s %s", humanBytes(d.Size), share, bar(share, barWidth), filepath.Base(d.Path))
+		lines = append(lines, v.row(line, i == l.selected, ""))
+	}
+	if len(rows) == 0 && !l.scanning && l.err == nil {
+		lines = append(lines, "No subdirectories.")
+	}
+	return v.footer(lines, "↑↓ select  enter open  ← back  s sort  S reverse  / filter  r rescan  q quit")
+}
+
+// settings is the line with the sort order and the filter.
+func (v *topView) settings(sortKey string) string {
+	order := "↓"
+	if v.reverse {
+		order = "↑"
+	}
+	line := "sort " + sortKey + " " + order
+	filter := v.filter
+	if l := v.current(); l != nil {
+		filter = l.filter
+	} else {
+		if v.overOnly {
+			line += "  over threshold only"
+		}
+		if v.all {
+			line += "  all filesystems"
+		}
+	}
+	switch {
+	case v.editing:
+		line += "  filter: " + filter + "█"
+	case filter != "":
+		line += "  filter: " + filter
+	}
+	return ansiDim + line + ansiReset
+}
+
+// row paints line in color and highlights it when it is selected.
+func (v *topView) row(line string, selected bool, color string) string {
+	line = cut(line, v.width)
+	if selected {
+		line = ansiReverse + line + strings.Repeat(" ", max(v.width-visibleLen(line), 0))
+	}
+	return color + line
+}
+
+// footer pads lines to the screen's height and puts help at the bottom.
+func (v *topView) footer(lines []string, help string) []string {
+	for len(lines) < v.height-1 {
+		lines = append(lines, "")
+	}
+	return append(lines, ansiDim+help+ansiReset)
+}
+
+// bar is a usage bar of width characters, percent of it filled.
+func bar(percent, width int) string {
+	if width < 3 {
+		return ""
+	}
+	filled := min(max(percent, 0), 100) * width / 100
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+// cut shortens s to width columns, marking the cut with ~. Escape
+// sequences take no room and are kept.
+func cut(s string, width int) string {
+	if visibleLen(s) <= width {
+		return s
+	}
+	var b strings.Builder
+	n, esc := 0, false
+	for _, r := range s {
+		switch {
+		case esc:
+			esc = r < '@' || r > '~' || r == '['
+		case r == '\x1b':
+			esc = true
+		case n == width-1:
+			b.WriteRune('~')
+			return b.String()
+		default:
+			n++
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// visibleLen is how many columns s takes, without its escape sequences.
+func visibleLen(s string) int {
+	n, esc := 0, false
+	for _, r := range s {
+		switch {
+		case esc:
+			esc = r < '@' || r > '~' || r == '['
+		case r == '\x1b':
+			esc = true
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+func sizeOrDash(n int64) string {
+	if n == diskusage.Unknown {
+		return "-"
+	}
+	return humanBytes(n)
+}
+
+func percentOrDash(n int) string {
+	if n == diskusage.Unknown {
+		return "-"
+	}
+	return fmt.Sprintf("%d%%", n)
+}
@@ -0,0 +1,187 @@
+package diskusage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BlockDevice is what backs a filesystem according to lsblk (Linux only).
+// Disks are the physical disks at the bottom of the device tree, a logical
+// volume spanning several disks has them all. For LVM the volume group and
+// its free space tell whether the filesystem can still be grown; VGFree is
+// nil when vgs could not be run, it usually needs root.
+type BlockDevice struct {
+	Path          string   `json:"path"`
+	Type          string   `json:"type"`
+	Size          int64    `json:"size_bytes"`
+	Disks         []string `json:"disks,omitempty"`
+	VolumeGroup   string   `json:"volume_group,omitempty"`
+	LogicalVolume string   `json:"logical_volume,omitempty"`
+	VGFree        *int64   `json:"vg_free_bytes,omitempty"`
+}
+
+// lsblkDevice is one node of `lsblk -J`. Older lsblk versions print sizes
+// as strings and have no path column.
+type lsblkDevice struct {
+	Name     string          `json:"name"`
+	Path     string          `json:"path"`
+	Type     string          `json:"type"`
+	Size     json.RawMessage `json:"size"`
+	Children []lsblkDevice   `json:"children"`
+}
+
+// Lsblk returns the block devices by path. A device appears under every
+// parent in lsblk's tree, its disks are collected from all of them.
+func Lsblk(ctx context.Context) (map[string]*BlockDevice, error) {
+	out, stderr, err := runCommand(ctx, "lsblk", "-J", "-b", "-o", "NAME,PATH,TYPE,SIZE")
+	if err != nil && ctx.Err() == nil {
+		// lsblk before 2.33 has no PATH column, paths are then guessed
+		// from the names
+		slog.Debug("lsblk failed, retrying without the path column", "err", err, "stderr", strings.TrimSpace(string(stderr)))
+		out, stderr, err = runCommand(ctx, "lsblk", "-J", "-b", "-o", "NAME,TYPE,SIZE")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lsblk: %w: %s", err, strings.TrimSpace(string(stderr)))
+	}
+	devices, err := parseLsblk(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if free, err := vgFree(ctx); err != nil {
+		slog.Debug("no volume group free space", "err", err)
+	} else {
+		for _, d := range devices {
+			if f, ok := free[d.VolumeGroup]; ok && d.VolumeGroup != "" {
+				d.VGFree = &f
+			}
+		}
+	}
+	return devices, nil
+}
+
+func parseLsblk(out []byte) (map[string]*BlockDevice, error) {
+	var tree struct {
+		BlockDevices []lsblkDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(out, &tree); err != nil {
+		return nil, fmt.Errorf("lsblk: %w", err)
+	}
+
+	devices := map[string]*BlockDevice{}
+	var walk func(n lsblkDevice, disks []string)
+	walk = func(n lsblkDevice, disks []string) {
+		path := n.Path
+		if path == "" {
+			path = "/dev/" + n.Name
+			if n.Type == "lvm" || n.Type == "crypt" {
+				path = "/dev/mapper/" + n.Name
+			}
+		}
+		if n.Type == "disk" {
+			disks = []string{path}
+		}
+
+		d, ok := devices[path]
+		if !ok {
+			size, _ := strconv.ParseInt(strings.Trim(string(n.Size), `"`), 10, 64)
+			d = &BlockDevice{Path: path, Type: n.Type, Size: size}
+			if n.Type == "lvm" {
+				d.VolumeGroup, d.LogicalVolume = splitDMName(n.Name)
+			}
+			devices[path] = d
+		}
+		for _, disk := range disks {
+			if !contains(d.Disks, disk) {
+				d.Disks = append(d.Disks, disk)
+			}
+		}
+
+		for _, c := range n.Children {
+			walk(c, disks)
+		}
+	}
+	for _, n := range tree.BlockDevices {
+		walk(n, nil)
+	}
+	return devices, nil
+}
+
+// splitDMName splits a device mapper name like "vg--data-root" into the
+// volume group and logical volume, dashes in either are doubled.
+func splitDMName(name string) (vg, lv string) {
+	for i := 0; i < len(name); i++ {
+		if name[i] != '-' {
+			continue
+		}
+		if i+1 < len(name) && name[i+1] == '-' {
+			i++
+			continue
+		}
+		return strings.ReplaceAll(name[:i], "--", "-"), strings.ReplaceAll(name[i+1:], "--", "-")
+	}
+	return "", name
+}
+
+// vgFree returns the free bytes of every volume group.
+func vgFree(ctx context.Context) (map[string]int64, error) {
+	out, stderr, err := runCommand(ctx, "vgs", "--noheadings", "--units", "b", "--nosuffix", "-o", "vg_name,vg_free")
+	if err != nil {
+		return nil, fmt.Errorf("vgs: %w: %s", err, strings.TrimSpace(string(stderr)))
+	}
+
+	free := map[string]int64{}
+	for _, line := range bytes.Split(out, []byte{'\n'}) {
+		fields := strings.Fields(string(line))
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			free[fields[0]] = n
+		}
+	}
+	return free, nil
+}
+
+// AnnotateBlockDevices sets BlockDevice on every filesystem whose source is
+// one of devices, following symlinks like /dev/disk/by-uuid/... or
+// /dev/mapper/... on both sides.
+func AnnotateBlockDevices(filesystems []Filesystem, devices map[string]*BlockDevice) {
+	byPath := map[string]*BlockDevice{}
+	for path, d := range devices {
+		byPath[path] = d
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			byPath[real] = d
+		}
+	}
+
+	for i := range filesystems {
+		fs := &filesystems[i]
+		d, ok := byPath[fs.Source]
+		if !ok {
+			real, err := filepath.EvalSymlinks(fs.Source)
+			if err != nil {
+				continue
+			}
+			if d, ok = byPath[real]; !ok {
+				continue
+			}
+		}
+		fs.BlockDevice = d
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
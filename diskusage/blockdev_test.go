@@ -0,0 +1,69 @@
+package diskusage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLsblk(t *testing.T) {
+	// a volume group over two disks, and an lsblk without the path column
+	// that prints sizes as strings
+	out := `{"blockdevices": [
+  {"name": "sda", "path": "/dev/sda", "type": "disk", "size": 1000, "children": [
+    {"name": "sda1", "path": "/dev/sda1", "type": "part", "size": 100},
+    {"name": "sda2", "path": "/dev/sda2", "type": "part", "size": 900, "children": [
+      {"name": "vg--data-root", "path": "/dev/mapper/vg--data-root", "type": "lvm", "size": 1500}
+    ]}
+  ]},
+  {"name": "sdb", "path": "/dev/sdb", "type": "disk", "size": 1000, "children": [
+    {"name": "sdb1", "path": "/dev/sdb1", "type": "part", "size": 1000, "children": [
+      {"name": "vg--data-root", "path": "/dev/mapper/vg--data-root", "type": "lvm", "size": 1500}
+    ]}
+  ]}
+]}`
+	devices, err := parseLsblk([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &BlockDevice{
+		Path: "/dev/mapper/vg--data-root", Type: "lvm", Size: 1500,
+		Disks:       []string{"/dev/sda", "/dev/sdb"},
+		VolumeGroup: "vg-data", LogicalVolume: "root",
+	}
+	if got := devices["/dev/mapper/vg--data-root"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("logical volume %+v, want %+v", got, want)
+	}
+	if got := devices["/dev/sda1"]; got == nil || got.Size != 100 || !reflect.DeepEqual(got.Disks, []string{"/dev/sda"}) {
+		t.Errorf("/dev/sda1 %+v", got)
+	}
+	if len(devices) != 6 {
+		t.Errorf("%d devices, want 6", len(devices))
+	}
+
+	old := `{"blockdevices": [{"name": "sda", "type": "disk", "size": "1000", "children": [
+  {"name": "cryptroot", "type": "crypt", "size": "990"}]}]}`
+	if devices, err = parseLsblk([]byte(old)); err != nil {
+		t.Fatal(err)
+	}
+	if got := devices["/dev/mapper/cryptroot"]; got == nil || got.Size != 990 || !reflect.DeepEqual(got.Disks, []string{"/dev/sda"}) {
+		t.Errorf("old lsblk: %+v", devices)
+	}
+
+	if _, err := parseLsblk([]byte("lsblk: not found")); err == nil {
+		t.Error("no error for output that isn't JSON")
+	}
+}
+
+func TestSplitDMName(t *testing.T) {
+	for name, want := range map[string][2]string{
+		"vg0-root":          {"vg0", "root"},
+		"vg--data-root":     {"vg-data", "root"},
+		"vg-my--lv":         {"vg", "my-lv"},
+		"vg--a--b-lv--c--d": {"vg-a-b", "lv-c-d"},
+		"cryptroot":         {"", "cryptroot"},
+	} {
+		if vg, lv := splitDMName(name); vg != want[0] || lv != want[1] {
+			t.Errorf("%s: got %q %q, want %q %q", name, vg, lv, want[0], want[1])
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package diskusage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BootTime returns when the machine booted, from btime in /proc/stat on
+// Linux and sysctl kern.boottime on macOS.
+func BootTime(ctx context.Context) (time.Time, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return procBootTime("/proc/stat")
+	case "darwin", "freebsd":
+		out, _, err := runCommand(ctx, "sysctl", "-n", "kern.boottime")
+		if err != nil {
+			return time.Time{}, fmt.Errorf("sysctl kern.boottime: %w", err)
+		}
+		return parseKernBoottime(string(out))
+	}
+	return time.Time{}, fmt.Errorf("boot time not supported on %s", runtime.GOOS)
+}
+
+func procBootTime(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("%s: btime: %w", path, err)
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("%s: no btime", path)
+}
+
+var boottimeSec = regexp.MustCompile(`sec = (\d+)`)
+
+// parseKernBoottime reads "{ sec = 1700000000, usec = 12345 } Tue Nov ...".
+func parseKernBoottime(out string) (time.Time, error) {
+	m := boottimeSec.FindStringSubmatch(out)
+	if m == nil {
+		return time.Time{}, errors.New("unexpected kern.boottime: " + strings.TrimSpace(out))
+	}
+	sec, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
@@ -0,0 +1,120 @@
+package diskusage
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BudgetPlan is a capacity plan: for each mount, the planned maximum use
+// percent at a few dates. Between two checkpoints the plan is interpolated
+// linearly, before the first and after the last the nearest one applies.
+//
+//	mounts:
+//	  - mount: /data
+//	    checkpoints:
+//	      - {date: 2026-01-01, max_percent: 50}
+//	      - {date: 2026-12-31, max_percent: 80}
+type BudgetPlan struct {
+	Mounts []MountBudget `yaml:"mounts"`
+}
+
+type MountBudget struct {
+	Mount       string       `yaml:"mount"`
+	Checkpoints []Checkpoint `yaml:"checkpoints"`
+}
+
+type Checkpoint struct {
+	Date       string  `yaml:"date"`
+	MaxPercent float64 `yaml:"max_percent"`
+
+	date time.Time
+}
+
+// BudgetResult compares one mount against its plan. OverBy is in percentage
+// points, negative when the mount is within budget.
+type BudgetResult struct {
+	MountPoint     string  `json:"mount_point"`
+	PlannedPercent float64 `json:"planned_percent"`
+	UsePercent     float64 `json:"use_percent"`
+	OverBy         float64 `json:"over_by"`
+	Over           bool    `json:"over"`
+}
+
+// LoadBudget reads and validates a plan file.
+func LoadBudget(path string) (*BudgetPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan BudgetPlan
+	if err := yaml.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i := range plan.Mounts {
+		mb := &plan.Mounts[i]
+		if len(mb.Checkpoints) == 0 {
+			return nil, fmt.Errorf("%s: mount %s has no checkpoints", path, mb.Mount)
+		}
+		for j := range mb.Checkpoints {
+			cp := &mb.Checkpoints[j]
+			if cp.date, err = time.Parse(time.DateOnly, cp.Date); err != nil {
+				return nil, fmt.Errorf("%s: mount %s: %w", path, mb.Mount, err)
+			}
+		}
+		sort.Slice(mb.Checkpoints, func(a, b int) bool {
+			return mb.Checkpoints[a].date.Before(mb.Checkpoints[b].date)
+		})
+	}
+	return &plan, nil
+}
+
+// planned returns the interpolated max percent at t.
+func (mb MountBudget) planned(t time.Time) float64 {
+	cps := mb.Checkpoints
+	if !t.After(cps[0].date) {
+		return cps[0].MaxPercent
+	}
+	for i := 1; i < len(cps); i++ {
+		prev, next := cps[i-1], cps[i]
+		if t.After(next.date) {
+			continue
+		}
+		frac := t.Sub(prev.date).Seconds() / next.date.Sub(prev.date).Seconds()
+		return prev.MaxPercent + frac*(next.MaxPercent-prev.MaxPercent)
+	}
+	return cps[len(cps)-1].MaxPercent
+}
+
+// Check compares the filesystems against the plan at time t. Mounts that
+// are not in the plan, or have no stats, are left out.
+func (plan *BudgetPlan) Check(filesystems []Filesystem, t time.Time) []BudgetResult {
+	byMount := make(map[string]Filesystem, len(filesystems))
+	for _, fs := range filesystems {
+		byMount[fs.MountPoint] = fs
+	}
+
+	var results []BudgetResult
+	for _, mb := range plan.Mounts {
+		fs, ok := byMount[mb.Mount]
+		if !ok || !fs.HasStats() {
+			continue
+		}
+
+		planned := mb.planned(t)
+		over := fs.Percent() - planned
+		results = append(results, BudgetResult{
+			MountPoint:     mb.Mount,
+			PlannedPercent: planned,
+			UsePercent:     fs.Percent(),
+			OverBy:         over,
+			Over:           over > 0,
+		})
+	}
+	return results
+}
@@ -0,0 +1,77 @@
+package diskusage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.yaml")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// out of order on purpose, they are sorted
+	write(`
+mounts:
+  - mount: /data
+    checkpoints:
+      - {date: 2026-12-31, max_percent: 80}
+      - {date: 2026-01-01, max_percent: 50}
+  - mount: /missing
+    checkpoints:
+      - {date: 2026-01-01, max_percent: 50}
+  - mount: /nfs
+    checkpoints:
+      - {date: 2026-01-01, max_percent: 50}
+`)
+	plan, err := LoadBudget(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filesystems := []Filesystem{
+		{MountPoint: "/data", Size: 100, Used: 60, Avail: 40, UsePercent: 60, Status: StatusOK},
+		{MountPoint: "/nfs", Status: StatusStale},
+	}
+	for _, tt := range []struct {
+		date    string
+		planned float64
+		over    bool
+	}{
+		{"2025-06-01", 50, true},
+		{"2026-01-01", 50, true},
+		{"2026-04-01", 50 + 30*90/364.0, true},
+		{"2026-07-01", 50 + 30*181/364.0, false},
+		{"2026-12-31", 80, false},
+		{"2027-06-01", 80, false},
+	} {
+		at, _ := time.Parse(time.DateOnly, tt.date)
+		results := plan.Check(filesystems, at)
+		if len(results) != 1 || results[0].MountPoint != "/data" {
+			t.Fatalf("%s: %+v, want /data only", tt.date, results)
+		}
+		r := results[0]
+		if diff := r.PlannedPercent - tt.planned; diff > 0.001 || diff < -0.001 {
+			t.Errorf("%s: planned %v, want %v", tt.date, r.PlannedPercent, tt.planned)
+		}
+		if r.Over != tt.over || r.OverBy != r.UsePercent-r.PlannedPercent {
+			t.Errorf("%s: %+v, want over %v", tt.date, r, tt.over)
+		}
+	}
+
+	for data, want := range map[string]string{
+		"mounts:\n  - mount: /data\n": "mount /data has no checkpoints",
+		"mounts:\n  - mount: /data\n    checkpoints:\n      - {date: soon, max_percent: 50}\n": "mount /data",
+		"mounts: [\n": "budget.yaml",
+	} {
+		write(data)
+		if _, err := LoadBudget(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want an error about %s", data, err, want)
+		}
+	}
+}
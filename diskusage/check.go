@@ -0,0 +1,125 @@
+package diskusage
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CheckLimits are the limits Check holds every mount to, 0 disables one.
+type CheckLimits struct {
+	// MaxPercent fails a mount used more than this percent.
+	MaxPercent float64 `json:"max_percent,omitempty"`
+	// MaxGrowthPoints fails a mount whose use percent grew more than this
+	// many points over the baseline.
+	MaxGrowthPoints float64 `json:"max_growth_points,omitempty"`
+	// MaxGrowthBytes fails a mount whose used bytes grew more than this
+	// over the baseline.
+	MaxGrowthBytes int64 `json:"max_growth_bytes,omitempty"`
+}
+
+// MountCheck is how one mount fared in a Check.
+type MountCheck struct {
+	MountPoint string  `json:"mount_point"`
+	UsePercent float64 `json:"use_percent"`
+	Used       int64   `json:"used_bytes"`
+	// Baseline is the mount in the baseline report, nil when it wasn't in
+	// it or there is no baseline.
+	Baseline *Filesystem `json:"baseline,omitempty"`
+	// Missing is set for a mount of the baseline that is gone, it is not
+	// a failure.
+	Missing bool `json:"missing,omitempty"`
+	// Status is the mount's status when it has no stats, stale or
+	// unreachable, which fails it: its use can't be checked.
+	Status string `json:"status,omitempty"`
+	// Failures say which limits the mount broke, none when it passed.
+	Failures []string `json:"failures,omitempty"`
+}
+
+// GrowthPoints is how many points the use percent grew over the baseline.
+func (m MountCheck) GrowthPoints() float64 {
+	if m.Baseline == nil {
+		return 0
+	}
+	return m.UsePercent - m.Baseline.Percent()
+}
+
+// GrowthBytes is how many bytes more are used than in the baseline.
+func (m MountCheck) GrowthBytes() int64 {
+	if m.Baseline == nil {
+		return 0
+	}
+	return m.Used - m.Baseline.Used
+}
+
+// Check holds the filesystems to limits, the growth ones against baseline,
+// which may be nil. A filesystem without stats fails, a hung mount must not
+// pass for one within its limits. The result has every mount of
+// filesystems, and those only in baseline as Missing, by mount point.
+func Check(filesystems []Filesystem, baseline *Report, limits CheckLimits) []MountCheck {
+	before := map[string]*Filesystem{}
+	if baseline != nil {
+		for i := range baseline.Filesystems {
+			if fs := &baseline.Filesystems[i]; fs.HasStats() {
+				before[fs.MountPoint] = fs
+			}
+		}
+	}
+
+	var checks []MountCheck
+	seen := map[string]bool{}
+	for _, fs := range filesystems {
+		if seen[fs.MountPoint] {
+			continue
+		}
+		seen[fs.MountPoint] = true
+		if !fs.HasStats() {
+			checks = append(checks, MountCheck{
+				MountPoint: fs.MountPoint,
+				Baseline:   before[fs.MountPoint],
+				Status:     fs.Status,
+				Failures:   []string{"no stats: " + fs.Status},
+			})
+			continue
+		}
+		m := MountCheck{MountPoint: fs.MountPoint, UsePercent: fs.Percent(), Used: fs.Used, Baseline: before[fs.MountPoint]}
+		if limits.MaxPercent > 0 && m.UsePercent > limits.MaxPercent {
+			m.Failures = append(m.Failures, fmt.Sprintf("%s%% used, over %s%%", trimFloat(m.UsePercent), trimFloat(limits.MaxPercent)))
+		}
+		if m.Baseline != nil {
+			if limits.MaxGrowthPoints > 0 && m.GrowthPoints() > limits.MaxGrowthPoints {
+				m.Failures = append(m.Failures, fmt.Sprintf("grew %s points, over %s", trimFloat(m.GrowthPoints()), trimFloat(limits.MaxGrowthPoints)))
+			}
+			if limits.MaxGrowthBytes > 0 && m.GrowthBytes() > limits.MaxGrowthBytes {
+				m.Failures = append(m.Failures, fmt.Sprintf("grew %d bytes, over %d", m.GrowthBytes(), limits.MaxGrowthBytes))
+			}
+		}
+		checks = append(checks, m)
+	}
+	for mount, fs := range before {
+		if !seen[mount] {
+			checks = append(checks, MountCheck{MountPoint: mount, Baseline: fs, Missing: true})
+		}
+	}
+
+	sort.Slice(checks, func(i, j int) bool { return checks[i].MountPoint < checks[j].MountPoint })
+	return checks
+}
+
+// CheckFailed reports whether a mount of checks broke a limit.
+func CheckFailed(checks []MountCheck) bool {
+	for _, m := range checks {
+		if len(m.Failures) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// trimFloat formats f with at most one decimal, none when it is whole.
+func trimFloat(f float64) string {
+	s := fmt.Sprintf("%.1f", f)
+	if len(s) > 2 && s[len(s)-2:] == ".0" {
+		return s[:len(s)-2]
+	}
+	return s
+}
@@ -0,0 +1,62 @@
+package diskusage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	fs := func(mount string, used int64, percent int) Filesystem {
+		return Filesystem{MountPoint: mount, Size: 1000, Used: used, Avail: 1000 - used, UsePercent: percent, Status: StatusOK}
+	}
+	baseline := &Report{Filesystems: []Filesystem{
+		fs("/", 500, 50),
+		fs("/var", 300, 30),
+		fs("/old", 100, 10),
+		{MountPoint: "/proc", Status: StatusUnavailable},
+	}}
+	current := []Filesystem{
+		fs("/", 920, 92),
+		fs("/var", 400, 40),
+		fs("/data", 100, 10),
+		{MountPoint: "/mnt/nfs", Status: StatusStale},
+	}
+
+	checks := Check(current, baseline, CheckLimits{MaxPercent: 90, MaxGrowthPoints: 5, MaxGrowthBytes: 200})
+	got := map[string]string{}
+	for _, m := range checks {
+		got[m.MountPoint] = strings.Join(m.Failures, "; ")
+		if m.Missing {
+			got[m.MountPoint] = "missing"
+		}
+	}
+	want := map[string]string{
+		"/":     "92% used, over 90%; grew 42 points, over 5; grew 420 bytes, over 200",
+		"/var":  "grew 10 points, over 5",
+		"/data": "",
+		"/old":  "missing",
+		// a hung mount can't pass for one within its limits
+		"/mnt/nfs": "no stats: stale",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for mount, w := range want {
+		if got[mount] != w {
+			t.Errorf("%s: got %q, want %q", mount, got[mount], w)
+		}
+	}
+	if !CheckFailed(checks) {
+		t.Error("CheckFailed = false")
+	}
+	if checks[0].MountPoint != "/" || checks[0].GrowthBytes() != 420 {
+		t.Errorf("first check %+v, want / grown by 420 bytes", checks[0])
+	}
+
+	if checks := Check(current[:3], nil, CheckLimits{MaxPercent: 95, MaxGrowthPoints: 1}); CheckFailed(checks) {
+		t.Errorf("no baseline, nothing over 95%%: %+v", checks)
+	}
+	if checks := Check(current, nil, CheckLimits{MaxPercent: 95}); !CheckFailed(checks) {
+		t.Errorf("the stale mount passed: %+v", checks)
+	}
+}
@@ -0,0 +1,139 @@
+package diskusage
+
+import "strings"
+
+// Filesystem classes. The report and alerts only look at ClassReal and
+// ClassNetwork unless the -include-* flags ask for more.
+const (
+	ClassReal    = "real"
+	ClassPseudo  = "pseudo"
+	ClassVirtual = "virtual"
+	ClassNetwork = "network"
+	ClassLoop    = "loop"
+	// ClassContainer are the mounts container runtimes make for their
+	// containers, whatever their type: overlay root filesystems, shm,
+	// volumes and secrets of pods. One host can have hundreds.
+	ClassContainer = "container"
+)
+
+// containerMountPrefixes are where Docker, Podman, containerd and the
+// kubelet mount things for their containers.
+var containerMountPrefixes = []string{
+	"/var/lib/docker/",
+	"/run/docker/",
+	"/var/snap/docker/",
+	"/var/lib/containers/",
+	"/run/containers/",
+	"/var/lib/containerd/",
+	"/run/containerd/",
+	"/var/lib/kubelet/pods/",
+	"/var/lib/kubelet/plugins/",
+}
+
+// classifyMount is Classify for a mount, ClassContainer when a container
+// runtime put it there.
+func classifyMount(fsType, source, mountPoint string) string {
+	for _, p := range containerMountPrefixes {
+		if strings.HasPrefix(mountPoint, p) {
+			return ClassContainer
+		}
+	}
+	// rootless Podman, under the user's home
+	if strings.Contains(mountPoint, "/containers/storage/") {
+		return ClassContainer
+	}
+	return Classify(fsType, source)
+}
+
+var fsTypeClasses = map[string]string{
+	"proc":        ClassPseudo,
+	"sysfs":       ClassPseudo,
+	"cgroup":      ClassPseudo,
+	"cgroup2":     ClassPseudo,
+	"devpts":      ClassPseudo,
+	"securityfs":  ClassPseudo,
+	"debugfs":     ClassPseudo,
+	"tracefs":     ClassPseudo,
+	"pstore":      ClassPseudo,
+	"bpf":         ClassPseudo,
+	"configfs":    ClassPseudo,
+	"fusectl":     ClassPseudo,
+	"mqueue":      ClassPseudo,
+	"hugetlbfs":   ClassPseudo,
+	"autofs":      ClassPseudo,
+	"binfmt_misc": ClassPseudo,
+	"nsfs":        ClassPseudo,
+	"rpc_pipefs":  ClassPseudo,
+	"efivarfs":    ClassPseudo,
+	"selinuxfs":   ClassPseudo,
+	"devfs":       ClassPseudo,
+
+	"tmpfs":    ClassVirtual,
+	"devtmpfs": ClassVirtual,
+	"ramfs":    ClassVirtual,
+	"overlay":  ClassVirtual,
+
+	"nfs":         ClassNetwork,
+	"nfs4":        ClassNetwork,
+	"cifs":        ClassNetwork,
+	"smbfs":       ClassNetwork,
+	"smb3":        ClassNetwork,
+	"fuse.sshfs":  ClassNetwork,
+	"glusterfs":   ClassNetwork,
+	"ceph":        ClassNetwork,
+	"fuse.rclone": ClassNetwork,
+
+	"squashfs": ClassLoop,
+	"iso9660":  ClassLoop,
+}
+
+// Classify returns the class of a filesystem from its type, falling back to
+// guessing from the source when the type is not known (e.g. no /proc/mounts).
+func Classify(fsType, source string) string {
+	if strings.HasPrefix(source, "/dev/loop") {
+		return ClassLoop
+	}
+	if class, ok := fsTypeClasses[fsType]; ok {
+		return class
+	}
+
+	switch {
+	case strings.HasPrefix(source, "/dev/"):
+		return ClassReal
+	case strings.HasPrefix(source, "//"), strings.Contains(source, ":/"):
+		return ClassNetwork
+	case fsType != "":
+		// ext4, xfs, btrfs, apfs, zfs...
+		return ClassReal
+	}
+	return ClassPseudo
+}
+
+// annotate fills in FSType, Class and ReadOnly from the mount table.
+func annotate(filesystems []Filesystem, mounts []Mount) {
+	byPoint := make(map[string]Mount, len(mounts))
+	for _, m := range mounts {
+		byPoint[m.MountPoint] = m
+	}
+
+	for i := range filesystems {
+		fs := &filesystems[i]
+		m := byPoint[fs.MountPoint]
+		if fs.FSType == "" {
+			fs.FSType = m.FSType
+		}
+		fs.ReadOnly = fs.ReadOnly || readOnlyOption(m.Options)
+		fs.Class = classifyMount(fs.FSType, fs.Source, fs.MountPoint)
+	}
+}
+
+// FilterClasses keeps the filesystems whose class is in include.
+func FilterClasses(filesystems []Filesystem, include map[string]bool) []Filesystem {
+	var kept []Filesystem
+	for _, fs := range filesystems {
+		if include[fs.Class] {
+			kept = append(kept, fs)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,371 @@
+package diskusage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CleanDanglingImages is the CleanRule.Docker value for untagged images
+// no container uses.
+const CleanDanglingImages = "dangling-images"
+
+// Kinds of CleanTarget.
+const (
+	CleanFile        = "file"
+	CleanDir         = "dir"
+	CleanDockerImage = "docker-image"
+)
+
+// CleanRules are what devops disk clean removes, rule by rule. Unlike
+// ReclaimRules these do delete, so every path rule needs a min_age and a
+// path of its own; / is refused.
+//
+//	rules:
+//	  - name: tmp
+//	    paths: [/tmp, /var/tmp]
+//	    min_age: 168h
+//	  - name: node_modules
+//	    paths: [/home]
+//	    match: [node_modules]
+//	    recursive: true
+//	    min_age: 720h
+//	  - name: docker-images
+//	    docker: dangling-images
+type CleanRules struct {
+	Rules []CleanRule `yaml:"rules"`
+}
+
+// CleanRule removes the entries in Paths whose name matches one of Match
+// (filepath.Match globs on the base name, every entry when empty), that
+// are at least MinSize and weren't modified for MinAge. Entries matching
+// Exclude are neither removed nor looked into. Only the entries directly
+// in Paths are looked at unless Recursive is set. A matching directory is
+// removed as a whole, its age is that of the newest file in it. Symlinks
+// are removed, never followed; sockets, pipes and devices are left alone.
+// A rule with Docker set removes Docker objects instead of paths.
+type CleanRule struct {
+	Name      string        `yaml:"name"`
+	Paths     []string      `yaml:"paths"`
+	Match     []string      `yaml:"match"`
+	Exclude   []string      `yaml:"exclude"`
+	Recursive bool          `yaml:"recursive"`
+	MinAge    time.Duration `yaml:"min_age"`
+	MinSize   string        `yaml:"min_size"`
+	Docker    string        `yaml:"docker"`
+
+	minSize int64
+}
+
+// DefaultCleanRules are temp files and rotated logs untouched for a week.
+func DefaultCleanRules() *CleanRules {
+	rules := &CleanRules{Rules: []CleanRule{
+		{
+			Name:  "tmp",
+			Paths: []string{"/tmp", "/var/tmp"},
+			// what running sessions and services keep there for long
+			Exclude: []string{".*-unix", "systemd-private-*", "snap-private-tmp", "tmux-*", "ssh-*", "lost+found"},
+			MinAge:  7 * 24 * time.Hour,
+		},
+		{
+			Name:      "rotated-logs",
+			Paths:     []string{"/var/log"},
+			Match:     []string{"*.[0-9]", "*.[0-9].gz", "*.log.[0-9]*", "*.old"},
+			Recursive: true,
+			MinAge:    7 * 24 * time.Hour,
+		},
+	}}
+	if err := rules.validate(); err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// LoadCleanRules reads a rules file, it replaces the default rules.
+func LoadCleanRules(path string) (*CleanRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules CleanRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := rules.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &rules, nil
+}
+
+func (rules *CleanRules) validate() error {
+	if len(rules.Rules) == 0 {
+		return errors.New("no rules")
+	}
+	seen := map[string]bool{}
+	for i := range rules.Rules {
+		r := &rules.Rules[i]
+		if r.Name == "" {
+			return fmt.Errorf("rule %d has no name", i+1)
+		}
+		if seen[r.Name] {
+			return fmt.Errorf("rule %s: defined twice", r.Name)
+		}
+		seen[r.Name] = true
+
+		if r.Docker != "" {
+			if r.Docker != CleanDanglingImages {
+				return fmt.Errorf("rule %s: docker: want %s", r.Name, CleanDanglingImages)
+			}
+			if len(r.Paths) > 0 {
+				return fmt.Errorf("rule %s: has both docker and paths", r.Name)
+			}
+			continue
+		}
+		if len(r.Paths) == 0 {
+			return fmt.Errorf("rule %s: no paths", r.Name)
+		}
+		for _, p := range r.Paths {
+			if !filepath.IsAbs(p) || filepath.Clean(p) == string(filepath.Separator) {
+				return fmt.Errorf("rule %s: path %q: want an absolute path other than /", r.Name, p)
+			}
+		}
+		// without an age a rule would remove what is being written
+		if r.MinAge <= 0 {
+			return fmt.Errorf("rule %s: min_age must be set", r.Name)
+		}
+		for _, p := range append(r.Match, r.Exclude...) {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return fmt.Errorf("rule %s: pattern %q: %w", r.Name, p, err)
+			}
+		}
+		if r.MinSize != "" {
+			size, err := parseHumanSize(r.MinSize)
+			if err != nil {
+				return fmt.Errorf("rule %s: min_size: %w", r.Name, err)
+			}
+			r.minSize = size
+		}
+	}
+	return nil
+}
+
+// Only keeps the rules named, in their order in the rules.
+func (rules *CleanRules) Only(names []string) error {
+	want := map[string]bool{}
+	for _, n := range names {
+		want[n] = true
+	}
+	var kept []CleanRule
+	for _, r := range rules.Rules {
+		if want[r.Name] {
+			kept = append(kept, r)
+			delete(want, r.Name)
+		}
+	}
+	for n := range want {
+		return fmt.Errorf("no rule %s", n)
+	}
+	rules.Rules = kept
+	return nil
+}
+
+// CleanTarget is a file, directory or Docker object a rule would remove.
+// Path is the image ID of a Docker image. Removed and Error are set by
+// the run that removed it.
+type CleanTarget struct {
+	Rule    string    `json:"rule"`
+	Kind    string    `json:"kind"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size_bytes"`
+	ModTime time.Time `json:"mod_time,omitzero"`
+	Removed bool      `json:"removed"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// CleanPlan is what the rules would remove, rule by rule and largest first
+// within a rule. Errors are the rules that couldn't be looked at, by name.
+type CleanPlan struct {
+	Targets    []CleanTarget     `json:"targets"`
+	TotalBytes int64             `json:"total_bytes"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+// PlanClean finds what the rules would remove, nothing is deleted. A rule
+// that fails, e.g. without a Docker engine at dockerHost, is in Errors and
+// the other rules still run. When ctx ends the targets found so far are
+// returned with the error.
+func PlanClean(ctx context.Context, rules *CleanRules, dockerHost string, now time.Time) (*CleanPlan, error) {
+	plan := &CleanPlan{Targets: []CleanTarget{}}
+	for _, r := range rules.Rules {
+		var targets []CleanTarget
+		var err error
+		if r.Docker != "" {
+			targets, err = r.planDocker(ctx, dockerHost)
+		} else {
+			targets, err = r.planPaths(ctx, now)
+		}
+		if ctx.Err() != nil {
+			return plan, ctx.Err()
+		}
+		if err != nil {
+			if plan.Errors == nil {
+				plan.Errors = map[string]string{}
+			}
+			plan.Errors[r.Name] = err.Error()
+		}
+		sort.SliceStable(targets, func(i, j int) bool { return targets[i].Size > targets[j].Size })
+		for _, t := range targets {
+			plan.TotalBytes += t.Size
+		}
+		plan.Targets = append(plan.Targets, targets...)
+	}
+	return plan, nil
+}
+
+func (r CleanRule) planDocker(ctx context.Context, host string) ([]CleanTarget, error) {
+	images, err := DockerDanglingImages(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var targets []CleanTarget
+	for _, im := range images {
+		targets = append(targets, CleanTarget{Rule: r.Name, Kind: CleanDockerImage, Path: im.ID, Size: im.Size, ModTime: im.Created})
+	}
+	return targets, nil
+}
+
+func (r CleanRule) planPaths(ctx context.Context, now time.Time) ([]CleanTarget, error) {
+	var targets []CleanTarget
+	var errs []error
+	for _, root := range r.Paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				if path == root && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+				// unreadable entry, look at what we can
+				return nil
+			}
+			if path == root {
+				return nil
+			}
+			if matchAny(r.Exclude, d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type()&(fs.ModeSocket|fs.ModeNamedPipe|fs.ModeDevice|fs.ModeCharDevice|fs.ModeIrregular) != 0 {
+				return nil
+			}
+			if len(r.Match) > 0 && !matchAny(r.Match, d.Name()) {
+				if d.IsDir() && !r.Recursive {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			size, mtime := treeSize(ctx, path)
+			if size >= r.minSize && now.Sub(mtime) >= r.MinAge {
+				kind := CleanFile
+				if d.IsDir() {
+					kind = CleanDir
+				}
+				targets = append(targets, CleanTarget{Rule: r.Name, Kind: kind, Path: path, Size: size, ModTime: mtime})
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return targets, errors.Join(errs...)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveTarget removes t. A file or directory modified after it was
+// planned is left alone, it is in use again.
+func RemoveTarget(ctx context.Context, t CleanTarget, dockerHost string) error {
+	switch t.Kind {
+	case CleanDockerImage:
+		return DockerRemoveImage(ctx, dockerHost, t.Path)
+	case CleanFile, CleanDir:
+	default:
+		return fmt.Errorf("unknown kind %q", t.Kind)
+	}
+
+	info, err := os.Lstat(t.Path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() != (t.Kind == CleanDir) {
+		return errors.New("changed since it was listed")
+	}
+	if _, mtime := treeSize(ctx, t.Path); mtime.After(t.ModTime) {
+		return errors.New("modified since it was listed")
+	}
+	if t.Kind == CleanDir {
+		return os.RemoveAll(t.Path)
+	}
+	return os.Remove(t.Path)
+}
+
+// CleanAudit is one line of the audit log: a target that was removed or
+// failed to be, who ran it and when.
+type CleanAudit struct {
+	Time time.Time `json:"time"`
+	User string    `json:"user,omitempty"`
+	CleanTarget
+}
+
+// AppendCleanAudit appends entries to the JSON lines audit log at path.
+func AppendCleanAudit(path string, entries []CleanAudit) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		e.Time = e.Time.UTC()
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// deletions are nobody else's business, unlike the history
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
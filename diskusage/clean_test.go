@@ -0,0 +1,124 @@
+package diskusage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanClean(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	old := now.Add(-10 * 24 * time.Hour)
+	files := map[string]time.Time{
+		"tmp/old.txt":                  old,
+		"tmp/new.txt":                  now,
+		"tmp/build/a.o":                old,
+		"tmp/build/b.o":                now,
+		"tmp/cache/x":                  old,
+		"tmp/.X11-unix/X0":             old,
+		"log/syslog":                   old,
+		"log/syslog.1":                 old,
+		"log/nginx/access.log.2.gz":    old,
+		"home/app/node_modules/x/y.js": old,
+		"home/app/src/main.js":         old,
+	}
+	for name, mtime := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a directory's own mtime counts towards its age too
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chtimes(path, old, old)
+		}
+		return nil
+	})
+
+	rules := &CleanRules{Rules: []CleanRule{
+		{Name: "tmp", Paths: []string{filepath.Join(root, "tmp")}, Exclude: []string{".*-unix"}, MinAge: 7 * 24 * time.Hour},
+		{Name: "logs", Paths: []string{filepath.Join(root, "log")}, Match: []string{"*.[0-9]", "*.log.[0-9]*"}, Recursive: true, MinAge: 24 * time.Hour},
+		{Name: "node_modules", Paths: []string{filepath.Join(root, "home")}, Match: []string{"node_modules"}, Recursive: true, MinAge: 24 * time.Hour},
+	}}
+	if err := rules.validate(); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := PlanClean(context.Background(), rules, "", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, target := range plan.Targets {
+		rel, _ := filepath.Rel(root, target.Path)
+		got = append(got, target.Rule+":"+rel)
+	}
+	// build has a new file, tmp entries are only looked at one level deep
+	want := "tmp:tmp/cache tmp:tmp/old.txt logs:log/nginx/access.log.2.gz logs:log/syslog.1 node_modules:home/app/node_modules"
+	if strings.Join(got, " ") != want {
+		t.Errorf("got  %s\nwant %s", strings.Join(got, " "), want)
+	}
+	if plan.TotalBytes != 50 {
+		t.Errorf("total %d, want 50", plan.TotalBytes)
+	}
+
+	// removing, a file touched since the plan is kept
+	touched := filepath.Join(root, "log/syslog.1")
+	if err := os.Chtimes(touched, now, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range plan.Targets {
+		err := RemoveTarget(context.Background(), target, "")
+		if target.Path == touched {
+			if err == nil {
+				t.Error("removed a file modified since the plan")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", target.Path, err)
+		}
+		if _, err := os.Lstat(target.Path); !os.IsNotExist(err) {
+			t.Errorf("%s is still there", target.Path)
+		}
+	}
+
+	audit := filepath.Join(root, "state", "clean.jsonl")
+	entry := CleanAudit{Time: now, User: "root", CleanTarget: plan.Targets[0]}
+	if err := AppendCleanAudit(audit, []CleanAudit{entry, entry}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(audit)
+	if n := strings.Count(string(data), "\n"); n != 2 || !strings.Contains(string(data), `"rule":"tmp"`) {
+		t.Errorf("audit log: %s", data)
+	}
+}
+
+func TestCleanRulesValidate(t *testing.T) {
+	for name, r := range map[string]CleanRule{
+		"root":     {Name: "r", Paths: []string{"/"}, MinAge: time.Hour},
+		"relative": {Name: "r", Paths: []string{"tmp"}, MinAge: time.Hour},
+		"no age":   {Name: "r", Paths: []string{"/tmp"}},
+		"docker":   {Name: "r", Docker: "everything"},
+		"pattern":  {Name: "r", Paths: []string{"/tmp"}, Match: []string{"["}, MinAge: time.Hour},
+	} {
+		rules := &CleanRules{Rules: []CleanRule{r}}
+		if err := rules.validate(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if err := DefaultCleanRules().Only([]string{"tmp", "nope"}); err == nil {
+		t.Error("Only: unknown rule accepted")
+	}
+}
@@ -0,0 +1,152 @@
+package diskusage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+	"unicode/utf16"
+)
+
+// Collector gathers the usage of the mounted filesystems.
+type Collector interface {
+	// Name is what the collector is called in a report's collectors.
+	Name() string
+	Collect(ctx context.Context) ([]Filesystem, error)
+}
+
+// DfCollector runs df, once for all mounts or with PerMount once per mount
+// (see DfPerMount). With Inodes it runs df -i too, on the mounts that
+// answered, and reports their inode usage; without it there is none.
+type DfCollector struct {
+	MountsFile string
+	Exact      bool
+	PerMount   bool
+	Timeout    time.Duration
+	Include    map[string]bool
+	Inodes     bool
+}
+
+func (c DfCollector) Name() string { return "df" }
+
+func (c DfCollector) Collect(ctx context.Context) ([]Filesystem, error) {
+	var filesystems []Filesystem
+	var err error
+	if c.PerMount {
+		filesystems, err = DfPerMount(ctx, c.MountsFile, c.Timeout, c.Exact, c.Include)
+	} else {
+		filesystems, err = Df(ctx, c.MountsFile, c.Exact)
+	}
+	if err != nil || !c.Inodes {
+		return filesystems, err
+	}
+	// the report is complete without inodes
+	if err := addDfInodes(ctx, filesystems, c.Timeout); err != nil {
+		slog.Warn("collecting inode usage failed", "err", err)
+	}
+	return filesystems, nil
+}
+
+// StatfsCollector asks the kernel directly, statfs on Linux and the BSDs
+// including macOS, GetDiskFreeSpaceEx on Windows, so nothing is run and
+// the numbers don't depend on which df is installed. They are always
+// exact, and include inode usage except on Windows. The mounts come from MountsFile on Linux and from the system
+// elsewhere. Every mount is looked at on its own with Timeout, like
+// DfPerMount.
+type StatfsCollector struct {
+	MountsFile string
+	Timeout    time.Duration
+	Include    map[string]bool
+}
+
+// statfsInfo is what statfs tells about a filesystem, sizes in bytes.
+// inodes is 0 for filesystems without a fixed inode count.
+type statfsInfo struct {
+	size, free, avail  int64
+	inodes, inodesFree int64
+	readOnly           bool
+}
+
+// splitDrives splits what GetLogicalDriveStrings wrote, drive roots like
+// C:\ each ended by a NUL and the list by another, into the roots.
+func splitDrives(buf []uint16) []string {
+	var drives []string
+	for len(buf) > 0 {
+		end := slices.Index(buf, 0)
+		if end < 0 {
+			end = len(buf)
+		}
+		if end > 0 {
+			drives = append(drives, string(utf16.Decode(buf[:end])))
+		}
+		buf = buf[min(end+1, len(buf)):]
+	}
+	return drives
+}
+
+// ErrNoStatfs is returned by StatfsCollector on platforms without it.
+var ErrNoStatfs = errors.New("statfs is not supported on this platform")
+
+func (c StatfsCollector) Name() string { return "statfs" }
+
+func (c StatfsCollector) Collect(ctx context.Context) ([]Filesystem, error) {
+	if !statfsSupported {
+		return nil, ErrNoStatfs
+	}
+	mounts, err := statfsMounts(c.MountsFile)
+	if err != nil {
+		return nil, err
+	}
+	return eachMount(ctx, filterMounts(mounts, c.Include), c.Timeout, func(_ context.Context, m Mount) (Filesystem, error) {
+		fs := Filesystem{Source: m.Source, FSType: m.FSType, MountPoint: m.MountPoint, Status: StatusOK, ReadOnly: readOnlyOption(m.Options)}
+		st, err := statfs(m.MountPoint)
+		if err != nil {
+			return fs, err
+		}
+		fs.setUsage(st.size, st.free, st.avail)
+		fs.Inodes = newInodeUsage(st.inodes, st.inodesFree)
+		fs.ReadOnly = fs.ReadOnly || st.readOnly
+		return fs, nil
+	})
+}
+
+// NewCollector returns the collector of kind: "statfs", "df", or "auto"
+// for statfs where the platform has it and df elsewhere. df takes the
+// settings of both.
+func NewCollector(kind string, df DfCollector) (Collector, error) {
+	statfs := StatfsCollector{MountsFile: df.MountsFile, Timeout: df.Timeout, Include: df.Include}
+	switch kind {
+	case "auto":
+		if statfsSupported {
+			return statfs, nil
+		}
+		return df, nil
+	case "statfs":
+		if !statfsSupported {
+			return nil, ErrNoStatfs
+		}
+		return statfs, nil
+	case "df":
+		return df, nil
+	}
+	return nil, errors.New("unknown collector " + kind + ", want auto, statfs or df")
+}
+
+// setUsage fills in the sizes from the total, free and available bytes the
+// way df computes them: used is what isn't free, and the percent is of
+// used+avail, rounded up, so blocks reserved for root count as neither. A
+// size of 0 is a filesystem without usage (proc, sysfs...), df prints "-"
+// for it.
+func (fs *Filesystem) setUsage(size, free, avail int64) {
+	if size == 0 {
+		fs.Size, fs.Used, fs.Avail, fs.UsePercent = Unknown, Unknown, Unknown, Unknown
+		fs.Status = StatusUnavailable
+		return
+	}
+	fs.Size, fs.Used, fs.Avail = size, size-free, avail
+	if total := fs.Used + fs.Avail; total > 0 {
+		fs.UsePercent = int((fs.Used*100 + total - 1) / total)
+	}
+	fs.computePercent()
+}
@@ -0,0 +1,81 @@
+package diskusage
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+func TestSetUsage(t *testing.T) {
+	tests := []struct {
+		name              string
+		size, free, avail int64
+		want              Filesystem
+	}{
+		{
+			name: "reserved blocks",
+			size: 1000, free: 300, avail: 250,
+			want: Filesystem{Size: 1000, Used: 700, Avail: 250, UsePercent: 74, Status: StatusOK},
+		},
+		{
+			name: "full",
+			size: 1000, free: 50, avail: 0,
+			want: Filesystem{Size: 1000, Used: 950, Avail: 0, UsePercent: 100, Status: StatusOK},
+		},
+		{
+			name: "no usage",
+			want: Filesystem{Size: Unknown, Used: Unknown, Avail: Unknown, UsePercent: Unknown, Status: StatusUnavailable},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := Filesystem{Status: StatusOK}
+			fs.setUsage(tt.size, tt.free, tt.avail)
+			if fs.Size != tt.want.Size || fs.Used != tt.want.Used || fs.Avail != tt.want.Avail ||
+				fs.UsePercent != tt.want.UsePercent || fs.Status != tt.want.Status {
+				t.Errorf("got %+v, want %+v", fs, tt.want)
+			}
+			if fs.HasStats() != (fs.UsedPercent != nil) {
+				t.Errorf("UsedPercent %v for HasStats %v", fs.UsedPercent, fs.HasStats())
+			}
+		})
+	}
+}
+
+func TestStatfsCollector(t *testing.T) {
+	if !statfsSupported {
+		t.Skip(ErrNoStatfs)
+	}
+	c := StatfsCollector{MountsFile: DefaultMountsFile, Timeout: 5 * time.Second, Include: map[string]bool{ClassReal: true}}
+	filesystems, err := c.Collect(context.Background())
+	if err != nil {
+		t.Skip("no mount table:", err)
+	}
+	for _, fs := range filesystems {
+		if fs.Class != ClassReal {
+			t.Errorf("%s: class %s not included", fs.MountPoint, fs.Class)
+		}
+		if fs.Status == StatusOK && (fs.Size <= 0 || fs.Used+fs.Avail > fs.Size) {
+			t.Errorf("%s: implausible usage %+v", fs.MountPoint, fs)
+		}
+	}
+}
+
+func TestSplitDrives(t *testing.T) {
+	for _, tt := range []struct {
+		buf  string
+		want []string
+	}{
+		{"C:\\\x00D:\\\x00Z:\\\x00\x00", []string{"C:\\", "D:\\", "Z:\\"}},
+		{"C:\\\x00", []string{"C:\\"}},
+		// cut short without the final NULs
+		{"C:\\\x00D:\\", []string{"C:\\", "D:\\"}},
+		{"", nil},
+	} {
+		if got := splitDrives(utf16.Encode([]rune(tt.buf))); !slices.Equal(got, tt.want) {
+			t.Errorf("splitDrives(%q) = %q, want %q", tt.buf, got, tt.want)
+		}
+	}
+}
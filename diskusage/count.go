@@ -0,0 +1,42 @@
+package diskusage
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"time"
+)
+
+// countFiles counts regular files under dir. It stops when ctx ends and
+// returns what it counted so far with partial set.
+func countFiles(ctx context.Context, dir string) (n int64, partial bool) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// unreadable directory, count what we can
+			return nil
+		}
+		if d.Type().IsRegular() {
+			n++
+		}
+		return nil
+	})
+	return n, err != nil
+}
+
+// CountFiles fills in Files for every dir, giving each walk at most
+// timeout so one huge tree can't stall the report.
+func CountFiles(ctx context.Context, dirs []Dir, timeout time.Duration) {
+	for i := range dirs {
+		dctx, cancel := context.WithTimeout(ctx, timeout)
+		dirs[i].Files, dirs[i].FilesPartial = countFiles(dctx, dirs[i].Path)
+		cancel()
+
+		if dirs[i].FilesPartial {
+			slog.Warn("file count incomplete", "path", dirs[i].Path, "counted", dirs[i].Files)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package diskusage
+
+import "strings"
+
+// Device is one underlying filesystem with every place it is mounted, bind
+// mounts on container hosts show up as several mounts of the same source.
+type Device struct {
+	// Filesystem is the primary mount, the one with the shortest mount point.
+	Filesystem
+	// MountPoints are all of them, primary first.
+	MountPoints []string
+}
+
+// GroupByDevice groups mounts of the same device, in the order the devices
+// were first seen. Only block devices (/dev/...) and network shares
+// (host:/path) are grouped, sources like tmpfs or overlay name a type, not a
+// device, and stay separate. Devices of different hosts are never grouped.
+func GroupByDevice(filesystems []Filesystem) []Device {
+	var devices []Device
+	index := map[string]int{}
+
+	for _, fs := range filesystems {
+		shared := strings.HasPrefix(fs.Source, "/") || strings.Contains(fs.Source, ":/")
+		key := fs.Host + "\x00" + fs.Source
+		i, seen := index[key]
+		if !shared || !seen {
+			if shared {
+				index[key] = len(devices)
+			}
+			devices = append(devices, Device{Filesystem: fs, MountPoints: []string{fs.MountPoint}})
+			continue
+		}
+
+		d := &devices[i]
+		if len(fs.MountPoint) < len(d.MountPoint) {
+			d.Filesystem = fs
+			d.MountPoints = append([]string{fs.MountPoint}, d.MountPoints...)
+		} else {
+			d.MountPoints = append(d.MountPoints, fs.MountPoint)
+		}
+	}
+	return devices
+}
@@ -0,0 +1,430 @@
+package diskusage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mount status values reported in Filesystem.Status.
+const (
+	StatusOK          = "ok"
+	StatusStale       = "stale"
+	StatusUnreachable = "unreachable"
+	// StatusUnavailable means df answered but printed "-" instead of numbers,
+	// as it does for many network and pseudo filesystems.
+	StatusUnavailable = "unavailable"
+)
+
+// Unknown is stored in size and percent fields df did not report.
+const Unknown = -1
+
+// Filesystem is one mount as reported by df. Sizes are in bytes.
+type Filesystem struct {
+	// Host is set for filesystems of other machines, see SSHCollector.
+	Host       string `json:"host,omitempty"`
+	Source     string `json:"source"`
+	FSType     string `json:"fs_type"`
+	Class      string `json:"class"`
+	Size       int64  `json:"size_bytes"`
+	Used       int64  `json:"used_bytes"`
+	Avail      int64  `json:"avail_bytes"`
+	UsePercent int    `json:"use_percent"`
+	// UsedPercent and FreePercent are computed from exact byte counts
+	// (-bytes) instead of df's rounded Use%, nil otherwise.
+	UsedPercent *float64 `json:"used_percent,omitempty"`
+	FreePercent *float64 `json:"free_percent,omitempty"`
+	MountPoint  string   `json:"mount_point"`
+	Status      string   `json:"status"`
+	// Path is set when usage was asked for a path rather than a mount.
+	Path string `json:"path,omitempty"`
+	// BlockDevice is set by AnnotateBlockDevices.
+	BlockDevice *BlockDevice `json:"block_device,omitempty"`
+	// Inodes is collected by statfs, and by df when asked for.
+	Inodes *InodeUsage `json:"inodes,omitempty"`
+	// ReadOnly is set when the filesystem is mounted read-only.
+	ReadOnly bool `json:"read_only,omitempty"`
+	// Errors is how many errors ext filesystems counted, see
+	// AnnotateErrors.
+	Errors *int64 `json:"fs_errors,omitempty"`
+}
+
+// Name is the mount point, as host:mount for a filesystem of another
+// machine, it tells the filesystems of several hosts apart.
+func (fs Filesystem) Name() string {
+	if fs.Host == "" {
+		return fs.MountPoint
+	}
+	return fs.Host + ":" + fs.MountPoint
+}
+
+// ParseDf parses the output of `df -hP`. Human readable sizes (20G, 1.5T)
+// are converted to bytes, so they are only as exact as df's rounding.
+func ParseDf(out []byte) ([]Filesystem, error) {
+	return parseDf(out, parseHumanSize)
+}
+
+// ParseDfBytes parses the output of `df -P` with an explicit block size
+// (`-B1` on GNU, `-k` elsewhere). The block size is read from the header.
+func ParseDfBytes(out []byte) ([]Filesystem, error) {
+	header := out
+	if i := bytes.IndexByte(out, '\n'); i >= 0 {
+		header = out[:i]
+	}
+
+	cols := strings.Fields(string(header))
+	if len(cols) < 2 {
+		return nil, fmt.Errorf("unexpected df header %q", header)
+	}
+	blockSize, err := parseBlockSize(cols[1])
+	if err != nil {
+		return nil, err
+	}
+
+	filesystems, err := parseDf(out, func(s string) (int64, error) {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return n * blockSize, nil
+	})
+	for i := range filesystems {
+		filesystems[i].computePercent()
+	}
+	return filesystems, err
+}
+
+// computePercent sets UsedPercent and FreePercent from the byte counts.
+// Like df it uses used+avail as the total, blocks reserved for root count
+// as neither. df rounds Use% up, so near a threshold the two can disagree.
+func (fs *Filesystem) computePercent() {
+	if !fs.HasStats() || fs.Used+fs.Avail == 0 {
+		return
+	}
+	used := float64(fs.Used) / float64(fs.Used+fs.Avail) * 100
+	free := 100 - used
+	fs.UsedPercent, fs.FreePercent = &used, &free
+}
+
+// Percent is the most exact use percent known: UsedPercent when bytes were
+// collected, df's Use% otherwise.
+func (fs Filesystem) Percent() float64 {
+	if fs.UsedPercent != nil {
+		return *fs.UsedPercent
+	}
+	return float64(fs.UsePercent)
+}
+
+func parseDf(out []byte, parseSize func(string) (int64, error)) ([]Filesystem, error) {
+	filesystems := make([]Filesystem, 0, bytes.Count(out, []byte{'\n'}))
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		// first line is the header
+		if n == 1 || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("df line %d: expected 6 columns, got %d: %q", n, len(fields), line)
+		}
+
+		fs := Filesystem{
+			Source: fields[0],
+			// mount points may contain spaces
+			MountPoint: fields[5],
+			Status:     StatusOK,
+		}
+		if len(fields) > 6 {
+			fs.MountPoint = strings.Join(fields[5:], " ")
+		}
+
+		var err error
+		if fs.Size, err = parseStat(fields[1], parseSize); err != nil {
+			return nil, fmt.Errorf("df line %d: size: %w", n, err)
+		}
+		if fs.Used, err = parseStat(fields[2], parseSize); err != nil {
+			return nil, fmt.Errorf("df line %d: used: %w", n, err)
+		}
+		if fs.Avail, err = parseStat(fields[3], parseSize); err != nil {
+			return nil, fmt.Errorf("df line %d: avail: %w", n, err)
+		}
+		percent, err := parseStat(strings.TrimSuffix(fields[4], "%"), parsePercent)
+		if err != nil {
+			return nil, fmt.Errorf("df line %d: use%%: %w", n, err)
+		}
+		fs.UsePercent = int(percent)
+
+		if fs.Size == Unknown || fs.Used == Unknown || fs.Avail == Unknown || fs.UsePercent == Unknown {
+			fs.Status = StatusUnavailable
+		}
+
+		filesystems = append(filesystems, fs)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return filesystems, nil
+}
+
+// parseStat parses one numeric df column. df prints "-" for stats the
+// filesystem does not provide, those become Unknown.
+func parseStat(s string, parse func(string) (int64, error)) (int64, error) {
+	if s == "" || s == "-" {
+		return Unknown, nil
+	}
+	return parse(s)
+}
+
+func parsePercent(s string) (int64, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// HasStats reports whether df returned usable numbers for the mount.
+// Threshold checks skip mounts without stats.
+func (fs Filesystem) HasStats() bool {
+	return fs.Status == StatusOK
+}
+
+// parseBlockSize reads the block size from the second df header column,
+// e.g. "1024-blocks", "1K-blocks" or "1B-blocks".
+func parseBlockSize(col string) (int64, error) {
+	size, ok := strings.CutSuffix(col, "-blocks")
+	if !ok {
+		return 0, fmt.Errorf("unexpected df block size column %q", col)
+	}
+	size = strings.TrimSuffix(size, "B")
+	if size == "" {
+		return 1, nil
+	}
+	return parseHumanSize(size)
+}
+
+// ParseSize converts sizes like "512", "100M" or "1.5T" to bytes, the way
+// the rules and reclaim configs take them.
+func ParseSize(s string) (int64, error) {
+	return parseHumanSize(s)
+}
+
+// parseHumanSize converts sizes like "512", "20G", "1.5T" or "931Gi" to bytes.
+func parseHumanSize(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "i")
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+
+	mult := int64(1)
+	switch s[len(s)-1] {
+	case 'K', 'k':
+		mult = 1 << 10
+	case 'M':
+		mult = 1 << 20
+	case 'G':
+		mult = 1 << 30
+	case 'T':
+		mult = 1 << 40
+	case 'P':
+		mult = 1 << 50
+	case 'E':
+		mult = 1 << 60
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// dfArgs returns the df arguments for the human or byte exact output.
+func dfArgs(exact bool) []string {
+	if !exact {
+		return []string{"-hP"}
+	}
+	if runtime.GOOS == "linux" {
+		return []string{"-P", "-B1"}
+	}
+	return []string{"-P", "-k"}
+}
+
+func parseDfOutput(out []byte, exact bool) ([]Filesystem, error) {
+	if exact {
+		return ParseDfBytes(out)
+	}
+	return ParseDf(out)
+}
+
+// DefaultMountsFile is the mount table Collect reads.
+const DefaultMountsFile = "/proc/mounts"
+
+// Collect is the simple way in: exact usage of every real and network
+// filesystem, one Filesystem per mount, from statfs where the platform has
+// it and df elsewhere. Pseudo, virtual and loop mounts are left out, use a
+// Collector and FilterClasses to choose.
+func Collect(ctx context.Context) ([]Filesystem, error) {
+	include := map[string]bool{ClassReal: true, ClassNetwork: true}
+	c, err := NewCollector("auto", DfCollector{MountsFile: DefaultMountsFile, Exact: true, Timeout: 5 * time.Second, Include: include})
+	if err != nil {
+		return nil, err
+	}
+	filesystems, err := c.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return FilterClasses(filesystems, include), nil
+}
+
+// Df runs df once for all mounts. Filesystem types come from mountsFile
+// when it can be read.
+func Df(ctx context.Context, mountsFile string, exact bool) ([]Filesystem, error) {
+	out, stderr, err := runCommand(ctx, "df", dfArgs(exact)...)
+	if err != nil {
+		// df exits non zero when a single mount fails but still prints the rest
+		if len(out) == 0 {
+			return nil, fmt.Errorf("df: %w: %s", err, strings.TrimSpace(string(stderr)))
+		}
+	}
+
+	filesystems, err := parseDfOutput(out, exact)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := ReadMounts(mountsFile)
+	if err != nil {
+		slog.Debug("no mount table, guessing filesystem classes", "err", err)
+	}
+	annotate(filesystems, mounts)
+	return filesystems, nil
+}
+
+// DfPerMount runs a separate df for every mount in mountsFile whose class is
+// in include (all of them when include is nil), each with its own timeout. A
+// mount whose df does not finish in time (typically a stale NFS mount) is
+// reported as stale instead of blocking the whole report. Mounts of other
+// classes are never looked at: a df on an autofs mount point mounts it.
+func DfPerMount(ctx context.Context, mountsFile string, timeout time.Duration, exact bool, include map[string]bool) ([]Filesystem, error) {
+	mounts, err := ReadMounts(mountsFile)
+	if err != nil {
+		return nil, err
+	}
+	return eachMount(ctx, filterMounts(mounts, include), timeout, func(ctx context.Context, m Mount) (Filesystem, error) {
+		args := append(dfArgs(exact), m.MountPoint)
+		out, stderr, err := runCommand(ctx, "df", args...)
+		if err != nil {
+			return Filesystem{}, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(stderr)))
+		}
+		filesystems, err := parseDfOutput(out, exact)
+		if err != nil {
+			return Filesystem{}, err
+		}
+		if len(filesystems) == 0 {
+			return Filesystem{}, errors.New("df printed no filesystem")
+		}
+		fs := filesystems[0]
+		fs.FSType = m.FSType
+		fs.ReadOnly = readOnlyOption(m.Options)
+		return fs, nil
+	})
+}
+
+// filterMounts keeps the mounts whose class is in include, all of them when
+// include is nil.
+func filterMounts(mounts []Mount, include map[string]bool) []Mount {
+	if include == nil {
+		return mounts
+	}
+	var kept []Mount
+	for _, m := range mounts {
+		if include[classifyMount(m.FSType, m.Source, m.MountPoint)] {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+// mountConcurrency is how many mounts eachMount looks at at once.
+const mountConcurrency = 8
+
+// eachMount calls stat for every mount, a few at a time and each with its
+// own timeout. A mount that doesn't answer in time is reported as stale, one
+// that fails as unreachable; the results keep the order of mounts.
+func eachMount(ctx context.Context, mounts []Mount, timeout time.Duration, stat func(context.Context, Mount) (Filesystem, error)) ([]Filesystem, error) {
+	type result struct {
+		fs  Filesystem
+		err error
+	}
+	results := make([]chan result, len(mounts))
+	sem := make(chan struct{}, mountConcurrency)
+
+	for i, m := range mounts {
+		results[i] = make(chan result, 1)
+
+		go func(m Mount, ch chan<- result) {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				ch <- result{err: ctx.Err()}
+				return
+			}
+			var once sync.Once
+			done := func(r result) {
+				once.Do(func() {
+					ch <- r
+					<-sem
+				})
+			}
+			stale := Filesystem{Source: m.Source, FSType: m.FSType, MountPoint: m.MountPoint, Status: StatusStale}
+
+			// A df or statfs stuck in uninterruptible IO on a dead NFS
+			// server can ignore the kill, give up its slot shortly after
+			// its deadline.
+			stuck := time.AfterFunc(timeout+time.Second, func() { done(result{fs: stale}) })
+			defer stuck.Stop()
+
+			mctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			fs, err := stat(mctx, m)
+			if errors.Is(mctx.Err(), context.DeadlineExceeded) {
+				done(result{fs: stale})
+				return
+			}
+			done(result{fs: fs, err: err})
+		}(m, results[i])
+	}
+
+	var filesystems []Filesystem
+	for i, m := range mounts {
+		select {
+		case r := <-results[i]:
+			if ctx.Err() != nil {
+				return filesystems, ctx.Err()
+			}
+			if r.err != nil {
+				slog.Warn("no usage for mount", "mount", m.MountPoint, "err", r.err)
+				r.fs = Filesystem{Source: m.Source, FSType: m.FSType, MountPoint: m.MountPoint, Status: StatusUnreachable}
+			}
+			filesystems = append(filesystems, r.fs)
+		case <-ctx.Done():
+			return filesystems, ctx.Err()
+		}
+	}
+
+	annotate(filesystems, mounts)
+	return filesystems, nil
+}
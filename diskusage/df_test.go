@@ -0,0 +1,238 @@
+package diskusage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ved/test/runner"
+)
+
+// A filesystem that has no stats, as GNU df -a prints autofs mounts.
+const (
+	dfUnavailableHuman = `Filesystem      Size  Used Avail Use% Mounted on
+/dev/sda1        30G   12G   17G  42% /
+systemd-1          -     -     -    - /proc/sys/fs/binfmt_misc
+`
+	dfUnavailableBytes = `Filesystem       1B-blocks        Used   Available Capacity Mounted on
+/dev/sda1      31845081088 12884901888 18253611008      42% /
+systemd-1                -           -           -        - /proc/sys/fs/binfmt_misc
+`
+)
+
+func TestParseDfUnavailable(t *testing.T) {
+	tests := []struct {
+		name  string
+		out   string
+		parse func([]byte) ([]Filesystem, error)
+	}{
+		{"human", dfUnavailableHuman, ParseDf},
+		{"bytes", dfUnavailableBytes, ParseDfBytes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filesystems, err := tt.parse([]byte(tt.out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(filesystems) != 2 {
+				t.Fatalf("got %d filesystems, want 2", len(filesystems))
+			}
+
+			if root := filesystems[0]; root.Status != StatusOK || root.UsePercent != 42 {
+				t.Errorf("/: status %q, use%% %d, want ok and 42", root.Status, root.UsePercent)
+			}
+
+			fs := filesystems[1]
+			if fs.Status != StatusUnavailable {
+				t.Errorf("status %q, want %q", fs.Status, StatusUnavailable)
+			}
+			if fs.Size != Unknown || fs.Used != Unknown || fs.Avail != Unknown || fs.UsePercent != Unknown {
+				t.Errorf("size %d, used %d, avail %d, use%% %d, want all Unknown", fs.Size, fs.Used, fs.Avail, fs.UsePercent)
+			}
+			if fs.MountPoint != "/proc/sys/fs/binfmt_misc" {
+				t.Errorf("mount point %q", fs.MountPoint)
+			}
+			if fs.HasStats() || fs.UsedPercent != nil {
+				t.Error("a mount without stats must not have a percent")
+			}
+		})
+	}
+}
+
+func TestParseDf(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    []Filesystem
+		wantErr bool
+	}{
+		{
+			name: "human sizes",
+			out: `Filesystem      Size  Used Avail Use% Mounted on
+/dev/sda1        20G  8.5G   11G  44% /
+`,
+			want: []Filesystem{{Source: "/dev/sda1", Size: 20 << 30, Used: int64(8.5 * (1 << 30)), Avail: 11 << 30, UsePercent: 44, MountPoint: "/", Status: StatusOK}},
+		},
+		{
+			name: "mount point with spaces",
+			out: `Filesystem      Size  Used Avail Use% Mounted on
+/dev/sdb1       100M   10M   90M  10% /mnt/my disk
+`,
+			want: []Filesystem{{Source: "/dev/sdb1", Size: 100 << 20, Used: 10 << 20, Avail: 90 << 20, UsePercent: 10, MountPoint: "/mnt/my disk", Status: StatusOK}},
+		},
+		{
+			name: "blank lines",
+			out:  "Filesystem Size Used Avail Use% Mounted on\n\n/dev/sda1 1K 0 1K 0% /\n\n",
+			want: []Filesystem{{Source: "/dev/sda1", Size: 1 << 10, Avail: 1 << 10, MountPoint: "/", Status: StatusOK}},
+		},
+		{
+			name: "header only",
+			out:  "Filesystem Size Used Avail Use% Mounted on\n",
+			want: []Filesystem{},
+		},
+		{
+			name:    "short line",
+			out:     "Filesystem Size Used Avail Use% Mounted on\n/dev/sda1 20G 8G\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad size",
+			out:     "Filesystem Size Used Avail Use% Mounted on\n/dev/sda1 lots 8G 12G 40% /\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDf([]byte(tt.out))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDfBytes(t *testing.T) {
+	tests := []struct {
+		name      string
+		out       string
+		wantSize  int64
+		wantUsed  float64
+		wantError bool
+	}{
+		{
+			name:     "GNU -B1",
+			out:      "Filesystem 1B-blocks Used Available Capacity Mounted on\n/dev/sda1 1000 250 750 25% /\n",
+			wantSize: 1000,
+			wantUsed: 25,
+		},
+		{
+			name:     "BSD -k",
+			out:      "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/disk1s1 1000 100 300 26% /\n",
+			wantSize: 1000 << 10,
+			wantUsed: 25,
+		},
+		{
+			name:      "no block size",
+			out:       "Filesystem Size Used Avail Use% Mounted on\n/dev/sda1 20G 8G 12G 40% /\n",
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDfBytes([]byte(tt.out))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("err %v, want error %v", err, tt.wantError)
+			}
+			if tt.wantError {
+				return
+			}
+			if len(got) != 1 {
+				t.Fatalf("got %d filesystems, want 1", len(got))
+			}
+			if got[0].Size != tt.wantSize {
+				t.Errorf("size %d, want %d", got[0].Size, tt.wantSize)
+			}
+			// used / (used+avail), reserved blocks count as neither
+			if got[0].UsedPercent == nil || *got[0].UsedPercent != tt.wantUsed || got[0].Percent() != tt.wantUsed {
+				t.Errorf("used percent %v, want %v", got[0].UsedPercent, tt.wantUsed)
+			}
+		})
+	}
+}
+
+// dfOutput fakes the df output of a host with n mounts.
+func dfOutput(n int, header string, line func(i int) string) []byte {
+	var b strings.Builder
+	b.WriteString(header + "\n")
+	for i := range n {
+		b.WriteString(line(i) + "\n")
+	}
+	return []byte(b.String())
+}
+
+func BenchmarkParseDf(b *testing.B) {
+	out := dfOutput(1000, "Filesystem Size Used Avail Use% Mounted on", func(i int) string {
+		return fmt.Sprintf("/dev/mapper/vg-lv%d 1.5T 512G 1000G 34%% /srv/data%d", i, i)
+	})
+	b.SetBytes(int64(len(out)))
+	for b.Loop() {
+		if _, err := ParseDf(out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseDfBytes(b *testing.B) {
+	out := dfOutput(1000, "Filesystem 1B-blocks Used Available Capacity Mounted on", func(i int) string {
+		return fmt.Sprintf("/dev/mapper/vg-lv%d 1649267441664 549755813888 1099511627776 34%% /srv/data%d", i, i)
+	})
+	b.SetBytes(int64(len(out)))
+	for b.Loop() {
+		if _, err := ParseDfBytes(out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDfPerMountFakeRunner(t *testing.T) {
+	mounts := filepath.Join(t.TempDir(), "mounts")
+	table := "/dev/sda1 / ext4 rw 0 0\nserver:/export /nfs nfs ro 0 0\n"
+	if err := os.WriteFile(mounts, []byte(table), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var fake runner.Fake
+	root := "Filesystem 1B-blocks Used Available Capacity Mounted on\n/dev/sda1 31845081088 12884901888 18253611008 42% /\n"
+	fake.Add(runner.Response{Stdout: root}, "df", append(dfArgs(true), "/")...)
+	// a dead NFS server: df never comes back
+	fake.Add(runner.Response{Delay: time.Hour}, "df", append(dfArgs(true), "/nfs")...)
+
+	ctx := runner.WithRunner(context.Background(), &fake)
+	filesystems, err := DfPerMount(ctx, mounts, 50*time.Millisecond, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filesystems) != 2 {
+		t.Fatalf("got %d filesystems, want 2", len(filesystems))
+	}
+	if fs := filesystems[0]; fs.Status != StatusOK || fs.UsePercent != 42 || fs.FSType != "ext4" {
+		t.Errorf("/: %+v", fs)
+	}
+	if fs := filesystems[1]; fs.Status != StatusStale || fs.MountPoint != "/nfs" {
+		t.Errorf("/nfs: status %q, want %q", fs.Status, StatusStale)
+	}
+	if n := len(fake.Calls()); n != 2 {
+		t.Errorf("%d df calls, want 2", n)
+	}
+}
@@ -0,0 +1,115 @@
+package diskusage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// Kinds of MountDiff.
+const (
+	DiffOnlyA   = "only_a"
+	DiffOnlyB   = "only_b"
+	DiffChanged = "changed"
+)
+
+// MountDiff is one mount that differs between two reports.
+type MountDiff struct {
+	// Host is the mount's host in reports of several, see Filesystem.Host.
+	Host       string      `json:"host,omitempty"`
+	MountPoint string      `json:"mount_point"`
+	Kind       string      `json:"kind"`
+	A          *Filesystem `json:"a,omitempty"`
+	B          *Filesystem `json:"b,omitempty"`
+	// Reason says what differs for changed mounts.
+	Reason string `json:"reason,omitempty"`
+}
+
+// LoadReport reads a report written with -format json.
+func LoadReport(path string) (Report, error) {
+	var r Report
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, fmt.Errorf("%s: %w", path, err)
+	}
+	if r.SchemaVersion != SchemaVersion {
+		return r, fmt.Errorf("%s: schema_version %d, want %d", path, r.SchemaVersion, SchemaVersion)
+	}
+	return r, nil
+}
+
+// Diff compares the filesystems of two reports, e.g. from two hosts that
+// should look alike. Mounts present in only one of them always differ.
+// Mounts in both differ when their use percent is more than tolerance
+// points apart, or their size or used bytes more than tolerance percent of
+// the larger value, so normal churn is not reported. Mounts are matched
+// by host and mount point, see Filesystem.Name.
+func Diff(a, b Report, tolerance float64) []MountDiff {
+	byMount := func(r Report) map[string]*Filesystem {
+		m := map[string]*Filesystem{}
+		for i := range r.Filesystems {
+			m[r.Filesystems[i].Name()] = &r.Filesystems[i]
+		}
+		return m
+	}
+	am, bm := byMount(a), byMount(b)
+
+	var diffs []MountDiff
+	for name, fa := range am {
+		fb, ok := bm[name]
+		if !ok {
+			diffs = append(diffs, MountDiff{Host: fa.Host, MountPoint: fa.MountPoint, Kind: DiffOnlyA, A: fa})
+			continue
+		}
+		if reason := compare(fa, fb, tolerance); reason != "" {
+			diffs = append(diffs, MountDiff{Host: fa.Host, MountPoint: fa.MountPoint, Kind: DiffChanged, A: fa, B: fb, Reason: reason})
+		}
+	}
+	for name, fb := range bm {
+		if _, ok := am[name]; !ok {
+			diffs = append(diffs, MountDiff{Host: fb.Host, MountPoint: fb.MountPoint, Kind: DiffOnlyB, B: fb})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name() < diffs[j].Name() })
+	return diffs
+}
+
+// Name is the mount point, prefixed by the host when there is one.
+func (d MountDiff) Name() string {
+	return Filesystem{Host: d.Host, MountPoint: d.MountPoint}.Name()
+}
+
+func compare(a, b *Filesystem, tolerance float64) string {
+	if a.HasStats() != b.HasStats() {
+		return fmt.Sprintf("status %s vs %s", a.Status, b.Status)
+	}
+	if !a.HasStats() {
+		return ""
+	}
+	if d := math.Abs(float64(a.UsePercent - b.UsePercent)); d > tolerance {
+		return fmt.Sprintf("use %d%% vs %d%%", a.UsePercent, b.UsePercent)
+	}
+	if bytesDiffer(a.Size, b.Size, tolerance) {
+		return fmt.Sprintf("size %d vs %d bytes", a.Size, b.Size)
+	}
+	if bytesDiffer(a.Used, b.Used, tolerance) {
+		return fmt.Sprintf("used %d vs %d bytes", a.Used, b.Used)
+	}
+	return ""
+}
+
+// bytesDiffer reports whether x and y are more than tolerance percent of
+// the larger one apart.
+func bytesDiffer(x, y int64, tolerance float64) bool {
+	larger := max(x, y)
+	if larger == 0 {
+		return false
+	}
+	return math.Abs(float64(x-y))/float64(larger)*100 > tolerance
+}
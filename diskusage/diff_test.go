@@ -0,0 +1,57 @@
+package diskusage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	fs := func(host, mount string, size, used int64) Filesystem {
+		return Filesystem{Host: host, MountPoint: mount, Size: size, Used: used, Avail: size - used, UsePercent: int(used * 100 / size), Status: StatusOK}
+	}
+	a := Report{Filesystems: []Filesystem{
+		fs("", "/", 1000, 500),
+		fs("", "/var", 1000, 300),
+		fs("", "/data", 1000, 100),
+		{MountPoint: "/mnt/nfs", Status: StatusStale},
+	}}
+	b := Report{Filesystems: []Filesystem{
+		// within the 2% tolerance
+		fs("", "/", 1000, 510),
+		fs("", "/var", 1000, 600),
+		fs("", "/home", 1000, 100),
+		fs("", "/mnt/nfs", 1000, 100),
+	}}
+
+	var got []string
+	for _, d := range Diff(a, b, 2) {
+		got = append(got, d.Name()+" "+d.Kind+" "+d.Reason)
+	}
+	want := []string{
+		"/data only_a ",
+		"/home only_b ",
+		"/mnt/nfs changed status stale vs ok",
+		"/var changed use 30% vs 60%",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDiffHosts(t *testing.T) {
+	fs := func(host, mount string, used int64) Filesystem {
+		return Filesystem{Host: host, MountPoint: mount, Size: 1000, Used: used, Avail: 1000 - used, UsePercent: int(used / 10), Status: StatusOK}
+	}
+	// the / of every host is compared with the same host's
+	a := Report{Filesystems: []Filesystem{fs("web1", "/", 200), fs("web2", "/", 900), fs("web2", "/var", 100)}}
+	b := Report{Filesystems: []Filesystem{fs("web2", "/", 900), fs("web1", "/", 700), fs("web1", "/var", 100)}}
+
+	var got []string
+	for _, d := range Diff(a, b, 2) {
+		got = append(got, d.Name()+" "+d.Kind)
+	}
+	want := []string{"web1:/ changed", "web1:/var only_b", "web2:/var only_a"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,10 @@
+// Package diskusage collects disk usage with statfs (GetDiskFreeSpaceEx on
+// Windows) or by running and parsing df, and du.
+//
+// Collect returns a Filesystem for every real mount, with its size, used and
+// available bytes and use percent. A Collector (StatfsCollector or
+// DfCollector), Df, DfPerMount and DfPaths give more control over what is
+// collected, Du reports the largest directories under a path. The parsers
+// (ParseDf, ParseDfBytes, TopDirs) work on plain command output and can be
+// used on their own.
+package diskusage
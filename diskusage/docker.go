@@ -0,0 +1,272 @@
+package diskusage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDockerHost is the engine DockerDf asks without DOCKER_HOST.
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// DockerUsage is the space the Docker engine uses, what `docker system df
+// -v` shows: images, containers, local volumes and the build cache, each
+// with its total and what pruning would free.
+type DockerUsage struct {
+	Images     DockerCategory `json:"images"`
+	Containers DockerCategory `json:"containers"`
+	Volumes    DockerCategory `json:"volumes"`
+	BuildCache DockerCategory `json:"build_cache"`
+}
+
+// DockerCategory totals one kind of object. Active are the ones in use: an
+// image with containers, a running container, a volume mounted by a
+// container, a build cache record in use. Items are the largest first.
+type DockerCategory struct {
+	Count       int          `json:"count"`
+	Active      int          `json:"active"`
+	Size        int64        `json:"size_bytes"`
+	Reclaimable int64        `json:"reclaimable_bytes"`
+	Items       []DockerItem `json:"items,omitempty"`
+}
+
+// DockerItem is one image, container, volume or cache record.
+type DockerItem struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name,omitempty"`
+	Size    int64     `json:"size_bytes"`
+	Active  bool      `json:"active"`
+	Created time.Time `json:"created,omitzero"`
+}
+
+// dockerDf is the part of the engine's GET /system/df answer we use.
+type dockerDf struct {
+	LayersSize int64
+	Images     []struct {
+		ID         string `json:"Id"`
+		RepoTags   []string
+		Size       int64
+		SharedSize int64
+		Containers int64
+		Created    int64
+	}
+	Containers []struct {
+		ID      string `json:"Id"`
+		Names   []string
+		Image   string
+		SizeRw  int64
+		State   string
+		Created int64
+	}
+	Volumes []struct {
+		Name      string
+		UsageData *struct {
+			Size     int64
+			RefCount int64
+		}
+	}
+	BuildCache []struct {
+		ID          string
+		Description string
+		Size        int64
+		InUse       bool
+		Shared      bool
+		CreatedAt   time.Time
+	}
+}
+
+// DockerDf asks the Docker engine at host for its disk usage. host is
+// unix:///path/to/docker.sock or tcp://host:port (without TLS), empty is
+// DOCKER_HOST or DefaultDockerHost. Podman's Docker compatible socket
+// answers too. Each category keeps its top largest items.
+func DockerDf(ctx context.Context, host string, top int) (*DockerUsage, error) {
+	var df dockerDf
+	if err := dockerRequest(ctx, host, http.MethodGet, "/system/df", &df); err != nil {
+		return nil, err
+	}
+	return df.usage(top), nil
+}
+
+// DockerDanglingImages are the images without a tag that no container
+// uses, what docker image prune removes.
+func DockerDanglingImages(ctx context.Context, host string) ([]DockerItem, error) {
+	var images []struct {
+		ID         string `json:"Id"`
+		Size       int64
+		Created    int64
+		Containers int64
+	}
+	path := "/images/json?filters=" + url.QueryEscape(`{"dangling":["true"]}`)
+	if err := dockerRequest(ctx, host, http.MethodGet, path, &images); err != nil {
+		return nil, err
+	}
+	var items []DockerItem
+	for _, im := range images {
+		// -1 is an engine that didn't count, removing a used one fails anyway
+		if im.Containers > 0 {
+			continue
+		}
+		items = append(items, DockerItem{ID: shortID(im.ID), Name: "<none>", Size: im.Size, Created: unixTime(im.Created)})
+	}
+	return items, nil
+}
+
+// DockerRemoveImage removes the image id, it fails when a container uses
+// it.
+func DockerRemoveImage(ctx context.Context, host, id string) error {
+	return dockerRequest(ctx, host, http.MethodDelete, "/images/"+url.PathEscape(id), nil)
+}
+
+// dockerRequest sends method path to the engine at host, as DockerDf takes
+// it, and decodes its JSON answer into out unless out is nil.
+func dockerRequest(ctx context.Context, host, method, path string, out any) error {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = DefaultDockerHost
+	}
+	client, base, err := dockerClient(host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, base+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("docker engine at %s: %w", host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var msg struct{ Message string }
+		json.NewDecoder(resp.Body).Decode(&msg)
+		return fmt.Errorf("docker engine at %s: %s: %s", host, resp.Status, msg.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("docker engine at %s: %w", host, err)
+	}
+	return nil
+}
+
+// dockerClient returns a client talking to host and the base URL of its
+// requests.
+func dockerClient(host string) (*http.Client, string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, "", fmt.Errorf("docker host %q: %w", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		var d net.Dialer
+		transport := &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", u.Path)
+		}}
+		// the host name is ignored, the socket is dialed
+		return &http.Client{Transport: transport}, "http://docker", nil
+	case "tcp", "http":
+		return http.DefaultClient, "http://" + u.Host, nil
+	}
+	return nil, "", fmt.Errorf("docker host %q: want unix:// or tcp://", host)
+}
+
+// usage totals the answer the way docker system df does.
+func (df *dockerDf) usage(top int) *DockerUsage {
+	var u DockerUsage
+
+	// images share layers, their total is the size of all layers; what
+	// active images use only counts their own layers
+	u.Images.Size = df.LayersSize
+	var used int64
+	for _, im := range df.Images {
+		name := "<none>"
+		if len(im.RepoTags) > 0 && im.RepoTags[0] != "<none>:<none>" {
+			name = im.RepoTags[0]
+		}
+		active := im.Containers > 0
+		if active {
+			u.Images.Active++
+			if im.SharedSize >= 0 {
+				used += im.Size - im.SharedSize
+			}
+		}
+		u.Images.Items = append(u.Images.Items, DockerItem{ID: shortID(im.ID), Name: name, Size: im.Size, Active: active, Created: unixTime(im.Created)})
+	}
+	u.Images.Count = len(df.Images)
+	u.Images.Reclaimable = max(u.Images.Size-used, 0)
+
+	for _, c := range df.Containers {
+		name := c.Image
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		active := c.State == "running"
+		u.Containers.add(DockerItem{ID: shortID(c.ID), Name: name, Size: c.SizeRw, Active: active, Created: unixTime(c.Created)})
+	}
+
+	for _, v := range df.Volumes {
+		// -1 is a volume whose size the engine doesn't know (not local)
+		size, active := int64(0), false
+		if v.UsageData != nil {
+			size, active = max(v.UsageData.Size, 0), v.UsageData.RefCount > 0
+		}
+		u.Volumes.add(DockerItem{ID: v.Name, Size: size, Active: active})
+	}
+
+	for _, b := range df.BuildCache {
+		if b.Shared {
+			// counted with the images
+			continue
+		}
+		u.BuildCache.add(DockerItem{ID: shortID(b.ID), Name: b.Description, Size: b.Size, Active: b.InUse, Created: b.CreatedAt})
+	}
+
+	for _, c := range []*DockerCategory{&u.Images, &u.Containers, &u.Volumes, &u.BuildCache} {
+		sort.SliceStable(c.Items, func(i, j int) bool { return c.Items[i].Size > c.Items[j].Size })
+		if len(c.Items) > top {
+			c.Items = c.Items[:top]
+		}
+	}
+	return &u
+}
+
+// add counts an item whose space is freed by pruning it when it isn't
+// active.
+func (c *DockerCategory) add(it DockerItem) {
+	c.Count++
+	c.Size += it.Size
+	if it.Active {
+		c.Active++
+	} else {
+		c.Reclaimable += it.Size
+	}
+	c.Items = append(c.Items, it)
+}
+
+// shortID is the 12 character ID docker prints, without sha256:.
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+func unixTime(sec int64) time.Time {
+	if sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
@@ -0,0 +1,73 @@
+package diskusage
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+const systemDf = `{
+  "LayersSize": 1000,
+  "Images": [
+    {"Id": "sha256:aaaaaaaaaaaaaaaaaaaa", "RepoTags": ["nginx:1.27"], "Size": 600, "SharedSize": 100, "Containers": 1, "Created": 1700000000},
+    {"Id": "sha256:bbbbbbbbbbbbbbbbbbbb", "RepoTags": ["<none>:<none>"], "Size": 500, "SharedSize": 100, "Containers": 0}
+  ],
+  "Containers": [
+    {"Id": "c1c1c1c1c1c1c1c1", "Names": ["/web"], "Image": "nginx:1.27", "SizeRw": 30, "State": "running"},
+    {"Id": "c2c2c2c2c2c2c2c2", "Names": ["/old"], "Image": "nginx:1.27", "SizeRw": 70, "State": "exited"}
+  ],
+  "Volumes": [
+    {"Name": "pgdata", "UsageData": {"Size": 400, "RefCount": 1}},
+    {"Name": "orphan", "UsageData": {"Size": 50, "RefCount": 0}},
+    {"Name": "remote", "UsageData": {"Size": -1, "RefCount": 0}}
+  ],
+  "BuildCache": [
+    {"ID": "k1", "Description": "RUN make", "Size": 200, "InUse": false, "Shared": false},
+    {"ID": "k2", "Size": 80, "InUse": false, "Shared": true}
+  ]
+}`
+
+func TestDockerDf(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "docker.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/system/df" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(systemDf))
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	u, err := DockerDf(context.Background(), "unix://"+sock, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][4]int64{ // count, active, size, reclaimable
+		"images":      {2, 1, 1000, 500},
+		"containers":  {2, 1, 100, 70},
+		"volumes":     {3, 1, 450, 50},
+		"build cache": {1, 0, 200, 200},
+	}
+	for name, c := range map[string]DockerCategory{"images": u.Images, "containers": u.Containers, "volumes": u.Volumes, "build cache": u.BuildCache} {
+		if got := [4]int64{int64(c.Count), int64(c.Active), c.Size, c.Reclaimable}; got != want[name] {
+			t.Errorf("%s: got %v, want %v", name, got, want[name])
+		}
+		if len(c.Items) != 1 {
+			t.Errorf("%s: %d items, want the largest", name, len(c.Items))
+		}
+	}
+	if im := u.Images.Items[0]; im.ID != "aaaaaaaaaaaa" || im.Name != "nginx:1.27" || !im.Active {
+		t.Errorf("largest image %+v", im)
+	}
+
+	if _, err := DockerDf(context.Background(), "unix://"+filepath.Join(t.TempDir(), "none.sock"), 1); err == nil {
+		t.Error("no engine: got no error")
+	}
+}
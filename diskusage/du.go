@@ -0,0 +1,176 @@
+package diskusage
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Dir is one line of du output.
+type Dir struct {
+	Path string `json:"path"`
+	Size int64  `json:"size_bytes"`
+	// Files is only counted with -count-files. FilesPartial means counting
+	// timed out and Files is a lower bound.
+	Files        int64 `json:"files,omitempty"`
+	FilesPartial bool  `json:"files_partial,omitempty"`
+}
+
+// TopDirs reads `du -h` output from r and returns the n largest directories,
+// largest first. Only n entries are kept in memory while streaming.
+func TopDirs(r io.Reader, n int) ([]Dir, error) {
+	return topDirs(r, n, false, parseHumanSize)
+}
+
+func topDirs(r io.Reader, n int, null bool, parseSize func(string) (int64, error)) ([]Dir, error) {
+	top := make(dirHeap, 0, n+1)
+
+	scanner := newDuScanner(r, null)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+
+		// du separates size and path with a tab, paths may contain spaces
+		size, path, ok := strings.Cut(text, "\t")
+		if !ok {
+			return nil, fmt.Errorf("du line %d: missing tab: %q", line, text)
+		}
+		dirSize, err := parseSize(size)
+		if err != nil {
+			return nil, fmt.Errorf("du line %d: %w", line, err)
+		}
+
+		if len(top) < n {
+			heap.Push(&top, Dir{Path: path, Size: dirSize})
+		} else if n > 0 && dirSize > top[0].Size {
+			top[0] = Dir{Path: path, Size: dirSize}
+			heap.Fix(&top, 0)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	dirs := []Dir(top)
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Size > dirs[j].Size })
+	return dirs, nil
+}
+
+// DuResult is what one du scan found. SkippedPaths are the directories du
+// was not allowed to read, their sizes are missing from the totals.
+type DuResult struct {
+	Dirs         []Dir
+	SkippedPaths []string
+}
+
+// Du runs du -h on path and returns its n largest directories, with exact
+// du -k instead, sizes exact to the KiB. When ctx ends first the directories
+// seen so far are returned with the error.
+func Du(ctx context.Context, path string, n int, exact bool, prio Priority) (DuResult, error) {
+	null := duNull()
+	unit, parseSize := "-h", parseHumanSize
+	if exact {
+		unit, parseSize = "-k", parseKiB
+	}
+	name, args := prio.wrap("du", duArgs(null, unit, path))
+	out, stderr, err := runCommand(ctx, name, args...)
+	if err != nil && len(out) == 0 {
+		return DuResult{}, fmt.Errorf("du: %w: %s", err, strings.TrimSpace(string(stderr)))
+	}
+
+	var res DuResult
+	res.SkippedPaths = permissionDenied(stderr)
+	if len(res.SkippedPaths) > 0 {
+		slog.Warn(fmt.Sprintf("du skipped %d directories due to permissions, totals are too low", len(res.SkippedPaths)))
+	}
+
+	var perr error
+	res.Dirs, perr = topDirs(bytes.NewReader(out), n, null, parseSize)
+	if ctx.Err() != nil {
+		// what du printed before it was killed is still worth showing
+		return res, fmt.Errorf("du: %w", ctx.Err())
+	}
+	return res, perr
+}
+
+// parseKiB parses a du -k size.
+func parseKiB(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n << 10, err
+}
+
+// deniedLine matches du's permission errors, GNU
+// "du: cannot read directory '/x': Permission denied" and BSD
+// "du: /x: Permission denied".
+var deniedLine = regexp.MustCompile(`^du: (?:cannot (?:read|open) directory |cannot access )?['‘]?(.*?)['’]?: Permission denied$`)
+
+// permissionDenied returns the paths du reported as not readable.
+func permissionDenied(stderr []byte) []string {
+	var paths []string
+	for _, line := range strings.Split(string(stderr), "\n") {
+		if m := deniedLine.FindStringSubmatch(line); m != nil {
+			paths = append(paths, m[1])
+		}
+	}
+	return paths
+}
+
+// duNull reports whether du supports -0 (GNU), which ends entries with a
+// NUL instead of a newline so paths containing newlines parse correctly.
+var duNull = sync.OnceValue(func() bool {
+	_, _, err := runCommand(context.Background(), "du", "-0", "-s", os.DevNull)
+	return err == nil
+})
+
+// duArgs adds -0 to args when null is set.
+func duArgs(null bool, args ...string) []string {
+	if null {
+		return append([]string{"-0"}, args...)
+	}
+	return args
+}
+
+// newDuScanner splits du output into entries, on NUL with du -0 and on
+// newlines otherwise.
+func newDuScanner(r io.Reader, null bool) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	if null {
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			if i := bytes.IndexByte(data, 0); i >= 0 {
+				return i + 1, data[:i], nil
+			}
+			if atEOF && len(data) > 0 {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		})
+	}
+	return scanner
+}
+
+// dirHeap is a min heap on size so the smallest of the current top n is
+// the one replaced.
+type dirHeap []Dir
+
+func (h dirHeap) Len() int           { return len(h) }
+func (h dirHeap) Less(i, j int) bool { return h[i].Size < h[j].Size }
+func (h dirHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *dirHeap) Push(x any)        { *h = append(*h, x.(Dir)) }
+func (h *dirHeap) Pop() any {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
@@ -0,0 +1,100 @@
+package diskusage
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTopDirs(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		n       int
+		null    bool
+		want    []Dir
+		wantErr bool
+	}{
+		{
+			name: "largest first",
+			out:  "4.0K\t/a\n2.0M\t/b\n1.0G\t/c\n12K\t/d\n",
+			n:    2,
+			want: []Dir{{Path: "/c", Size: 1 << 30}, {Path: "/b", Size: 2 << 20}},
+		},
+		{
+			name: "fewer dirs than n",
+			out:  "4.0K\t/a\n",
+			n:    10,
+			want: []Dir{{Path: "/a", Size: 4 << 10}},
+		},
+		{
+			name: "path with spaces",
+			out:  "8.0K\t/my dir/sub dir\n",
+			n:    1,
+			want: []Dir{{Path: "/my dir/sub dir", Size: 8 << 10}},
+		},
+		{
+			name: "du -0 path with newline",
+			out:  "8.0K\t/odd\nname\x004.0K\t/a\x00",
+			n:    1,
+			null: true,
+			want: []Dir{{Path: "/odd\nname", Size: 8 << 10}},
+		},
+		{
+			name: "zero keeps nothing",
+			out:  "4.0K\t/a\n",
+			n:    0,
+			want: []Dir{},
+		},
+		{
+			name:    "missing tab",
+			out:     "4.0K /a\n",
+			n:       1,
+			wantErr: true,
+		},
+		{
+			name:    "bad size",
+			out:     "big\t/a\n",
+			n:       1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := topDirs(strings.NewReader(tt.out), tt.n, tt.null, parseHumanSize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermissionDenied(t *testing.T) {
+	stderr := "du: cannot read directory '/root': Permission denied\n" +
+		"du: /private/var/db: Permission denied\n" +
+		"du: cannot access '/gone': No such file or directory\n"
+	got := permissionDenied([]byte(stderr))
+	if fmt.Sprint(got) != "[/root /private/var/db]" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func BenchmarkTopDirs(b *testing.B) {
+	var out bytes.Buffer
+	for i := range 100000 {
+		fmt.Fprintf(&out, "%dK\t/var/lib/data/%d/%d\n", i*37%100000, i/100, i)
+	}
+	b.SetBytes(int64(out.Len()))
+	for b.Loop() {
+		if _, err := TopDirs(bytes.NewReader(out.Bytes()), 20); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package diskusage
+
+import (
+	"context"
+	"io"
+
+	"ved/test/runner"
+)
+
+// LocaleEnv is added to the environment of every command so df and du print
+// sizes, dates and headers the same way on every host. A comma decimal
+// separator would otherwise break the size parsing.
+var LocaleEnv = []string{"LC_ALL=C", "LANG=C"}
+
+// WithEnv returns a context that makes every command run with it also get
+// env (KEY=VALUE), after LocaleEnv so it can override the locale.
+func WithEnv(ctx context.Context, env []string) context.Context {
+	return runner.WithEnv(ctx, env...)
+}
+
+// WithStderr returns a context that makes every command run with it also
+// copy its stderr to w, even when the command succeeds. Commands can run
+// concurrently, w must be safe for that.
+func WithStderr(ctx context.Context, w io.Writer) context.Context {
+	return runner.WithStderr(ctx, w)
+}
+
+// runCommand runs name with args and returns stdout and stderr separately.
+// It uses the runner.WithRunner of ctx, the real commands with LocaleEnv
+// without one. The command is killed when ctx is done.
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	r, ok := runner.FromContext(ctx)
+	if !ok {
+		r = runner.Exec{Env: LocaleEnv}
+	}
+	res, err := r.Run(ctx, name, args...)
+	return res.Stdout, res.Stderr, err
+}
@@ -0,0 +1,285 @@
+package diskusage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An alert rule expression compares filesystem fields with numbers and
+// strings and combines the comparisons:
+//
+//	use_percent > 85 || avail_bytes < 20G || fs_type == "nfs" && !(free_percent >= 10)
+//
+// Numbers can have a size suffix (K, M, G, T, P, E, powers of 1024), strings
+// are double quoted. && binds tighter than ||, "and", "or" and "not" can be
+// used instead of &&, || and !.
+
+// exprFields are the fields an expression can use.
+var exprFields = map[string]func(fs Filesystem) any{
+	"use_percent":  func(fs Filesystem) any { return float64(fs.UsePercent) },
+	"used_percent": func(fs Filesystem) any { return fs.Percent() },
+	"free_percent": func(fs Filesystem) any {
+		if fs.FreePercent != nil {
+			return *fs.FreePercent
+		}
+		return 100 - fs.Percent()
+	},
+	"size_bytes":  func(fs Filesystem) any { return float64(fs.Size) },
+	"used_bytes":  func(fs Filesystem) any { return float64(fs.Used) },
+	"avail_bytes": func(fs Filesystem) any { return float64(fs.Avail) },
+	"fs_type":     func(fs Filesystem) any { return fs.FSType },
+	"class":       func(fs Filesystem) any { return fs.Class },
+	"source":      func(fs Filesystem) any { return fs.Source },
+	"mount_point": func(fs Filesystem) any { return fs.MountPoint },
+	"host":        func(fs Filesystem) any { return fs.Host },
+	// the inode fields are -1 for filesystems without an inode count
+	"inode_percent": func(fs Filesystem) any { return fs.InodePercent() },
+	"inodes_free": func(fs Filesystem) any {
+		if fs.Inodes == nil {
+			return float64(Unknown)
+		}
+		return float64(fs.Inodes.Free)
+	},
+	"read_only": func(fs Filesystem) any {
+		if fs.ReadOnly {
+			return 1.0
+		}
+		return 0.0
+	},
+	// -1 where the filesystem doesn't count errors
+	"fs_errors": func(fs Filesystem) any {
+		if fs.Errors == nil {
+			return float64(Unknown)
+		}
+		return float64(*fs.Errors)
+	},
+}
+
+// expr is a compiled expression, it returns a bool for conditions and a
+// float64 or string for operands.
+type expr func(fs Filesystem) any
+
+// compileExpr parses s, checking field names and operand types so a rule
+// can't fail later, at evaluation.
+func compileExpr(s string) (expr, error) {
+	toks, err := lexExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	e, kind, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	if kind != kindBool {
+		return nil, errors.New("not a condition")
+	}
+	return e, nil
+}
+
+type exprKind int
+
+const (
+	kindBool exprKind = iota
+	kindNumber
+	kindString
+)
+
+func lexExpr(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			toks = append(toks, s[i:i+1])
+			i++
+		case strings.ContainsRune("<>=!&|", rune(c)):
+			j := i + 1
+			if j < len(s) && strings.ContainsRune("=&|", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		case c == '"':
+			j := strings.IndexByte(s[i+1:], '"')
+			if j < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			toks = append(toks, s[i:i+j+2])
+			i += j + 2
+		case c == '.' || c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i
+			for j < len(s) && (s[j] == '.' || s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return toks, nil
+}
+
+type exprParser struct {
+	toks []string
+	pos  int
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) accept(toks ...string) bool {
+	for _, t := range toks {
+		if p.peek() == t {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) or() (expr, exprKind, error) {
+	return p.binary(p.and, "||", "or")
+}
+
+func (p *exprParser) and() (expr, exprKind, error) {
+	return p.binary(p.not, "&&", "and")
+}
+
+// binary parses next (op next)*, every operand must be a condition.
+func (p *exprParser) binary(next func() (expr, exprKind, error), op, word string) (expr, exprKind, error) {
+	left, kind, err := next()
+	if err != nil {
+		return nil, 0, err
+	}
+	for p.accept(op, word) {
+		right, rkind, err := next()
+		if err != nil {
+			return nil, 0, err
+		}
+		if kind != kindBool || rkind != kindBool {
+			return nil, 0, fmt.Errorf("%s needs conditions on both sides", op)
+		}
+		l := left
+		if op == "||" {
+			left = func(fs Filesystem) any { return l(fs).(bool) || right(fs).(bool) }
+		} else {
+			left = func(fs Filesystem) any { return l(fs).(bool) && right(fs).(bool) }
+		}
+	}
+	return left, kind, nil
+}
+
+func (p *exprParser) not() (expr, exprKind, error) {
+	if p.accept("!", "not") {
+		e, kind, err := p.not()
+		if err != nil {
+			return nil, 0, err
+		}
+		if kind != kindBool {
+			return nil, 0, errors.New("! needs a condition")
+		}
+		return func(fs Filesystem) any { return !e(fs).(bool) }, kindBool, nil
+	}
+	return p.compare()
+}
+
+func (p *exprParser) compare() (expr, exprKind, error) {
+	left, kind, err := p.operand()
+	if err != nil {
+		return nil, 0, err
+	}
+	op := p.peek()
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		p.pos++
+	default:
+		return left, kind, nil
+	}
+
+	right, rkind, err := p.operand()
+	if err != nil {
+		return nil, 0, err
+	}
+	if kind != rkind || kind == kindBool {
+		return nil, 0, fmt.Errorf("%s needs two numbers or two strings", op)
+	}
+	if kind == kindString && op != "==" && op != "!=" {
+		return nil, 0, fmt.Errorf("strings can only be compared with == and !=")
+	}
+
+	return func(fs Filesystem) any {
+		l, r := left(fs), right(fs)
+		if kind == kindString {
+			return (l == r) == (op == "==")
+		}
+		a, b := l.(float64), r.(float64)
+		switch op {
+		case "<":
+			return a < b
+		case "<=":
+			return a <= b
+		case ">":
+			return a > b
+		case ">=":
+			return a >= b
+		case "==":
+			return a == b
+		default:
+			return a != b
+		}
+	}, kindBool, nil
+}
+
+func (p *exprParser) operand() (expr, exprKind, error) {
+	tok := p.peek()
+	p.pos++
+	switch {
+	case tok == "":
+		return nil, 0, errors.New("unexpected end")
+	case tok == "(":
+		e, kind, err := p.or()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !p.accept(")") {
+			return nil, 0, errors.New("missing )")
+		}
+		return e, kind, nil
+	case tok[0] == '"':
+		s := tok[1 : len(tok)-1]
+		return func(Filesystem) any { return s }, kindString, nil
+	case tok[0] == '.' || unicode.IsDigit(rune(tok[0])):
+		n, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			size, serr := parseHumanSize(tok)
+			if serr != nil {
+				return nil, 0, fmt.Errorf("bad number %q", tok)
+			}
+			n = float64(size)
+		}
+		return func(Filesystem) any { return n }, kindNumber, nil
+	}
+
+	field, ok := exprFields[tok]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown field %q", tok)
+	}
+	if _, ok := field(Filesystem{}).(string); ok {
+		return expr(field), kindString, nil
+	}
+	return expr(field), kindNumber, nil
+}
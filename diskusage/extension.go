@@ -0,0 +1,142 @@
+package diskusage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ved/test/runner"
+)
+
+// NoExtension is the Ext of files without one, dotfiles like .bashrc
+// included.
+const NoExtension = "(none)"
+
+// ExtensionUsage is the space taken by all files with one extension.
+type ExtensionUsage struct {
+	Ext   string `json:"ext"`
+	Size  int64  `json:"size_bytes"`
+	Files int64  `json:"files"`
+}
+
+// ByExtension reads `du -a -k` output from r and totals file sizes per
+// extension, largest first. Only the totals are kept while streaming.
+//
+// du -a lists directories too, after everything in them, so an entry is
+// taken as a directory when the entry before it is inside it. Empty
+// directories can't be told apart that way and count as files without
+// extension, they are small.
+func ByExtension(r io.Reader) ([]ExtensionUsage, error) {
+	return byExtension(r, false)
+}
+
+func byExtension(r io.Reader, null bool) ([]ExtensionUsage, error) {
+	totals := map[string]*ExtensionUsage{}
+	prev := ""
+
+	scanner := newDuScanner(r, null)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+
+		size, path, ok := strings.Cut(text, "\t")
+		if !ok {
+			return nil, fmt.Errorf("du line %d: missing tab: %q", line, text)
+		}
+		kb, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("du line %d: %w", line, err)
+		}
+
+		isDir := strings.HasPrefix(prev, strings.TrimSuffix(path, "/")+"/")
+		prev = path
+		if isDir {
+			continue
+		}
+
+		ext := extension(path)
+		t, ok := totals[ext]
+		if !ok {
+			t = &ExtensionUsage{Ext: ext}
+			totals[ext] = t
+		}
+		t.Size += kb * 1024
+		t.Files++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	usage := make([]ExtensionUsage, 0, len(totals))
+	for _, t := range totals {
+		usage = append(usage, *t)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Size != usage[j].Size {
+			return usage[i].Size > usage[j].Size
+		}
+		return usage[i].Ext < usage[j].Ext
+	})
+	return usage, nil
+}
+
+// extension is the lower-cased last extension of path's file name,
+// archive.tar.gz is .gz. A leading dot doesn't start an extension.
+func extension(path string) string {
+	base := strings.TrimPrefix(filepath.Base(path), ".")
+	ext := strings.ToLower(filepath.Ext(base))
+	if ext == "" || ext == "." {
+		return NoExtension
+	}
+	return ext
+}
+
+// DuByExtension runs du -a on path and totals its files per extension,
+// reading du's output as it comes instead of holding the file list.
+func DuByExtension(ctx context.Context, path string, prio Priority) ([]ExtensionUsage, error) {
+	null := duNull()
+	name, args := prio.wrap("du", duArgs(null, "-a", "-k", path))
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	// read as it comes, so not through a runner.Runner
+	cmd.Env = append(append(os.Environ(), LocaleEnv...), runner.Env(ctx)...)
+	cmd.Stderr = runner.Stderr(ctx, &stderr)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		runner.Log(cmd, start, err)
+		return nil, fmt.Errorf("du: %w", err)
+	}
+
+	usage, perr := byExtension(stdout, null)
+	if perr != nil {
+		// stop du, nobody reads its output anymore
+		cmd.Process.Kill()
+	}
+	werr := cmd.Wait()
+	runner.Log(cmd, start, werr)
+
+	switch {
+	case perr != nil:
+		return nil, perr
+	case ctx.Err() != nil:
+		return usage, fmt.Errorf("du: %w", ctx.Err())
+	case werr != nil && len(usage) == 0:
+		return nil, fmt.Errorf("du: %w: %s", werr, strings.TrimSpace(stderr.String()))
+	}
+	return usage, nil
+}
@@ -0,0 +1,64 @@
+package diskusage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestByExtension(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		null    bool
+		want    []ExtensionUsage
+		wantErr bool
+	}{
+		{
+			name: "directories left out",
+			out:  "4\t/d/a.log\n8\t/d/sub/b.LOG\n8\t/d/sub\n2\t/d/c.tar.gz\n1\t/d/.bashrc\n1\t/d/README\n24\t/d\n",
+			want: []ExtensionUsage{
+				{Ext: ".log", Size: 12 << 10, Files: 2},
+				{Ext: NoExtension, Size: 2 << 10, Files: 2},
+				{Ext: ".gz", Size: 2 << 10, Files: 1},
+			},
+		},
+		{
+			name: "same size by extension",
+			out:  "1\t/b.txt\n1\t/a.md\n",
+			want: []ExtensionUsage{{Ext: ".md", Size: 1 << 10, Files: 1}, {Ext: ".txt", Size: 1 << 10, Files: 1}},
+		},
+		{
+			name: "du -0 path with newline",
+			out:  "4\t/d/odd\nname.log\x004\t/d\x00",
+			null: true,
+			want: []ExtensionUsage{{Ext: ".log", Size: 4 << 10, Files: 1}},
+		},
+		{
+			name: "nothing",
+			out:  "",
+			want: []ExtensionUsage{},
+		},
+		{
+			name:    "missing tab",
+			out:     "4 /a.log\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad size",
+			out:     "4K\t/a.log\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := byExtension(strings.NewReader(tt.out), tt.null)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package diskusage
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// BaselineDirs is how many of the largest directories a baseline keeps,
+// more than are reported so a directory that grew into the top still has a
+// starting size.
+const BaselineDirs = 1000
+
+// Baseline is a du snapshot taken once per boot.
+type Baseline struct {
+	Path     string    `json:"path"`
+	BootTime time.Time `json:"boot_time"`
+	Taken    time.Time `json:"taken"`
+	Dirs     []Dir     `json:"dirs"`
+}
+
+// SinceBoot is how much directories grew since the baseline of this boot.
+type SinceBoot struct {
+	BootTime     time.Time   `json:"boot_time"`
+	BaselineTime time.Time   `json:"baseline_time"`
+	Dirs         []DirGrowth `json:"dirs"`
+}
+
+// DirGrowth is one directory's growth. New means it was not in the
+// baseline, BaselineSize is 0 then.
+type DirGrowth struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size_bytes"`
+	BaselineSize int64  `json:"baseline_size_bytes"`
+	Grown        int64  `json:"grown_bytes"`
+	New          bool   `json:"new,omitempty"`
+}
+
+// LoadOrCreateBaseline returns the baseline for duPath taken during the
+// boot at boot, kept in stateDir. Without one, dirs become the baseline and
+// created is true. Baselines of earlier boots are removed, a reboot starts
+// over.
+func LoadOrCreateBaseline(stateDir, duPath string, boot time.Time, dirs []Dir) (b *Baseline, created bool, err error) {
+	h := fnv.New64a()
+	h.Write([]byte(duPath))
+	key := fmt.Sprintf("%x", h.Sum64())
+	path := filepath.Join(stateDir, fmt.Sprintf("since-boot-%s-%d.json", key, boot.Unix()))
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		b = &Baseline{}
+		if err := json.Unmarshal(data, b); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		return b, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	old, _ := filepath.Glob(filepath.Join(stateDir, fmt.Sprintf("since-boot-%s-*.json", key)))
+	for _, f := range old {
+		os.Remove(f)
+	}
+
+	b = &Baseline{Path: duPath, BootTime: boot, Taken: time.Now(), Dirs: dirs}
+	if data, err = json.Marshal(b); err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return nil, false, err
+	}
+	return b, true, os.WriteFile(path, data, 0o644)
+}
+
+// Growth compares dirs with the baseline and returns the n that grew most.
+func (b *Baseline) Growth(dirs []Dir, n int) *SinceBoot {
+	base := make(map[string]int64, len(b.Dirs))
+	for _, d := range b.Dirs {
+		base[d.Path] = d.Size
+	}
+
+	var growth []DirGrowth
+	for _, d := range dirs {
+		size, ok := base[d.Path]
+		g := DirGrowth{Path: d.Path, Size: d.Size, BaselineSize: size, Grown: d.Size - size, New: !ok}
+		if g.Grown > 0 {
+			growth = append(growth, g)
+		}
+	}
+	sort.Slice(growth, func(i, j int) bool { return growth[i].Grown > growth[j].Grown })
+	if len(growth) > n {
+		growth = growth[:n]
+	}
+
+	return &SinceBoot{BootTime: b.BootTime, BaselineTime: b.Taken, Dirs: growth}
+}
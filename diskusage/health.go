@@ -0,0 +1,124 @@
+package diskusage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InodeUsage is how many of a filesystem's inodes are used. Filesystems
+// that allocate inodes as needed (btrfs, ZFS, most network filesystems)
+// report none and have no InodeUsage.
+type InodeUsage struct {
+	Total      int64   `json:"total"`
+	Used       int64   `json:"used"`
+	Free       int64   `json:"free"`
+	UsePercent float64 `json:"use_percent"`
+}
+
+func newInodeUsage(total, free int64) *InodeUsage {
+	if total <= 0 {
+		return nil
+	}
+	free = min(max(free, 0), total)
+	used := total - free
+	return &InodeUsage{Total: total, Used: used, Free: free, UsePercent: float64(used) / float64(total) * 100}
+}
+
+// InodePercent is the used percent of the inodes, Unknown when the
+// filesystem has no inode count.
+func (fs Filesystem) InodePercent() float64 {
+	if fs.Inodes == nil {
+		return Unknown
+	}
+	return fs.Inodes.UsePercent
+}
+
+// ParseDfInodes parses the output of `df -iP` (GNU and busybox), inode
+// usage by mount point. Filesystems without an inode count are left out.
+func ParseDfInodes(out []byte) (map[string]*InodeUsage, error) {
+	rows, err := parseDf(out, func(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) })
+	if err != nil {
+		return nil, err
+	}
+	inodes := make(map[string]*InodeUsage, len(rows))
+	for _, r := range rows {
+		if u := newInodeUsage(r.Size, r.Avail); u != nil && r.Status == StatusOK {
+			inodes[r.MountPoint] = u
+		}
+	}
+	return inodes, nil
+}
+
+// addDfInodes runs df -iP on the mounts of filesystems that have stats,
+// stale ones would hang it, and fills in their inode usage. It gives up
+// after timeout, when it is not 0.
+func addDfInodes(ctx context.Context, filesystems []Filesystem, timeout time.Duration) error {
+	args := []string{"-iP"}
+	for _, fs := range filesystems {
+		if fs.HasStats() {
+			args = append(args, fs.MountPoint)
+		}
+	}
+	if len(args) == 1 {
+		return nil
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	out, stderr, err := runCommand(ctx, "df", args...)
+	if err != nil && len(out) == 0 {
+		return fmt.Errorf("df -i: %w: %s", err, strings.TrimSpace(string(stderr)))
+	}
+	inodes, err := ParseDfInodes(out)
+	if err != nil {
+		return err
+	}
+	for i := range filesystems {
+		if u, ok := inodes[filesystems[i].MountPoint]; ok {
+			filesystems[i].Inodes = u
+		}
+	}
+	return nil
+}
+
+// readOnlyOption tells whether mount options include ro.
+func readOnlyOption(options []string) bool {
+	return slices.Contains(options, "ro")
+}
+
+// sysFS is where Linux filesystems export their counters.
+var sysFS = "/sys/fs"
+
+// AnnotateErrors sets Errors of the ext2/3/4 filesystems of this machine to
+// the number of errors the kernel counted since they were created, from
+// /sys/fs/ext4/<device>/errors_count. Anything above 0 means fsck has
+// work to do; with errors=remount-ro the filesystem is read-only by now.
+// Other filesystems and machines are left alone.
+func AnnotateErrors(filesystems []Filesystem) {
+	for i := range filesystems {
+		fs := &filesystems[i]
+		if fs.Host != "" || !strings.HasPrefix(fs.FSType, "ext") || !strings.HasPrefix(fs.Source, "/dev/") {
+			continue
+		}
+		dev := fs.Source
+		// /dev/mapper/vg-root is a link to /dev/dm-0, which /sys/fs knows
+		if resolved, err := filepath.EvalSymlinks(dev); err == nil {
+			dev = resolved
+		}
+		data, err := os.ReadFile(filepath.Join(sysFS, "ext4", filepath.Base(dev), "errors_count"))
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			fs.Errors = &n
+		}
+	}
+}
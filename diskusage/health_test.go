@@ -0,0 +1,71 @@
+package diskusage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDfInodes(t *testing.T) {
+	out := `Filesystem      Inodes   IUsed   IFree IUse% Mounted on
+/dev/sda1      6553600 6225920  327680   95% /
+tmpfs          1019325       1 1019324    1% /dev/shm
+/dev/sdb1            0       0       0     - /srv/my data
+`
+	inodes, err := ParseDfInodes([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inodes) != 2 {
+		t.Fatalf("got %d mounts, want 2 (btrfs has no inode count): %v", len(inodes), inodes)
+	}
+	if root := inodes["/"]; root.Total != 6553600 || root.Used != 6225920 || root.Free != 327680 || root.UsePercent != 95 {
+		t.Errorf("/: %+v", root)
+	}
+}
+
+func TestAnnotateReadOnly(t *testing.T) {
+	mounts, err := parseMounts(strings.NewReader("/dev/sda1 / ext4 rw,relatime 0 0\n/dev/sdb1 /data ext4 ro,relatime 0 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filesystems := []Filesystem{{Source: "/dev/sda1", MountPoint: "/"}, {Source: "/dev/sdb1", MountPoint: "/data"}}
+	annotate(filesystems, mounts)
+	if filesystems[0].ReadOnly || !filesystems[1].ReadOnly {
+		t.Errorf("read only: / %v, /data %v", filesystems[0].ReadOnly, filesystems[1].ReadOnly)
+	}
+}
+
+func TestAnnotateErrors(t *testing.T) {
+	sysFS = t.TempDir()
+	defer func() { sysFS = "/sys/fs" }()
+	os.MkdirAll(filepath.Join(sysFS, "ext4", "sdz1"), 0o755)
+	os.WriteFile(filepath.Join(sysFS, "ext4", "sdz1", "errors_count"), []byte("3\n"), 0o644)
+
+	filesystems := []Filesystem{
+		{Source: "/dev/sdz1", FSType: "ext4", MountPoint: "/"},
+		{Source: "/dev/sdz1", FSType: "ext4", MountPoint: "/", Host: "web1"},
+		{Source: "/dev/sdz2", FSType: "xfs", MountPoint: "/data"},
+	}
+	AnnotateErrors(filesystems)
+	if e := filesystems[0].Errors; e == nil || *e != 3 {
+		t.Errorf("/dev/sdz1 errors %v, want 3", e)
+	}
+	if filesystems[1].Errors != nil || filesystems[2].Errors != nil {
+		t.Error("errors set for another host or filesystem")
+	}
+}
+
+func TestInodeRule(t *testing.T) {
+	e, err := compileExpr("inode_percent > 90 || read_only == 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := Filesystem{Inodes: newInodeUsage(100, 5)}
+	none := Filesystem{}
+	ro := Filesystem{ReadOnly: true}
+	if e(full) != true || e(none) != false || e(ro) != true {
+		t.Errorf("full %v, no inodes %v, read only %v", e(full), e(none), e(ro))
+	}
+}
@@ -0,0 +1,148 @@
+package diskusage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// HistorySample is one mount's usage at one poll, a line of a history file.
+// Mount is the Filesystem's Name, host:mount for other machines.
+type HistorySample struct {
+	Time  time.Time `json:"time"`
+	Mount string    `json:"mount"`
+	Size  int64     `json:"size_bytes"`
+	Used  int64     `json:"used_bytes"`
+	Avail int64     `json:"avail_bytes"`
+}
+
+// AppendHistory appends the filesystems of r that have stats to the history
+// file at path, one JSON line each, all of them in one write.
+func AppendHistory(path string, r Report) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, fs := range r.Filesystems {
+		if !fs.HasStats() {
+			continue
+		}
+		s := HistorySample{Time: r.Time.UTC(), Mount: fs.Name(), Size: fs.Size, Used: fs.Used, Avail: fs.Avail}
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadHistory returns the samples of mount, of every mount when it is
+// empty, taken at or after since, oldest first. A last line cut short by a
+// crash while appending is ignored.
+func ReadHistory(path, mount string, since time.Time) ([]HistorySample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []HistorySample
+	sc := bufio.NewScanner(f)
+	var bad error
+	for line := 1; sc.Scan(); line++ {
+		if bad != nil {
+			return nil, bad
+		}
+		var s HistorySample
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			bad = fmt.Errorf("%s:%d: %w", path, line, err)
+			continue
+		}
+		if (mount == "" || s.Mount == mount) && !s.Time.Before(since) {
+			samples = append(samples, s)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples, nil
+}
+
+// Trend is how a mount's usage changed over its samples.
+type Trend struct {
+	Mount   string    `json:"mount"`
+	Samples int       `json:"samples"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	// Last is the newest sample.
+	Last HistorySample `json:"last"`
+	// GrowthPerDay is the least squares slope of used bytes over time.
+	GrowthPerDay float64 `json:"growth_bytes_per_day"`
+	// FullAt is when the mount runs out of space growing at that rate
+	// from Last, nil when it doesn't grow.
+	FullAt *time.Time `json:"full_at,omitempty"`
+}
+
+// MinTrendSpan is how far apart a mount's first and last sample must be
+// for a growth rate, seconds of churn say nothing about days.
+const MinTrendSpan = time.Hour
+
+// Trends computes the trend of every mount in samples, sorted by mount.
+func Trends(samples []HistorySample) []Trend {
+	byMount := make(map[string][]HistorySample)
+	for _, s := range samples {
+		byMount[s.Mount] = append(byMount[s.Mount], s)
+	}
+
+	trends := make([]Trend, 0, len(byMount))
+	for mount, ss := range byMount {
+		t := Trend{Mount: mount, Samples: len(ss), From: ss[0].Time, To: ss[len(ss)-1].Time, Last: ss[len(ss)-1]}
+		if t.To.Sub(t.From) >= MinTrendSpan {
+			t.GrowthPerDay = growthPerDay(ss)
+		}
+		if t.GrowthPerDay > 0 {
+			full := t.Last.Time.Add(time.Duration(float64(t.Last.Avail) / t.GrowthPerDay * float64(24*time.Hour)))
+			t.FullAt = &full
+		}
+		trends = append(trends, t)
+	}
+	sort.Slice(trends, func(i, j int) bool { return trends[i].Mount < trends[j].Mount })
+	return trends
+}
+
+// growthPerDay fits used bytes against days since the first sample.
+func growthPerDay(ss []HistorySample) float64 {
+	n := float64(len(ss))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range ss {
+		x := s.Time.Sub(ss[0].Time).Hours() / 24
+		y := float64(s.Used)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	den := n*sumXX - sumX*sumX
+	if den == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / den
+}
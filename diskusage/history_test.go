@@ -0,0 +1,66 @@
+package diskusage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "history.jsonl")
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for day := range 4 {
+		r := Report{Time: start.Add(time.Duration(day) * 24 * time.Hour), Filesystems: []Filesystem{
+			{MountPoint: "/", Size: 100 << 30, Used: int64(40+day) << 30, Avail: int64(60-day) << 30, Status: StatusOK},
+			{MountPoint: "/data", Size: 10 << 30, Used: 5 << 30, Avail: 5 << 30, Status: StatusOK},
+			{MountPoint: "/mnt/nfs", Status: StatusStale},
+		}}
+		if err := AppendHistory(path, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a crash halfway through an append
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2026-03-05T00:00:00Z","mou`)
+	f.Close()
+
+	samples, err := ReadHistory(path, "/", start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 || samples[0].Used != 41<<30 {
+		t.Fatalf("samples %+v", samples)
+	}
+
+	all, err := ReadHistory(path, "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trends := Trends(all)
+	if len(trends) != 2 || trends[0].Mount != "/" || trends[1].Mount != "/data" {
+		t.Fatalf("trends %+v", trends)
+	}
+	root := trends[0]
+	if root.Samples != 4 || root.GrowthPerDay != 1<<30 {
+		t.Errorf("/ trend %+v", root)
+	}
+	// 57G left at 1G a day
+	if want := start.Add((3 + 57) * 24 * time.Hour); root.FullAt == nil || !root.FullAt.Equal(want) {
+		t.Errorf("/ full at %v, want %v", root.FullAt, want)
+	}
+	if data := trends[1]; data.GrowthPerDay != 0 || data.FullAt != nil {
+		t.Errorf("/data trend %+v", data)
+	}
+}
+
+func TestReadHistoryCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	os.WriteFile(path, []byte("garbage\n{\"mount\":\"/\"}\n"), 0o644)
+	if _, err := ReadHistory(path, "", time.Time{}); err == nil {
+		t.Error("corrupt line in the middle: got no error")
+	}
+}
@@ -0,0 +1,360 @@
+package diskusage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mount points of the filesystems KubeletCollector reports for a node, the
+// kubelet doesn't say where they are mounted.
+const (
+	KubeNodeFS  = "nodefs"
+	KubeImageFS = "imagefs"
+)
+
+// KubeletCollector reports the disks of a Kubernetes cluster: for every
+// node the kubelet's /stats/summary, through the API server's node proxy,
+// gives the node's root filesystem (nodefs), the container runtime's image
+// filesystem (imagefs) and the usage of every PersistentVolumeClaim a pod
+// on it has mounted. It runs kubectl, so the kubeconfig, its contexts and
+// credential plugins work as they do for kubectl, and nothing has to be
+// deployed in the cluster. The user needs get on nodes/proxy and list on
+// nodes and persistentvolumeclaims.
+//
+// Filesystems have Host set to the node, a claim's mount point is
+// pvc/<namespace>/<name>. Claims that are bound but not mounted by any pod
+// have no usage, they are reported as unavailable with their capacity as
+// Size, when every node answered. Like SSHCollector, a node whose kubelet
+// doesn't answer is a single unreachable Filesystem and Collect only fails
+// when no node answered.
+type KubeletCollector struct {
+	// Nodes limits the report to these nodes, all nodes when empty.
+	Nodes []string
+	// Kubeconfig and Context select the cluster as kubectl's --kubeconfig
+	// and --context do, kubectl's defaults when empty.
+	Kubeconfig string
+	Context    string
+	Timeout    time.Duration
+	Parallel   int
+	// Kubectl is the kubectl command, "kubectl" when empty.
+	Kubectl string
+}
+
+func (c KubeletCollector) Name() string { return "kubelet" }
+
+func (c KubeletCollector) Collect(ctx context.Context) ([]Filesystem, error) {
+	nodes := c.Nodes
+	if len(nodes) == 0 {
+		var err error
+		if nodes, err = c.listNodes(ctx); err != nil {
+			return nil, err
+		}
+		if len(nodes) == 0 {
+			return nil, errors.New("the cluster has no nodes")
+		}
+	}
+	claims, err := c.listClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parallel := c.Parallel
+	if parallel <= 0 {
+		parallel = mountConcurrency
+	}
+	sem := make(chan struct{}, parallel)
+	results := make([]*kubeSummary, len(nodes))
+	errs := make([]error, len(nodes))
+
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			results[i], errs[i] = c.summary(ctx, node)
+		}()
+	}
+	wg.Wait()
+
+	var filesystems []Filesystem
+	// a claim mounted by several pods, or on several nodes, is reported once
+	seen := map[string]bool{}
+	failed := 0
+	for i, node := range nodes {
+		if errs[i] != nil {
+			failed++
+			filesystems = append(filesystems, Filesystem{
+				Host: node, Source: errs[i].Error(), Class: ClassNetwork, Status: StatusUnreachable,
+				Size: Unknown, Used: Unknown, Avail: Unknown, UsePercent: Unknown,
+			})
+			continue
+		}
+		filesystems = append(filesystems, results[i].filesystems(node, claims, seen)...)
+	}
+	if failed > 0 && failed == len(nodes) {
+		return filesystems, fmt.Errorf("no node answered, %s: %w", nodes[0], errs[0])
+	}
+
+	// bound claims no pod mounts only have the size they were given, with
+	// a node missing there is no telling which those are
+	if failed > 0 {
+		return filesystems, nil
+	}
+	var idle []Filesystem
+	for key, claim := range claims {
+		if seen[key] {
+			continue
+		}
+		idle = append(idle, Filesystem{
+			Source: claim.volume, FSType: "pvc", Class: ClassReal, MountPoint: "pvc/" + key, Status: StatusUnavailable,
+			Size: claim.capacity, Used: Unknown, Avail: Unknown, UsePercent: Unknown,
+		})
+	}
+	sort.Slice(idle, func(i, j int) bool { return idle[i].MountPoint < idle[j].MountPoint })
+	return append(filesystems, idle...), nil
+}
+
+// kubectl runs kubectl with the cluster flags and args, with c.Timeout.
+func (c KubeletCollector) kubectl(ctx context.Context, args ...string) ([]byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	kubectl := c.Kubectl
+	if kubectl == "" {
+		kubectl = "kubectl"
+	}
+	var global []string
+	if c.Kubeconfig != "" {
+		global = append(global, "--kubeconfig", c.Kubeconfig)
+	}
+	if c.Context != "" {
+		global = append(global, "--context", c.Context)
+	}
+	if c.Timeout > 0 {
+		global = append(global, "--request-timeout", c.Timeout.String())
+	}
+
+	out, stderr, err := runCommand(ctx, kubectl, append(global, args...)...)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("timed out after %s", c.Timeout)
+	}
+	if err != nil {
+		// kubectl explains itself on the last stderr line
+		msg := strings.TrimSpace(string(stderr))
+		if i := strings.LastIndexByte(msg, '\n'); i >= 0 {
+			msg = msg[i+1:]
+		}
+		if msg == "" {
+			return nil, err
+		}
+		return nil, errors.New(msg)
+	}
+	return out, nil
+}
+
+func (c KubeletCollector) listNodes(ctx context.Context) ([]string, error) {
+	out, err := c.kubectl(ctx, "get", "nodes", "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("listing nodes: %w", err)
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("listing nodes: %w", err)
+	}
+	nodes := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		nodes = append(nodes, item.Metadata.Name)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// kubeClaim is a bound PersistentVolumeClaim.
+type kubeClaim struct {
+	volume   string
+	capacity int64
+}
+
+// listClaims returns the bound claims of all namespaces by namespace/name.
+func (c KubeletCollector) listClaims(ctx context.Context) (map[string]kubeClaim, error) {
+	out, err := c.kubectl(ctx, "get", "persistentvolumeclaims", "--all-namespaces", "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("listing persistent volume claims: %w", err)
+	}
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Spec struct {
+				VolumeName string `json:"volumeName"`
+			} `json:"spec"`
+			Status struct {
+				Phase    string            `json:"phase"`
+				Capacity map[string]string `json:"capacity"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("listing persistent volume claims: %w", err)
+	}
+	claims := map[string]kubeClaim{}
+	for _, item := range list.Items {
+		if item.Status.Phase != "Bound" {
+			continue
+		}
+		capacity, err := parseQuantity(item.Status.Capacity["storage"])
+		if err != nil {
+			capacity = Unknown
+		}
+		claims[item.Metadata.Namespace+"/"+item.Metadata.Name] = kubeClaim{volume: item.Spec.VolumeName, capacity: capacity}
+	}
+	return claims, nil
+}
+
+// kubeSummary is the part of the kubelet's /stats/summary about disks.
+type kubeSummary struct {
+	Node struct {
+		FS      *kubeFsStats `json:"fs"`
+		Runtime *struct {
+			ImageFS *kubeFsStats `json:"imageFs"`
+		} `json:"runtime"`
+	} `json:"node"`
+	Pods []struct {
+		Volume []struct {
+			kubeFsStats
+			PVCRef *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef"`
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+type kubeFsStats struct {
+	AvailableBytes *int64 `json:"availableBytes"`
+	CapacityBytes  *int64 `json:"capacityBytes"`
+	UsedBytes      *int64 `json:"usedBytes"`
+	Inodes         *int64 `json:"inodes"`
+	InodesFree     *int64 `json:"inodesFree"`
+}
+
+func (c KubeletCollector) summary(ctx context.Context, node string) (*kubeSummary, error) {
+	out, err := c.kubectl(ctx, "get", "--raw", "/api/v1/nodes/"+node+"/proxy/stats/summary")
+	if err != nil {
+		return nil, err
+	}
+	var s kubeSummary
+	if err := json.Unmarshal(out, &s); err != nil {
+		return nil, fmt.Errorf("kubelet stats summary: %w", err)
+	}
+	return &s, nil
+}
+
+// filesystems are node's nodefs, imagefs and the claims its pods mount
+// that are not in seen yet, in that order.
+func (s *kubeSummary) filesystems(node string, claims map[string]kubeClaim, seen map[string]bool) []Filesystem {
+	var filesystems []Filesystem
+	if s.Node.FS != nil {
+		filesystems = append(filesystems, s.Node.FS.filesystem(node, KubeNodeFS, "kubelet", "kubelet"))
+	}
+	if s.Node.Runtime != nil && s.Node.Runtime.ImageFS != nil {
+		image := s.Node.Runtime.ImageFS
+		// without a separate image disk the kubelet reports nodefs twice
+		if s.Node.FS == nil || !s.Node.FS.same(image) {
+			filesystems = append(filesystems, image.filesystem(node, KubeImageFS, "kubelet", "kubelet"))
+		}
+	}
+
+	var volumes []Filesystem
+	for _, pod := range s.Pods {
+		for _, v := range pod.Volume {
+			if v.PVCRef == nil {
+				continue
+			}
+			key := v.PVCRef.Namespace + "/" + v.PVCRef.Name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			volumes = append(volumes, v.filesystem(node, "pvc/"+key, claims[key].volume, "pvc"))
+		}
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].MountPoint < volumes[j].MountPoint })
+	return append(filesystems, volumes...)
+}
+
+func (st *kubeFsStats) same(other *kubeFsStats) bool {
+	return eqInt64(st.CapacityBytes, other.CapacityBytes) && eqInt64(st.AvailableBytes, other.AvailableBytes)
+}
+
+func eqInt64(a, b *int64) bool {
+	return a != nil && b != nil && *a == *b
+}
+
+func (st *kubeFsStats) filesystem(node, mountPoint, source, fsType string) Filesystem {
+	fs := Filesystem{Host: node, Source: source, FSType: fsType, Class: ClassReal, MountPoint: mountPoint, Status: StatusOK}
+	if st.CapacityBytes == nil || st.AvailableBytes == nil || st.UsedBytes == nil || *st.CapacityBytes == 0 {
+		fs.Size, fs.Used, fs.Avail, fs.UsePercent = Unknown, Unknown, Unknown, Unknown
+		fs.Status = StatusUnavailable
+		return fs
+	}
+	// the kubelet's used is what is in use, not capacity-available, the
+	// percent is of used+avail like df's
+	fs.Size, fs.Used, fs.Avail = *st.CapacityBytes, *st.UsedBytes, *st.AvailableBytes
+	if total := fs.Used + fs.Avail; total > 0 {
+		fs.UsePercent = int((fs.Used*100 + total - 1) / total)
+	}
+	fs.computePercent()
+	if st.Inodes != nil && st.InodesFree != nil {
+		fs.Inodes = newInodeUsage(*st.Inodes, *st.InodesFree)
+	}
+	return fs
+}
+
+// parseQuantity parses a Kubernetes resource quantity like 10Gi, 500M or
+// 1e9 into bytes.
+func parseQuantity(s string) (int64, error) {
+	suffixes := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40}, {"Pi", 1 << 50}, {"Ei", 1 << 60},
+		{"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12}, {"P", 1e15}, {"E", 1e18},
+	}
+	mult := 1.0
+	for _, sf := range suffixes {
+		if n, ok := strings.CutSuffix(s, sf.suffix); ok {
+			s, mult = n, sf.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad quantity %q", s)
+	}
+	return int64(n * mult), nil
+}
@@ -0,0 +1,101 @@
+package diskusage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeKubectl has the nodes n1, with a separate image disk and the claim
+// db/data mounted by two pods, and n2, whose kubelet doesn't answer.
+const fakeKubectl = `#!/bin/sh
+while [ "${1#--}" != "$1" ]; do shift 2; done
+case "$*" in
+"get nodes -o json") echo '{"items":[{"metadata":{"name":"n2"}},{"metadata":{"name":"n1"}}]}' ;;
+"get persistentvolumeclaims --all-namespaces -o json") cat <<'OUT'
+{"items":[
+{"metadata":{"name":"data","namespace":"db"},"spec":{"volumeName":"pv-1"},"status":{"phase":"Bound","capacity":{"storage":"10Gi"}}},
+{"metadata":{"name":"old","namespace":"db"},"spec":{"volumeName":"pv-2"},"status":{"phase":"Bound","capacity":{"storage":"5Gi"}}},
+{"metadata":{"name":"new","namespace":"db"},"spec":{},"status":{"phase":"Pending"}}
+]}
+OUT
+;;
+"get --raw /api/v1/nodes/n1/proxy/stats/summary") cat <<'OUT'
+{"node":{"nodeName":"n1",
+ "fs":{"capacityBytes":1000,"availableBytes":400,"usedBytes":500,"inodes":100,"inodesFree":25},
+ "runtime":{"imageFs":{"capacityBytes":2000,"availableBytes":1000,"usedBytes":1000}}},
+ "pods":[
+  {"volume":[{"name":"tmp","capacityBytes":1,"availableBytes":1,"usedBytes":0},
+             {"name":"data","capacityBytes":10737418240,"availableBytes":1073741824,"usedBytes":9663676416,"pvcRef":{"name":"data","namespace":"db"}}]},
+  {"volume":[{"name":"data","capacityBytes":10737418240,"availableBytes":1073741824,"usedBytes":9663676416,"pvcRef":{"name":"data","namespace":"db"}}]}
+ ]}
+OUT
+;;
+*) echo 'Error from server (ServiceUnavailable): the server is currently unable to handle the request' >&2; exit 1 ;;
+esac
+`
+
+func TestKubeletCollector(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	kubectl := filepath.Join(t.TempDir(), "kubectl")
+	if err := os.WriteFile(kubectl, []byte(fakeKubectl), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := KubeletCollector{Kubectl: kubectl, Context: "prod"}
+	filesystems, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fs := range filesystems {
+		names = append(names, fs.Name())
+	}
+	want := "n1:nodefs n1:imagefs n1:pvc/db/data n2:"
+	if got := strings.Join(names, " "); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	node := filesystems[0]
+	if node.Used != 500 || node.Avail != 400 || node.UsePercent != 56 || node.Inodes == nil || node.Inodes.Used != 75 {
+		t.Errorf("nodefs: %+v", node)
+	}
+	if data := filesystems[2]; data.Source != "pv-1" || data.UsePercent != 90 || data.Status != StatusOK {
+		t.Errorf("db/data: %+v", data)
+	}
+	if n2 := filesystems[3]; n2.Status != StatusUnreachable || !strings.Contains(n2.Source, "ServiceUnavailable") {
+		t.Errorf("n2: %+v", n2)
+	}
+
+	// with every node answering, the claim no pod mounts is reported too
+	c.Nodes = []string{"n1"}
+	filesystems, err = c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	idle := filesystems[len(filesystems)-1]
+	if idle.Name() != "pvc/db/old" || idle.Status != StatusUnavailable || idle.Size != 5<<30 {
+		t.Errorf("db/old: %+v", idle)
+	}
+
+	c.Nodes = []string{"n2"}
+	if _, err := c.Collect(context.Background()); err == nil {
+		t.Error("no node answered: got no error")
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	for s, want := range map[string]int64{"10Gi": 10 << 30, "500M": 500e6, "1e9": 1e9, "1.5Ki": 1536, "42": 42} {
+		if got, err := parseQuantity(s); err != nil || got != want {
+			t.Errorf("parseQuantity(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	if _, err := parseQuantity("lots"); err == nil {
+		t.Error("parseQuantity(lots): got no error")
+	}
+}
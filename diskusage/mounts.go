@@ -0,0 +1,77 @@
+package diskusage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Mount is one entry of /proc/mounts.
+type Mount struct {
+	Source     string
+	MountPoint string
+	FSType     string
+	// Options are the mount options, ro for a read-only mount.
+	Options []string
+}
+
+// ReadMounts reads the mount table at path (normally /proc/mounts).
+func ReadMounts(path string) ([]Mount, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mounts, err := parseMounts(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return mounts, nil
+}
+
+// parseMounts reads a mount table in the /proc/mounts format.
+func parseMounts(r io.Reader) ([]Mount, error) {
+	var mounts []Mount
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		m := Mount{
+			Source:     unescapeMount(fields[0]),
+			MountPoint: unescapeMount(fields[1]),
+			FSType:     fields[2],
+		}
+		if len(fields) > 3 {
+			m.Options = strings.Split(fields[3], ",")
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMount decodes the octal escapes (\040 for space etc.) the kernel
+// uses for whitespace in /proc/mounts.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package diskusage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// ReadPaths reads one path per line. Blank lines and # comments are skipped.
+func ReadPaths(r io.Reader) ([]string, error) {
+	var paths []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	return paths, scanner.Err()
+}
+
+// DfPaths reports the filesystem holding each path, in the order given.
+// Missing paths are an error unless skipMissing is set.
+func DfPaths(ctx context.Context, paths []string, mountsFile string, exact bool, skipMissing bool) ([]Filesystem, error) {
+	var existing []string
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			if skipMissing {
+				slog.Warn("skipping path", "path", p, "err", err)
+				continue
+			}
+			return nil, err
+		}
+		existing = append(existing, p)
+	}
+	if len(existing) == 0 {
+		return nil, nil
+	}
+
+	// df prints one row per argument in argument order
+	args := append(dfArgs(exact), existing...)
+	out, stderr, err := runCommand(ctx, "df", args...)
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("df: %w: %s", err, strings.TrimSpace(string(stderr)))
+	}
+
+	filesystems, err := parseDfOutput(out, exact)
+	if err != nil {
+		return nil, err
+	}
+	if len(filesystems) != len(existing) {
+		return nil, fmt.Errorf("df returned %d rows for %d paths: %s", len(filesystems), len(existing), strings.TrimSpace(string(stderr)))
+	}
+	for i := range filesystems {
+		filesystems[i].Path = existing[i]
+	}
+
+	mounts, _ := ReadMounts(mountsFile)
+	annotate(filesystems, mounts)
+	return filesystems, nil
+}
@@ -0,0 +1,36 @@
+package diskusage
+
+import (
+	"log/slog"
+	"os/exec"
+	"runtime"
+)
+
+// Priority lowers the CPU and IO priority of a scan subprocess so it doesn't
+// compete with production workloads.
+type Priority struct {
+	Nice   bool // nice -n 19
+	IONice bool // ionice -c3, the idle IO class (Linux only)
+}
+
+// wrap prefixes name and args with nice/ionice as requested. A missing tool
+// is skipped with a warning and the command runs at normal priority.
+func (p Priority) wrap(name string, args []string) (string, []string) {
+	if p.Nice {
+		if _, err := exec.LookPath("nice"); err != nil {
+			slog.Warn("nice not found, running at normal CPU priority")
+		} else {
+			name, args = "nice", append([]string{"-n", "19", name}, args...)
+		}
+	}
+
+	if p.IONice {
+		if _, err := exec.LookPath("ionice"); err != nil || runtime.GOOS != "linux" {
+			slog.Warn("ionice not available, running at normal IO priority")
+		} else {
+			name, args = "ionice", append([]string{"-c3", name}, args...)
+		}
+	}
+
+	return name, args
+}
@@ -0,0 +1,203 @@
+package diskusage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProcIO is what /proc/<pid>/io counted for a process since it started,
+// Linux only.
+type ProcIO struct {
+	PID     int    `json:"pid"`
+	Comm    string `json:"comm"`
+	Cmdline string `json:"cmdline,omitempty"`
+	// UID is the process's real user ID.
+	UID string `json:"uid"`
+	// ReadBytes and WriteBytes are what went to and came from the block
+	// layer, page cache hits aren't in them. CancelledWriteBytes were
+	// written, then truncated away before they reached the disk.
+	ReadBytes           int64 `json:"read_bytes"`
+	WriteBytes          int64 `json:"write_bytes"`
+	CancelledWriteBytes int64 `json:"cancelled_write_bytes"`
+
+	// start is when the process started, in clock ticks after boot, so a
+	// reused pid isn't taken for the same process
+	start string
+}
+
+// ReadProcIO reads the counters of every process under procDir, /proc.
+// Processes that exit meanwhile are left out. Those of other users can
+// only be read as root, denied counts them.
+func ReadProcIO(procDir string) (procs []ProcIO, denied int, err error) {
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		p, err := readProc(filepath.Join(procDir, e.Name()), pid)
+		switch {
+		case err == nil:
+			procs = append(procs, p)
+		case errors.Is(err, fs.ErrPermission):
+			denied++
+		case errors.Is(err, fs.ErrNotExist), errors.Is(err, errProcExited):
+		default:
+			return nil, 0, err
+		}
+	}
+	if len(procs) == 0 && denied == 0 {
+		return nil, 0, fmt.Errorf("no process I/O counters in %s, it needs Linux with task I/O accounting", procDir)
+	}
+	return procs, denied, nil
+}
+
+// errProcExited is reading a process whose files are empty, it is going
+// away.
+var errProcExited = errors.New("process exited")
+
+func readProc(dir string, pid int) (ProcIO, error) {
+	p := ProcIO{PID: pid}
+	data, err := os.ReadFile(filepath.Join(dir, "io"))
+	if err != nil {
+		return p, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("%s/io: %s: %w", dir, key, err)
+		}
+		switch key {
+		case "read_bytes":
+			p.ReadBytes = n
+		case "write_bytes":
+			p.WriteBytes = n
+		case "cancelled_write_bytes":
+			p.CancelledWriteBytes = n
+		}
+	}
+
+	// the name is in parentheses and may hold anything, even ") ", the
+	// fields after the last ) are fixed: state is the 3rd, starttime the 22nd
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return p, err
+	}
+	open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return p, errProcExited
+	}
+	p.Comm = string(stat[open+1 : end])
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return p, fmt.Errorf("%s/stat: %d fields", dir, len(fields))
+	}
+	p.start = fields[19]
+
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		p.Cmdline = strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})))
+	}
+	if status, err := os.ReadFile(filepath.Join(dir, "status")); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if uids, ok := strings.CutPrefix(line, "Uid:"); ok {
+				if f := strings.Fields(uids); len(f) > 0 {
+					p.UID = f[0]
+				}
+				break
+			}
+		}
+	}
+	return p, nil
+}
+
+// IORate is a process's disk I/O over a sampling interval.
+type IORate struct {
+	ProcIO
+	// User is the name of UID, when the caller looked it up.
+	User string `json:"user,omitempty"`
+	// Read and Written are the bytes of the interval, Written without
+	// what was cancelled.
+	Read          int64   `json:"read_bytes_interval"`
+	Written       int64   `json:"written_bytes_interval"`
+	ReadPerSec    float64 `json:"read_bytes_per_second"`
+	WrittenPerSec float64 `json:"written_bytes_per_second"`
+}
+
+// IORates is what the processes of after did since before, interval
+// earlier, most written first, then most read. Processes that did no
+// disk I/O are left out, those not in before count from when they
+// started.
+func IORates(before, after []ProcIO, interval time.Duration) []IORate {
+	prev := make(map[int]ProcIO, len(before))
+	for _, p := range before {
+		prev[p.PID] = p
+	}
+	var rates []IORate
+	for _, p := range after {
+		b, ok := prev[p.PID]
+		if !ok || b.start != p.start {
+			b = ProcIO{}
+		}
+		r := IORate{
+			ProcIO:  p,
+			Read:    max(p.ReadBytes-b.ReadBytes, 0),
+			Written: max((p.WriteBytes-b.WriteBytes)-(p.CancelledWriteBytes-b.CancelledWriteBytes), 0),
+		}
+		if r.Read == 0 && r.Written == 0 {
+			continue
+		}
+		if secs := interval.Seconds(); secs > 0 {
+			r.ReadPerSec, r.WrittenPerSec = float64(r.Read)/secs, float64(r.Written)/secs
+		}
+		rates = append(rates, r)
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].Written != rates[j].Written {
+			return rates[i].Written > rates[j].Written
+		}
+		if rates[i].Read != rates[j].Read {
+			return rates[i].Read > rates[j].Read
+		}
+		return rates[i].PID < rates[j].PID
+	})
+	return rates
+}
+
+// SampleIO reads the counters under procDir twice, interval apart, and
+// returns the rates of the processes that did disk I/O in between. denied
+// is as for ReadProcIO, of the second read.
+func SampleIO(ctx context.Context, procDir string, interval time.Duration) (rates []IORate, denied int, err error) {
+	before, _, err := ReadProcIO(procDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	start := time.Now()
+	select {
+	case <-time.After(interval):
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	after, denied, err := ReadProcIO(procDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	return IORates(before, after, time.Since(start)), denied, nil
+}
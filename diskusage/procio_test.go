@@ -0,0 +1,84 @@
+package diskusage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeProc fakes /proc/<pid> with the files ReadProcIO reads.
+func writeProc(t *testing.T, procDir string, pid int, comm, start string, read, write, cancelled int64) {
+	t.Helper()
+	dir := filepath.Join(procDir, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"io": fmt.Sprintf("rchar: 999999\nwchar: 999999\nsyscr: 10\nsyscw: 10\nread_bytes: %d\nwrite_bytes: %d\ncancelled_write_bytes: %d\n", read, write, cancelled),
+		// 19 fields between the state and starttime
+		"stat":    fmt.Sprintf("%d (%s) S 1 1 1 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 %s 0 0\n", pid, comm, start),
+		"cmdline": comm + "\x00--flag\x00",
+		"status":  "Name:\t" + comm + "\nUid:\t1000\t1000\t1000\t1000\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIORates(t *testing.T) {
+	proc := t.TempDir()
+	writeProc(t, proc, 10, "postgres", "100", 1000, 5000, 0)
+	writeProc(t, proc, 20, "cp) (evil", "200", 0, 100, 0)
+	writeProc(t, proc, 30, "idle", "300", 50, 50, 0)
+	// not a process
+	os.MkdirAll(filepath.Join(proc, "self-not-a-pid"), 0o755)
+	before, denied, err := ReadProcIO(proc)
+	if err != nil || denied != 0 {
+		t.Fatal(denied, err)
+	}
+	if len(before) != 3 {
+		t.Fatalf("read %d processes, want 3", len(before))
+	}
+
+	writeProc(t, proc, 10, "postgres", "100", 3000, 25000, 1000)
+	// pid 20 was reused by another process
+	writeProc(t, proc, 20, "rsync", "250", 4000, 400, 0)
+	writeProc(t, proc, 40, "tar", "400", 0, 8000, 0)
+	after, _, err := ReadProcIO(proc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rates := IORates(before, after, 2*time.Second)
+	type rate struct {
+		comm          string
+		read, written int64
+		writtenPerSec float64
+	}
+	var got []rate
+	for _, r := range rates {
+		got = append(got, rate{r.Comm, r.Read, r.Written, r.WrittenPerSec})
+	}
+	want := []rate{
+		{"postgres", 2000, 19000, 9500},
+		{"tar", 0, 8000, 4000},
+		{"rsync", 4000, 400, 200},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if p := rates[0]; p.UID != "1000" || p.Cmdline != "postgres --flag" {
+		t.Errorf("got uid %q, cmdline %q", p.UID, p.Cmdline)
+	}
+}
+
+func TestReadProcIOEmpty(t *testing.T) {
+	if _, _, err := ReadProcIO(t.TempDir()); err == nil {
+		t.Error("no processes read without an error")
+	}
+}
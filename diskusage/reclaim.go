@@ -0,0 +1,162 @@
+package diskusage
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReclaimRules decide what counts as a cleanup candidate: an entry whose
+// name matches one of Patterns (filepath.Match globs on the base name), that
+// is at least MinSize and was not modified for MinAge. FilePatterns only
+// match regular files, a directory named core is a source tree, not a dump.
+//
+//	min_size: 100M
+//	min_age: 168h
+//	patterns: ["*.log", "*.tmp", ".cache"]
+//	file_patterns: ["core", "core.[0-9]*"]
+type ReclaimRules struct {
+	MinSize      string        `yaml:"min_size"`
+	MinAge       time.Duration `yaml:"min_age"`
+	Patterns     []string      `yaml:"patterns"`
+	FilePatterns []string      `yaml:"file_patterns"`
+
+	minSize int64
+}
+
+// DefaultReclaimRules are logs, temp files, caches and core dumps untouched
+// for a week.
+func DefaultReclaimRules() *ReclaimRules {
+	return &ReclaimRules{
+		MinSize: "1M",
+		MinAge:  7 * 24 * time.Hour,
+		Patterns: []string{
+			"*.log", "*.log.[0-9]*", "*.log.gz",
+			"*.tmp", "tmp", ".tmp",
+			"cache", ".cache",
+		},
+		FilePatterns: []string{"core", "core.[0-9]*", "*.core"},
+		minSize:      1 << 20,
+	}
+}
+
+// LoadReclaimRules reads a rules file, fields left out keep their default.
+func LoadReclaimRules(path string) (*ReclaimRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := DefaultReclaimRules()
+	if err := yaml.Unmarshal(data, rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if rules.minSize, err = parseHumanSize(rules.MinSize); err != nil {
+		return nil, fmt.Errorf("%s: min_size: %w", path, err)
+	}
+	for _, p := range append(rules.Patterns, rules.FilePatterns...) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("%s: pattern %q: %w", path, p, err)
+		}
+	}
+	return rules, nil
+}
+
+// ReclaimCandidate is a file or directory that looks safe to clean up.
+// ModTime is the newest modification anywhere below it.
+type ReclaimCandidate struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size_bytes"`
+	ModTime time.Time `json:"mod_time"`
+	Pattern string    `json:"pattern"`
+}
+
+// Reclaim is the advisory cleanup report: candidates largest first and what
+// removing all of them would free. Nothing is ever deleted.
+type Reclaim struct {
+	Candidates []ReclaimCandidate `json:"candidates"`
+	TotalBytes int64              `json:"total_bytes"`
+}
+
+// FindReclaim walks root for entries matching the rules. A matching
+// directory is taken as a whole and not descended into. When ctx ends the
+// candidates found so far are returned with the error.
+func FindReclaim(ctx context.Context, root string, rules *ReclaimRules, now time.Time) (*Reclaim, error) {
+	var r Reclaim
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || path == root {
+			// unreadable entry, look at what we can
+			return nil
+		}
+
+		pattern, ok := rules.match(d.Name(), d.Type().IsRegular())
+		if !ok {
+			return nil
+		}
+
+		size, mtime := treeSize(ctx, path)
+		if size >= rules.minSize && now.Sub(mtime) >= rules.MinAge {
+			r.Candidates = append(r.Candidates, ReclaimCandidate{Path: path, Size: size, ModTime: mtime, Pattern: pattern})
+			r.TotalBytes += size
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	sort.Slice(r.Candidates, func(i, j int) bool { return r.Candidates[i].Size > r.Candidates[j].Size })
+	return &r, err
+}
+
+func (rules *ReclaimRules) match(name string, regular bool) (string, bool) {
+	for _, p := range rules.Patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return p, true
+		}
+	}
+	if !regular {
+		return "", false
+	}
+	for _, p := range rules.FilePatterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// treeSize sums the regular files under path and returns the newest
+// modification time, path itself may be a file.
+func treeSize(ctx context.Context, path string) (size int64, mtime time.Time) {
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.ModTime().After(mtime) {
+			mtime = info.ModTime()
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, mtime
+}
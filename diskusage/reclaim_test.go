@@ -0,0 +1,101 @@
+package diskusage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindReclaim(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	old := now.Add(-30 * 24 * time.Hour)
+	file := func(path string, size int, mtime time.Time) {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file("app/app.log", 3000, old)
+	file("app/fresh.log", 3000, now)
+	file("app/small.log", 10, old)
+	file("app/core", 2000, old)
+	// taken whole, the fresh file in it keeps the directory
+	file("home/.cache/a", 1500, old)
+	file("home/.cache/b", 1500, old)
+	file("build/.cache/a", 5000, now)
+	if err := os.Chtimes(filepath.Join(root, "home/.cache"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	// a directory named core is a source tree
+	file("src/core/main.go", 5000, old)
+
+	rules := DefaultReclaimRules()
+	rules.minSize = 1000
+	r, err := FindReclaim(context.Background(), root, rules, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range r.Candidates {
+		rel, _ := filepath.Rel(root, c.Path)
+		got = append(got, rel+" "+c.Pattern)
+	}
+	want := []string{"app/app.log *.log", "home/.cache .cache", "app/core core"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if r.TotalBytes != 8000 {
+		t.Errorf("total %d, want 8000", r.TotalBytes)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FindReclaim(ctx, root, rules, now); err == nil {
+		t.Error("no error after the context ended")
+	}
+}
+
+func TestLoadReclaimRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reclaim.yaml")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("min_size: 100M\npatterns: [\"*.bak\"]\n")
+	rules, err := LoadReclaimRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.minSize != 100<<20 || rules.MinAge != 7*24*time.Hour {
+		t.Errorf("min size %d, min age %v, want 100M and the default week", rules.minSize, rules.MinAge)
+	}
+	if _, ok := rules.match("x.log", true); ok {
+		t.Error("patterns replace the defaults, *.log still matches")
+	}
+	if p, ok := rules.match("core.123", true); !ok || p != "core.[0-9]*" {
+		t.Errorf("core.123 matched %q, %v, want the default file patterns kept", p, ok)
+	}
+
+	for data, want := range map[string]string{
+		"min_size: lots\n":    "min_size",
+		"patterns: [\"[\"]\n": `pattern "["`,
+		"min_age: tomorrow\n": "reclaim.yaml",
+	} {
+		write(data)
+		if _, err := LoadReclaimRules(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want an error about %s", data, err, want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package diskusage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteDf is what SSHCollector runs on a host: df in POSIX format with
+// 1024 byte blocks, which GNU, BSD and busybox df all print, and the mount
+// table for filesystem types where there is one.
+const remoteDf = "LC_ALL=C df -P -k; echo " + remoteSeparator + "; cat /proc/mounts 2>/dev/null; true"
+
+const remoteSeparator = "--- mounts ---"
+
+// SSHCollector runs df on other machines with the ssh command, so their
+// keys, agent, known_hosts and ~/.ssh/config apply as they do for ssh.
+// BatchMode is on: a host that would ask for a password or to confirm its
+// key fails instead of hanging. Every host has its own Timeout and at most
+// Parallel run at once. Each filesystem has its Host set, a host that
+// could not be reached is a single unreachable Filesystem with Source set
+// to the error, so one dead host doesn't fail the report. Collect only
+// fails when no host answered.
+type SSHCollector struct {
+	// Hosts are ssh destinations, [user@]host, or ssh://[user@]host[:port].
+	Hosts    []string
+	Timeout  time.Duration
+	Parallel int
+	// SSH is the ssh command, "ssh" when empty.
+	SSH string
+}
+
+func (c SSHCollector) Name() string { return "ssh" }
+
+func (c SSHCollector) Collect(ctx context.Context) ([]Filesystem, error) {
+	parallel := c.Parallel
+	if parallel <= 0 {
+		parallel = mountConcurrency
+	}
+	sem := make(chan struct{}, parallel)
+	results := make([][]Filesystem, len(c.Hosts))
+	errs := make([]error, len(c.Hosts))
+
+	var wg sync.WaitGroup
+	for i, host := range c.Hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+			results[i], errs[i] = c.collectHost(ctx, host)
+		}()
+	}
+	wg.Wait()
+
+	var filesystems []Filesystem
+	failed := 0
+	for i, host := range c.Hosts {
+		if errs[i] != nil {
+			failed++
+			filesystems = append(filesystems, Filesystem{
+				Host: host, Source: errs[i].Error(), Class: ClassNetwork, Status: StatusUnreachable,
+				Size: Unknown, Used: Unknown, Avail: Unknown, UsePercent: Unknown,
+			})
+			continue
+		}
+		filesystems = append(filesystems, results[i]...)
+	}
+	if failed > 0 && failed == len(c.Hosts) {
+		return filesystems, fmt.Errorf("no host answered, %s: %w", c.Hosts[0], errs[0])
+	}
+	return filesystems, nil
+}
+
+func (c SSHCollector) collectHost(ctx context.Context, host string) ([]Filesystem, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	ssh := c.SSH
+	if ssh == "" {
+		ssh = "ssh"
+	}
+	args := []string{"-o", "BatchMode=yes"}
+	if c.Timeout > 0 {
+		args = append(args, "-o", fmt.Sprintf("ConnectTimeout=%d", max(1, int(c.Timeout.Seconds()))))
+	}
+	// -- so a host can't be taken for an option
+	args = append(args, "--", host, remoteDf)
+
+	out, stderr, err := runCommand(ctx, ssh, args...)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("timed out after %s", c.Timeout)
+	}
+	df, mounts, found := bytes.Cut(out, []byte(remoteSeparator+"\n"))
+	if err != nil && (!found || len(df) == 0) {
+		// ssh exits 255 for its own errors, the last stderr line says why
+		msg := strings.TrimSpace(string(stderr))
+		if i := strings.LastIndexByte(msg, '\n'); i >= 0 {
+			msg = msg[i+1:]
+		}
+		if msg == "" {
+			return nil, err
+		}
+		return nil, errors.New(msg)
+	}
+
+	filesystems, err := ParseDfBytes(df)
+	if err != nil {
+		return nil, err
+	}
+	table, _ := parseMounts(bytes.NewReader(mounts))
+	annotate(filesystems, table)
+	for i := range filesystems {
+		filesystems[i].Host = host
+	}
+	sort.SliceStable(filesystems, func(i, j int) bool { return filesystems[i].MountPoint < filesystems[j].MountPoint })
+	return filesystems, nil
+}
@@ -0,0 +1,64 @@
+package diskusage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSSH answers like ssh would for "web1", fails to connect to anything
+// else and hangs for "slow".
+const fakeSSH = `#!/bin/sh
+while [ "$1" != "--" ]; do shift; done
+case "$2" in
+web1) cat <<'OUT'
+Filesystem     1024-blocks     Used Available Capacity Mounted on
+/dev/sda1         10485760  5242880   5242880      50% /
+/dev/sdb1         20971520 19922944   1048576      95% /data
+--- mounts ---
+/dev/sda1 / ext4 rw 0 0
+/dev/sdb1 /data xfs rw 0 0
+OUT
+;;
+slow) exec sleep 10 ;;
+*) echo "ssh: connect to host $2 port 22: Connection refused" >&2; exit 255 ;;
+esac
+`
+
+func TestSSHCollector(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	ssh := filepath.Join(t.TempDir(), "ssh")
+	if err := os.WriteFile(ssh, []byte(fakeSSH), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := SSHCollector{Hosts: []string{"web1", "db1", "slow"}, Timeout: 500 * time.Millisecond, SSH: ssh}
+	filesystems, err := c.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filesystems) != 4 {
+		t.Fatalf("got %d filesystems: %+v", len(filesystems), filesystems)
+	}
+	data := filesystems[1]
+	if data.Name() != "web1:/data" || data.FSType != "xfs" || data.Class != ClassReal || data.Used != 19922944<<10 || data.UsePercent != 95 {
+		t.Errorf("web1 /data: %+v", data)
+	}
+	if db := filesystems[2]; db.Host != "db1" || db.Status != StatusUnreachable || !strings.Contains(db.Source, "Connection refused") {
+		t.Errorf("db1: %+v", db)
+	}
+	if slow := filesystems[3]; slow.Host != "slow" || slow.Status != StatusUnreachable || !strings.Contains(slow.Source, "timed out") {
+		t.Errorf("slow: %+v", slow)
+	}
+
+	c.Hosts = []string{"db1"}
+	if _, err := c.Collect(context.Background()); err == nil {
+		t.Error("no host answered: got no error")
+	}
+}
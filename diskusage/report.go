@@ -0,0 +1,78 @@
+package diskusage
+
+import (
+	"sort"
+	"time"
+)
+
+// SchemaVersion is written with every JSON and CSV report. It is bumped when
+// a field is removed, renamed or changes meaning, consumers should check it.
+// New fields can appear without a bump.
+const SchemaVersion = 1
+
+// Report is everything one run collected.
+type Report struct {
+	SchemaVersion int          `json:"schema_version"`
+	Time          time.Time    `json:"time"`
+	Filesystems   []Filesystem `json:"filesystems"`
+	Dirs          []Dir        `json:"dirs,omitempty"`
+	// DuSkippedPaths are directories du could not read, Dirs sizes miss
+	// whatever is in them.
+	DuSkippedPaths []string       `json:"du_skipped_paths,omitempty"`
+	DuSkipped      int            `json:"du_skipped,omitempty"`
+	Budget         []BudgetResult `json:"budget,omitempty"`
+	Reclaim        *Reclaim       `json:"reclaim,omitempty"`
+	SinceBoot      *SinceBoot     `json:"since_boot,omitempty"`
+	// Extensions is only collected with -by-extension.
+	Extensions []ExtensionUsage `json:"extensions,omitempty"`
+	// Collectors has one entry per collector that ran, so a section that
+	// failed or timed out can be told apart from one with nothing in it.
+	Collectors []CollectorStatus `json:"collectors,omitempty"`
+}
+
+// CollectorStatus is how one collector (df, du, ...) did.
+type CollectorStatus struct {
+	Name     string        `json:"name"`
+	OK       bool          `json:"ok"`
+	Err      string        `json:"err,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	// Stderr is what the collector's commands printed there, only kept
+	// when asked for.
+	Stderr string `json:"stderr,omitempty"`
+}
+
+// Sort orders the report by stable keys so two runs on the same host diff
+// cleanly: filesystems by host, mount point then path, directories, growth and
+// reclaim candidates by path, budget results by mount point and extensions
+// by name.
+func (r *Report) Sort() {
+	sort.SliceStable(r.Filesystems, func(i, j int) bool {
+		a, b := r.Filesystems[i], r.Filesystems[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.MountPoint != b.MountPoint {
+			return a.MountPoint < b.MountPoint
+		}
+		return a.Path < b.Path
+	})
+	sort.SliceStable(r.Dirs, func(i, j int) bool {
+		return r.Dirs[i].Path < r.Dirs[j].Path
+	})
+	sort.SliceStable(r.Budget, func(i, j int) bool {
+		return r.Budget[i].MountPoint < r.Budget[j].MountPoint
+	})
+	sort.SliceStable(r.Extensions, func(i, j int) bool {
+		return r.Extensions[i].Ext < r.Extensions[j].Ext
+	})
+	if r.SinceBoot != nil {
+		sort.SliceStable(r.SinceBoot.Dirs, func(i, j int) bool {
+			return r.SinceBoot.Dirs[i].Path < r.SinceBoot.Dirs[j].Path
+		})
+	}
+	if r.Reclaim != nil {
+		sort.SliceStable(r.Reclaim.Candidates, func(i, j int) bool {
+			return r.Reclaim.Candidates[i].Path < r.Reclaim.Candidates[j].Path
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package diskusage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"ved/test/notify"
+)
+
+// AlertRules are alert conditions beyond a single use percent, each for the
+// mounts matching its globs (all mounts without any):
+//
+//	rules:
+//	  - name: var-full
+//	    mounts: ["/var", "/var/*"]
+//	    when: use_percent > 85 || avail_bytes < 20G
+//	  - name: nfs-low
+//	    when: fs_type == "nfs" && free_percent < 10
+//	  - name: var-growing
+//	    mounts: ["/var"]
+//	    growth_per_hour: 1G
+//	notifiers:
+//	  - type: slack
+//	    url: https://hooks.slack.com/services/...
+//	repeat_interval: 4h
+//
+// The expressions can use use_percent, used_percent, free_percent,
+// size_bytes, used_bytes, avail_bytes, fs_type, class, source, mount_point
+// and host, inode_percent and inodes_free (-1 without inode usage),
+// read_only (1 or 0) and fs_errors (-1 where errors aren't counted).
+// A rule with growth_per_hour fires when the used bytes grew faster than
+// that since the previous collection, and its when (if any) holds too.
+//
+// Notifiers are told when an alert starts and when it resolves, and again
+// every RepeatInterval while it lasts (never when 0).
+type AlertRules struct {
+	Rules          []AlertRule     `yaml:"rules"`
+	Notifiers      []notify.Config `yaml:"notifiers"`
+	RepeatInterval time.Duration   `yaml:"repeat_interval"`
+
+	targets []notify.Notifier
+}
+
+type AlertRule struct {
+	Name          string   `yaml:"name"`
+	Mounts        []string `yaml:"mounts"`
+	When          string   `yaml:"when"`
+	GrowthPerHour string   `yaml:"growth_per_hour"`
+
+	when   expr
+	growth int64
+}
+
+// LoadAlertRules reads a rules file and compiles every expression, so a
+// broken rule fails at startup and not at the first poll.
+func LoadAlertRules(path string) (*AlertRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules AlertRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for i := range rules.Rules {
+		r := &rules.Rules[i]
+		if r.Name == "" {
+			return nil, fmt.Errorf("%s: rule %d has no name", path, i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("%s: duplicate rule %s", path, r.Name)
+		}
+		names[r.Name] = true
+
+		for _, m := range r.Mounts {
+			if _, err := filepath.Match(m, ""); err != nil {
+				return nil, fmt.Errorf("%s: rule %s: mount %q: %w", path, r.Name, m, err)
+			}
+		}
+		if r.GrowthPerHour != "" {
+			if r.growth, err = parseHumanSize(r.GrowthPerHour); err != nil || r.growth <= 0 {
+				return nil, fmt.Errorf("%s: rule %s: growth_per_hour %q: want a size like 1G", path, r.Name, r.GrowthPerHour)
+			}
+		}
+		if r.When == "" && r.growth > 0 {
+			continue
+		}
+		if r.when, err = compileExpr(r.When); err != nil {
+			return nil, fmt.Errorf("%s: rule %s: %w", path, r.Name, err)
+		}
+	}
+
+	if rules.RepeatInterval < 0 {
+		return nil, fmt.Errorf("%s: repeat_interval can't be negative", path)
+	}
+	for i, c := range rules.Notifiers {
+		n, err := notify.New(c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: notifier %d: %w", path, i+1, err)
+		}
+		rules.targets = append(rules.targets, n)
+	}
+	return &rules, nil
+}
+
+// Targets returns the notifiers built from Notifiers.
+func (rules *AlertRules) Targets() []notify.Notifier {
+	return rules.targets
+}
+
+// Fired returns the rules whose condition holds for fs. perHour is how fast
+// its used bytes grew since the previous collection, nil when there is no
+// previous one, rules with growth_per_hour never fire then. Mounts without
+// stats never fire, there is nothing to compare.
+func (rules *AlertRules) Fired(fs Filesystem, perHour *float64) []AlertRule {
+	if !fs.HasStats() {
+		return nil
+	}
+	var fired []AlertRule
+	for _, r := range rules.Rules {
+		if !r.applies(fs.MountPoint) {
+			continue
+		}
+		if r.growth > 0 && (perHour == nil || *perHour <= float64(r.growth)) {
+			continue
+		}
+		if r.when != nil && !r.when(fs).(bool) {
+			continue
+		}
+		fired = append(fired, r)
+	}
+	return fired
+}
+
+func (r AlertRule) applies(mount string) bool {
+	if len(r.Mounts) == 0 {
+		return true
+	}
+	for _, m := range r.Mounts {
+		if ok, _ := filepath.Match(m, mount); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package diskusage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompileExpr(t *testing.T) {
+	free := 12.5
+	fs := Filesystem{
+		MountPoint: "/var", FSType: "ext4", Class: ClassReal, Source: "/dev/sda2",
+		Size: 100 << 30, Used: 90 << 30, Avail: 10 << 30, UsePercent: 90, FreePercent: &free,
+		Status: StatusOK,
+	}
+	for _, tt := range []struct {
+		expr string
+		want bool
+	}{
+		{"use_percent > 85", true},
+		{"use_percent >= 90 && use_percent <= 90", true},
+		{"use_percent == 90 and use_percent != 91", true},
+		{"avail_bytes < 20G", true},
+		{"avail_bytes < 10G", false},
+		{"size_bytes == 100G", true},
+		{"free_percent < 12.6", true},
+		{`fs_type == "ext4"`, true},
+		{`fs_type != "ext4"`, false},
+		{`mount_point == "/var" && class == "real"`, true},
+		// && binds tighter than ||, as (true || false) && false would be false
+		{"use_percent > 85 || use_percent < 10 && avail_bytes > 1T", true},
+		{"(use_percent > 85 || use_percent < 10) && avail_bytes > 1T", false},
+		{"use_percent > 85 or use_percent < 10 and avail_bytes > 1T", true},
+		// ! binds tighter than &&
+		{"!(use_percent > 95) && use_percent > 85", true},
+		{"not use_percent > 95 and use_percent > 85", true},
+		{"!!(use_percent > 85)", true},
+		{"!(use_percent > 85 || read_only == 1)", false},
+		// no inode count is -1, errors that aren't counted too
+		{"inodes_free > 0", false},
+		{"inodes_free < 0 || fs_errors < 0", true},
+		{"read_only == 0", true},
+	} {
+		e, err := compileExpr(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := e(fs).(bool); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCompileExprErrors(t *testing.T) {
+	for expr, want := range map[string]string{
+		"":                             "unexpected end",
+		"use_percent":                  "not a condition",
+		"85":                           "not a condition",
+		"use_percent > ":               "unexpected end",
+		"use_percent > 85 ||":          "unexpected end",
+		"(use_percent > 85":            "missing )",
+		"use_percent > 85)":            `unexpected ")"`,
+		"usage > 85":                   `unknown field "usage"`,
+		`fs_type == 5`:                 "two numbers or two strings",
+		`use_percent == "90"`:          "two numbers or two strings",
+		`fs_type < "nfs"`:              "only be compared with == and !=",
+		"(use_percent > 5) > 3":        "two numbers or two strings",
+		"use_percent && read_only > 0": "conditions on both sides",
+		"!use_percent":                 "needs a condition",
+		`fs_type == "nfs`:              "unterminated string",
+		"use_percent > 85 # comment":   "unexpected",
+		"avail_bytes < 20Q":            `bad number "20Q"`,
+	} {
+		if _, err := compileExpr(expr); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want an error about %s", expr, err, want)
+		}
+	}
+}
+
+func TestLoadAlertRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(data string) string {
+		path := filepath.Join(dir, "rules.yaml")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	rules, err := LoadAlertRules(write(`
+rules:
+  - name: var-full
+    mounts: ["/var", "/var/*"]
+    when: use_percent > 85
+  - name: var-growing
+    mounts: ["/var"]
+    growth_per_hour: 1G
+  - name: growing-and-full
+    growth_per_hour: 1G
+    when: use_percent > 95
+  - name: read-only
+    when: read_only == 1
+repeat_interval: 4h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := Filesystem{MountPoint: "/var", Size: 100, Used: 90, Avail: 10, UsePercent: 90, Status: StatusOK}
+	fired := func(fs Filesystem, perHour *float64) []string {
+		var names []string
+		for _, r := range rules.Fired(fs, perHour) {
+			names = append(names, r.Name)
+		}
+		return names
+	}
+	slow, fast := float64(1<<20), float64(2<<30)
+	for _, tt := range []struct {
+		name    string
+		fs      Filesystem
+		perHour *float64
+		want    string
+	}{
+		{"first collection, no growth yet", fs, nil, "var-full"},
+		{"growing slowly", fs, &slow, "var-full"},
+		{"growing fast", fs, &fast, "var-full var-growing"},
+		{"other mount", Filesystem{MountPoint: "/home", Size: 100, Used: 96, Avail: 4, UsePercent: 96, Status: StatusOK}, &fast, "growing-and-full"},
+		{"below the mount glob", Filesystem{MountPoint: "/var/lib", Size: 100, Used: 90, Avail: 10, UsePercent: 90, Status: StatusOK}, nil, "var-full"},
+		{"no stats", Filesystem{MountPoint: "/var", Status: StatusStale, ReadOnly: true}, &fast, ""},
+	} {
+		if got := strings.Join(fired(tt.fs, tt.perHour), " "); got != tt.want {
+			t.Errorf("%s: fired %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	for data, want := range map[string]string{
+		"rules:\n  - when: use_percent > 85\n":                                                       "rule 1 has no name",
+		"rules:\n  - name: a\n    when: use_percent > 85\n  - name: a\n    when: use_percent > 90\n": "duplicate rule a",
+		"rules:\n  - name: a\n    when: use_percent >\n":                                             "rule a: unexpected end",
+		"rules:\n  - name: a\n":                                                                      "rule a: unexpected end",
+		"rules:\n  - name: a\n    mounts: [\"[\"]\n    when: use_percent > 85\n":                     `mount "["`,
+		"rules:\n  - name: a\n    growth_per_hour: fast\n":                                           "growth_per_hour",
+		"rules:\n  - name: a\n    growth_per_hour: -1G\n":                                            "growth_per_hour",
+		"repeat_interval: -1h\n":                                                                     "repeat_interval",
+		"notifiers:\n  - type: pager\n":                                                              "notifier 1",
+		"rules: {\n":                                                                                 "rules.yaml",
+	} {
+		if _, err := LoadAlertRules(write(data)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want an error about %s", data, err, want)
+		}
+	}
+}
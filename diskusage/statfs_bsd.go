@@ -0,0 +1,41 @@
+//go:build darwin || freebsd
+
+package diskusage
+
+import "golang.org/x/sys/unix"
+
+func blockSize(st *unix.Statfs_t) int64 {
+	return int64(st.Bsize)
+}
+
+func readOnlyFlag(st *unix.Statfs_t) bool {
+	return uint64(st.Flags)&unix.MNT_RDONLY != 0
+}
+
+// statfsMounts lists the mounts with getfsstat, there is no /proc/mounts.
+// MNT_NOWAIT returns what the kernel has cached instead of asking every
+// filesystem, so a dead NFS server can't block the listing.
+func statfsMounts(string) ([]Mount, error) {
+	n, err := unix.Getfsstat(nil, unix.MNT_NOWAIT)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]unix.Statfs_t, n)
+	if n, err = unix.Getfsstat(buf, unix.MNT_NOWAIT); err != nil {
+		return nil, err
+	}
+
+	mounts := make([]Mount, 0, n)
+	for _, st := range buf[:n] {
+		m := Mount{
+			Source:     unix.ByteSliceToString(st.Mntfromname[:]),
+			MountPoint: unix.ByteSliceToString(st.Mntonname[:]),
+			FSType:     unix.ByteSliceToString(st.Fstypename[:]),
+		}
+		if readOnlyFlag(&st) {
+			m.Options = []string{"ro"}
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
@@ -0,0 +1,19 @@
+package diskusage
+
+import "golang.org/x/sys/unix"
+
+// blockSize is the fragment size the block counts are in, like df uses.
+func blockSize(st *unix.Statfs_t) int64 {
+	if st.Frsize > 0 {
+		return st.Frsize
+	}
+	return st.Bsize
+}
+
+func readOnlyFlag(st *unix.Statfs_t) bool {
+	return st.Flags&unix.ST_RDONLY != 0
+}
+
+func statfsMounts(mountsFile string) ([]Mount, error) {
+	return ReadMounts(mountsFile)
+}
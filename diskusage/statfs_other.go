@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package diskusage
+
+const statfsSupported = false
+
+func statfs(string) (statfsInfo, error) {
+	return statfsInfo{}, ErrNoStatfs
+}
+
+func statfsMounts(string) ([]Mount, error) {
+	return nil, ErrNoStatfs
+}
@@ -0,0 +1,24 @@
+//go:build linux || darwin || freebsd
+
+package diskusage
+
+import "golang.org/x/sys/unix"
+
+const statfsSupported = true
+
+// statfs returns what the kernel says about the filesystem mounted at path.
+func statfs(path string) (statfsInfo, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return statfsInfo{}, err
+	}
+	bs := blockSize(&st)
+	return statfsInfo{
+		size:       int64(st.Blocks) * bs,
+		free:       int64(st.Bfree) * bs,
+		avail:      int64(st.Bavail) * bs,
+		inodes:     int64(st.Files),
+		inodesFree: int64(st.Ffree),
+		readOnly:   readOnlyFlag(&st),
+	}, nil
+}
@@ -0,0 +1,54 @@
+package diskusage
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+const statfsSupported = true
+
+// statfs has no inode counts, NTFS has no fixed number of them.
+func statfs(path string) (statfsInfo, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return statfsInfo{}, err
+	}
+	var callerFree, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &callerFree, &total, &totalFree); err != nil {
+		return statfsInfo{}, err
+	}
+	return statfsInfo{size: int64(total), free: int64(totalFree), avail: int64(callerFree)}, nil
+}
+
+// statfsMounts lists the drive letters. The filesystem type comes from the
+// volume, lowercased (ntfs, refs, fat32); network drives are "smbfs" and
+// classified as such, drives without media (an empty card reader) are left
+// out.
+func statfsMounts(string) ([]Mount, error) {
+	buf := make([]uint16, 256)
+	n, err := windows.GetLogicalDriveStrings(uint32(len(buf)), &buf[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var mounts []Mount
+	for _, root := range splitDrives(buf[:n]) {
+		p, err := windows.UTF16PtrFromString(root)
+		if err != nil {
+			continue
+		}
+		m := Mount{Source: root, MountPoint: root}
+		if windows.GetDriveType(p) == windows.DRIVE_REMOTE {
+			m.FSType = "smbfs"
+		} else {
+			name := make([]uint16, windows.MAX_PATH+1)
+			if err := windows.GetVolumeInformation(p, nil, 0, nil, nil, nil, &name[0], uint32(len(name))); err != nil {
+				continue
+			}
+			m.FSType = strings.ToLower(windows.UTF16ToString(name))
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
@@ -0,0 +1,317 @@
+// Package duscan totals directory sizes the way du does, without running
+// du: directories are read in parallel by a bounded number of goroutines,
+// which on SSDs and NVMe is several times faster than du's single thread.
+//
+// Like du it counts allocated blocks (Options.Apparent counts file sizes
+// instead), counts a file with several hard links once, and doesn't follow
+// symlinks unless asked. The result is the n largest directories, in the
+// diskusage.DuResult the du collector returns too.
+package duscan
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"ved/test/diskusage"
+)
+
+// DefaultWorkers is how many directories are read at once when
+// Options.Workers is 0. Reading directories waits on the disk, not the CPU.
+const DefaultWorkers = 16
+
+// Options tune a Scan.
+type Options struct {
+	// Top is how many of the largest directories are returned.
+	Top int
+	// MaxDepth limits which directories are reported, root is depth 0 and
+	// its subdirectories 1. Deeper directories still count towards their
+	// parents. 0 means no limit.
+	MaxDepth int
+	// Exclude are glob patterns (filepath.Match) matched against the name
+	// of every file and directory and against its path relative to root.
+	// Excluded entries are neither counted nor descended into.
+	Exclude []string
+	// FollowSymlinks counts what symlinks point to, like du -L. A directory
+	// reached twice, e.g. through a link loop, is counted once.
+	FollowSymlinks bool
+	// Apparent counts file sizes instead of allocated blocks, like du
+	// --apparent-size. Sizes are always apparent where the platform
+	// doesn't report blocks (Windows).
+	Apparent bool
+	// OneFilesystem doesn't descend into filesystems mounted below root,
+	// like du -x.
+	OneFilesystem bool
+	Workers       int
+}
+
+// Validate checks the exclude patterns.
+func (o Options) Validate() error {
+	for _, p := range o.Exclude {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("exclude %q: %w", p, err)
+		}
+	}
+	if o.MaxDepth < 0 {
+		return errors.New("max depth can't be negative")
+	}
+	return nil
+}
+
+// Scan walks root and returns its opts.Top largest directories, largest
+// first. Directories it can't read are in SkippedPaths, their sizes are
+// missing from the totals. When ctx ends first the directories finished so
+// far are returned with the error.
+func Scan(ctx context.Context, root string, opts Options) (diskusage.DuResult, error) {
+	s, info, err := newScanner(ctx, root, opts)
+	if err != nil {
+		return diskusage.DuResult{}, err
+	}
+	s.dir(root, 0, s.size(info))
+
+	res := diskusage.DuResult{Dirs: []diskusage.Dir(s.top), SkippedPaths: s.skipped}
+	sort.Slice(res.Dirs, func(i, j int) bool { return res.Dirs[i].Size > res.Dirs[j].Size })
+	sort.Strings(res.SkippedPaths)
+	if len(res.SkippedPaths) > 0 {
+		slog.Warn(fmt.Sprintf("skipped %d directories due to permissions, totals are too low", len(res.SkippedPaths)))
+	}
+	if ctx.Err() != nil {
+		return res, fmt.Errorf("scanning %s: %w", root, ctx.Err())
+	}
+	return res, nil
+}
+
+// newScanner checks opts and root and returns a scanner for it, with the
+// info of root.
+func newScanner(ctx context.Context, root string, opts Options) (*scanner, fs.FileInfo, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		return nil, nil, fmt.Errorf("%s is not a directory", root)
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+
+	s := &scanner{
+		ctx:   ctx,
+		opts:  opts,
+		root:  root,
+		sem:   make(chan struct{}, opts.Workers),
+		links: map[fileID]bool{},
+		dirs:  map[fileID]bool{},
+	}
+	s.rootID, _, _, _ = stat(info)
+	s.firstVisit(info)
+	return s, info, nil
+}
+
+type scanner struct {
+	ctx  context.Context
+	opts Options
+	root string
+	sem  chan struct{}
+	// rootID is root's, for OneFilesystem
+	rootID fileID
+	// file, when set, is called with every file that isn't a directory and
+	// its size, from several goroutines
+	file func(path string, info fs.FileInfo, size int64)
+
+	mu      sync.Mutex
+	top     dirHeap
+	skipped []string
+	// links are the hard linked files counted so far, dirs the directories
+	// entered when following symlinks
+	links map[fileID]bool
+	dirs  map[fileID]bool
+}
+
+// dir returns the size of path and everything under it, self is the size
+// of the directory itself. Subdirectories get their own goroutine while a
+// worker is free and are walked in this one otherwise, so the walk never
+// waits for a worker.
+func (s *scanner) dir(path string, depth int, self int64) int64 {
+	if s.ctx.Err() != nil {
+		return 0
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		s.skip(path, err)
+		return self
+	}
+	// unsorted, os.ReadDir would sort every directory
+	entries, err := f.ReadDir(-1)
+	f.Close()
+	if err != nil {
+		s.skip(path, err)
+	}
+
+	total := self
+	var sub atomic.Int64
+	var wg sync.WaitGroup
+	for _, e := range entries {
+		child := filepath.Join(path, e.Name())
+		if s.excluded(child, e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// removed while we looked
+			continue
+		}
+		if info.Mode()&fs.ModeSymlink != 0 && s.opts.FollowSymlinks {
+			if target, err := os.Stat(child); err == nil {
+				info = target
+			}
+		}
+
+		if !info.IsDir() {
+			size := s.size(info)
+			total += size
+			if s.file != nil {
+				s.file(child, info, size)
+			}
+			continue
+		}
+		if s.opts.OneFilesystem && !s.sameFilesystem(info) {
+			continue
+		}
+		if s.opts.FollowSymlinks && !s.firstVisit(info) {
+			continue
+		}
+		select {
+		case s.sem <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-s.sem }()
+				sub.Add(s.dir(child, depth+1, s.size(info)))
+			}()
+		default:
+			total += s.dir(child, depth+1, s.size(info))
+		}
+	}
+	wg.Wait()
+	total += sub.Load()
+
+	if s.opts.MaxDepth == 0 || depth <= s.opts.MaxDepth {
+		s.report(diskusage.Dir{Path: path, Size: total})
+	}
+	return total
+}
+
+// size is what info takes on disk, 0 for a hard link already counted.
+func (s *scanner) size(info fs.FileInfo) int64 {
+	id, links, blocks, ok := stat(info)
+	if !ok {
+		return info.Size()
+	}
+	if links > 1 && !info.IsDir() {
+		s.mu.Lock()
+		seen := s.links[id]
+		s.links[id] = true
+		s.mu.Unlock()
+		if seen {
+			return 0
+		}
+	}
+	if s.opts.Apparent {
+		return info.Size()
+	}
+	return blocks * 512
+}
+
+// firstVisit records the directory info and tells whether it is new.
+// Without file IDs every directory is new, following a link loop then only
+// ends at the OS's path length limit.
+func (s *scanner) firstVisit(info fs.FileInfo) bool {
+	id, _, _, ok := stat(info)
+	if !ok {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirs[id] {
+		return false
+	}
+	s.dirs[id] = true
+	return true
+}
+
+// sameFilesystem tells whether info is on root's filesystem, always where
+// the platform has no device numbers.
+func (s *scanner) sameFilesystem(info fs.FileInfo) bool {
+	id, _, _, ok := stat(info)
+	return !ok || id.dev == s.rootID.dev
+}
+
+func (s *scanner) excluded(path, name string) bool {
+	if len(s.opts.Exclude) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		rel = path
+	}
+	for _, p := range s.opts.Exclude {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *scanner) skip(path string, err error) {
+	if !errors.Is(err, fs.ErrPermission) {
+		slog.Debug("can't read directory", "path", path, "err", err)
+	}
+	s.mu.Lock()
+	s.skipped = append(s.skipped, path)
+	s.mu.Unlock()
+}
+
+// report keeps d if it is among the Top largest so far.
+func (s *scanner) report(d diskusage.Dir) {
+	if s.opts.Top <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.top) < s.opts.Top {
+		heap.Push(&s.top, d)
+	} else if d.Size > s.top[0].Size {
+		s.top[0] = d
+		heap.Fix(&s.top, 0)
+	}
+}
+
+// dirHeap is a min heap on size so the smallest of the current top n is
+// the one replaced.
+type dirHeap []diskusage.Dir
+
+func (h dirHeap) Len() int           { return len(h) }
+func (h dirHeap) Less(i, j int) bool { return h[i].Size < h[j].Size }
+func (h dirHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *dirHeap) Push(x any)        { *h = append(*h, x.(diskusage.Dir)) }
+func (h *dirHeap) Pop() any {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
@@ -0,0 +1,207 @@
+package duscan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tree creates files of the given sizes under a temp dir and returns it.
+func tree(t *testing.T, files map[string]int) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, size := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// sizes maps the scanned directories, relative to root, to their sizes
+// minus the sizes of the directories themselves, which depend on the
+// filesystem.
+func sizes(t *testing.T, root string, opts Options) map[string]int64 {
+	t.Helper()
+	opts.Apparent = true
+	if opts.Top == 0 {
+		opts.Top = 100
+	}
+	res, err := Scan(context.Background(), root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, d := range res.Dirs {
+		rel, _ := filepath.Rel(root, d.Path)
+		var dirs int64
+		filepath.Walk(d.Path, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() && !excludedByTest(opts, root, path) {
+				dirs += info.Size()
+			}
+			return nil
+		})
+		got[rel] = d.Size - dirs
+	}
+	return got
+}
+
+func excludedByTest(opts Options, root, path string) bool {
+	s := &scanner{opts: opts, root: root}
+	return s.excluded(path, filepath.Base(path))
+}
+
+func TestScan(t *testing.T) {
+	root := tree(t, map[string]int{
+		"a/one":       100,
+		"a/b/two":     200,
+		"a/b/c/three": 300,
+		"d/four.log":  400,
+		"top":         5,
+	})
+
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]int64
+	}{
+		{
+			name: "all",
+			want: map[string]int64{".": 1005, "a": 600, "a/b": 500, "a/b/c": 300, "d": 400},
+		},
+		{
+			name: "max depth",
+			opts: Options{MaxDepth: 1},
+			want: map[string]int64{".": 1005, "a": 600, "d": 400},
+		},
+		{
+			name: "exclude",
+			opts: Options{Exclude: []string{"*.log", "a/b/c"}},
+			want: map[string]int64{".": 305, "a": 300, "a/b": 200, "d": 0},
+		},
+		{
+			name: "top",
+			opts: Options{Top: 2},
+			want: map[string]int64{".": 1005, "a": 600},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sizes(t, root, tt.opts)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for path, size := range tt.want {
+				if got[path] != size {
+					t.Errorf("%s: got %d, want %d", path, got[path], size)
+				}
+			}
+		})
+	}
+}
+
+func TestScanLinks(t *testing.T) {
+	root := tree(t, map[string]int{"a/data": 1000})
+	if err := os.Link(filepath.Join(root, "a/data"), filepath.Join(root, "a/hardlink")); err != nil {
+		t.Skip("no hard links:", err)
+	}
+	if err := os.Symlink(root, filepath.Join(root, "a/loop")); err != nil {
+		t.Skip("no symlinks:", err)
+	}
+
+	for _, follow := range []bool{false, true} {
+		got := sizes(t, root, Options{FollowSymlinks: follow})
+		// the symlink itself is a few bytes when not followed
+		if got["a"] < 1000 || got["a"] > 1000+int64(len(root)) {
+			t.Errorf("follow %v: a is %d, want the file counted once", follow, got["a"])
+		}
+	}
+}
+
+func TestScanInvalid(t *testing.T) {
+	if _, err := Scan(context.Background(), t.TempDir(), Options{Exclude: []string{"["}}); err == nil {
+		t.Error("bad exclude pattern accepted")
+	}
+	if _, err := Scan(context.Background(), filepath.Join(t.TempDir(), "missing"), Options{}); err == nil {
+		t.Error("missing root accepted")
+	}
+}
+
+func TestLargest(t *testing.T) {
+	root := tree(t, map[string]int{
+		"a/one":       100,
+		"a/b/two":     200,
+		"a/b/c/three": 300,
+		"d/four.log":  400,
+		"top":         5,
+	})
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "a/b/two"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		opts   Options
+		filter FileFilter
+		want   []string
+	}{
+		{name: "top", opts: Options{Top: 3}, want: []string{"d/four.log", "a/b/c/three", "a/b/two"}},
+		{name: "min size", opts: Options{Top: 10}, filter: FileFilter{MinSize: 300}, want: []string{"d/four.log", "a/b/c/three"}},
+		{name: "older than", opts: Options{Top: 10}, filter: FileFilter{ModifiedBefore: time.Now().Add(-24 * time.Hour)}, want: []string{"a/b/two"}},
+		{name: "exclude", opts: Options{Top: 2, Exclude: []string{"*.log", "c"}}, want: []string{"a/b/two", "a/one"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Apparent = true
+			res, err := Largest(context.Background(), root, tt.opts, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range res.Files {
+				rel, _ := filepath.Rel(root, f.Path)
+				got = append(got, rel)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	res, err := Largest(context.Background(), root, Options{Top: 1}, FileFilter{Owner: strconv.Itoa(os.Getuid())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Files) != 1 || res.Files[0].Owner == "" {
+		t.Errorf("own files: %+v", res.Files)
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	root := b.TempDir()
+	for i := range 50 {
+		dir := filepath.Join(root, "d"+string(rune('a'+i%26)), "sub"+string(rune('a'+i/26)))
+		os.MkdirAll(dir, 0o755)
+		for j := range 20 {
+			os.WriteFile(filepath.Join(dir, "f"+string(rune('a'+j))), []byte("x"), 0o644)
+		}
+	}
+	for b.Loop() {
+		if _, err := Scan(context.Background(), root, Options{Top: 10}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package duscan
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os/user"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// File is one of the files Largest found. Size is allocated bytes, or the
+// file size with Options.Apparent, like the directory totals of Scan.
+// Owner is the user name, or the numeric ID of a user without one.
+type File struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size_bytes"`
+	ModTime time.Time `json:"mod_time"`
+	Owner   string    `json:"owner,omitempty"`
+	UID     string    `json:"uid,omitempty"`
+}
+
+// FileFilter picks the files Largest lists, the zero value lists all.
+type FileFilter struct {
+	MinSize int64
+	// ModifiedBefore only lists files last modified before it.
+	ModifiedBefore time.Time
+	// Owner only lists files of this user, a name or a numeric ID.
+	Owner string
+}
+
+// LargestResult is what Largest found, largest first, and the directories
+// it couldn't read.
+type LargestResult struct {
+	Files        []File   `json:"files"`
+	SkippedPaths []string `json:"skipped_paths,omitempty"`
+}
+
+// Largest walks root like Scan and returns the opts.Top largest regular
+// files that pass filter. A file with several hard links is listed once.
+// opts.MaxDepth doesn't apply, files at any depth are looked at. When ctx
+// ends first the largest files seen so far are returned with the error.
+func Largest(ctx context.Context, root string, opts Options, filter FileFilter) (LargestResult, error) {
+	uid := ""
+	if filter.Owner != "" {
+		var err error
+		if uid, err = lookupUID(filter.Owner); err != nil {
+			return LargestResult{}, err
+		}
+	}
+	s, _, err := newScanner(ctx, root, opts)
+	if err != nil {
+		return LargestResult{}, err
+	}
+
+	var mu sync.Mutex
+	var top fileHeap
+	s.file = func(path string, info fs.FileInfo, size int64) {
+		// size is 0 for a hard link seen before
+		if !info.Mode().IsRegular() || size == 0 || size < filter.MinSize || opts.Top <= 0 {
+			return
+		}
+		if !filter.ModifiedBefore.IsZero() && !info.ModTime().Before(filter.ModifiedBefore) {
+			return
+		}
+		owner, _ := owner(info)
+		if uid != "" && owner != uid {
+			return
+		}
+		f := File{Path: path, Size: size, ModTime: info.ModTime(), UID: owner}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(top) < opts.Top {
+			heap.Push(&top, f)
+		} else if f.Size > top[0].Size {
+			top[0] = f
+			heap.Fix(&top, 0)
+		}
+	}
+	// only files are wanted, Scan's directory list stays empty
+	s.opts.Top = 0
+	s.dir(root, 0, 0)
+
+	res := LargestResult{Files: []File(top), SkippedPaths: s.skipped}
+	sort.Slice(res.Files, func(i, j int) bool { return res.Files[i].Size > res.Files[j].Size })
+	sort.Strings(res.SkippedPaths)
+	names := map[string]string{}
+	for i := range res.Files {
+		f := &res.Files[i]
+		if f.UID == "" {
+			continue
+		}
+		name, ok := names[f.UID]
+		if !ok {
+			name = f.UID
+			if u, err := user.LookupId(f.UID); err == nil {
+				name = u.Username
+			}
+			names[f.UID] = name
+		}
+		f.Owner = name
+	}
+	if len(res.SkippedPaths) > 0 {
+		slog.Warn(fmt.Sprintf("skipped %d directories due to permissions, files in them are missing", len(res.SkippedPaths)))
+	}
+	if ctx.Err() != nil {
+		return res, fmt.Errorf("scanning %s: %w", root, ctx.Err())
+	}
+	return res, nil
+}
+
+// lookupUID returns the user ID of a user name, or the ID it was given.
+func lookupUID(owner string) (string, error) {
+	if _, err := strconv.ParseUint(owner, 10, 32); err == nil {
+		return owner, nil
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+// fileHeap is a min heap on size, like dirHeap.
+type fileHeap []File
+
+func (h fileHeap) Len() int           { return len(h) }
+func (h fileHeap) Less(i, j int) bool { return h[i].Size < h[j].Size }
+func (h fileHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *fileHeap) Push(x any)        { *h = append(*h, x.(File)) }
+func (h *fileHeap) Pop() any {
+	old := *h
+	f := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return f
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package duscan
+
+import "io/fs"
+
+// fileID is always zero here, dev is only for sameFilesystem.
+type fileID struct{ dev uint64 }
+
+// stat has nothing to offer here, sizes are apparent and hard links are
+// counted every time.
+func stat(fs.FileInfo) (id fileID, links uint64, blocks int64, ok bool) {
+	return fileID{}, 0, 0, false
+}
+
+// owner is unknown, Windows owners are security descriptors.
+func owner(fs.FileInfo) (uid string, ok bool) {
+	return "", false
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package duscan
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+type fileID struct {
+	dev, ino uint64
+}
+
+// stat returns the identity, link count and 512 byte blocks of info.
+func stat(info fs.FileInfo) (id fileID, links uint64, blocks int64, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, 0, false
+	}
+	return fileID{uint64(st.Dev), uint64(st.Ino)}, uint64(st.Nlink), int64(st.Blocks), true
+}
+
+// owner returns the user ID owning info.
+func owner(info fs.FileInfo) (uid string, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return strconv.FormatUint(uint64(st.Uid), 10), true
+}
@@ -0,0 +1,139 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// journalSocket is where journald takes native protocol datagrams.
+var journalSocket = "/run/systemd/journal/socket"
+
+// journalHandler sends records to journald as native protocol datagrams:
+// MESSAGE, PRIORITY and SYSLOG_IDENTIFIER, and every attribute as a field
+// of its own, upper cased with groups joined by _, so `journalctl
+// CMD=...` or `-o json` see them. A record too large for one datagram is
+// dropped with an error, journald's limit is far above what we log.
+type journalHandler struct {
+	conn   *net.UnixConn
+	ident  string
+	level  slog.Leveler
+	fields []byte // from WithAttrs, encoded
+	prefix string // from WithGroup, "GROUP_"
+}
+
+func newJournalHandler(ident string, level slog.Leveler) (*journalHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalHandler{conn: conn, ident: ident, level: level}, nil
+}
+
+func (h *journalHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *journalHandler) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", r.Message)
+	journalField(&b, "PRIORITY", strconv.Itoa(journalPriority(r.Level)))
+	journalField(&b, "SYSLOG_IDENTIFIER", h.ident)
+	b.Write(h.fields)
+	r.Attrs(func(a slog.Attr) bool {
+		journalAttr(&b, h.prefix, a)
+		return true
+	})
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b bytes.Buffer
+	b.Write(h.fields)
+	for _, a := range attrs {
+		journalAttr(&b, h.prefix, a)
+	}
+	h2 := *h
+	h2.fields = b.Bytes()
+	return &h2
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix += name + "_"
+	return &h2
+}
+
+// journalPriority maps a level to a syslog priority.
+func journalPriority(l slog.Level) int {
+	switch {
+	case l >= slog.LevelError:
+		return 3
+	case l >= slog.LevelWarn:
+		return 4
+	case l >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+func journalAttr(b *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "_"
+		}
+		for _, ga := range a.Value.Group() {
+			journalAttr(b, prefix, ga)
+		}
+		return
+	}
+	journalField(b, journalKey(prefix+a.Key), a.Value.String())
+}
+
+// journalKey makes key a valid field name: upper case letters, digits and
+// underscores, not starting with an underscore (those are journald's own)
+// or a digit.
+func journalKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, key)
+	if key == "" || key[0] == '_' || key[0] >= '0' && key[0] <= '9' {
+		key = "X" + key
+	}
+	return key
+}
+
+// journalField appends KEY=value, or the length prefixed form when value
+// has a newline.
+func journalField(b *bytes.Buffer, key, value string) {
+	b.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
@@ -0,0 +1,129 @@
+// Package logging sets up slog for the commands in this repo from their
+// -log-level, -log-format and -log-output flags, so every command logs the
+// same way:
+//
+//	var logs logging.Config
+//	logs.Flags(fs)
+//	fs.Parse(args)
+//	if err := logs.Open(name); err != nil {
+//		...
+//	}
+//	slog.SetDefault(slog.New(logs.Handler()))
+//	...
+//	logs.Close(code)
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats for -log-format.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Destinations for -log-output besides a file path.
+const (
+	OutputStderr   = "stderr"
+	OutputSyslog   = "syslog"
+	OutputJournald = "journald"
+)
+
+// Config is the logging setup of one command run. The zero value logs text
+// at info to stderr.
+type Config struct {
+	// Level is -log-level, -v lowers it to debug.
+	Level  slog.LevelVar
+	Format string
+	Output string
+
+	handler slog.Handler
+	closer  io.Closer
+	start   time.Time
+}
+
+// Flags adds -log-level, -log-format and -log-output to fs.
+func (c *Config) Flags(fs *flag.FlagSet) {
+	fs.TextVar(&c.Level, "log-level", new(slog.LevelVar), "log records at this level and above: debug, info, warn or error")
+	fs.StringVar(&c.Format, "log-format", FormatText, "log format: text (key=value) or json, one record per line")
+	fs.StringVar(&c.Output, "log-output", OutputStderr, "where logs go: stderr, a file they are appended to, syslog, or journald (its native protocol, fields as journal fields)")
+}
+
+// Open opens the destination. Every record carries cmd, the command line
+// it was started as, syslog and journald get its first word as identifier.
+func (c *Config) Open(cmd string) error {
+	c.start = time.Now()
+	switch c.Format {
+	case "", FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("-log-format %q: want text or json", c.Format)
+	}
+
+	ident, _, _ := strings.Cut(cmd, " ")
+	ident = filepath.Base(ident)
+	var h slog.Handler
+	switch c.Output {
+	case "", OutputStderr:
+		h = c.format(os.Stderr, nil)
+	case OutputSyslog:
+		w, err := openSyslog(ident)
+		if err != nil {
+			return fmt.Errorf("-log-output syslog: %w", err)
+		}
+		h, c.closer = newSyslogHandler(w, c), w
+	case OutputJournald:
+		jh, err := newJournalHandler(ident, &c.Level)
+		if err != nil {
+			return fmt.Errorf("-log-output journald: %w", err)
+		}
+		h, c.closer = jh, jh.conn
+	default:
+		f, err := os.OpenFile(c.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("-log-output: %w", err)
+		}
+		h, c.closer = c.format(f, nil), f
+	}
+	c.handler = h.WithAttrs([]slog.Attr{slog.String("cmd", cmd)})
+	return nil
+}
+
+// format is the text or json handler writing to w. replace is its
+// ReplaceAttr, syslog uses it to drop the time and level it adds itself.
+func (c *Config) format(w io.Writer, replace func([]string, slog.Attr) slog.Attr) slog.Handler {
+	opts := &slog.HandlerOptions{Level: &c.Level, ReplaceAttr: replace}
+	if c.Format == FormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// Handler is the handler Open set up, text to stderr before it.
+func (c *Config) Handler() slog.Handler {
+	if c.handler == nil {
+		return c.format(os.Stderr, nil)
+	}
+	return c.handler
+}
+
+// Close logs how the run ended at debug level, with its duration and exit
+// code, and closes the destination.
+func (c *Config) Close(exitCode int) error {
+	if c.handler == nil {
+		return nil
+	}
+	slog.New(c.handler).Debug("finished", "duration", time.Since(c.start).Round(time.Millisecond), "exit_code", exitCode)
+	c.handler = nil
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.log")
+	var c Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c.Flags(fs)
+	if err := fs.Parse([]string{"-log-level", "warn", "-log-format", "json", "-log-output", path}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Open("devops disk report"); err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(c.Handler())
+	log.Info("dropped")
+	log.Error("collecting failed", "err", errors.New("df: timed out"))
+	c.Level.Set(slog.LevelDebug)
+	if err := c.Close(1); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d records, want 2:\n%s", len(lines), data)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["msg"] != "collecting failed" || rec["err"] != "df: timed out" || rec["cmd"] != "devops disk report" {
+		t.Errorf("record %v", rec)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["msg"] != "finished" || rec["exit_code"] != 1.0 {
+		t.Errorf("finished record %v", rec)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	for _, c := range []*Config{
+		{Format: "xml"},
+		{Output: filepath.Join(t.TempDir(), "missing", "run.log")},
+	} {
+		if err := c.Open("test"); err == nil {
+			t.Errorf("Open with %q %q succeeded", c.Format, c.Output)
+		}
+	}
+}
+
+func TestJournald(t *testing.T) {
+	journalSocket = filepath.Join(t.TempDir(), "journal.sock")
+	defer func() { journalSocket = "/run/systemd/journal/socket" }()
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+
+	c := Config{Output: OutputJournald}
+	if err := c.Open("/usr/local/bin/devops auto mcp"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(0)
+	slog.New(c.Handler()).WithGroup("step").Warn("retrying", "n", 2, "output", "line 1\nline 2")
+
+	buf := make([]byte, 4096)
+	n, err := ln.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	for _, want := range []string{
+		"MESSAGE=retrying\n",
+		"PRIORITY=4\n",
+		"SYSLOG_IDENTIFIER=devops\n",
+		"CMD=/usr/local/bin/devops auto mcp\n",
+		"STEP_N=2\n",
+		"STEP_OUTPUT\n\x0d\x00\x00\x00\x00\x00\x00\x00line 1\nline 2\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("datagram has no %q:\n%q", want, got)
+		}
+	}
+}
+
+func TestJournalKey(t *testing.T) {
+	for key, want := range map[string]string{
+		"exit_code": "EXIT_CODE",
+		"mount.dev": "MOUNT_DEV",
+		"_pid":      "X_PID",
+		"2xx":       "X2XX",
+	} {
+		if got := journalKey(key); got != want {
+			t.Errorf("journalKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"log/syslog"
+	"strings"
+	"sync"
+)
+
+func openSyslog(ident string) (*syslog.Writer, error) {
+	return syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, ident)
+}
+
+// syslogHandler formats a record like -log-format would, without the time
+// and level syslog has already, and sends it at the record's priority.
+type syslogHandler struct {
+	w   *syslog.Writer
+	mu  *sync.Mutex
+	buf *bytes.Buffer
+	h   slog.Handler // writes to buf
+}
+
+func newSyslogHandler(w *syslog.Writer, c *Config) *syslogHandler {
+	buf := new(bytes.Buffer)
+	h := c.format(buf, func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+			return slog.Attr{}
+		}
+		return a
+	})
+	return &syslogHandler{w: w, mu: new(sync.Mutex), buf: buf, h: h}
+}
+
+func (h *syslogHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.h.Enabled(ctx, l)
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf.Reset()
+	if err := h.h.Handle(ctx, r); err != nil {
+		return err
+	}
+	msg := strings.TrimSuffix(h.buf.String(), "\n")
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.w.Err(msg)
+	case r.Level >= slog.LevelWarn:
+		return h.w.Warning(msg)
+	case r.Level >= slog.LevelInfo:
+		return h.w.Info(msg)
+	default:
+		return h.w.Debug(msg)
+	}
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{h.w, h.mu, h.buf, h.h.WithAttrs(attrs)}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{h.w, h.mu, h.buf, h.h.WithGroup(name)}
+}
@@ -0,0 +1,17 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+)
+
+func openSyslog(ident string) (io.WriteCloser, error) {
+	return nil, errors.New("not supported on this system")
+}
+
+func newSyslogHandler(w io.WriteCloser, c *Config) slog.Handler {
+	return c.format(w, nil)
+}
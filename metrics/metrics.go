@@ -0,0 +1,165 @@
+// Package metrics writes gauges in the Prometheus text exposition format.
+// The tools here only export values computed from their last report at
+// scrape time, a Registry is filled for every scrape and thrown away, so
+// the client library and its global state are not needed.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the Content-Type of WriteTo's output.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry collects gauges for one scrape.
+type Registry struct {
+	gauges []*Gauge
+	byName map[string]*Gauge
+}
+
+// Gauge is one metric family, a value per set of label values.
+type Gauge struct {
+	name    string
+	help    string
+	labels  []string
+	samples map[string]sample
+}
+
+type sample struct {
+	values []string
+	value  float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{byName: map[string]*Gauge{}}
+}
+
+// Gauge returns the gauge called name with the given label names, creating
+// it on first use. Names are sanitized, so a label called "fs-type" becomes
+// fs_type.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	name = SanitizeName(name)
+	if g, ok := r.byName[name]; ok {
+		return g
+	}
+	g := &Gauge{name: name, help: help, samples: map[string]sample{}}
+	for _, l := range labels {
+		g.labels = append(g.labels, SanitizeName(l))
+	}
+	r.gauges = append(r.gauges, g)
+	r.byName[name] = g
+	return g
+}
+
+// Set sets the value for the label values, given in the order of the
+// gauge's label names. Setting the same labels again replaces the value.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	if len(labelValues) != len(g.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", g.name, len(g.labels), len(labelValues)))
+	}
+	g.samples[strings.Join(labelValues, "\x00")] = sample{values: labelValues, value: value}
+}
+
+// WriteTo writes every gauge with its samples sorted by label values, so
+// two scrapes of the same data are identical.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, g := range r.gauges {
+		if len(g.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", g.name, escapeHelp(g.help))
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", g.name)
+
+		keys := make([]string, 0, len(g.samples))
+		for k := range g.samples {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := g.samples[k]
+			bw.WriteString(g.name)
+			if len(g.labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range g.labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, `%s="%s"`, l, escapeLabelValue(s.values[i]))
+				}
+				bw.WriteByte('}')
+			}
+			fmt.Fprintf(bw, " %s\n", formatValue(s.value))
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// SanitizeName turns s into a valid metric or label name: characters
+// outside [a-zA-Z0-9_:] become underscores, a leading digit gets one
+// prepended.
+func SanitizeName(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes a label value, mount points and paths can hold
+// anything.
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	used := r.Gauge("disk_used_bytes", "Used bytes of the filesystem.", "mount", "fs-type")
+	used.Set(2048, "/var", "ext4")
+	used.Set(1e12, "/", "xfs")
+	used.Set(1, `/mnt/odd "name"\`+"\n", "nfs")
+	r.Gauge("dir_size_bytes", "Unused.", "path")
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP disk_used_bytes Used bytes of the filesystem.
+# TYPE disk_used_bytes gauge
+disk_used_bytes{mount="/",fs_type="xfs"} 1e+12
+disk_used_bytes{mount="/mnt/odd \"name\"\\\n",fs_type="nfs"} 1
+disk_used_bytes{mount="/var",fs_type="ext4"} 2048
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := map[string]string{
+		"disk_used_bytes": "disk_used_bytes",
+		"fs-type":         "fs_type",
+		"9lives":          "_9lives",
+		"a.b/c":           "a_b_c",
+		"":                "_",
+	}
+	for in, want := range tests {
+		if got := SanitizeName(in); got != want {
+			t.Errorf("SanitizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package notify sends alerts to where people see them: a generic webhook,
+// a Slack incoming webhook or email. Notifiers are configured in YAML,
+//
+//	notifiers:
+//	  - type: slack
+//	    url: https://hooks.slack.com/services/...
+//	  - type: webhook
+//	    url: https://alerts.example.com/disk
+//	  - type: smtp
+//	    addr: smtp.example.com:587
+//	    from: disk@example.com
+//	    to: [ops@example.com]
+//	    username: disk@example.com
+//	    password_env: SMTP_PASSWORD
+//
+// and built with New. Deciding when to send, debouncing and resolving is
+// up to the caller.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of an Alert.
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Alert is one alert starting or ending.
+type Alert struct {
+	State   string    `json:"state"`
+	Mount   string    `json:"mount"`
+	Rule    string    `json:"rule"`
+	Host    string    `json:"host,omitempty"`
+	Message string    `json:"message"`
+	Since   time.Time `json:"since"`
+	Time    time.Time `json:"time"`
+	// TopWriters are the processes writing the most when the alert fired,
+	// when the caller sampled them.
+	TopWriters []string `json:"top_writers,omitempty"`
+}
+
+// Text is the alert as one line, for chat and mail subjects.
+func (a Alert) Text() string {
+	prefix := "[FIRING]"
+	if a.State == StateResolved {
+		prefix = "[RESOLVED]"
+	}
+	if a.Host != "" {
+		prefix += " " + a.Host
+	}
+	return fmt.Sprintf("%s %s %s: %s", prefix, a.Mount, a.Rule, a.Message)
+}
+
+// Notifier delivers alerts.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Config is one notifier of the YAML config. URL is used by webhook and
+// slack, the rest by smtp. The SMTP password is read from the environment
+// variable PasswordEnv so it stays out of the file.
+type Config struct {
+	Type        string   `yaml:"type"`
+	URL         string   `yaml:"url"`
+	Addr        string   `yaml:"addr"`
+	From        string   `yaml:"from"`
+	To          []string `yaml:"to"`
+	Username    string   `yaml:"username"`
+	PasswordEnv string   `yaml:"password_env"`
+}
+
+// New builds the notifier c describes.
+func New(c Config) (Notifier, error) {
+	switch c.Type {
+	case "webhook", "slack":
+		if c.URL == "" {
+			return nil, fmt.Errorf("%s notifier needs a url", c.Type)
+		}
+		if c.Type == "slack" {
+			return Slack{URL: c.URL}, nil
+		}
+		return Webhook{URL: c.URL}, nil
+	case "smtp":
+		if c.Addr == "" || c.From == "" || len(c.To) == 0 {
+			return nil, errors.New("smtp notifier needs addr, from and to")
+		}
+		if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+			return nil, fmt.Errorf("smtp notifier: addr %q: %w", c.Addr, err)
+		}
+		m := SMTP{Addr: c.Addr, From: c.From, To: c.To, Username: c.Username}
+		if c.PasswordEnv != "" {
+			var ok bool
+			if m.Password, ok = os.LookupEnv(c.PasswordEnv); !ok {
+				return nil, fmt.Errorf("smtp notifier: %s is not set", c.PasswordEnv)
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q, want webhook, slack or smtp", c.Type)
+}
+
+// Webhook POSTs the alert as JSON.
+type Webhook struct {
+	URL string
+}
+
+func (w Webhook) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return post(ctx, w.URL, body)
+}
+
+// Slack posts the alert's Text to an incoming webhook, and its top
+// writers on a second line.
+type Slack struct {
+	URL string
+}
+
+func (s Slack) Notify(ctx context.Context, a Alert) error {
+	text := a.Text()
+	if len(a.TopWriters) > 0 {
+		text += "\ntop writers: " + strings.Join(a.TopWriters, ", ")
+	}
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return post(ctx, s.URL, body)
+}
+
+func post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("POST %s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// SMTP mails the alert. With a Username it authenticates with PLAIN, which
+// net/smtp only allows over TLS (STARTTLS) or to localhost.
+type SMTP struct {
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+func (m SMTP) Notify(ctx context.Context, a Alert) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, _ := net.SplitHostPort(m.Addr)
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", a.Text())
+	fmt.Fprintf(&msg, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nmount: %s\r\nrule: %s\r\nstate: %s\r\nsince: %s\r\n",
+		a.Message, a.Mount, a.Rule, a.State, a.Since.Format(time.RFC3339))
+	if len(a.TopWriters) > 0 {
+		fmt.Fprintf(&msg, "top writers: %s\r\n", strings.Join(a.TopWriters, ", "))
+	}
+
+	// net/smtp has no context, the send runs on until the server answers
+	// but the caller doesn't wait for it past its deadline
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(m.Addr, auth, m.From, m.To, msg.Bytes()) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SendAll sends a through every notifier at once and returns their errors
+// joined.
+func SendAll(ctx context.Context, notifiers []Notifier, a Alert) error {
+	errs := make([]error, len(notifiers))
+	var wg sync.WaitGroup
+	for i, n := range notifiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = n.Notify(ctx, a)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
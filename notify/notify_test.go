@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendAll(t *testing.T) {
+	var webhook Alert
+	var slack map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch r.URL.Path {
+		case "/webhook":
+			err = json.NewDecoder(r.Body).Decode(&webhook)
+		case "/slack":
+			err = json.NewDecoder(r.Body).Decode(&slack)
+		default:
+			http.Error(w, "no such hook", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	var notifiers []Notifier
+	for _, c := range []Config{
+		{Type: "webhook", URL: srv.URL + "/webhook"},
+		{Type: "slack", URL: srv.URL + "/slack"},
+	} {
+		n, err := New(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	a := Alert{State: StateResolved, Mount: "/var", Rule: "var-full", Message: "use 80%", Time: time.Now()}
+	if err := SendAll(context.Background(), notifiers, a); err != nil {
+		t.Fatal(err)
+	}
+	if webhook.Mount != "/var" || webhook.State != StateResolved {
+		t.Errorf("webhook got %+v", webhook)
+	}
+	if want := "[RESOLVED] /var var-full: use 80%"; slack["text"] != want {
+		t.Errorf("slack got %q, want %q", slack["text"], want)
+	}
+
+	a = Alert{State: StateFiring, Mount: "/var", Rule: "var-full", Message: "use 95%", TopWriters: []string{"postgres[812] 40M/s"}, Time: time.Now()}
+	if err := SendAll(context.Background(), notifiers, a); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.TopWriters) != 1 {
+		t.Errorf("webhook got top writers %q", webhook.TopWriters)
+	}
+	if want := "[FIRING] /var var-full: use 95%\ntop writers: postgres[812] 40M/s"; slack["text"] != want {
+		t.Errorf("slack got %q, want %q", slack["text"], want)
+	}
+
+	err := SendAll(context.Background(), []Notifier{Webhook{URL: srv.URL + "/gone"}}, a)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got %v, want a 404 error", err)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, c := range []Config{
+		{Type: "pager"},
+		{Type: "slack"},
+		{Type: "smtp", Addr: "smtp.example.com", From: "a@example.com", To: []string{"b@example.com"}},
+		{Type: "smtp", Addr: "smtp.example.com:25", From: "a@example.com"},
+		{Type: "smtp", Addr: "smtp.example.com:25", From: "a@example.com", To: []string{"b@example.com"}, PasswordEnv: "NOTIFY_TEST_UNSET"},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+}
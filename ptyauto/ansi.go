@@ -0,0 +1,60 @@
+package ptyauto
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ansiSeq matches CSI sequences (colors, cursor movement), OSC sequences
+// (window titles, hyperlinks) and the remaining two byte escapes.
+var ansiSeq = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// StripANSI removes terminal escape sequences and the carriage returns of
+// \r\n line ends, leaving the text a TUI printed.
+func StripANSI(s string) string {
+	return strings.ReplaceAll(ansiSeq.ReplaceAllString(s, ""), "\r\n", "\n")
+}
+
+// StripControl removes C0 control characters and DEL, except newlines and
+// tabs: the carriage returns, backspaces and bells a TUI prints that would
+// otherwise end up inside captured text.
+func StripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if isControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Clean is StripANSI followed by StripControl, the text as a reader would
+// see it.
+func Clean(s string) string {
+	return StripControl(StripANSI(s))
+}
+
+func isControl(r rune) bool {
+	return (r < 0x20 && r != '\n' && r != '\t') || r == 0x7f
+}
+
+// cleanIndexed is Clean keeping, for every byte of the result and one past
+// its end, the offset in s it came from, so a match in the clean text can be
+// mapped back to the raw output.
+func cleanIndexed(s string) (string, []int) {
+	escapes := ansiSeq.FindAllStringIndex(s, -1)
+	clean := make([]byte, 0, len(s))
+	offsets := make([]int, 0, len(s)+1)
+	for i := 0; i < len(s); i++ {
+		if len(escapes) > 0 && i == escapes[0][0] {
+			i = escapes[0][1] - 1
+			escapes = escapes[1:]
+			continue
+		}
+		if isControl(rune(s[i])) {
+			continue
+		}
+		clean = append(clean, s[i])
+		offsets = append(offsets, i)
+	}
+	return string(clean), append(offsets, len(s))
+}
@@ -0,0 +1,260 @@
+package ptyauto
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// CastHeader is the first line of an asciicast v2 file, the format
+// asciinema records and plays:
+// https://docs.asciinema.org/manual/asciicast/v2/
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// CastEvent is one line after the header: what happened Time seconds into
+// the recording. Type "o" is output, the only kind Recorder writes and
+// ReplayPTY plays.
+type CastEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// Cast is a recording read with ReadCast.
+type Cast struct {
+	Header CastHeader
+	Events []CastEvent
+}
+
+// Size is the terminal size of the recording, DefaultRows x DefaultCols
+// when the header doesn't have it.
+func (c *Cast) Size() (rows, cols int) {
+	if c.Header.Height <= 0 || c.Header.Width <= 0 {
+		return DefaultRows, DefaultCols
+	}
+	return c.Header.Height, c.Header.Width
+}
+
+// ReadCast reads an asciicast v2 recording.
+func ReadCast(r io.Reader) (*Cast, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("empty cast")
+	}
+	var c Cast
+	if err := json.Unmarshal(sc.Bytes(), &c.Header); err != nil {
+		return nil, fmt.Errorf("cast header: %w", err)
+	}
+	if c.Header.Version != 2 {
+		return nil, fmt.Errorf("cast version %d, only 2 is supported", c.Header.Version)
+	}
+
+	for line := 2; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var fields []any
+		if err := json.Unmarshal(sc.Bytes(), &fields); err != nil {
+			return nil, fmt.Errorf("cast line %d: %w", line, err)
+		}
+		var e CastEvent
+		var ok1, ok2, ok3 bool
+		if len(fields) == 3 {
+			e.Time, ok1 = fields[0].(float64)
+			e.Type, ok2 = fields[1].(string)
+			e.Data, ok3 = fields[2].(string)
+		}
+		if !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("cast line %d: want [time, type, data]", line)
+		}
+		c.Events = append(c.Events, e)
+	}
+	return &c, sc.Err()
+}
+
+// LoadCast reads the recording at path.
+func LoadCast(path string) (*Cast, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := ReadCast(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Recorder writes a program's output as an asciicast v2 recording, one
+// event per Write. Use it as or next to a Session's Echo. Input is not
+// recorded, it can be a secret; the output is, as printed.
+type Recorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	start   time.Time
+	partial []byte // an incomplete UTF-8 sequence held for the next write
+	err     error
+}
+
+// NewRecorder writes the header for a rows x cols terminal to w and
+// returns a Recorder timing events from now.
+func NewRecorder(w io.Writer, rows, cols int) (*Recorder, error) {
+	start := time.Now()
+	h := CastHeader{Version: 2, Width: cols, Height: rows, Timestamp: start.Unix()}
+	if term := os.Getenv("TERM"); term != "" {
+		h.Env = map[string]string{"TERM": term}
+	}
+	line, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	return &Recorder{w: w, start: start}, nil
+}
+
+// Write records p as an output event. Events are JSON strings, a rune
+// split across reads is held back until it is complete. It never fails,
+// Err has the first write error.
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return len(p), nil
+	}
+
+	data := append(r.partial, p...)
+	r.partial = nil
+	// back up to the start of a trailing incomplete rune
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				r.partial = append([]byte(nil), data[i:]...)
+				data = data[:i]
+			}
+			break
+		}
+	}
+	if len(data) == 0 {
+		return len(p), nil
+	}
+
+	line, err := json.Marshal([]any{time.Since(r.start).Seconds(), "o", string(data)})
+	if err == nil {
+		_, err = r.w.Write(append(line, '\n'))
+	}
+	r.err = err
+	return len(p), nil
+}
+
+// Err returns the first error writing the recording.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// ReplayPTY is a PTY playing back a Cast: reads return its output events
+// at their recorded times divided by speed, all at once when speed is 0,
+// then io.EOF as if the program exited. Input is discarded, so a scenario
+// run against it sees the same output whatever it sends.
+type ReplayPTY struct {
+	events []CastEvent
+	speed  float64
+	start  time.Time
+	next   int
+	buf    []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	endOnce   sync.Once
+	ended     chan struct{} // closed when the last event was read
+}
+
+// NewReplayPTY returns a ReplayPTY whose clock starts now.
+func NewReplayPTY(c *Cast, speed float64) *ReplayPTY {
+	var events []CastEvent
+	for _, e := range c.Events {
+		if e.Type == "o" {
+			events = append(events, e)
+		}
+	}
+	return &ReplayPTY{events: events, speed: speed, start: time.Now(), closed: make(chan struct{}), ended: make(chan struct{})}
+}
+
+func (p *ReplayPTY) Read(b []byte) (int, error) {
+	for len(p.buf) == 0 {
+		if p.next == len(p.events) {
+			p.endOnce.Do(func() { close(p.ended) })
+			return 0, io.EOF
+		}
+		e := p.events[p.next]
+		if p.speed > 0 {
+			at := p.start.Add(time.Duration(e.Time / p.speed * float64(time.Second)))
+			select {
+			case <-time.After(time.Until(at)):
+			case <-p.closed:
+				return 0, os.ErrClosed
+			}
+		}
+		p.buf = []byte(e.Data)
+		p.next++
+	}
+	select {
+	case <-p.closed:
+		return 0, os.ErrClosed
+	default:
+	}
+	n := copy(b, p.buf)
+	p.buf = p.buf[n:]
+	return n, nil
+}
+
+func (p *ReplayPTY) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (p *ReplayPTY) Setsize(rows, cols uint16) error {
+	return nil
+}
+
+func (p *ReplayPTY) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
+
+// Replay returns a Session reading c, its screen sized like the recording.
+// The end of the recording is the program exiting with code 0, for Expect
+// and WaitExit.
+func Replay(c *Cast, speed float64) *Session {
+	rows, cols := c.Size()
+	p := NewReplayPTY(c, speed)
+	s := newSession(p, nil, rows, cols)
+	s.exited = make(chan struct{})
+	go func() {
+		select {
+		case <-p.ended:
+		case <-p.closed:
+		}
+		close(s.exited)
+	}()
+	return s
+}
@@ -0,0 +1,84 @@
+package ptyauto
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorderRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf, 24, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ✔ split across two reads is recorded as one rune
+	for _, w := range []string{"\x1b[32m\xe2\x9c", "\x94\x1b[0m connected\r\n", "done"} {
+		rec.Write([]byte(w))
+	}
+
+	c, err := ReadCast(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows, cols := c.Size(); rows != 24 || cols != 80 {
+		t.Errorf("size %dx%d, want 24x80", rows, cols)
+	}
+	var out strings.Builder
+	for i, e := range c.Events {
+		if e.Type != "o" || (i > 0 && e.Time < c.Events[i-1].Time) {
+			t.Errorf("event %d: %+v", i, e)
+		}
+		out.WriteString(e.Data)
+	}
+	if want := "\x1b[32m✔\x1b[0m connected\r\ndone"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestReadCastInvalid(t *testing.T) {
+	for _, data := range []string{
+		"",
+		`{"version": 1, "width": 80, "height": 24}`,
+		"{\"version\": 2}\n[0.1, \"o\"]\n",
+	} {
+		if _, err := ReadCast(strings.NewReader(data)); err == nil {
+			t.Errorf("ReadCast(%q) succeeded", data)
+		}
+	}
+}
+
+// The figma flow against a recording of claude's /mcp screens, the list
+// repainted in place as the servers connect.
+func TestReplayFigma(t *testing.T) {
+	c, err := LoadCast("testdata/mcp-figma.cast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Replay(c, 20)
+	defer s.Close()
+
+	instant := &Settle{Kind: SettleInstant}
+	sc := &Scenario{Timeout: 2 * time.Second, Steps: []Step{
+		{WaitFor: "/mcp"},
+		{WaitForRegexp: `figma\W+△ (needs authentication)`, OnScreen: true, Capture: "state", Settle: instant, Send: "2"},
+		{WaitFor: "Authenticate", Settle: instant, Send: "1"},
+		{WaitFor: "state=", WaitStable: 100 * time.Millisecond},
+	}}
+	results, err := sc.RunSteps(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Captures(results)["state"]; got != "needs authentication" {
+		t.Errorf("captured state %q", got)
+	}
+	url, err := ExtractURL(s.Text(), "figma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://www.figma.com/oauth/mcp?client_id=abc&redirect_uri=http%3A%2F%2Flocalhost%3A51234%2Fcallback&state=s3cr3t"; url != want {
+		t.Errorf("got %s, want %s", url, want)
+	}
+}
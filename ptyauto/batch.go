@@ -0,0 +1,104 @@
+package ptyauto
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Result is how one scenario of a RunAll batch went. Captures are values
+// the run pulled out of the output, by the steps' capture names.
+type Result struct {
+	Steps    []StepResult
+	Captures map[string]string
+	Output   string
+	Err      error
+}
+
+// Size of the pty RunAll starts programs on.
+const DefaultRows, DefaultCols = 40, 120
+
+// RunAll runs every scenario in its own pty, at most concurrency at a time,
+// and returns the results in the order of scenarios. Every session keeps
+// its output to itself (Result.Output, nothing is echoed) and logs through
+// the default logger tagged with session=<index in scenarios>. Unless a
+// step captured "url", the first https URL a session printed is.
+func RunAll(scenarios []*Scenario, concurrency int) []Result {
+	return RunAllFunc(scenarios, concurrency, func(i int, sc *Scenario) Result {
+		return sc.runIsolated(slog.Default().With("session", i))
+	})
+}
+
+// RunAllFunc is RunAll for callers that start the programs themselves, e.g.
+// to echo every session with a prefix or apply a deadline: it calls run for
+// every scenario, at most concurrency at a time.
+func RunAllFunc(scenarios []*Scenario, concurrency int, run func(i int, sc *Scenario) Result) []Result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]Result, len(scenarios))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, sc := range scenarios {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i] = run(i, sc)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (sc *Scenario) runIsolated(log *slog.Logger) (r Result) {
+	s, cmd, err := sc.Start(DefaultRows, DefaultCols, nil)
+	if err != nil {
+		r.Err = fmt.Errorf("starting %s: %w", sc.Cmd, err)
+		return r
+	}
+	s.Log = log
+	defer func() {
+		s.Close()
+		cmd.Process.Kill()
+		s.WaitExit(5 * time.Second)
+		r.Output = s.Output()
+	}()
+
+	r.Steps, r.Err = sc.RunSteps(context.Background(), s)
+	r.Captures = Captures(r.Steps)
+	if r.Err != nil {
+		log.Error("scenario failed", "cmd", sc.Cmd, "err", r.Err)
+		return r
+	}
+	if _, ok := r.Captures["url"]; !ok {
+		if url, _ := ExtractURL(s.Text(), ""); url != "" {
+			r.Captures["url"] = url
+		}
+	}
+	return r
+}
+
+// Start starts sc's command on a new pty of rows x cols, its output copied
+// to echo if that isn't nil, and returns a session that reaps it.
+func (sc *Scenario) Start(rows, cols uint16, echo io.Writer) (*Session, *exec.Cmd, error) {
+	cmd := exec.Command(sc.Cmd, sc.Args...)
+	cmd.Dir = sc.Dir
+	cmd.Env = append(os.Environ(), sc.Env...)
+
+	p, err := StartPTY(cmd, rows, cols)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := newSession(p, echo, int(rows), int(cols))
+	s.WatchProcess(cmd)
+	return s, cmd, nil
+}
@@ -0,0 +1,29 @@
+package ptyauto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunAll(t *testing.T) {
+	scenarios := []*Scenario{
+		{Cmd: "sh", Args: []string{"-c", "echo ready; read x; echo https://example.com/auth?code=$x"},
+			Steps: []Step{{WaitFor: "ready", Send: "one\r"}, {WaitFor: "https://", WaitStable: 100 * time.Millisecond}}},
+		{Cmd: "sh", Args: []string{"-c", "echo nothing here"},
+			Steps: []Step{{WaitFor: "never", Timeout: 200 * time.Millisecond}}},
+	}
+
+	results := RunAll(scenarios, 2)
+	if len(results) != 2 {
+		t.Fatalf("got %d results", len(results))
+	}
+	if err := results[0].Err; err != nil {
+		t.Fatal(err)
+	}
+	if url := results[0].Captures["url"]; url != "https://example.com/auth?code=one" {
+		t.Errorf("captured %q", url)
+	}
+	if results[1].Err == nil {
+		t.Error("second scenario should time out")
+	}
+}
@@ -0,0 +1,13 @@
+// Package ptyauto drives interactive terminal programs through a pty, in the
+// style of expect: wait for text with Session.Expect or Session.ExpectRegexp,
+// type with Session.Send. Matching sees the output across reads, every wait
+// has its own timeout and fails early with ErrExited when the program quits
+// first.
+//
+// Output is matched as a stream with escape sequences removed. TUIs that
+// redraw in place are easier to match on the Screen, the text a terminal
+// would show now, with Session.ExpectScreen or a step's onScreen.
+//
+// A Session runs on anything implementing PTY, StartPTY gives the real one.
+// Scenario loads a list of steps from YAML.
+package ptyauto
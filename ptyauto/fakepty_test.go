@@ -0,0 +1,82 @@
+package ptyauto
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// fakePTY is an in-memory PTY for driving a Session without a real child.
+// Feed plays the child's output, Written returns what the Session typed.
+type fakePTY struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+
+	// OnWrite, if set, is called with every write so a fake can answer
+	// input, e.g. by calling Feed.
+	OnWrite func(p []byte)
+
+	mu      sync.Mutex
+	written bytes.Buffer
+	rows    uint16
+	cols    uint16
+}
+
+// newFakePTY returns a fakePTY with no output yet.
+func newFakePTY() *fakePTY {
+	r, w := io.Pipe()
+	return &fakePTY{r: r, w: w}
+}
+
+// Feed makes s readable as child output. It blocks until the Session read it.
+func (f *fakePTY) Feed(s string) error {
+	_, err := io.WriteString(f.w, s)
+	return err
+}
+
+// Exit ends the child's output, like the process exiting.
+func (f *fakePTY) Exit() error {
+	return f.w.Close()
+}
+
+// Written returns everything written to the pty so far.
+func (f *fakePTY) Written() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.written.String()
+}
+
+// Size returns the last size set with Setsize.
+func (f *fakePTY) Size() (rows, cols uint16) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rows, f.cols
+}
+
+func (f *fakePTY) Read(p []byte) (int, error) {
+	return f.r.Read(p)
+}
+
+func (f *fakePTY) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	f.written.Write(p)
+	onWrite := f.OnWrite
+	f.mu.Unlock()
+
+	if onWrite != nil {
+		onWrite(p)
+	}
+	return len(p), nil
+}
+
+func (f *fakePTY) Setsize(rows, cols uint16) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rows, f.cols = rows, cols
+	return nil
+}
+
+func (f *fakePTY) Close() error {
+	f.w.Close()
+	return f.r.Close()
+}
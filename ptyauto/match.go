@@ -0,0 +1,74 @@
+package ptyauto
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MatchOptions loosen how Expect compares text, the zero value is an exact
+// match.
+type MatchOptions struct {
+	IgnoreCase bool
+	// CollapseSpace lets any whitespace in the pattern match any run of
+	// whitespace in the output, TUIs pad labels to line them up.
+	CollapseSpace bool
+}
+
+// matcher finds a pattern in output and returns where the match starts and
+// ends, or -1, -1.
+type matcher struct {
+	desc string
+	find func(out string) (start, end int)
+}
+
+func literal(pattern string) matcher {
+	return matcher{
+		desc: strconv.Quote(pattern),
+		find: func(out string) (int, int) {
+			i := strings.Index(out, pattern)
+			if i < 0 {
+				return -1, -1
+			}
+			return i, i + len(pattern)
+		},
+	}
+}
+
+func (o MatchOptions) matcher(pattern string) matcher {
+	if !o.IgnoreCase && !o.CollapseSpace {
+		return literal(pattern)
+	}
+
+	expr := regexp.QuoteMeta(pattern)
+	if o.CollapseSpace {
+		words := strings.Fields(pattern)
+		for i, w := range words {
+			words[i] = regexp.QuoteMeta(w)
+		}
+		expr = strings.Join(words, `\s+`)
+	}
+	if o.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re := regexp.MustCompile(expr)
+
+	return matcher{
+		desc: strconv.Quote(pattern),
+		find: func(out string) (int, int) {
+			loc := re.FindStringIndex(out)
+			if loc == nil {
+				return -1, -1
+			}
+			return loc[0], loc[1]
+		},
+	}
+}
+
+func describe(matchers []matcher) string {
+	d := make([]string, len(matchers))
+	for i, m := range matchers {
+		d[i] = m.desc
+	}
+	return strings.Join(d, " or ")
+}
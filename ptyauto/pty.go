@@ -0,0 +1,33 @@
+package ptyauto
+
+import (
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// PTY is the terminal a Session talks to. ptyFile is the real one backed by
+// creack/pty, the tests use an in-memory double.
+type PTY interface {
+	io.ReadWriteCloser
+	Setsize(rows, cols uint16) error
+}
+
+type ptyFile struct {
+	*os.File
+}
+
+func (p ptyFile) Setsize(rows, cols uint16) error {
+	return pty.Setsize(p.File, &pty.Winsize{Rows: rows, Cols: cols})
+}
+
+// StartPTY starts cmd attached to a new pty of the given size.
+func StartPTY(cmd *exec.Cmd, rows, cols uint16) (PTY, error) {
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Rows: rows, Cols: cols})
+	if err != nil {
+		return nil, err
+	}
+	return ptyFile{f}, nil
+}
@@ -0,0 +1,193 @@
+package ptyauto
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"sync"
+)
+
+// defaultRedactions cover what auth flows tend to print. When a pattern has
+// a capture group only the group is masked, so "token=***" stays readable.
+var defaultRedactions = []string{
+	`(?i)\bbearer\s+([A-Za-z0-9\-._~+/]+=*)`,
+	`(?i)\b(?:code|token|access_token|refresh_token|id_token|client_secret|password)=([^&\s"']+)`,
+}
+
+// Redactor masks secrets matching a list of regexps with ***, and the
+// secrets it was given with AddSecret wherever they show up.
+type Redactor struct {
+	patterns []*regexp.Regexp
+
+	mu      sync.RWMutex
+	secrets [][]byte
+}
+
+// NewRedactor compiles the default patterns plus extra.
+func NewRedactor(extra []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, p := range append(defaultRedactions, extra...) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// AddSecret masks secret from now on. The Redactor keeps a copy, secret
+// can be wiped after.
+func (r *Redactor) AddSecret(secret []byte) {
+	if len(secret) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, known := range r.secrets {
+		if bytes.Equal(known, secret) {
+			return
+		}
+	}
+	r.secrets = append(r.secrets, bytes.Clone(secret))
+}
+
+// maskSecrets returns s with the secrets of AddSecret masked.
+func (r *Redactor) maskSecrets(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.secrets) == 0 {
+		return s
+	}
+	b := []byte(s)
+	for _, secret := range r.secrets {
+		b = bytes.ReplaceAll(b, secret, []byte("***"))
+	}
+	return string(b)
+}
+
+// Redact returns s with every match masked.
+func (r *Redactor) Redact(s string) string {
+	// before the patterns, which could mask part of a secret and leave
+	// the rest
+	s = r.maskSecrets(s)
+	for _, re := range r.patterns {
+		matches := re.FindAllStringSubmatchIndex(s, -1)
+		if matches == nil {
+			continue
+		}
+
+		out := make([]byte, 0, len(s))
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if len(m) >= 4 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			out = append(out, s[last:start]...)
+			out = append(out, "***"...)
+			last = end
+		}
+		s = string(append(out, s[last:]...))
+	}
+	return s
+}
+
+// Writer wraps w so everything written to it is redacted first. Each write
+// is redacted on its own, a secret split across two writes can slip through.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return redactWriter{w: w, r: r}
+}
+
+// SecretWriter is Writer that only masks the secrets of AddSecret, for
+// recordings that should otherwise keep the output as printed.
+func (r *Redactor) SecretWriter(w io.Writer) io.Writer {
+	return redactWriter{w: w, r: r, secretsOnly: true}
+}
+
+type redactWriter struct {
+	w           io.Writer
+	r           *Redactor
+	secretsOnly bool
+}
+
+func (rw redactWriter) Write(p []byte) (int, error) {
+	redact := rw.r.Redact
+	if rw.secretsOnly {
+		redact = rw.r.maskSecrets
+	}
+	if _, err := io.WriteString(rw.w, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Unredacted logs value as is. Use it for the few values the caller really
+// needs to see, like the captured auth URL.
+func Unredacted(key, value string) slog.Attr {
+	return slog.Any(key, unredacted(value))
+}
+
+type unredacted string
+
+func (u unredacted) String() string { return string(u) }
+
+// Handler wraps h so log messages and attributes are redacted.
+func (r *Redactor) Handler(h slog.Handler) slog.Handler {
+	return redactHandler{inner: h, r: r}
+}
+
+type redactHandler struct {
+	inner slog.Handler
+	r     *Redactor
+}
+
+func (h redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h redactHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, h.r.Redact(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.redactAttr(a))
+		return true
+	})
+	return h.inner.Handle(ctx, out)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactAttr(a)
+	}
+	return redactHandler{inner: h.inner.WithAttrs(redacted), r: h.r}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{inner: h.inner.WithGroup(name), r: h.r}
+}
+
+func (h redactHandler) redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.r.Redact(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		redacted := make([]any, len(group))
+		for i, ga := range group {
+			redacted[i] = h.redactAttr(ga)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case unredacted:
+			return slog.String(a.Key, string(x))
+		case error:
+			return slog.String(a.Key, h.r.Redact(x.Error()))
+		}
+	}
+	return a
+}
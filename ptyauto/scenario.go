@@ -0,0 +1,538 @@
+package ptyauto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultStepTimeout = 30 * time.Second
+
+// Scenario is an automation script loaded from YAML (or JSON, which YAML
+// reads too): the program to start and the steps to drive it with.
+type Scenario struct {
+	Cmd  string   `yaml:"cmd"`
+	Args []string `yaml:"args"`
+	Dir  string   `yaml:"dir"`
+	Env  []string `yaml:"env"`
+	// Redact lists extra regexps masked in output and logs.
+	Redact []string `yaml:"redact"`
+	// MatchTimeoutAction is what happens when an optional step's waitFor
+	// times out: "skip" (the default) goes on with the next step, "fail"
+	// fails like any other step.
+	MatchTimeoutAction string `yaml:"matchTimeoutAction"`
+	// Settle is how steps that send let the program settle first, unless
+	// they set their own or use waitStable or sleep. DefaultSettle if nil.
+	Settle *Settle `yaml:"settle"`
+	// Timeout is the timeout of steps that don't set their own, 30s when
+	// 0. -timeout overrides it.
+	Timeout time.Duration `yaml:"timeout"`
+	Steps   []Step        `yaml:"steps"`
+}
+
+// Values of MatchTimeoutAction.
+const (
+	MatchTimeoutSkip = "skip"
+	MatchTimeoutFail = "fail"
+)
+
+// errSkipped is how an optional step that didn't match reports back.
+var errSkipped = errors.New("optional step skipped")
+
+// errFinished is how a step whose FinishIf matched reports back.
+var errFinished = errors.New("finished early")
+
+// defaultRetryBackoff is the RetryBackoff of steps with Retries.
+const defaultRetryBackoff = 2 * time.Second
+
+// Step does, in order, whatever of its fields are set: wait for text, wait
+// for the screen to settle, sleep, settle and send input, then wait for the
+// program to exit.
+type Step struct {
+	WaitFor string `yaml:"waitFor"`
+	// WaitForRegexp waits for a regular expression instead. Capture keeps
+	// what it matched under that name, its first group if it has one, e.g.
+	// a device code or a URL.
+	WaitForRegexp string `yaml:"waitForRegexp"`
+	Capture       string `yaml:"capture"`
+	// OnScreen matches WaitFor or WaitForRegexp against the rendered Screen
+	// instead of the output stream, for TUIs that redraw in place. Capture
+	// then takes the value from the screen too.
+	OnScreen bool `yaml:"onScreen"`
+	// IgnoreCase and CollapseSpace loosen how WaitFor matches.
+	IgnoreCase    bool          `yaml:"ignoreCase"`
+	CollapseSpace bool          `yaml:"collapseSpace"`
+	WaitStable    time.Duration `yaml:"waitStable"`
+	Sleep         time.Duration `yaml:"sleep"`
+	Send          string        `yaml:"send"`
+	// SendSecret types a password or token right before Send (which can
+	// be just "\r"). It is read when the step runs from an environment
+	// variable, $VAR or ${VAR}, or a file, file:/path without its trailing
+	// newline, so it is never in the scenario. It is masked in the echo,
+	// logs, transcripts and recordings, and wiped from memory once typed.
+	SendSecret string `yaml:"sendSecret"`
+	// Settle overrides the scenario's Settle before Send and SendSecret.
+	Settle *Settle `yaml:"settle"`
+	// ConfirmEcho waits for Send to be echoed back before the next step.
+	ConfirmEcho bool `yaml:"confirmEcho"`
+	// RetryIfEchoed re-sends up to this many times when Send shows up as
+	// text on the next settled screen, i.e. it was typed too early and the
+	// program printed it instead of acting on it. Only for input that
+	// wouldn't appear on that screen anyway.
+	RetryIfEchoed int           `yaml:"retryIfEchoed"`
+	Timeout       time.Duration `yaml:"timeout"`
+	// Optional steps wait for a screen that only sometimes appears, when
+	// WaitFor or WaitForRegexp times out the rest of the step is skipped.
+	Optional bool `yaml:"optional"`
+	// FinishIf is a regular expression for the screen that shows there is
+	// nothing left to do, e.g. a server that is already authenticated.
+	// When it matches before WaitFor or WaitForRegexp the scenario ends
+	// there, successfully.
+	FinishIf string `yaml:"finishIf"`
+	// Retries runs a step that timed out again, up to this many times,
+	// waiting RetryBackoff (2s by default) times the retry number first.
+	// Other failures, like the program exiting, are not retried.
+	Retries      int           `yaml:"retries"`
+	RetryBackoff time.Duration `yaml:"retryBackoff"`
+	// WaitExit, only allowed on the last step, waits for the program to
+	// quit after everything else in the step.
+	WaitExit *WaitExit `yaml:"waitExit"`
+}
+
+// WaitExit fails the step unless the program exits with ExpectCode within
+// Timeout (the step's timeout when 0).
+type WaitExit struct {
+	ExpectCode int           `yaml:"expectCode"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// LoadScenario reads a scenario file. Unknown fields, steps that do nothing
+// and bad redact patterns are errors here rather than surprises mid-run.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := ParseScenario(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sc, nil
+}
+
+// ParseScenario is LoadScenario for a scenario already in memory, e.g. one
+// embedded in the binary.
+func ParseScenario(data []byte) (*Scenario, error) {
+	var sc Scenario
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&sc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if err := sc.validate(); err != nil {
+		return nil, err
+	}
+	if err := sc.expandEnv(); err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+func (sc *Scenario) validate() error {
+	if sc.Cmd == "" {
+		return errors.New("cmd is required")
+	}
+	if err := sc.validateAction(); err != nil {
+		return err
+	}
+	if sc.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	for _, pattern := range sc.Redact {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("redact: %w", err)
+		}
+	}
+	required := 0
+	for i, step := range sc.Steps {
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+		if step.WaitExit != nil && i != len(sc.Steps)-1 {
+			return fmt.Errorf("step %d: waitExit must be the last step", i+1)
+		}
+		if !step.Optional {
+			required++
+		}
+	}
+	if len(sc.Steps) > 0 && required == 0 {
+		return errors.New("all steps are optional, the scenario could pass without doing anything")
+	}
+	return nil
+}
+
+func (sc *Scenario) validateAction() error {
+	switch sc.MatchTimeoutAction {
+	case "", MatchTimeoutSkip, MatchTimeoutFail:
+		return nil
+	}
+	return fmt.Errorf("matchTimeoutAction %q, want skip or fail", sc.MatchTimeoutAction)
+}
+
+func (step Step) validate() error {
+	if step.WaitFor == "" && step.WaitForRegexp == "" && step.WaitStable == 0 && step.Sleep == 0 && step.Send == "" && step.SendSecret == "" && step.WaitExit == nil {
+		return errors.New("needs at least one of waitFor, waitForRegexp, waitStable, sleep, send, sendSecret or waitExit")
+	}
+	if step.SendSecret != "" {
+		if _, err := parseSecretRef(step.SendSecret); err != nil {
+			return err
+		}
+	}
+	if step.WaitFor != "" && step.WaitForRegexp != "" {
+		return errors.New("waitFor and waitForRegexp can't both be set")
+	}
+	if step.WaitForRegexp != "" {
+		if _, err := regexp.Compile(step.WaitForRegexp); err != nil {
+			return fmt.Errorf("waitForRegexp: %w", err)
+		}
+	}
+	if step.Capture != "" && step.WaitForRegexp == "" {
+		return errors.New("capture needs waitForRegexp")
+	}
+	if step.Optional && step.WaitFor == "" && step.WaitForRegexp == "" {
+		return errors.New("optional needs waitFor or waitForRegexp")
+	}
+	if step.OnScreen && step.WaitFor == "" && step.WaitForRegexp == "" {
+		return errors.New("onScreen needs waitFor or waitForRegexp")
+	}
+	if step.FinishIf != "" {
+		if step.WaitFor == "" && step.WaitForRegexp == "" {
+			return errors.New("finishIf needs waitFor or waitForRegexp")
+		}
+		if _, err := regexp.Compile(step.FinishIf); err != nil {
+			return fmt.Errorf("finishIf: %w", err)
+		}
+	}
+	switch {
+	case step.Retries < 0:
+		return errors.New("retries must not be negative")
+	case step.Retries > 0 && step.Optional:
+		return errors.New("an optional step is skipped when it times out, it can't have retries")
+	case step.RetryBackoff != 0 && step.Retries == 0:
+		return errors.New("retryBackoff needs retries")
+	}
+	if (step.IgnoreCase || step.CollapseSpace) && step.WaitFor == "" {
+		return errors.New("ignoreCase and collapseSpace need waitFor")
+	}
+	if step.ConfirmEcho && step.Send == "" {
+		return errors.New("confirmEcho without send")
+	}
+	if step.Settle != nil && step.Send == "" && step.SendSecret == "" {
+		return errors.New("settle needs send or sendSecret")
+	}
+	if step.RetryIfEchoed != 0 {
+		switch {
+		case step.RetryIfEchoed < 0:
+			return errors.New("retryIfEchoed must not be negative")
+		case step.ConfirmEcho:
+			return errors.New("retryIfEchoed and confirmEcho contradict each other")
+		case strings.TrimSpace(StripControl(step.Send)) == "":
+			return errors.New("retryIfEchoed needs send with printable text")
+		}
+	}
+	if step.WaitStable < 0 || step.Sleep < 0 || step.Timeout < 0 || step.RetryBackoff < 0 {
+		return errors.New("durations must not be negative")
+	}
+	return nil
+}
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} in cmd, args, dir, env and send values with the
+// environment variable, so secrets don't have to live in the scenario file.
+// A referenced variable that is not set is an error, the variable of a
+// sendSecret too, though it is only read when its step runs.
+func (sc *Scenario) expandEnv() error {
+	var missing []string
+	expand := func(s string) string {
+		return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+			name := envRef.FindStringSubmatch(ref)[1]
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return v
+		})
+	}
+
+	sc.Cmd = expand(sc.Cmd)
+	sc.Dir = expand(sc.Dir)
+	for i := range sc.Args {
+		sc.Args[i] = expand(sc.Args[i])
+	}
+	for i := range sc.Env {
+		sc.Env[i] = expand(sc.Env[i])
+	}
+	for i := range sc.Steps {
+		sc.Steps[i].Send = expand(sc.Steps[i].Send)
+		if ref, err := parseSecretRef(sc.Steps[i].SendSecret); err == nil && ref.env != "" {
+			if _, ok := os.LookupEnv(ref.env); !ok {
+				missing = append(missing, ref.env)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// StepResult is how one step of a run went.
+type StepResult struct {
+	Step     int // 1-based
+	Duration time.Duration
+	Err      error
+	// Skipped is set for an optional step whose WaitFor didn't match.
+	Skipped bool
+	// Finished is set for the step whose FinishIf matched, the steps after
+	// it didn't run.
+	Finished bool
+	// Retries is how often the step was run again after timing out.
+	Retries int
+	// Capture is the step's Capture name and Value what it captured.
+	Capture string
+	Value   string
+}
+
+// Captures returns the values the steps of results captured by name.
+func Captures(results []StepResult) map[string]string {
+	captures := map[string]string{}
+	for _, r := range results {
+		if r.Capture != "" && r.Err == nil && !r.Skipped {
+			captures[r.Capture] = r.Value
+		}
+	}
+	return captures
+}
+
+// Run executes the scenario's steps against s. When ctx ends (the
+// -max-runtime cap) the error names the step that was running and the
+// last output.
+func (sc *Scenario) Run(ctx context.Context, s *Session) error {
+	_, err := sc.RunSteps(ctx, s)
+	return err
+}
+
+// RunSteps is Run that also returns a result for every step it got to.
+func (sc *Scenario) RunSteps(ctx context.Context, s *Session) ([]StepResult, error) {
+	skip := sc.MatchTimeoutAction != MatchTimeoutFail
+	settle := DefaultSettle
+	if sc.Settle != nil {
+		settle = *sc.Settle
+	}
+	timeout := sc.Timeout
+	if timeout == 0 {
+		timeout = defaultStepTimeout
+	}
+
+	var results []StepResult
+	for i, step := range sc.Steps {
+		start := time.Now()
+		value, retries, err := step.runWithRetries(ctx, s, skip, settle, timeout, i+1)
+		if errors.Is(err, errSkipped) {
+			s.logger().Info("optional step did not match, skipping it", "step", i+1, "waitFor", step.WaitFor+step.WaitForRegexp)
+			results = append(results, StepResult{Step: i + 1, Duration: time.Since(start), Skipped: true})
+			continue
+		}
+		if errors.Is(err, errFinished) {
+			s.logger().Info("nothing left to do, finishing early", "step", i+1, "finishIf", step.FinishIf)
+			results = append(results, StepResult{Step: i + 1, Duration: time.Since(start), Finished: true, Retries: retries})
+			return results, nil
+		}
+		if ctx.Err() != nil {
+			err = fmt.Errorf("stopped at step %d: %w\nlast output:\n%s", i+1, ctx.Err(), s.Tail(500))
+		} else if err != nil {
+			err = fmt.Errorf("step %d: %w", i+1, err)
+		}
+		results = append(results, StepResult{Step: i + 1, Duration: time.Since(start), Err: err, Capture: step.Capture, Value: value, Retries: retries})
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// runWithRetries runs the step, again after a timeout as long as it has
+// retries left, and returns what it captured and how often it retried.
+func (step Step) runWithRetries(ctx context.Context, s *Session, skipOptional bool, settle Settle, timeout time.Duration, n int) (value string, retries int, err error) {
+	backoff := step.RetryBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
+	}
+	for ; ; retries++ {
+		if err := ctx.Err(); err != nil {
+			return "", retries, err
+		}
+		value, err = step.run(ctx, s, skipOptional, settle, timeout)
+		if err == nil || retries == step.Retries || !errors.Is(err, ErrTimeout) {
+			return value, retries, err
+		}
+		wait := time.Duration(retries+1) * backoff
+		s.logger().Info("step timed out, retrying", "step", n, "retry", retries+1, "of", step.Retries, "in", wait, "err", firstLine(err))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", retries, ctx.Err()
+		}
+	}
+}
+
+// firstLine is err without the output errors carry after their first line.
+func firstLine(err error) string {
+	msg, _, _ := strings.Cut(err.Error(), "\n")
+	return msg
+}
+
+// run runs the step and returns what it captured. timeout is used unless
+// the step has its own.
+func (step Step) run(ctx context.Context, s *Session, skipOptional bool, settle Settle, timeout time.Duration) (value string, err error) {
+	if step.Timeout > 0 {
+		timeout = step.Timeout
+	}
+
+	if step.WaitFor != "" || step.WaitForRegexp != "" {
+		var m matcher
+		var groups *[]string
+		if step.WaitFor != "" {
+			m = MatchOptions{IgnoreCase: step.IgnoreCase, CollapseSpace: step.CollapseSpace}.matcher(step.WaitFor)
+		} else if m, groups, err = regexpMatcher(step.WaitForRegexp); err != nil {
+			return "", err
+		}
+		matchers := []matcher{m}
+		if step.FinishIf != "" {
+			finish, _, err := regexpMatcher(step.FinishIf)
+			if err != nil {
+				return "", err
+			}
+			matchers = append(matchers, finish)
+		}
+
+		var match int
+		if step.OnScreen {
+			match, err = s.expectScreen(matchers, timeout)
+		} else {
+			match, err = s.expect(matchers, timeout)
+		}
+		if match == 1 {
+			return "", errFinished
+		}
+		if err == nil && groups != nil {
+			value = (*groups)[min(1, len(*groups)-1)]
+		}
+		if err != nil {
+			if step.Optional && skipOptional && errors.Is(err, ErrTimeout) {
+				return "", errSkipped
+			}
+			return "", err
+		}
+	}
+	if step.WaitStable > 0 {
+		if err := s.WaitStable(step.WaitStable, timeout); err != nil {
+			return value, err
+		}
+	}
+	if step.Sleep > 0 {
+		select {
+		case <-time.After(step.Sleep):
+		case <-ctx.Done():
+			return value, ctx.Err()
+		}
+	}
+
+	if step.Send != "" || step.SendSecret != "" {
+		// an explicit waitStable or sleep is the step's own settling
+		if step.Settle != nil {
+			settle = *step.Settle
+		} else if step.WaitStable > 0 || step.Sleep > 0 {
+			settle = Settle{Kind: SettleInstant}
+		}
+		if err := settle.wait(ctx, s, timeout); err != nil {
+			return value, fmt.Errorf("settling (%s): %w", settle, err)
+		}
+		if step.SendSecret != "" {
+			if err := step.sendSecret(s); err != nil {
+				return value, err
+			}
+		}
+		if step.Send != "" {
+			if err := step.send(ctx, s, settle, timeout); err != nil {
+				return value, err
+			}
+		}
+	}
+
+	if step.WaitExit != nil {
+		if step.WaitExit.Timeout > 0 {
+			timeout = step.WaitExit.Timeout
+		}
+		code, err := s.WaitExit(timeout)
+		if err != nil {
+			return value, err
+		}
+		if code != step.WaitExit.ExpectCode {
+			return value, fmt.Errorf("exited with code %d, want %d\n%s", code, step.WaitExit.ExpectCode, s.Tail(500))
+		}
+	}
+	return value, nil
+}
+
+// sendSecret reads step.SendSecret, types it and wipes it.
+func (step Step) sendSecret(s *Session) error {
+	ref, err := parseSecretRef(step.SendSecret)
+	if err != nil {
+		return err
+	}
+	secret, err := ref.read()
+	if err != nil {
+		return err
+	}
+	defer wipe(secret)
+	if err := s.SendSecret(secret); err != nil {
+		return fmt.Errorf("sending %s: %w", ref, err)
+	}
+	return nil
+}
+
+// send types step.Send. With RetryIfEchoed, input that comes back as text
+// is sent again after settling again, the input is never logged.
+func (step Step) send(ctx context.Context, s *Session, settle Settle, timeout time.Duration) error {
+	if step.ConfirmEcho {
+		return s.SendAndConfirm(step.Send, timeout)
+	}
+	if step.RetryIfEchoed == 0 {
+		return s.Send(step.Send)
+	}
+
+	for retry := 0; ; retry++ {
+		consumed, err := s.SendAndCheckConsumed(step.Send, DefaultSettle.Duration, timeout)
+		if err != nil || consumed {
+			return err
+		}
+		if retry == step.RetryIfEchoed {
+			return fmt.Errorf("input still printed as text after %d retries, the program never took it", retry)
+		}
+		s.logger().Info("input was printed as text, typed too early? sending it again", "retry", retry+1, "of", step.RetryIfEchoed)
+		if err := settle.wait(ctx, s, timeout); err != nil {
+			return fmt.Errorf("settling (%s): %w", settle, err)
+		}
+	}
+}
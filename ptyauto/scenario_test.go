@@ -0,0 +1,154 @@
+package ptyauto
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScenario(t *testing.T) {
+	tests := []struct {
+		name, data, wantErr string
+	}{
+		{"yaml", "cmd: gh\nsteps:\n  - waitForRegexp: 'code: (\\S+)'\n    capture: code\n", ""},
+		{"json", `{"cmd": "gh", "steps": [{"waitForRegexp": "code: (\\S+)", "capture": "code"}]}`, ""},
+		{"capture without regexp", "cmd: gh\nsteps:\n  - waitFor: code\n    capture: code\n", "capture needs waitForRegexp"},
+		{"both waits", "cmd: gh\nsteps:\n  - waitFor: a\n    waitForRegexp: b\n", "can't both be set"},
+		{"bad regexp", "cmd: gh\nsteps:\n  - waitForRegexp: '('\n", "waitForRegexp"},
+		{"finishIf without wait", "cmd: gh\nsteps:\n  - send: x\n    finishIf: done\n", "finishIf needs"},
+		{"optional with retries", "cmd: gh\nsteps:\n  - waitFor: a\n    optional: true\n    retries: 1\n  - send: x\n", "can't have retries"},
+		{"literal secret", "cmd: gh\nsteps:\n  - sendSecret: hunter2\n", "want $VAR"},
+		{"secret not set", "cmd: gh\nsteps:\n  - sendSecret: $PTYAUTO_TEST_UNSET\n", "PTYAUTO_TEST_UNSET"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseScenario([]byte(tt.data))
+			if tt.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunStepsCapture(t *testing.T) {
+	s, f := newTestSession(t)
+	go func() {
+		f.Feed("First copy your one-time code: \x1b[1mAB12-CD34\x1b[0m\r\n")
+		f.Feed("Open https://github.com/login/device\r\n")
+	}()
+
+	sc := &Scenario{Steps: []Step{
+		{WaitForRegexp: `one-time code: (\S+)`, Capture: "code"},
+		{WaitForRegexp: `https://\S+`, Capture: "url"},
+		{WaitForRegexp: `never`, Capture: "missing", Optional: true, Timeout: 50 * time.Millisecond},
+	}}
+	results, err := sc.RunSteps(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Captures(results)
+	want := map[string]string{"code": "AB12-CD34", "url": "https://github.com/login/device"}
+	if len(got) != len(want) || got["code"] != want["code"] || got["url"] != want["url"] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRunStepsSendSecret(t *testing.T) {
+	t.Setenv("PTYAUTO_TEST_TOKEN", "tok-123456")
+	file := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(file, []byte("hunter22\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	redactor, err := NewRedactor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var echo bytes.Buffer
+	f := newFakePTY()
+	s := newSession(f, redactor.Writer(&echo), DefaultRows, DefaultCols)
+	s.Redactor = redactor
+	t.Cleanup(func() { s.Close() })
+
+	// the program echoes what it got, like a prompt that isn't hidden
+	go func() {
+		for !strings.HasSuffix(f.Written(), "\r") {
+			time.Sleep(5 * time.Millisecond)
+		}
+		f.Feed("Token: " + f.Written() + "\n")
+		for strings.Count(f.Written(), "\r") < 2 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		f.Feed("Password: hunter22\r\nlogged in\r\n")
+	}()
+
+	instant := &Settle{Kind: SettleInstant}
+	sc := &Scenario{Steps: []Step{
+		{SendSecret: "${PTYAUTO_TEST_TOKEN}", Send: "\r", Settle: instant},
+		{WaitFor: "Token:", SendSecret: "file:" + file, Send: "\r", Settle: instant},
+		{WaitFor: "logged in"},
+	}}
+	if _, err := sc.RunSteps(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	if w := f.Written(); w != "tok-123456\rhunter22\r" {
+		t.Errorf("sent %q", w)
+	}
+	if out := echo.String(); strings.Contains(out, "tok-123456") || strings.Contains(out, "hunter22") || !strings.Contains(out, "Password: ***") {
+		t.Errorf("echo not masked: %q", out)
+	}
+	if got := redactor.Redact("error: hunter22 rejected"); got != "error: *** rejected" {
+		t.Errorf("Redact = %q", got)
+	}
+
+	sc = &Scenario{Steps: []Step{{SendSecret: "file:" + filepath.Join(t.TempDir(), "missing")}}}
+	if err := sc.Run(context.Background(), s); err == nil {
+		t.Error("missing secret file: no error")
+	}
+}
+
+func TestRunStepsFinishIf(t *testing.T) {
+	s, f := newTestSession(t)
+	go f.Feed("  figma · ✔ connected\r\n  linear · △ needs authentication\r\n")
+
+	sc := &Scenario{Steps: []Step{
+		{WaitFor: "figma · △ needs authentication", FinishIf: `figma\W+[✓✔] connected`, Send: "2"},
+		{WaitFor: "Authenticate", Send: "1"},
+	}}
+	results, err := sc.RunSteps(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Finished {
+		t.Errorf("got %+v, want only the first step, finished", results)
+	}
+	if w := f.Written(); w != "" {
+		t.Errorf("sent %q after finishing", w)
+	}
+}
+
+func TestRunStepsRetries(t *testing.T) {
+	s, f := newTestSession(t)
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		f.Feed("menu\r\n")
+	}()
+
+	sc := &Scenario{Steps: []Step{
+		{WaitFor: "menu", Timeout: 100 * time.Millisecond, Retries: 2, RetryBackoff: time.Millisecond},
+	}}
+	results, err := sc.RunSteps(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Retries != 1 {
+		t.Errorf("got %d retries, want 1", results[0].Retries)
+	}
+}
@@ -0,0 +1,403 @@
+package ptyauto
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Screen is a virtual terminal: it applies a program's output the way a
+// terminal would, cursor movement, erasing and redraws included, and keeps
+// the text that is visible now. A TUI repaints a menu many times while it
+// is drawn, in the output stream every repaint is another copy, on the
+// screen there is the one being shown.
+//
+// It understands the VT100/xterm subset TUIs use: cursor movement and
+// save/restore, erasing and inserting, scroll regions and the alternate
+// screen. Colors and other attributes are dropped, every rune takes one
+// cell.
+type Screen struct {
+	rows, cols int
+	cells      [][]rune
+	row, col   int
+	// wrap is set after writing the last column, the next rune goes to the
+	// start of the next line
+	wrap bool
+	// top and bottom are the scroll region, inclusive
+	top, bottom        int
+	savedRow, savedCol int
+	// main is the normal screen while the alternate one is shown
+	main [][]rune
+
+	state   int
+	params  []byte
+	partial []byte // an incomplete UTF-8 sequence at the end of a write
+}
+
+// Parser states.
+const (
+	stGround = iota
+	stEscape
+	stEscapeIntermediate
+	stCSI
+	stOSC
+	stOSCEscape
+)
+
+// NewScreen returns an empty screen of rows x cols.
+func NewScreen(rows, cols int) *Screen {
+	sc := &Screen{}
+	sc.Resize(rows, cols)
+	return sc
+}
+
+// Resize changes the size, keeping the text at the top left.
+func (sc *Screen) Resize(rows, cols int) {
+	rows, cols = max(rows, 1), max(cols, 1)
+	cells := blank(rows, cols)
+	for r := 0; r < min(rows, sc.rows); r++ {
+		copy(cells[r], sc.cells[r])
+	}
+	sc.rows, sc.cols, sc.cells = rows, cols, cells
+	sc.top, sc.bottom = 0, rows-1
+	sc.row, sc.col = min(sc.row, rows-1), min(sc.col, cols-1)
+	sc.main = nil
+}
+
+func blank(rows, cols int) [][]rune {
+	cells := make([][]rune, rows)
+	for r := range cells {
+		cells[r] = blankLine(cols)
+	}
+	return cells
+}
+
+func blankLine(cols int) []rune {
+	line := make([]rune, cols)
+	for i := range line {
+		line[i] = ' '
+	}
+	return line
+}
+
+// String returns the visible text, one line per row with trailing spaces
+// and trailing empty rows removed.
+func (sc *Screen) String() string {
+	lines := make([]string, sc.rows)
+	for r, line := range sc.cells {
+		lines[r] = strings.TrimRight(string(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Write applies output to the screen, it never fails.
+func (sc *Screen) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(sc.partial) > 0 {
+		p = append(sc.partial, p...)
+		sc.partial = nil
+	}
+	for i := 0; i < len(p); {
+		b := p[i]
+		if sc.state != stGround || b < utf8.RuneSelf {
+			sc.byte(b)
+			i++
+			continue
+		}
+		if !utf8.FullRune(p[i:]) {
+			sc.partial = append([]byte(nil), p[i:]...)
+			break
+		}
+		r, size := utf8.DecodeRune(p[i:])
+		sc.put(r)
+		i += size
+	}
+	return n, nil
+}
+
+// byte handles one byte outside of a multibyte rune.
+func (sc *Screen) byte(b byte) {
+	switch sc.state {
+	case stGround:
+		sc.control(b)
+	case stEscape:
+		sc.escape(b)
+	case stEscapeIntermediate:
+		// the charset designated by ESC ( B and the like doesn't matter
+		sc.state = stGround
+	case stCSI:
+		switch {
+		case b >= 0x30 && b <= 0x3f:
+			sc.params = append(sc.params, b)
+		case b >= 0x40 && b <= 0x7e:
+			sc.state = stGround
+			sc.csi(b)
+		case b == 0x1b:
+			sc.state = stEscape
+		}
+	case stOSC:
+		switch b {
+		case 0x07:
+			sc.state = stGround
+		case 0x1b:
+			sc.state = stOSCEscape
+		}
+	case stOSCEscape:
+		// ESC \ ends the OSC, anything else aborts it just the same
+		sc.state = stGround
+		if b != '\\' {
+			sc.byte(b)
+		}
+	}
+}
+
+func (sc *Screen) control(b byte) {
+	switch b {
+	case 0x1b:
+		sc.state = stEscape
+	case '\r':
+		sc.col, sc.wrap = 0, false
+	case '\n', '\v', '\f':
+		sc.lineFeed()
+	case '\b':
+		sc.col, sc.wrap = max(sc.col-1, 0), false
+	case '\t':
+		sc.col = min((sc.col/8+1)*8, sc.cols-1)
+	default:
+		if b >= 0x20 && b != 0x7f {
+			sc.put(rune(b))
+		}
+	}
+}
+
+func (sc *Screen) put(r rune) {
+	if sc.wrap {
+		sc.col = 0
+		sc.lineFeed()
+	}
+	sc.cells[sc.row][sc.col] = r
+	if sc.col == sc.cols-1 {
+		sc.wrap = true
+	} else {
+		sc.col++
+	}
+}
+
+// lineFeed moves down a row, scrolling the region at its bottom.
+func (sc *Screen) lineFeed() {
+	sc.wrap = false
+	if sc.row == sc.bottom {
+		sc.scrollUp(1)
+	} else if sc.row < sc.rows-1 {
+		sc.row++
+	}
+}
+
+func (sc *Screen) reverseLineFeed() {
+	sc.wrap = false
+	if sc.row == sc.top {
+		sc.scrollDown(1)
+	} else if sc.row > 0 {
+		sc.row--
+	}
+}
+
+// scrollUp moves the scroll region's lines up by n, blank lines come in at
+// the bottom.
+func (sc *Screen) scrollUp(n int) {
+	region := sc.cells[sc.top : sc.bottom+1]
+	n = min(n, len(region))
+	copy(region, region[n:])
+	for i := len(region) - n; i < len(region); i++ {
+		region[i] = blankLine(sc.cols)
+	}
+}
+
+func (sc *Screen) scrollDown(n int) {
+	region := sc.cells[sc.top : sc.bottom+1]
+	n = min(n, len(region))
+	copy(region[n:], region)
+	for i := 0; i < n; i++ {
+		region[i] = blankLine(sc.cols)
+	}
+}
+
+func (sc *Screen) escape(b byte) {
+	sc.state = stGround
+	switch b {
+	case '[':
+		sc.state, sc.params = stCSI, sc.params[:0]
+	case ']':
+		sc.state = stOSC
+	case '(', ')', '*', '+', '#', '%':
+		sc.state = stEscapeIntermediate
+	case '7':
+		sc.savedRow, sc.savedCol = sc.row, sc.col
+	case '8':
+		sc.row, sc.col, sc.wrap = sc.savedRow, sc.savedCol, false
+	case 'D':
+		sc.lineFeed()
+	case 'E':
+		sc.col = 0
+		sc.lineFeed()
+	case 'M':
+		sc.reverseLineFeed()
+	case 'c':
+		sc.main = nil
+		sc.cells = blank(sc.rows, sc.cols)
+		sc.row, sc.col, sc.wrap = 0, 0, false
+		sc.top, sc.bottom = 0, sc.rows-1
+	}
+}
+
+// csi runs the control sequence ending in final with the parameters read.
+func (sc *Screen) csi(final byte) {
+	private := len(sc.params) > 0 && sc.params[0] == '?'
+	args := parseParams(sc.params)
+	// arg is parameter i, def when it is missing or 0
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	if private {
+		if final == 'h' || final == 'l' {
+			for _, mode := range args {
+				if mode == 47 || mode == 1047 || mode == 1049 {
+					sc.alternate(final == 'h')
+				}
+			}
+		}
+		return
+	}
+
+	sc.wrap = false
+	switch final {
+	case 'A':
+		sc.row = max(sc.row-arg(0, 1), 0)
+	case 'B', 'e':
+		sc.row = min(sc.row+arg(0, 1), sc.rows-1)
+	case 'C', 'a':
+		sc.col = min(sc.col+arg(0, 1), sc.cols-1)
+	case 'D':
+		sc.col = max(sc.col-arg(0, 1), 0)
+	case 'E':
+		sc.row, sc.col = min(sc.row+arg(0, 1), sc.rows-1), 0
+	case 'F':
+		sc.row, sc.col = max(sc.row-arg(0, 1), 0), 0
+	case 'G', '`':
+		sc.col = min(arg(0, 1), sc.cols) - 1
+	case 'd':
+		sc.row = min(arg(0, 1), sc.rows) - 1
+	case 'H', 'f':
+		sc.row, sc.col = min(arg(0, 1), sc.rows)-1, min(arg(1, 1), sc.cols)-1
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			sc.eraseLine(sc.row, sc.col, sc.cols)
+			sc.eraseRows(sc.row+1, sc.rows)
+		case 1:
+			sc.eraseRows(0, sc.row)
+			sc.eraseLine(sc.row, 0, sc.col+1)
+		default:
+			sc.eraseRows(0, sc.rows)
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			sc.eraseLine(sc.row, sc.col, sc.cols)
+		case 1:
+			sc.eraseLine(sc.row, 0, sc.col+1)
+		default:
+			sc.eraseLine(sc.row, 0, sc.cols)
+		}
+	case 'X':
+		sc.eraseLine(sc.row, sc.col, min(sc.col+arg(0, 1), sc.cols))
+	case 'P':
+		line := sc.cells[sc.row]
+		n := min(arg(0, 1), sc.cols-sc.col)
+		copy(line[sc.col:], line[sc.col+n:])
+		sc.eraseLine(sc.row, sc.cols-n, sc.cols)
+	case '@':
+		line := sc.cells[sc.row]
+		n := min(arg(0, 1), sc.cols-sc.col)
+		copy(line[sc.col+n:], line[sc.col:])
+		sc.eraseLine(sc.row, sc.col, sc.col+n)
+	case 'L', 'M':
+		if sc.row < sc.top || sc.row > sc.bottom {
+			return
+		}
+		top := sc.top
+		sc.top = sc.row
+		if final == 'L' {
+			sc.scrollDown(arg(0, 1))
+		} else {
+			sc.scrollUp(arg(0, 1))
+		}
+		sc.top = top
+		sc.col = 0
+	case 'S':
+		sc.scrollUp(arg(0, 1))
+	case 'T':
+		sc.scrollDown(arg(0, 1))
+	case 'r':
+		top, bottom := arg(0, 1)-1, min(arg(1, sc.rows), sc.rows)-1
+		if top < bottom {
+			sc.top, sc.bottom = top, bottom
+			sc.row, sc.col = 0, 0
+		}
+	case 's':
+		sc.savedRow, sc.savedCol = sc.row, sc.col
+	case 'u':
+		sc.row, sc.col = sc.savedRow, sc.savedCol
+	}
+}
+
+// alternate switches to the alternate screen, blank, or back to the
+// normal one as it was.
+func (sc *Screen) alternate(on bool) {
+	switch {
+	case on && sc.main == nil:
+		sc.main = sc.cells
+		sc.cells = blank(sc.rows, sc.cols)
+		sc.savedRow, sc.savedCol = sc.row, sc.col
+	case !on && sc.main != nil:
+		sc.cells, sc.main = sc.main, nil
+		sc.row, sc.col = sc.savedRow, sc.savedCol
+	}
+}
+
+func (sc *Screen) eraseLine(row, from, to int) {
+	line := sc.cells[row]
+	for i := max(from, 0); i < min(to, len(line)); i++ {
+		line[i] = ' '
+	}
+}
+
+func (sc *Screen) eraseRows(from, to int) {
+	for r := max(from, 0); r < min(to, sc.rows); r++ {
+		sc.cells[r] = blankLine(sc.cols)
+	}
+}
+
+// parseParams splits "1;2" into numbers, a missing one is 0. A leading ?
+// or > marking private modes is skipped.
+func parseParams(params []byte) []int {
+	s := strings.TrimLeft(string(params), "?>=<")
+	if s == "" {
+		return nil
+	}
+	fields := strings.Split(s, ";")
+	args := make([]int, len(fields))
+	for i, f := range fields {
+		// sub parameters (38:2:...) only matter for colors
+		f, _, _ = strings.Cut(f, ":")
+		args[i], _ = strconv.Atoi(f)
+	}
+	return args
+}
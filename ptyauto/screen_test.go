@@ -0,0 +1,95 @@
+package ptyauto
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScreen(t *testing.T) {
+	tests := []struct {
+		name, out, want string
+	}{
+		{"text", "hello\r\nworld", "hello\nworld"},
+		{"carriage return overwrites", "loading...\rdone      ", "done"},
+		{"colors dropped", "\x1b[1;32m✓\x1b[0m Connected", "✓ Connected"},
+		{"cursor up and erase line", "1. Authenticate\r\n2. Cancel\r\n\x1b[2A\x1b[2K❯ 1. Authenticate", "❯ 1. Authenticate\n2. Cancel"},
+		{"home and erase display", "old menu\r\n\x1b[H\x1b[2Jnew menu", "new menu"},
+		{"absolute position", "\x1b[2;5Hx\x1b[1;1Hy", "y\n    x"},
+		{"column", "abcdef\x1b[3Gx", "abxdef"},
+		{"erase to end of line", "abcdef\x1b[4D\x1b[K", "ab"},
+		{"backspace", "ab\bc", "ac"},
+		{"tab", "a\tb", "a       b"},
+		{"wraps at the last column", "abcdefghijklmnopqrstuv", "abcdefghijklmnopqrst\nuv"},
+		{"scrolls", "1\r\n2\r\n3\r\n4\r\n5", "2\n3\n4\n5"},
+		{"delete characters", "abcdef\x1b[1;2H\x1b[2P", "adef"},
+		{"insert characters", "abc\x1b[1;2H\x1b[2@", "a  bc"},
+		{"save and restore", "\x1b7abc\x1b8x", "xbc"},
+		{"osc title skipped", "\x1b]0;title\x07text\x1b]2;other\x1b\\!", "text!"},
+		{"alternate screen", "shell\x1b[?1049hmenu\x1b[?1049l$", "shell$"},
+		{"charset", "\x1b(Bplain", "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := NewScreen(4, 20)
+			sc.Write([]byte(tt.out))
+			if got := sc.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScreenSplitWrites(t *testing.T) {
+	sc := NewScreen(4, 20)
+	// an escape sequence and a multibyte rune split across reads
+	for _, w := range []string{"\x1b[", "1mb", "\xe2\x9c", "\x93 ok\x1b[0m"} {
+		sc.Write([]byte(w))
+	}
+	if got, want := sc.String(), "b✓ ok"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExpectScreen(t *testing.T) {
+	s, f := newTestSession(t)
+	go func() {
+		f.Feed("  figma  ·  ◯ connecting…\r")
+		f.Feed("\x1b[2K  figma  ·  ✔ connected\r\n")
+	}()
+
+	if err := s.ExpectScreen("figma · ✔ connected", MatchOptions{CollapseSpace: true}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// the screen is not consumed
+	if err := s.ExpectScreen("connected", MatchOptions{}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// connecting is in the output but no longer on the screen
+	if err := s.ExpectScreen("connecting", MatchOptions{}, 50*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want ErrTimeout", err)
+	}
+	if err := s.Expect("connecting", time.Second); err != nil {
+		t.Errorf("stream: %v", err)
+	}
+}
+
+func TestRunStepsOnScreen(t *testing.T) {
+	s, f := newTestSession(t)
+	go func() {
+		f.Feed("code: ????-????\r")
+		f.Feed("\x1b[Kcode: WXYZ-1234\r\n")
+	}()
+
+	sc := &Scenario{Steps: []Step{
+		{WaitForRegexp: `code: (\w{4}-\w{4})`, Capture: "code", OnScreen: true},
+	}}
+	results, err := sc.RunSteps(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Captures(results)["code"]; got != "WXYZ-1234" {
+		t.Errorf("got %q, want WXYZ-1234", got)
+	}
+}
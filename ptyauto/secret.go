@@ -0,0 +1,73 @@
+package ptyauto
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretEnvRef is a sendSecret naming an environment variable, $VAR or
+// ${VAR}.
+var secretEnvRef = regexp.MustCompile(`^\$(?:([A-Za-z_][A-Za-z0-9_]*)|\{([A-Za-z_][A-Za-z0-9_]*)\})$`)
+
+// secretRef is where a step's SendSecret comes from: an environment
+// variable or a file, never the scenario itself.
+type secretRef struct {
+	env  string
+	file string
+}
+
+func parseSecretRef(ref string) (secretRef, error) {
+	if m := secretEnvRef.FindStringSubmatch(ref); m != nil {
+		return secretRef{env: m[1] + m[2]}, nil
+	}
+	if path, ok := strings.CutPrefix(ref, "file:"); ok && path != "" {
+		return secretRef{file: path}, nil
+	}
+	return secretRef{}, fmt.Errorf("sendSecret %q: want $VAR, ${VAR} or file:/path", ref)
+}
+
+func (r secretRef) String() string {
+	if r.env != "" {
+		return "$" + r.env
+	}
+	return "file:" + r.file
+}
+
+// read returns the secret in a buffer of its own the caller wipes. A file
+// loses its trailing newline, an empty secret is an error.
+func (r secretRef) read() ([]byte, error) {
+	var secret []byte
+	if r.env != "" {
+		v, ok := os.LookupEnv(r.env)
+		if !ok {
+			return nil, fmt.Errorf("%s is not set", r)
+		}
+		secret = []byte(v)
+	} else {
+		data, err := os.ReadFile(r.file)
+		if err != nil {
+			// the path error doesn't carry the content
+			return nil, err
+		}
+		secret = data
+		for len(secret) > 0 && (secret[len(secret)-1] == '\n' || secret[len(secret)-1] == '\r') {
+			secret = secret[:len(secret)-1]
+		}
+		if len(secret) < len(data) {
+			wipe(data[len(secret):])
+		}
+	}
+	if len(bytes.TrimSpace(secret)) == 0 {
+		wipe(secret)
+		return nil, fmt.Errorf("%s is empty", r)
+	}
+	return secret, nil
+}
+
+// wipe zeroes b, so a secret doesn't stay in memory after it was typed.
+func wipe(b []byte) {
+	clear(b)
+}
@@ -0,0 +1,559 @@
+package ptyauto
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Expect when the child's output ended (the
+// process exited or the pty was closed) before the pattern showed up.
+var ErrClosed = errors.New("session closed")
+
+// ErrTimeout is returned by Expect when the pattern didn't show up in time.
+var ErrTimeout = errors.New("timed out")
+
+// ErrExited is returned by Expect when the process given to WatchProcess
+// exited before the pattern showed up, with its exit code. It usually
+// comes with ErrClosed, the pty ends with the process.
+var ErrExited = errors.New("process exited")
+
+// exitGrace is how long Expect keeps reading after the process exited, its
+// last output can still be on the way.
+const exitGrace = 200 * time.Millisecond
+
+// Session drives a program running in a PTY: it keeps reading everything
+// the program prints and lets the caller wait for text and type input.
+type Session struct {
+	pty PTY
+
+	// Echo, if set, receives a copy of everything the child prints.
+	Echo io.Writer
+	// Log, if set, gets a debug record for every Expect, WaitStable and
+	// Send with how long it waited, to find the slow steps of a scenario.
+	Log *slog.Logger
+	// MatchRaw makes Expect and Text see the output as printed. By default
+	// escape sequences and control characters are removed first (Clean).
+	MatchRaw bool
+	// Redactor, if set, learns what SendSecret types before it is sent, so
+	// an echo of it is masked wherever the Redactor masks.
+	Redactor *Redactor
+
+	mu       sync.Mutex
+	buf      []byte
+	pos      int // output before pos was already consumed by Expect
+	screen   *Screen
+	lastRead time.Time
+	notify   chan struct{}
+	done     bool
+	readErr  error
+
+	// closed when the process given to WatchProcess exited
+	exited   chan struct{}
+	exitCode int
+}
+
+// NewSession starts reading from p in the background. Its Screen is
+// DefaultRows x DefaultCols, call Resize when p has another size.
+func NewSession(p PTY) *Session {
+	return newSession(p, nil, DefaultRows, DefaultCols)
+}
+
+// newSession is NewSession with Echo set before the first read and the
+// screen sized like p.
+func newSession(p PTY, echo io.Writer, rows, cols int) *Session {
+	s := &Session{
+		pty:      p,
+		Echo:     echo,
+		screen:   NewScreen(rows, cols),
+		lastRead: time.Now(),
+		notify:   make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+func (s *Session) readLoop() {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := s.pty.Read(chunk)
+
+		s.mu.Lock()
+		if n > 0 {
+			s.buf = append(s.buf, chunk[:n]...)
+			s.screen.Write(chunk[:n])
+			s.lastRead = time.Now()
+			if s.Echo != nil {
+				s.Echo.Write(chunk[:n])
+			}
+		}
+		if err != nil {
+			s.done = true
+			s.readErr = err
+		}
+		// wake up everyone waiting in Expect or WaitStable
+		close(s.notify)
+		s.notify = make(chan struct{})
+		s.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Expect waits until pattern appears in output that was not consumed by an
+// earlier Expect. Output up to the end of the match is consumed.
+func (s *Session) Expect(pattern string, timeout time.Duration) error {
+	return s.ExpectWith(pattern, MatchOptions{}, timeout)
+}
+
+// ExpectWith is Expect with looser matching, see MatchOptions.
+func (s *Session) ExpectWith(pattern string, opts MatchOptions, timeout time.Duration) error {
+	_, err := s.expect([]matcher{opts.matcher(pattern)}, timeout)
+	return err
+}
+
+// ExpectRegexp waits until pattern, a regular expression, matches output
+// that was not consumed by an earlier Expect and returns the match followed
+// by its submatches. Output up to the end of the match is consumed.
+func (s *Session) ExpectRegexp(pattern string, timeout time.Duration) ([]string, error) {
+	m, groups, err := regexpMatcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.expect([]matcher{m}, timeout); err != nil {
+		return nil, err
+	}
+	return *groups, nil
+}
+
+// regexpMatcher compiles pattern into a matcher that keeps the match and
+// its submatches of the last find in groups.
+func regexpMatcher(pattern string) (matcher, *[]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return matcher{}, nil, err
+	}
+	groups := new([]string)
+	m := matcher{
+		desc: "/" + pattern + "/",
+		find: func(out string) (int, int) {
+			loc := re.FindStringSubmatchIndex(out)
+			if loc == nil {
+				return -1, -1
+			}
+			*groups = make([]string, len(loc)/2)
+			for i := range *groups {
+				if loc[2*i] >= 0 {
+					(*groups)[i] = out[loc[2*i]:loc[2*i+1]]
+				}
+			}
+			return loc[0], loc[1]
+		},
+	}
+	return m, groups, nil
+}
+
+// ExpectAny waits until one of patterns appears and returns its index. If
+// several are present the one appearing first in the output wins.
+func (s *Session) ExpectAny(patterns []string, timeout time.Duration) (int, error) {
+	matchers := make([]matcher, len(patterns))
+	for i, p := range patterns {
+		matchers[i] = literal(p)
+	}
+	return s.expect(matchers, timeout)
+}
+
+func (s *Session) expect(matchers []matcher, timeout time.Duration) (int, error) {
+	s.mu.Lock()
+	from := s.pos
+	s.mu.Unlock()
+
+	return s.expectFrom(from, matchers, timeout)
+}
+
+func (s *Session) expectFrom(from int, matchers []matcher, timeout time.Duration) (int, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	start := time.Now()
+	// grace fires exitGrace after the process exited, gone is set then and
+	// the output is matched one last time
+	var grace <-chan time.Time
+	processExited, gone := s.exited, false
+
+	for {
+		s.mu.Lock()
+		out, offsets := string(s.buf[from:]), []int(nil)
+		if !s.MatchRaw {
+			out, offsets = cleanIndexed(out)
+		}
+		match, at, end := -1, len(out), 0
+		for i, m := range matchers {
+			if start, stop := m.find(out); start >= 0 && start < at {
+				match, at, end = i, start, stop
+			}
+		}
+		if match >= 0 {
+			if offsets != nil && end > 0 {
+				// just past the last matched byte, not the escapes after it
+				end = offsets[end-1] + 1
+			}
+			s.pos = from + end
+			s.mu.Unlock()
+			s.debug("expect", "pattern", matchers[match].desc, "waited", time.Since(start), "consumed_bytes", end)
+			return match, nil
+		}
+		if s.done {
+			s.mu.Unlock()
+			s.debug("expect", "pattern", describe(matchers), "waited", time.Since(start), "err", ErrClosed)
+			// the pty ends as the process exits, it may not be reaped yet
+			if s.waitExited(exitGrace) {
+				return -1, fmt.Errorf("waiting for %s: %w with code %d (%w)\n%s", describe(matchers), ErrExited, s.exitCode, ErrClosed, s.Tail(500))
+			}
+			return -1, fmt.Errorf("waiting for %s: %w\n%s", describe(matchers), ErrClosed, s.Tail(500))
+		}
+		notify := s.notify
+		s.mu.Unlock()
+
+		if gone {
+			s.debug("expect", "pattern", describe(matchers), "waited", time.Since(start), "err", ErrExited)
+			return -1, fmt.Errorf("waiting for %s: %w with code %d\n%s", describe(matchers), ErrExited, s.exitCode, s.Tail(500))
+		}
+
+		select {
+		case <-notify:
+		case <-processExited:
+			processExited = nil
+			grace = time.After(exitGrace)
+		case <-grace:
+			gone = true
+		case <-deadline.C:
+			s.debug("expect", "pattern", describe(matchers), "waited", time.Since(start), "err", "timeout")
+			return -1, fmt.Errorf("%w after %s waiting for %s\n%s", ErrTimeout, timeout, describe(matchers), s.Tail(500))
+		}
+	}
+}
+
+// ExpectScreen waits until pattern is on the Screen. It sees the program's
+// output as rendered, a menu redrawn ten times is there once and text that
+// was erased is gone, but nothing is consumed: the same screen matches
+// again until it changes.
+func (s *Session) ExpectScreen(pattern string, opts MatchOptions, timeout time.Duration) error {
+	_, err := s.expectScreen([]matcher{opts.matcher(pattern)}, timeout)
+	return err
+}
+
+// ExpectScreenRegexp is ExpectScreen for a regular expression, it returns
+// the match followed by its submatches like ExpectRegexp.
+func (s *Session) ExpectScreenRegexp(pattern string, timeout time.Duration) ([]string, error) {
+	m, groups, err := regexpMatcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.expectScreen([]matcher{m}, timeout); err != nil {
+		return nil, err
+	}
+	return *groups, nil
+}
+
+// expectScreen is expect on the screen, the matcher whose match comes
+// first wins.
+func (s *Session) expectScreen(matchers []matcher, timeout time.Duration) (int, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	start := time.Now()
+
+	for {
+		s.mu.Lock()
+		screen := s.screen.String()
+		match, at := -1, len(screen)
+		for i, m := range matchers {
+			if start, _ := m.find(screen); start >= 0 && start < at {
+				match, at = i, start
+			}
+		}
+		done, notify := s.done, s.notify
+		s.mu.Unlock()
+
+		if match >= 0 {
+			s.debug("expect screen", "pattern", matchers[match].desc, "waited", time.Since(start))
+			return match, nil
+		}
+		if done {
+			s.debug("expect screen", "pattern", describe(matchers), "waited", time.Since(start), "err", ErrClosed)
+			if s.waitExited(exitGrace) {
+				return -1, fmt.Errorf("waiting for %s on the screen: %w with code %d (%w)\n%s", describe(matchers), ErrExited, s.exitCode, ErrClosed, screen)
+			}
+			return -1, fmt.Errorf("waiting for %s on the screen: %w\n%s", describe(matchers), ErrClosed, screen)
+		}
+
+		select {
+		case <-notify:
+		case <-deadline.C:
+			s.debug("expect screen", "pattern", describe(matchers), "waited", time.Since(start), "err", "timeout")
+			return -1, fmt.Errorf("%w after %s waiting for %s on the screen\n%s", ErrTimeout, timeout, describe(matchers), screen)
+		}
+	}
+}
+
+// WaitStable waits until the child printed nothing for quiet, which is how
+// we know a TUI finished redrawing. It gives up after timeout.
+func (s *Session) WaitStable(quiet, timeout time.Duration) error {
+	return s.waitStable(time.Time{}, quiet, timeout)
+}
+
+// waitStable is WaitStable counting the quiet time from after, so that
+// output that was quiet before it doesn't count.
+func (s *Session) waitStable(after time.Time, quiet, timeout time.Duration) error {
+	start := time.Now()
+	deadline := start.Add(timeout)
+
+	for {
+		s.mu.Lock()
+		idle := time.Since(s.lastRead)
+		if since := time.Since(after); since < idle {
+			idle = since
+		}
+		done := s.done
+		s.mu.Unlock()
+
+		if idle >= quiet || done {
+			s.debug("wait stable", "quiet", quiet, "waited", time.Since(start))
+			return nil
+		}
+		if time.Now().Add(quiet - idle).After(deadline) {
+			s.debug("wait stable", "quiet", quiet, "waited", time.Since(start), "err", "timeout")
+			return fmt.Errorf("output still changing after %s", timeout)
+		}
+		time.Sleep(quiet - idle)
+	}
+}
+
+// Send types input into the child. Only its length is logged, input can
+// be a secret.
+func (s *Session) Send(input string) error {
+	start := time.Now()
+	_, err := io.WriteString(s.pty, input)
+	s.debug("send", "bytes", len(input), "took", time.Since(start))
+	return err
+}
+
+// SendSecret is Send for a password or token. The Redactor learns secret
+// first and nothing about it but that it was sent is logged. The caller
+// still owns secret and wipes it.
+func (s *Session) SendSecret(secret []byte) error {
+	if s.Redactor != nil {
+		s.Redactor.AddSecret(secret)
+	}
+	start := time.Now()
+	_, err := s.pty.Write(secret)
+	s.debug("send secret", "took", time.Since(start))
+	return err
+}
+
+// logger is Log, or the default logger when it isn't set.
+func (s *Session) logger() *slog.Logger {
+	if s.Log == nil {
+		return slog.Default()
+	}
+	return s.Log
+}
+
+// debug logs one operation under a "session" group.
+func (s *Session) debug(op string, args ...any) {
+	if s.Log == nil {
+		return
+	}
+	s.Log.Debug(op, slog.Group("session", args...))
+}
+
+// SendAndConfirm types input and waits until the child echoes it back,
+// which proves the keys were received instead of hoping a sleep was long
+// enough. Only output printed after the send counts. Input that is only
+// whitespace (like a bare "\r") has nothing to confirm.
+func (s *Session) SendAndConfirm(input string, timeout time.Duration) error {
+	s.mu.Lock()
+	from := len(s.buf)
+	s.mu.Unlock()
+
+	if err := s.Send(input); err != nil {
+		return err
+	}
+
+	echo := strings.TrimSpace(input)
+	if echo == "" {
+		return nil
+	}
+	if _, err := s.expectFrom(from, []matcher{literal(echo)}, timeout); err != nil {
+		return fmt.Errorf("input %q was not echoed: %w", echo, err)
+	}
+	return nil
+}
+
+// SendAndCheckConsumed types input, waits until the output was quiet for
+// quiet and reports whether the child consumed it. It is not consumed when
+// all the child printed since is the screen from before the send with the
+// input typed into it, the way a menu that isn't ready yet prints a digit
+// instead of acting on it. A new screen counts as consumed even when the
+// input shows up on it, like the next numbered menu. Only printable input
+// can be checked, whitespace and control characters are ignored.
+func (s *Session) SendAndCheckConsumed(input string, quiet, timeout time.Duration) (bool, error) {
+	s.mu.Lock()
+	from := len(s.buf)
+	s.mu.Unlock()
+
+	sent := time.Now()
+	if err := s.Send(input); err != nil {
+		return false, err
+	}
+	if err := s.waitStable(sent, quiet, timeout); err != nil {
+		return false, err
+	}
+
+	text := strings.TrimSpace(StripControl(input))
+	if text == "" {
+		return true, nil
+	}
+	s.mu.Lock()
+	before, after := string(s.buf[max(0, from-screenBytes):from]), string(s.buf[from:])
+	s.mu.Unlock()
+	return !echoed(Clean(before), Clean(after), text), nil
+}
+
+// screenBytes is how much output before a send SendAndCheckConsumed takes
+// as the screen it was typed into.
+const screenBytes = 8 << 10
+
+// echoed reports whether after, the output following a send, only redraws
+// lines of before with text typed into at least one of them.
+func echoed(before, after, text string) bool {
+	seen := map[string]bool{}
+	for _, line := range strings.Split(before, "\n") {
+		seen[strings.TrimSpace(line)] = true
+	}
+
+	typed := false
+	for _, line := range strings.Split(after, "\n") {
+		line = strings.TrimSpace(line)
+		if seen[line] {
+			continue
+		}
+		i := strings.LastIndex(line, text)
+		if i < 0 {
+			// something new, the program acted on the input
+			return false
+		}
+		// the line as it was before the input was typed into it
+		if rest := strings.TrimSpace(line[:i] + line[i+len(text):]); rest != "" && !seen[rest] {
+			return false
+		}
+		typed = true
+	}
+	return typed
+}
+
+// WatchProcess reaps cmd in the background so WaitExit can report its exit
+// code. cmd must be started and nobody else may call cmd.Wait.
+func (s *Session) WatchProcess(cmd *exec.Cmd) {
+	s.exited = make(chan struct{})
+	go func() {
+		cmd.Wait()
+		s.exitCode = cmd.ProcessState.ExitCode()
+		close(s.exited)
+	}()
+}
+
+// waitExited reports whether the process given to WatchProcess exited,
+// waiting up to timeout for it.
+func (s *Session) waitExited(timeout time.Duration) bool {
+	if s.exited == nil {
+		return false
+	}
+	select {
+	case <-s.exited:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// WaitExit waits for the process given to WatchProcess to exit and returns
+// its exit code, -1 when it was killed by a signal.
+func (s *Session) WaitExit(timeout time.Duration) (int, error) {
+	if s.exited == nil {
+		return 0, errors.New("no process to wait for")
+	}
+
+	start := time.Now()
+	select {
+	case <-s.exited:
+		s.debug("wait exit", "code", s.exitCode, "waited", time.Since(start))
+		return s.exitCode, nil
+	case <-time.After(timeout):
+		s.debug("wait exit", "waited", time.Since(start), "err", "timeout")
+		return 0, fmt.Errorf("process still running: %w after %s", ErrTimeout, timeout)
+	}
+}
+
+// Output returns everything the child printed so far.
+func (s *Session) Output() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.buf)
+}
+
+// Text returns the output to extract values from: Output, cleaned unless
+// MatchRaw is set.
+func (s *Session) Text() string {
+	if s.MatchRaw {
+		return s.Output()
+	}
+	return Clean(s.Output())
+}
+
+// Screen returns what a terminal showing the output would display now,
+// see Screen.
+func (s *Session) Screen() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.screen.String()
+}
+
+// Resize changes the size of the pty, the program gets a SIGWINCH, and of
+// the Screen.
+func (s *Session) Resize(rows, cols uint16) error {
+	if err := s.pty.Setsize(rows, cols); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.screen.Resize(int(rows), int(cols))
+	s.mu.Unlock()
+	return nil
+}
+
+// Tail returns the last n bytes of output, for error messages.
+func (s *Session) Tail(n int) string {
+	out := s.Output()
+	if len(out) > n {
+		out = out[len(out)-n:]
+	}
+	return out
+}
+
+// PTY returns the terminal the session runs on, e.g. to forward a user's
+// keystrokes to it.
+func (s *Session) PTY() PTY {
+	return s.pty
+}
+
+// Close closes the pty.
+func (s *Session) Close() error {
+	return s.pty.Close()
+}
@@ -0,0 +1,231 @@
+package ptyauto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSession(t *testing.T) (*Session, *fakePTY) {
+	t.Helper()
+	f := newFakePTY()
+	s := NewSession(f)
+	t.Cleanup(func() { s.Close() })
+	return s, f
+}
+
+func TestExpect(t *testing.T) {
+	s, f := newTestSession(t)
+	go func() {
+		// split across reads, with escapes between the words
+		f.Feed("\x1b[1mWel")
+		f.Feed("come\x1b[0m to ")
+		f.Feed("the menu\r\n")
+	}()
+
+	if err := s.Expect("Welcome to the menu", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// the match is consumed
+	if err := s.Expect("Welcome", 50*time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("second Expect: %v, want ErrTimeout", err)
+	}
+}
+
+func TestExpectRaw(t *testing.T) {
+	s, f := newTestSession(t)
+	s.MatchRaw = true
+	go f.Feed("\x1b[1mbold\x1b[0m\n")
+
+	if err := s.Expect("\x1b[1mbold", time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpectClosed(t *testing.T) {
+	s, f := newTestSession(t)
+	go func() {
+		f.Feed("goodbye\n")
+		f.Exit()
+	}()
+
+	if err := s.Expect("never", time.Second); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v, want ErrClosed", err)
+	}
+}
+
+func TestExpectRegexp(t *testing.T) {
+	s, f := newTestSession(t)
+	go func() {
+		f.Feed("code: AB")
+		f.Feed("C-123 expires in 5m\n")
+	}()
+
+	got, err := s.ExpectRegexp(`code: ([A-Z]+)-(\d+)`, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"code: ABC-123", "ABC", "123"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := s.ExpectRegexp(`(`, time.Second); err == nil {
+		t.Error("bad regexp accepted")
+	}
+}
+
+func TestExpectExited(t *testing.T) {
+	sc := &Scenario{Cmd: "sh", Args: []string{"-c", "echo bye; exit 3"}}
+	s, cmd, err := sc.Start(DefaultRows, DefaultCols, nil)
+	if err != nil {
+		t.Skip("no sh:", err)
+	}
+	defer s.Close()
+	defer cmd.Process.Kill()
+
+	start := time.Now()
+	err = s.Expect("never", 3*time.Second)
+	if !errors.Is(err, ErrExited) || !strings.Contains(err.Error(), "code 3") {
+		t.Errorf("got %v, want ErrExited with code 3", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("took %s, should not wait for the timeout", time.Since(start))
+	}
+}
+
+func TestExpectAny(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   int
+	}{
+		{"first pattern", "Press Enter to continue\n", 0},
+		{"second pattern", "Authentication successful\n", 1},
+		{"earliest in output wins", "Authentication successful, Press Enter\n", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, f := newTestSession(t)
+			go f.Feed(tt.output)
+
+			got, err := s.ExpectAny([]string{"Press Enter", "Authentication successful"}, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("matched %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpectAnyTimeout(t *testing.T) {
+	s, f := newTestSession(t)
+	go f.Feed("something else\n")
+
+	_, err := s.ExpectAny([]string{"a pattern", "another"}, 50*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	// the error shows what the program printed
+	if !strings.Contains(err.Error(), "something else") {
+		t.Errorf("error without output: %v", err)
+	}
+}
+
+func TestWaitStable(t *testing.T) {
+	s, f := newTestSession(t)
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+				f.Feed(".")
+			}
+		}
+	}()
+
+	// still drawing
+	if err := s.WaitStable(50*time.Millisecond, 150*time.Millisecond); err == nil {
+		t.Error("WaitStable returned while output kept changing")
+	}
+
+	close(stop)
+	start := time.Now()
+	if err := s.WaitStable(50*time.Millisecond, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Errorf("waited %s for 50ms of quiet", waited)
+	}
+}
+
+func TestSendAndConfirm(t *testing.T) {
+	s, f := newTestSession(t)
+	// a program echoing what it reads, like a shell
+	f.OnWrite = func(p []byte) { go f.Feed(string(p)) }
+
+	if err := s.SendAndConfirm("/mcp\r", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Written(); got != "/mcp\r" {
+		t.Errorf("wrote %q", got)
+	}
+	// whitespace has nothing to confirm
+	if err := s.SendAndConfirm("\r", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendAndConfirmNoEcho(t *testing.T) {
+	s, f := newTestSession(t)
+	// printed before the send, it must not count as the echo
+	go f.Feed("/mcp\n")
+	if err := s.Expect("/mcp", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.SendAndConfirm("/mcp\r", 50*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want ErrTimeout", err)
+	}
+}
+
+func TestSendAndCheckConsumed(t *testing.T) {
+	const menu = "Manage MCP servers\r\n  1. github  ✔ connected\r\n❯ 2. figma   △ needs authentication\r\n> \r\n"
+	tests := []struct {
+		name   string
+		answer string // what the program prints when "2" is typed
+		want   bool
+	}{
+		{"printed as text", "2\r\n", false},
+		{"typed into the prompt", "\x1b[2K\r> 2", false},
+		{"whole screen redrawn with the digit", "\x1b[2J\x1b[H" + strings.Replace(menu, "> ", "> 2", 1), false},
+		{"next menu has a 2 too", "\x1b[2J\x1b[HFigma MCP Server\r\n❯ 1. Authenticate\r\n  2. Disable\r\n", true},
+		{"prints nothing", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, f := newTestSession(t)
+			go f.Feed(menu)
+			if err := s.Expect("> ", time.Second); err != nil {
+				t.Fatal(err)
+			}
+			f.OnWrite = func([]byte) {
+				if tt.answer != "" {
+					go f.Feed(tt.answer)
+				}
+			}
+
+			consumed, err := s.SendAndCheckConsumed("2", 50*time.Millisecond, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if consumed != tt.want {
+				t.Errorf("consumed %v, want %v", consumed, tt.want)
+			}
+		})
+	}
+}
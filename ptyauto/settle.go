@@ -0,0 +1,112 @@
+package ptyauto
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Settle is how long a step lets the program settle before it sends input.
+// In YAML it is written as one of:
+//
+//	instant        send right away. Fine for programs that read input at any
+//	               time, a TUI that is still drawing may drop the keys.
+//	stable(quiet)  wait until the program printed nothing for quiet (500ms
+//	               if left out, "stable" alone works too). Adapts to the
+//	               machine's speed, but can't see a program that becomes
+//	               ready without printing anything.
+//	fixed(d)       sleep d. For exactly that kind of program, e.g. Claude's
+//	               MCP listener needs ~5s after the list is drawn. Too short
+//	               is flaky on slow machines, too long wastes time every run.
+//
+// The default is stable(500ms).
+type Settle struct {
+	Kind     string
+	Duration time.Duration
+}
+
+// Kinds of Settle.
+const (
+	SettleInstant = "instant"
+	SettleStable  = "stable"
+	SettleFixed   = "fixed"
+)
+
+// DefaultSettle is used by steps that send without saying how to settle.
+var DefaultSettle = Settle{Kind: SettleStable, Duration: 500 * time.Millisecond}
+
+// ParseSettle parses the YAML form of a Settle.
+func ParseSettle(s string) (Settle, error) {
+	kind, arg, hasArg := strings.Cut(strings.TrimSpace(s), "(")
+	if hasArg {
+		var ok bool
+		if arg, ok = strings.CutSuffix(arg, ")"); !ok {
+			return Settle{}, fmt.Errorf("settle %q: missing )", s)
+		}
+	}
+
+	var d time.Duration
+	if arg != "" {
+		var err error
+		if d, err = time.ParseDuration(arg); err != nil || d <= 0 {
+			return Settle{}, fmt.Errorf("settle %q: want a positive duration like 500ms", s)
+		}
+	}
+
+	switch kind {
+	case SettleInstant:
+		if hasArg {
+			return Settle{}, fmt.Errorf("settle %q: instant takes no duration", s)
+		}
+		return Settle{Kind: SettleInstant}, nil
+	case SettleStable:
+		if d == 0 {
+			d = DefaultSettle.Duration
+		}
+		return Settle{Kind: SettleStable, Duration: d}, nil
+	case SettleFixed:
+		if d == 0 {
+			return Settle{}, fmt.Errorf("settle %q: fixed needs a duration, e.g. fixed(5s)", s)
+		}
+		return Settle{Kind: SettleFixed, Duration: d}, nil
+	}
+	return Settle{}, fmt.Errorf("settle %q: want instant, stable(quiet) or fixed(duration)", s)
+}
+
+func (st *Settle) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := ParseSettle(s)
+	if err != nil {
+		return err
+	}
+	*st = parsed
+	return nil
+}
+
+func (st Settle) String() string {
+	if st.Kind == SettleInstant {
+		return st.Kind
+	}
+	return fmt.Sprintf("%s(%s)", st.Kind, st.Duration)
+}
+
+// wait settles s, a stable wait gives up after timeout.
+func (st Settle) wait(ctx context.Context, s *Session, timeout time.Duration) error {
+	switch st.Kind {
+	case SettleStable:
+		return s.WaitStable(st.Duration, timeout)
+	case SettleFixed:
+		select {
+		case <-time.After(st.Duration):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package ptyauto
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseSettle(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Settle
+	}{
+		{"instant", Settle{Kind: SettleInstant}},
+		{"stable", DefaultSettle},
+		{"stable()", DefaultSettle},
+		{" stable(2s) ", Settle{Kind: SettleStable, Duration: 2 * time.Second}},
+		{"fixed(5s)", Settle{Kind: SettleFixed, Duration: 5 * time.Second}},
+		{"fixed(1m30s)", Settle{Kind: SettleFixed, Duration: 90 * time.Second}},
+	}
+	for _, tt := range tests {
+		got, err := ParseSettle(tt.in)
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+		}
+		if again, err := ParseSettle(got.String()); err != nil || again != got {
+			t.Errorf("%q: %s parsed back to %+v, %v", tt.in, got, again, err)
+		}
+	}
+
+	for in, want := range map[string]string{
+		"":              "want instant",
+		"soon":          "want instant",
+		"fixed":         "fixed needs a duration",
+		"fixed()":       "fixed needs a duration",
+		"fixed(5s":      "missing )",
+		"fixed(five)":   "positive duration",
+		"stable(-1s)":   "positive duration",
+		"stable(0s)":    "positive duration",
+		"instant(1s)":   "instant takes no duration",
+		"stable(1s)ms)": "positive duration",
+	} {
+		if _, err := ParseSettle(in); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want an error about %s", in, err, want)
+		}
+	}
+
+	var step struct {
+		Settle Settle `yaml:"settle"`
+	}
+	if err := yaml.Unmarshal([]byte("settle: fixed(3s)\n"), &step); err != nil || step.Settle != (Settle{Kind: SettleFixed, Duration: 3 * time.Second}) {
+		t.Errorf("YAML: %+v, %v", step.Settle, err)
+	}
+	if err := yaml.Unmarshal([]byte("settle: [fixed]\n"), &step); err == nil {
+		t.Error("YAML list: got no error")
+	}
+}
@@ -0,0 +1,105 @@
+package ptyauto
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var urlCandidate = regexp.MustCompile(`https?://[^\s"'<>` + "`" + `]+`)
+
+// urlContinuation is a line that can only be the rest of a URL the TUI
+// wrapped: one run of URL characters with at least one that words don't
+// have, so a line saying "Done" isn't glued to the URL above it.
+var urlContinuation = regexp.MustCompile(`^[A-Za-z0-9\-._~:/?#\[\]@!$&'()*+,;=%]*[/?=&%][A-Za-z0-9\-._~:/?#\[\]@!$&'()*+,;=%]*$`)
+
+// ExtractURL returns the first https URL in text whose host contains
+// hostContains, cleaned up by CleanURL. A URL the TUI wrapped onto the
+// following lines is joined back together. The error lists the candidates
+// that were rejected.
+func ExtractURL(text string, hostContains string) (string, error) {
+	var rejected []string
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		for _, loc := range urlCandidate.FindAllStringIndex(line, -1) {
+			candidate := line[loc[0]:loc[1]]
+			if strings.TrimFunc(line[loc[1]:], isURLTrailer) == "" {
+				candidate += wrappedRest(lines[i+1:])
+			}
+
+			u, err := CleanURL(candidate)
+			if err != nil || !strings.Contains(hostname(u), hostContains) {
+				rejected = append(rejected, strings.TrimRightFunc(candidate, isURLTrailer))
+				continue
+			}
+			return u, nil
+		}
+	}
+
+	if len(rejected) == 0 {
+		return "", fmt.Errorf("no URL found")
+	}
+	return "", fmt.Errorf("no https URL with host containing %q, candidates: %s", hostContains, strings.Join(rejected, ", "))
+}
+
+// wrappedRest returns the continuation of a URL that ended a line: the
+// following lines for as long as they are nothing but URL characters,
+// between the borders the TUI draws.
+func wrappedRest(lines []string) string {
+	var rest strings.Builder
+	for _, line := range lines {
+		line = strings.TrimFunc(line, isURLTrailer)
+		if line == "" || strings.Contains(line, "://") || !urlContinuation.MatchString(line) {
+			break
+		}
+		rest.WriteString(line)
+	}
+	return rest.String()
+}
+
+// CleanURL turns a URL copied out of a terminal into one that is safe to
+// open: TUI borders and punctuation glued to its end are trimmed and
+// invisible characters (zero width spaces, bidi marks) removed. It must be
+// https with a host and no whitespace or control characters left.
+func CleanURL(raw string) (string, error) {
+	s := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, raw)
+	s = strings.TrimFunc(s, isURLTrailer)
+
+	if strings.IndexFunc(s, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return "", fmt.Errorf("url %q has whitespace or control characters in it", s)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return "", errors.New("not an https url with a host")
+	}
+	return u.String(), nil
+}
+
+func hostname(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// isURLTrailer reports whether r can't end a URL: box drawing characters the
+// TUI draws around it and sentence punctuation.
+func isURLTrailer(r rune) bool {
+	if r >= 0x2500 && r <= 0x257F {
+		return true
+	}
+	return strings.ContainsRune(".,;:!?)]}", r) || unicode.IsSpace(r) || unicode.IsControl(r)
+}
@@ -0,0 +1,45 @@
+package ptyauto
+
+import "testing"
+
+func TestExtractURL(t *testing.T) {
+	tests := []struct {
+		name, text, host, want string
+	}{
+		{"box border", "│ https://www.figma.com/oauth?client_id=abc │", "figma", "https://www.figma.com/oauth?client_id=abc"},
+		{"punctuation", "Open https://github.com/login/device.", "", "https://github.com/login/device"},
+		{"zero width space", "https://www.figma.com/oauth\u200b?state=1", "figma", "https://www.figma.com/oauth?state=1"},
+		{"wrapped", "│ https://www.figma.com/oauth?client_id=abc&redirect │\n│ _uri=http%3A%2F%2Flocalhost&state=xyz            │\n│ Done │", "figma", "https://www.figma.com/oauth?client_id=abc&redirect_uri=http%3A%2F%2Flocalhost&state=xyz"},
+		{"next line is text", "https://www.figma.com/oauth?a=1\nPress Enter to continue", "figma", "https://www.figma.com/oauth?a=1"},
+		{"next line is a word", "https://www.figma.com/oauth?a=1\nDone", "figma", "https://www.figma.com/oauth?a=1"},
+		{"host filter", "see http://figma.com and https://docs.example.com then https://www.figma.com/x", "figma", "https://www.figma.com/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractURL(tt.text, tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ExtractURL("no links here", ""); err == nil {
+		t.Error("no URL: got no error")
+	}
+}
+
+func TestCleanURL(t *testing.T) {
+	for _, raw := range []string{
+		"http://www.figma.com/oauth",
+		"https:///path",
+		"javascript:alert(1)",
+		"https://www.figma.com/a\x07b",
+	} {
+		if u, err := CleanURL(raw); err == nil {
+			t.Errorf("CleanURL(%q) = %q, want an error", raw, u)
+		}
+	}
+}
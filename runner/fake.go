@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake is a Runner for tests. It runs nothing: every command gets the
+// Response added for it, and is remembered for Calls. The zero Fake has no
+// responses, every command fails as if it wasn't installed.
+type Fake struct {
+	mu        sync.Mutex
+	responses []fakeResponse
+	calls     []Call
+}
+
+// Response is what Fake answers a command with. A non-zero ExitCode fails
+// it like a command that exited with that code, Err like one that couldn't
+// start. With a Delay the command takes that long, unless ctx ends first.
+type Response struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Err      error
+	Delay    time.Duration
+}
+
+// Call is a command Fake was asked to run, with the WithEnv variables of
+// its context.
+type Call struct {
+	Name string
+	Args []string
+	Env  []string
+}
+
+// String is the command line, for test failures.
+func (c Call) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+type fakeResponse struct {
+	name string
+	args []string
+	Response
+}
+
+// Add answers the commands called name whose arguments start with args
+// with r. When several match the one added last wins, so a test can add
+// a general answer first and exceptions after it.
+func (f *Fake) Add(r Response, name string, args ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, fakeResponse{name: name, args: args, Response: r})
+}
+
+// Calls returns the commands run so far, in order.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// Run implements Runner.
+func (f *Fake) Run(ctx context.Context, name string, args ...string) (Result, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, Call{Name: name, Args: slices.Clone(args), Env: Env(ctx)})
+	var r *Response
+	for i := len(f.responses) - 1; i >= 0; i-- {
+		fr := &f.responses[i]
+		if fr.name == name && len(args) >= len(fr.args) && slices.Equal(args[:len(fr.args)], fr.args) {
+			r = &fr.Response
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if r == nil {
+		return Result{ExitCode: -1}, &exec.Error{Name: name, Err: exec.ErrNotFound}
+	}
+	if r.Err != nil {
+		return Result{ExitCode: -1}, r.Err
+	}
+	if r.Delay > 0 {
+		select {
+		case <-time.After(r.Delay):
+		case <-ctx.Done():
+			// killed, like exec.CommandContext does
+			return Result{ExitCode: -1}, ctx.Err()
+		}
+	}
+
+	var stderr bytes.Buffer
+	io.WriteString(Stderr(ctx, &stderr), r.Stderr)
+	res := Result{Stdout: []byte(r.Stdout), Stderr: stderr.Bytes(), ExitCode: r.ExitCode, Duration: r.Delay}
+	if r.ExitCode != 0 {
+		return res, fmt.Errorf("exit status %d", r.ExitCode)
+	}
+	return res, nil
+}
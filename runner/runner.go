@@ -0,0 +1,128 @@
+// Package runner runs external commands to completion and hands back what
+// they printed, behind an interface so code that shells out to df, du,
+// ssh or kubectl can be tested with a Fake instead of the real binaries.
+//
+// The Runner to use travels in the context (WithRunner), like the extra
+// environment (WithEnv) and the stderr copy (WithStderr), so they reach
+// the commands deep in a call without every function taking them.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Result is what a command printed and how it exited. ExitCode is -1 when
+// the command didn't start or was killed.
+type Result struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+	Duration time.Duration
+}
+
+// Runner runs name with args until it exits or ctx ends. A command that
+// exits non-zero returns its Result along with the error.
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) (Result, error)
+}
+
+type runnerKey struct{}
+
+type envKey struct{}
+
+type stderrKey struct{}
+
+// WithRunner returns a context whose commands run with r.
+func WithRunner(ctx context.Context, r Runner) context.Context {
+	return context.WithValue(ctx, runnerKey{}, r)
+}
+
+// FromContext returns the Runner of ctx, ok is false when it has none.
+func FromContext(ctx context.Context) (r Runner, ok bool) {
+	r, ok = ctx.Value(runnerKey{}).(Runner)
+	return r, ok
+}
+
+// WithEnv returns a context that makes every command run with it also get
+// env (KEY=VALUE), after the variables of earlier WithEnv calls so it can
+// override them.
+func WithEnv(ctx context.Context, env ...string) context.Context {
+	return context.WithValue(ctx, envKey{}, append(Env(ctx), env...))
+}
+
+// Env returns the variables WithEnv added to ctx.
+func Env(ctx context.Context) []string {
+	env, _ := ctx.Value(envKey{}).([]string)
+	// a copy, so appending to it can't change another context's
+	return append([]string(nil), env...)
+}
+
+// WithStderr returns a context that makes every command run with it also
+// copy its stderr to w, even when the command succeeds. Commands can run
+// concurrently, w must be safe for that.
+func WithStderr(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, stderrKey{}, w)
+}
+
+// Stderr is where a command's stderr goes: buf, and the WithStderr writer
+// if ctx has one.
+func Stderr(ctx context.Context, buf *bytes.Buffer) io.Writer {
+	if w, ok := ctx.Value(stderrKey{}).(io.Writer); ok {
+		return io.MultiWriter(buf, w)
+	}
+	return buf
+}
+
+// Exec runs commands for real. Their environment is ours, then Env, then
+// the WithEnv variables; the last value of a variable wins. A command is
+// killed when ctx ends or, with a Timeout, when that is over.
+type Exec struct {
+	Env     []string
+	Timeout time.Duration
+}
+
+// Run implements Runner.
+func (e Exec) Run(ctx context.Context, name string, args ...string) (Result, error) {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(append(os.Environ(), e.Env...), Env(ctx)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = Stderr(ctx, &stderr)
+
+	start := time.Now()
+	err := cmd.Run()
+	res := Result{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), ExitCode: -1, Duration: time.Since(start)}
+	if cmd.ProcessState != nil {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	Log(cmd, start, err)
+	return res, err
+}
+
+// Log logs at debug level what exactly ran, with the resolved path, how
+// long it took and how it exited. Exec logs every command with it, code
+// that starts commands itself, e.g. to read their output as it comes,
+// calls it too.
+func Log(cmd *exec.Cmd, start time.Time, err error) {
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	args := []any{"cmd", cmd.String(), "took", time.Since(start).Round(time.Millisecond), "exit_code", exitCode}
+	if err != nil {
+		args = append(args, "err", err)
+	}
+	slog.Debug("command finished", args...)
+}
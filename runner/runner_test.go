@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec(t *testing.T) {
+	var copied bytes.Buffer
+	ctx := WithStderr(WithEnv(WithEnv(context.Background(), "A=ctx", "B=ctx"), "B=later"), &copied)
+	res, err := Exec{Env: []string{"A=exec"}}.Run(ctx, "sh", "-c", `echo "$A $B"; echo oops >&2; exit 3`)
+	if err == nil || res.ExitCode != 3 {
+		t.Fatalf("exit code %d, err %v, want 3 and an error", res.ExitCode, err)
+	}
+	if got := strings.TrimSpace(string(res.Stdout)); got != "ctx later" {
+		t.Errorf("stdout %q, want the WithEnv values to win", got)
+	}
+	if string(res.Stderr) != "oops\n" || copied.String() != "oops\n" {
+		t.Errorf("stderr %q, copied %q", res.Stderr, copied.String())
+	}
+
+	start := time.Now()
+	if _, err := (Exec{Timeout: 50 * time.Millisecond}).Run(context.Background(), "sleep", "5"); err == nil {
+		t.Error("sleep outlived the timeout")
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("timeout took %s", took)
+	}
+}
+
+func TestFake(t *testing.T) {
+	var f Fake
+	f.Add(Response{Stdout: "any\n"}, "df")
+	f.Add(Response{Stderr: "df: /mnt: Permission denied\n", ExitCode: 1}, "df", "-P", "/mnt")
+	f.Add(Response{Delay: time.Hour}, "df", "-P", "/nfs")
+
+	var copied bytes.Buffer
+	ctx := WithStderr(WithEnv(context.Background(), "LC_ALL=C"), &copied)
+	res, err := f.Run(ctx, "df", "-hP")
+	if err != nil || string(res.Stdout) != "any\n" {
+		t.Errorf("df -hP: %q, %v", res.Stdout, err)
+	}
+	// the more specific answer was added last
+	res, err = f.Run(ctx, "df", "-P", "/mnt", "-x")
+	if err == nil || res.ExitCode != 1 || copied.String() != "df: /mnt: Permission denied\n" {
+		t.Errorf("df -P /mnt: exit %d, err %v, stderr copy %q", res.ExitCode, err, copied.String())
+	}
+
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.Run(short, "df", "-P", "/nfs"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("hanging df: %v, want the deadline", err)
+	}
+
+	if _, err := f.Run(ctx, "du", "-s"); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("du without a response: %v, want not found", err)
+	}
+
+	calls := f.Calls()
+	if len(calls) != 4 || calls[1].String() != "df -P /mnt -x" || strings.Join(calls[0].Env, " ") != "LC_ALL=C" {
+		t.Errorf("calls %v", calls)
+	}
+}
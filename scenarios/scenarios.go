@@ -0,0 +1,10 @@
+// Package scenarios holds the scenarios built into the auth tool. The
+// others in this directory are examples to run with -script.
+package scenarios
+
+import _ "embed"
+
+// Figma is figma.yaml, claude -> /mcp -> Figma -> Authenticate.
+//
+//go:embed figma.yaml
+var Figma []byte
@@ -0,0 +1,346 @@
+// Package servecmd is `devops serve`: an HTTP API that other tools and
+// dashboards call for disk reports, directory sizes and MCP authentication
+// instead of logging into the machine. Every endpoint but /healthz needs
+// the bearer token.
+//
+//	GET  /v1/disk                         filesystem usage, like devops disk report -format json
+//	GET  /v1/du?path=/var&depth=2&top=20  the largest directories under path
+//	POST /v1/auth/mcp                     run the MCP authentication, returns the auth URL
+//	GET  /healthz                         200 while the server runs
+package servecmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"ved/test/authcmd"
+	"ved/test/config"
+	"ved/test/diskusage"
+	"ved/test/duscan"
+	"ved/test/logging"
+)
+
+// tokenEnv is where the token is read from without -token-file.
+const tokenEnv = "DEVOPS_API_TOKEN"
+
+// logs is -log-level, -log-format and -log-output.
+var logs logging.Config
+
+// server holds what the handlers share.
+type server struct {
+	token          []byte
+	requestTimeout time.Duration
+	duTimeout      time.Duration
+	authTimeout    time.Duration
+	duRoots        []string
+	authScript     string
+	// auth is held while an authentication runs, there is one claude
+	// configuration to drive
+	auth sync.Mutex
+}
+
+// Main runs the server until SIGINT or SIGTERM and returns the exit code.
+func Main(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	addr := fs.String("listen", "127.0.0.1:8080", "address to serve the API on")
+	tokenFile := fs.String("token-file", "", "file holding the bearer token clients must send, $"+tokenEnv+" when empty")
+	s := &server{}
+	fs.DurationVar(&s.requestTimeout, "request-timeout", 30*time.Second, "how long /v1/disk may take")
+	fs.DurationVar(&s.duTimeout, "du-timeout", 2*time.Minute, "how long /v1/du may take, it answers with what it found so far")
+	fs.DurationVar(&s.authTimeout, "auth-timeout", 2*time.Minute, "how long /v1/auth/mcp may take")
+	duRoots := fs.String("du-roots", "/", "comma separated directories /v1/du may scan below")
+	fs.StringVar(&s.authScript, "auth-script", "", "scenario /v1/auth/mcp runs instead of the built-in Figma flow")
+	logs.Flags(fs)
+	fs.Parse(args)
+	configErr := config.Apply(fs, "serve")
+
+	if err := logs.Open(name); err != nil {
+		slog.Error("bad logging flags", "err", err)
+		return 2
+	}
+	if configErr != nil {
+		slog.Error("bad configuration", "err", configErr)
+		logs.Close(2)
+		return 2
+	}
+	code := run(s, *addr, *tokenFile, *duRoots)
+	logs.Close(code)
+	return code
+}
+
+func run(s *server, addr, tokenFile, duRoots string) int {
+	slog.SetDefault(slog.New(logs.Handler()))
+
+	token := os.Getenv(tokenEnv)
+	if tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			slog.Error("reading -token-file failed", "err", err)
+			return 1
+		}
+		token = string(data)
+	}
+	if token = strings.TrimSpace(token); token == "" {
+		slog.Error("no token, set -token-file or $" + tokenEnv)
+		return 2
+	}
+	s.token = []byte(token)
+
+	for _, root := range strings.Split(duRoots, ",") {
+		if root = strings.TrimSpace(root); root == "" {
+			continue
+		}
+		if !filepath.IsAbs(root) {
+			slog.Error("-du-roots must be absolute paths", "root", root)
+			return 2
+		}
+		s.duRoots = append(s.duRoots, filepath.Clean(root))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.Handle("GET /v1/disk", s.authorized(s.disk))
+	mux.Handle("GET /v1/du", s.authorized(s.du))
+	mux.Handle("POST /v1/auth/mcp", s.authorized(s.authMCP))
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           logRequests(mux),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		// the slowest handler answers when its own timeout is over
+		WriteTimeout: max(s.requestTimeout, s.duTimeout, s.authTimeout) + 10*time.Second,
+		IdleTimeout:  time.Minute,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("listening", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("http server failed", "err", err)
+		return 1
+	}
+	return 0
+}
+
+// authorized lets requests with the bearer token through to h.
+func (s *server) authorized(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), s.token) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="devops"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or wrong bearer token"))
+			return
+		}
+		h(w, r)
+	})
+}
+
+// disk is GET /v1/disk: the filesystems of this machine, real and network
+// ones unless all=true.
+func (s *server) disk(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.requestTimeout)
+	defer cancel()
+
+	all := r.URL.Query().Get("all") == "true"
+	include := map[string]bool{diskusage.ClassReal: true, diskusage.ClassNetwork: true}
+	if all {
+		for _, class := range []string{diskusage.ClassPseudo, diskusage.ClassVirtual, diskusage.ClassLoop, diskusage.ClassContainer} {
+			include[class] = true
+		}
+	}
+	collector, err := diskusage.NewCollector("auto", diskusage.DfCollector{MountsFile: "/proc/mounts", Include: include, Timeout: 5 * time.Second})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	report := diskusage.Report{SchemaVersion: diskusage.SchemaVersion, Time: time.Now()}
+	start := time.Now()
+	filesystems, err := collector.Collect(ctx)
+	status := diskusage.CollectorStatus{Name: collector.Name(), OK: err == nil, Duration: time.Since(start)}
+	if err != nil {
+		status.Err = err.Error()
+	}
+	report.Collectors = []diskusage.CollectorStatus{status}
+	if err != nil && len(filesystems) == 0 {
+		writeError(w, timeoutStatus(ctx, http.StatusInternalServerError), err)
+		return
+	}
+	report.Filesystems = diskusage.FilterClasses(filesystems, include)
+	diskusage.AnnotateErrors(report.Filesystems)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// duResult is the answer of /v1/du.
+type duResult struct {
+	Path         string          `json:"path"`
+	Dirs         []diskusage.Dir `json:"dirs"`
+	SkippedPaths []string        `json:"skipped_paths,omitempty"`
+	// Partial is set when -du-timeout ended the scan, sizes are too low
+	Partial bool `json:"partial,omitempty"`
+}
+
+// du is GET /v1/du?path=/var&depth=2&top=20: the top largest directories
+// under path, at most depth levels down, on path's filesystem.
+func (s *server) du(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	path := filepath.Clean(q.Get("path"))
+	if !filepath.IsAbs(path) {
+		writeError(w, http.StatusBadRequest, errors.New("path must be an absolute path"))
+		return
+	}
+	// the scan follows a symlink given as path, so the check looks at
+	// where it leads
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, os.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	if !s.duAllowed(resolved) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s is not below -du-roots", path))
+		return
+	}
+	path = resolved
+	opts := duscan.Options{Top: 20, MaxDepth: 1, OneFilesystem: true}
+	for name, n := range map[string]*int{"top": &opts.Top, "depth": &opts.MaxDepth} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 || (name == "top" && i == 0) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("bad %s %q", name, v))
+			return
+		}
+		*n = i
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.duTimeout)
+	defer cancel()
+	res, err := duscan.Scan(ctx, path, opts)
+	if err != nil && ctx.Err() == nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, os.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, duResult{Path: path, Dirs: res.Dirs, SkippedPaths: res.SkippedPaths, Partial: err != nil})
+}
+
+// duAllowed tells whether path, without symlinks, is below one of the
+// -du-roots. They are resolved on every check, a root that is a symlink
+// may be pointed elsewhere while the server runs.
+func (s *server) duAllowed(path string) bool {
+	for _, root := range s.duRoots {
+		if r, err := filepath.EvalSymlinks(root); err == nil {
+			root = r
+		}
+		if rel, err := filepath.Rel(root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// authMCP is POST /v1/auth/mcp: it runs the authentication flow and
+// answers with the URL to open. Only one runs at a time, a second request
+// gets 409.
+func (s *server) authMCP(w http.ResponseWriter, r *http.Request) {
+	if !s.auth.TryLock() {
+		writeError(w, http.StatusConflict, errors.New("an authentication is already running"))
+		return
+	}
+	defer s.auth.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.authTimeout)
+	defer cancel()
+	url, err := authcmd.Authenticate(ctx, s.authScript)
+	if err != nil {
+		writeError(w, timeoutStatus(ctx, http.StatusBadGateway), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		URL           string `json:"url,omitempty"`
+		Authenticated bool   `json:"already_authenticated"`
+	}{url, url == ""})
+}
+
+// timeoutStatus is 504 when ctx timed out, status otherwise.
+func timeoutStatus(ctx context.Context, status int) int {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return status
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// statusWriter remembers the status code for the request log.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs every request with its status and duration.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		level := slog.LevelInfo
+		if sw.status >= 500 {
+			level = slog.LevelError
+		} else if r.URL.Path == "/healthz" {
+			level = slog.LevelDebug
+		}
+		slog.Log(r.Context(), level, "request", "method", r.Method, "path", r.URL.Path, "status", sw.status,
+			"took", time.Since(start).Round(time.Millisecond), "remote", r.RemoteAddr)
+	})
+}
@@ -0,0 +1,132 @@
+package servecmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDuRoots(t *testing.T) {
+	dir := t.TempDir()
+	root, outside := filepath.Join(dir, "srv"), filepath.Join(dir, "secret")
+	for _, d := range []string{filepath.Join(root, "data"), outside} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "x")); err != nil {
+		t.Fatal(err)
+	}
+	// a root given through a symlink still allows what is below it
+	if err := os.Symlink(root, filepath.Join(dir, "srv-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		roots []string
+		path  string
+		want  int
+	}{
+		{[]string{root}, filepath.Join(root, "data"), http.StatusOK},
+		{[]string{root}, filepath.Join(root, "x"), http.StatusForbidden},
+		{[]string{root}, filepath.Join(root, "x", "..", "..", "secret"), http.StatusForbidden},
+		{[]string{root}, filepath.Join(root, "missing"), http.StatusNotFound},
+		{[]string{root}, "relative", http.StatusBadRequest},
+		{[]string{filepath.Join(dir, "srv-link")}, filepath.Join(root, "data"), http.StatusOK},
+	} {
+		s := &server{duRoots: tt.roots, duTimeout: 10 * time.Second}
+		rec := httptest.NewRecorder()
+		s.du(rec, httptest.NewRequest(http.MethodGet, "/v1/du?path="+url.QueryEscape(tt.path), nil))
+		if rec.Code != tt.want {
+			t.Errorf("roots %v, path %s: got %d %s, want %d", tt.roots, tt.path, rec.Code, rec.Body, tt.want)
+		}
+	}
+}
+
+func TestDuQuery(t *testing.T) {
+	root := t.TempDir()
+	for _, d := range []string{"a/deep", "b"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "deep", "file"), make([]byte, 64<<10), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &server{duRoots: []string{root}, duTimeout: 10 * time.Second}
+	for _, tt := range []struct {
+		query string
+		want  int
+	}{
+		{"", http.StatusOK},
+		{"&depth=2&top=1", http.StatusOK},
+		{"&depth=0", http.StatusOK},
+		{"&depth=-1", http.StatusBadRequest},
+		{"&top=0", http.StatusBadRequest},
+		{"&top=many", http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		s.du(rec, httptest.NewRequest(http.MethodGet, "/v1/du?path="+url.QueryEscape(root)+tt.query, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%q: got %d %s, want %d", tt.query, rec.Code, rec.Body, tt.want)
+			continue
+		}
+		if tt.query != "&depth=2&top=1" {
+			continue
+		}
+		var res duResult
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Path != root || len(res.Dirs) != 1 || res.Partial {
+			t.Errorf("%q: %+v, want the largest directory of %s", tt.query, res, root)
+		}
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	s := &server{token: []byte("s3cret")}
+	h := s.authorized(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	for header, want := range map[string]int{
+		"":               http.StatusUnauthorized,
+		"s3cret":         http.StatusUnauthorized,
+		"Bearer wrong":   http.StatusUnauthorized,
+		"Bearer s3cret!": http.StatusUnauthorized,
+		"Basic s3cret":   http.StatusUnauthorized,
+		"Bearer s3cret":  http.StatusNoContent,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/v1/disk", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != want {
+			t.Errorf("%q: got %d, want %d", header, rec.Code, want)
+		}
+		if want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%q: no WWW-Authenticate header", header)
+		}
+	}
+}
+
+func TestTimeoutStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+	if got := timeoutStatus(ctx, http.StatusBadGateway); got != http.StatusGatewayTimeout {
+		t.Errorf("timed out: %d", got)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if got := timeoutStatus(ctx, http.StatusBadGateway); got != http.StatusBadGateway {
+		t.Errorf("canceled: %d", got)
+	}
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metadataToken is where a Google Cloud machine gets the access token of
+// its service account.
+const metadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCS stores objects in a Google Cloud Storage bucket through its JSON
+// API.
+type GCS struct {
+	Bucket string
+	Prefix string
+	// Endpoint is https://storage.googleapis.com when empty.
+	Endpoint string
+	// Token returns the OAuth access token requests are sent with. When
+	// nil it is $GOOGLE_OAUTH_ACCESS_TOKEN, else the metadata server's.
+	Token func(ctx context.Context) (string, error)
+
+	// Client is http.DefaultClient when nil.
+	Client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (g *GCS) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	query := url.Values{"uploadType": {"media"}, "name": {fullKey(g.Prefix, key)}}
+	_, err := g.do(ctx, http.MethodPost, "/upload/storage/v1/b/"+url.PathEscape(g.Bucket)+"/o?"+query.Encode(), contentType, data)
+	return err
+}
+
+// gcsList is a page of the objects list.
+type gcsList struct {
+	Items []struct {
+		Name string `json:"name"`
+		// Size is a decimal string, it can exceed what JSON numbers hold
+		Size    string    `json:"size"`
+		Updated time.Time `json:"updated"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (g *GCS) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	query := url.Values{"prefix": {fullKey(g.Prefix, prefix)}, "fields": {"items(name,size,updated),nextPageToken"}}
+	for {
+		body, err := g.do(ctx, http.MethodGet, "/storage/v1/b/"+url.PathEscape(g.Bucket)+"/o?"+query.Encode(), "", nil)
+		if err != nil {
+			return nil, err
+		}
+		var page gcsList
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("listing gs://%s: %w", g.Bucket, err)
+		}
+		for _, item := range page.Items {
+			size, _ := strconv.ParseInt(item.Size, 10, 64)
+			objects = append(objects, Object{Key: relativeKey(g.Prefix, item.Name), Size: size, Modified: item.Updated})
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (g *GCS) Delete(ctx context.Context, key string) error {
+	_, err := g.do(ctx, http.MethodDelete, "/storage/v1/b/"+url.PathEscape(g.Bucket)+"/o/"+url.PathEscape(fullKey(g.Prefix, key)), "", nil)
+	var notFound *gcsNotFound
+	if errors.As(err, &notFound) {
+		return nil
+	}
+	return err
+}
+
+// gcsNotFound is a 404 answer.
+type gcsNotFound struct{ err error }
+
+func (e *gcsNotFound) Error() string { return e.err.Error() }
+
+// do sends a request for path, with the query, and returns the body of a
+// successful answer.
+func (g *GCS) do(ctx context.Context, method, path, contentType string, body []byte) ([]byte, error) {
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("gcs access token: %w", err)
+	}
+	endpoint := g.Endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, &gcsNotFound{statusError(req, resp, data)}
+	case resp.StatusCode/100 != 2:
+		return nil, statusError(req, resp, data)
+	}
+	return data, nil
+}
+
+// accessToken is Token's, or the environment's, or the metadata server's,
+// which is kept until shortly before it expires.
+func (g *GCS) accessToken(ctx context.Context) (string, error) {
+	if g.Token != nil {
+		return g.Token(ctx)
+	}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Now().Before(g.expires) {
+		return g.token, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataToken, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("no GOOGLE_OAUTH_ACCESS_TOKEN and no metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError(req, resp, data)
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return "", err
+	}
+	g.token = t.AccessToken
+	g.expires = time.Now().Add(time.Duration(t.ExpiresIn)*time.Second - time.Minute)
+	return g.token, nil
+}
@@ -0,0 +1,232 @@
+// Package storage keeps files in a bucket of an object store behind one
+// small interface: Amazon S3 or anything speaking its API (MinIO, Ceph,
+// Cloudflare R2...), Google Cloud Storage, or a local directory, e.g. on a
+// shared mount. A store is opened from a URL,
+//
+//	s3://bucket/prefix?region=eu-west-1
+//	s3://bucket/prefix?endpoint=https://minio.example.com:9000
+//	gs://bucket/prefix
+//	file:///var/backups/disk
+//
+// and credentials come from the environment so they stay out of command
+// lines: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// for S3, GOOGLE_OAUTH_ACCESS_TOKEN or the metadata server of the machine
+// for GCS. Keys are relative to the prefix and use / between their parts.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Object is a stored file.
+type Object struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size_bytes"`
+	Modified time.Time `json:"modified"`
+}
+
+// Storage stores files by key.
+type Storage interface {
+	// Put stores data under key, replacing what was there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// List returns the objects whose key starts with prefix, by key.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Delete removes key, a key that isn't there is no error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Open returns the store rawURL names, see the package doc.
+func Open(rawURL string) (Storage, error) {
+	if strings.HasPrefix(rawURL, "/") {
+		rawURL = "file://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	q := u.Query()
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s: no bucket", rawURL)
+		}
+		s := &S3{
+			Bucket:       u.Host,
+			Prefix:       prefix,
+			Region:       firstOf(q.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+			Endpoint:     q.Get("endpoint"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if s.AccessKey == "" || s.SecretKey == "" {
+			return nil, fmt.Errorf("%s: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set", rawURL)
+		}
+		return s, nil
+	case "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s: no bucket", rawURL)
+		}
+		return &GCS{Bucket: u.Host, Prefix: prefix, Endpoint: q.Get("endpoint")}, nil
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("%s: want file:///path", rawURL)
+		}
+		return Dir(filepath.FromSlash(u.Path)), nil
+	}
+	return nil, fmt.Errorf("%s: unknown store, want s3://, gs:// or file://", rawURL)
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Retention says which objects Prune keeps: those at most MaxAge old, and
+// of them the Keep newest. 0 disables either.
+type Retention struct {
+	MaxAge time.Duration
+	Keep   int
+}
+
+// Prune deletes the objects under prefix r doesn't keep and returns their
+// keys. It stops at the first failed delete.
+func Prune(ctx context.Context, s Storage, prefix string, r Retention, now time.Time) ([]string, error) {
+	if r.MaxAge == 0 && r.Keep == 0 {
+		return nil, nil
+	}
+	objects, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(objects, func(i, j int) bool { return objects[i].Modified.After(objects[j].Modified) })
+
+	var deleted []string
+	for i, o := range objects {
+		if (r.MaxAge == 0 || now.Sub(o.Modified) <= r.MaxAge) && (r.Keep == 0 || i < r.Keep) {
+			continue
+		}
+		if err := s.Delete(ctx, o.Key); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, o.Key)
+	}
+	return deleted, nil
+}
+
+// fullKey is key below prefix.
+func fullKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+// relativeKey is the key of a listed object without prefix.
+func relativeKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, prefix+"/")
+}
+
+// statusError is an answer of an HTTP store that isn't a success.
+func statusError(req *http.Request, resp *http.Response, body []byte) error {
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > 512 {
+		msg = msg[:512]
+	}
+	return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, msg)
+}
+
+// Dir stores objects as files under a directory.
+type Dir string
+
+func (d Dir) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// written next to it and renamed, a reader never sees half a file
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (d Dir) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(string(d), func(p string, e os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && p == string(d) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(string(d), p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		return ctx.Err()
+	})
+	return objects, err
+}
+
+func (d Dir) Delete(ctx context.Context, key string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// path is the file of key, which must stay inside d.
+func (d Dir) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return "", fmt.Errorf("bad key %q", key)
+	}
+	return filepath.Join(string(d), filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,225 @@
+// Package summary writes the -summary-file of the tools in this repo: a
+// small JSON record of how a run ended, for CI to keep as an artifact and
+// dashboards to aggregate. It is written on every exit, failures included.
+//
+// New returns nil when no -summary-file was given, all methods do nothing on
+// a nil *Summary so callers don't have to check. A main ends with
+//
+//	defer func() {
+//		if r := recover(); r != nil {
+//			runSummary.Crash(r)
+//			panic(r)
+//		}
+//	}()
+//	...
+//	runSummary.Finish(code)
+//	os.Exit(code)
+package summary
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SchemaVersion is bumped when fields are renamed or removed.
+const SchemaVersion = 1
+
+// Outcomes of a run.
+const (
+	OutcomeOK      = "ok"
+	OutcomeFailed  = "failed"
+	OutcomeCrashed = "crashed"
+)
+
+type Summary struct {
+	SchemaVersion int           `json:"schema_version"`
+	Tool          string        `json:"tool"`
+	Outcome       string        `json:"outcome"`
+	Start         time.Time     `json:"start"`
+	Duration      time.Duration `json:"duration_ns"`
+	ExitCode      int           `json:"exit_code"`
+	// Error is the last error logged, it is only kept when the run failed.
+	Error  string   `json:"error,omitempty"`
+	Alerts []Alert  `json:"alerts,omitempty"`
+	URLs   []string `json:"urls,omitempty"`
+
+	mu      sync.Mutex
+	crashed bool
+	path    string
+}
+
+// Alert counts how often a rule fired for a mount during the run.
+type Alert struct {
+	Mount string `json:"mount"`
+	Rule  string `json:"rule"`
+	Count int    `json:"count"`
+}
+
+// New starts the summary of a run of tool, Finish writes it to path. It is
+// nil when path is empty.
+func New(tool, path string) *Summary {
+	if path == "" {
+		return nil
+	}
+	return &Summary{SchemaVersion: SchemaVersion, Tool: tool, Start: time.Now(), path: path}
+}
+
+// Alert records that rule fired for mount.
+func (s *Summary) Alert(mount, rule string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.Alerts {
+		if s.Alerts[i].Mount == mount && s.Alerts[i].Rule == rule {
+			s.Alerts[i].Count++
+			return
+		}
+	}
+	s.Alerts = append(s.Alerts, Alert{Mount: mount, Rule: rule, Count: 1})
+}
+
+// URL records a captured URL, each one once.
+func (s *Summary) URL(url string) {
+	if s == nil || url == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.URLs {
+		if u == url {
+			return
+		}
+	}
+	s.URLs = append(s.URLs, url)
+}
+
+// Fail records an error the way it was logged: msg, and the "err"
+// attribute if args has one. Only the first line is kept, errors carrying
+// program output belong in the transcript.
+func (s *Summary) Fail(msg string, args ...any) {
+	if s == nil {
+		return
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		if args[i] == "err" {
+			msg = fmt.Sprintf("%s: %v", msg, args[i+1])
+			break
+		}
+	}
+	msg, _, _ = strings.Cut(msg, "\n")
+	s.mu.Lock()
+	s.Error = msg
+	s.mu.Unlock()
+}
+
+// Crash records a panic and writes the summary with the outcome crashed and
+// exit code 2, the code of a Go program dying of a panic.
+func (s *Summary) Crash(v any) {
+	if s == nil {
+		return
+	}
+	s.Fail(fmt.Sprint("panic: ", v))
+	s.mu.Lock()
+	s.crashed = true
+	s.mu.Unlock()
+	s.Finish(2)
+}
+
+// Finish writes the summary for a run ending with exitCode. Not being able
+// to write it is logged, it doesn't change how the run ends.
+func (s *Summary) Finish(exitCode int) {
+	if s == nil {
+		return
+	}
+	if err := s.Write(s.path, exitCode); err != nil {
+		slog.Error("writing summary failed", "path", s.path, "err", err)
+	}
+}
+
+// Handler wraps h so that every error logged through it is recorded with
+// Fail.
+func (s *Summary) Handler(h slog.Handler) slog.Handler {
+	if s == nil {
+		return h
+	}
+	return errorHandler{h, s}
+}
+
+type errorHandler struct {
+	slog.Handler
+	s *Summary
+}
+
+func (h errorHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		var args []any
+		r.Attrs(func(a slog.Attr) bool {
+			args = append(args, a.Key, a.Value.Any())
+			return true
+		})
+		h.s.Fail(r.Message, args...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h errorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return errorHandler{h.Handler.WithAttrs(attrs), h.s}
+}
+
+func (h errorHandler) WithGroup(name string) slog.Handler {
+	return errorHandler{h.Handler.WithGroup(name), h.s}
+}
+
+// Write finishes the summary with the exit code and writes it to path. It
+// goes to a temporary file first and is renamed into place, so a reader
+// never sees half of it.
+func (s *Summary) Write(path string, exitCode int) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Duration = time.Since(s.Start)
+	s.ExitCode = exitCode
+	switch {
+	case s.crashed:
+		s.Outcome = OutcomeCrashed
+	case exitCode != 0:
+		s.Outcome = OutcomeFailed
+	default:
+		s.Outcome = OutcomeOK
+		s.Error = ""
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".summary-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
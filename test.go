@@ -0,0 +1,17 @@
+// Command test automates `claude` -> /mcp -> Figma -> Authenticate and
+// prints the auth URL, it is `devops auto mcp` under its old name. `test
+// replay session.cast` is `devops auto replay`.
+package main
+
+import (
+	"os"
+
+	"ved/test/authcmd"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(authcmd.Replay(os.Args[0]+" replay", os.Args[2:]))
+	}
+	os.Exit(authcmd.Main(os.Args[0], os.Args[1:]))
+}